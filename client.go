@@ -1,11 +1,12 @@
 package socks
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
 	"io"
 	"net"
-	"net/netip"
-	"strconv"
-	"unsafe"
+	"time"
 
 	s5 "github.com/kayabe/socks/s5"
 )
@@ -21,16 +22,31 @@ type Authentication interface {
 
 type Client struct {
 	net.Dialer
-	ProtocolVersion any
-	Authentication  Authentication
-	ProxyAddr       string
+	Protocol       Protocol // V5 by default
+	Authentication Authentication
+	ProxyAddr      string
+
+	// Upstream is used to reach ProxyAddr instead of the embedded net.Dialer when set,
+	// another *Client can be used to chain proxies.
+	Upstream ContextDialer
+
+	// TLSConfig wraps the connection to ProxyAddr in TLS when set.
+	TLSConfig *tls.Config
+
+	// TLSPins optionally restricts the proxy certificate to the given public keys, see PinSHA256.
+	TLSPins [][sha256.Size]byte
+
+	// UDPFragmentMTU enables the fragmentation of RFC 1928 on UDP associations when positive: the payloads
+	// larger than a datagram of this size are sent as FRAG sequences, and the sequences received are
+	// reassembled. Fragments are dropped and payloads sent as single datagrams when zero.
+	UDPFragmentMTU int
 }
 
 // NewClient creates a new SOCKS client, defaults to protocol version socks5
 func NewClient(proxyAddr string, options ...func(*Client)) (client *Client, err error) {
 	client = &Client{
-		ProtocolVersion: V5,
-		ProxyAddr:       proxyAddr,
+		Protocol:  V5,
+		ProxyAddr: proxyAddr,
 	}
 	for _, o := range options {
 		o(client)
@@ -40,161 +56,116 @@ func NewClient(proxyAddr string, options ...func(*Client)) (client *Client, err
 
 // DialTCP connects to the given TCPAddr via the proxy server.
 func (c *Client) DialTCP(network string, laddr, raddr *net.TCPAddr) (conn *net.TCPConn, err error) {
+	if c.TLSConfig != nil {
+		return nil, ErrTLSNotSupported
+	}
 	proxyAddr, err2 := net.ResolveTCPAddr("tcp", c.ProxyAddr)
 	if err2 != nil {
 		return nil, err2
 	}
+	addr, err := s5.AddrFromNet(raddr)
+	if err != nil {
+		return
+	}
 	if conn, err = net.DialTCP(network, laddr, proxyAddr); err != nil {
 		return
 	}
-	switch c.ProtocolVersion.(type) {
-	case ProtocolV5:
-		if err = c.HandshakeV5(conn); err != nil {
-			return
-		}
-		if _, err = c.ConnectV5(conn, raddr); err != nil {
-			return
-		}
-	default:
-		return nil, s5.ErrUnsupportedVersion
+	if err = c.negotiate(context.Background(), conn, addr); err != nil {
+		conn.Close()
+		return nil, err
 	}
 	return conn, nil
 }
 
 // Dial connects to the given address via the proxy server.
 func (c *Client) Dial(network string, address string) (conn net.Conn, err error) {
+	return c.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to the given address via the proxy server using the provided context.
+// The context covers dialing the proxy server and the protocol handshake.
+// The udp networks relay datagrams through a UDP ASSOCIATE, which SOCKS5 only supports.
+func (c *Client) DialContext(ctx context.Context, network string, address string) (conn net.Conn, err error) {
 	switch network {
 	case "udp", "udp4", "udp6":
-		return nil, s5.ErrUnimplemented
+		return c.dialUDP(ctx, address)
 	}
 
-	if conn, err = c.Dialer.Dial(network, c.ProxyAddr); err != nil {
+	addr, err := s5.ParseAddr(address)
+	if err != nil {
 		return
 	}
 
-	switch c.ProtocolVersion.(type) {
-	case ProtocolV5:
-		if err = c.HandshakeV5(conn); err != nil {
-			return
-		}
-		if _, err = c.ConnectV5(conn, address); err != nil {
-			return
-		}
-	default:
-		return nil, s5.ErrUnsupportedVersion
+	if conn, err = c.dialProxy(ctx, network); err != nil {
+		return
 	}
 
-	return conn, nil
-}
-
-func (c *Client) HandshakeV5(conn net.Conn) (err error) {
+	stop := interruptOnDone(ctx, conn)
 	defer func() {
+		stop()
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
 		if err != nil {
 			conn.Close()
+			conn = nil
 		}
 	}()
 
-	var handshake = new(s5.HandshakeRequest)
-
-	if c.Authentication == nil {
-		handshake.Methods = []s5.AuthMethod{s5.MethodAuthNone}
-	} else {
-		handshake.Methods = []s5.AuthMethod{s5.MethodAuthNone, s5.AuthMethod(c.Authentication.Method())}
-	}
+	return conn, c.negotiate(ctx, conn, addr)
+}
 
-	if err = handshake.Pack(conn); err != nil {
-		return
+// negotiate runs the handshake of the protocol and connects conn to addr.
+func (c *Client) negotiate(ctx context.Context, conn net.Conn, addr s5.Addr) (err error) {
+	if c.Protocol == nil {
+		return s5.ErrUnsupportedVersion
 	}
-
-	var reply s5.HandshakeReply
-
-	if err = reply.Unpack(conn); err != nil {
+	if err = c.Protocol.Handshake(ctx, c, conn); err != nil {
 		return
 	}
+	_, err = c.Protocol.Connect(ctx, c, conn, addr)
+	return
+}
 
-	switch reply.Method {
-	case s5.MethodAuthNone: // ignore
-	case s5.MethodAuthNoneAcceptable:
-		return s5.ErrAuthNoneAcceptable
-	case s5.MethodAuthUserPW:
-		if c.Authentication != nil {
-			if err = c.Authentication.Pack(conn); err != nil {
-				return
-			}
-			if _, err = s5.AuthReplyFromConn(conn, func(Version uint8) error {
-				if Version != c.Authentication.Ver() {
-					return s5.ErrAuthVersion
-				}
-				return nil
-			}); err != nil {
-				return
-			}
-		}
-	default:
-		return s5.ErrAuthUnknown
-	}
-
-	return nil
+// DialProxy connects to the proxy server the way DialContext does, through Upstream and TLS if configured,
+// leaving the handshake of the protocol to the caller.
+func (c *Client) DialProxy(ctx context.Context) (net.Conn, error) {
+	return c.dialProxy(ctx, "tcp")
 }
 
-// ConnectV5 target can be used as string for both ip and domain or *net.TCPAddr for ip:port only
-func (c *Client) ConnectV5(conn net.Conn, target any) (reply *s5.Reply, err error) {
-	var req = &s5.Request{
-		Version: s5.VERSION,
-		Command: s5.CommandConnect,
+// dialProxy connects to the proxy server, through Upstream if set, and wraps the connection in TLS if configured.
+func (c *Client) dialProxy(ctx context.Context, network string) (conn net.Conn, err error) {
+	if c.Upstream != nil {
+		conn, err = c.Upstream.DialContext(ctx, network, c.ProxyAddr)
+	} else {
+		conn, err = c.Dialer.DialContext(ctx, network, c.ProxyAddr)
 	}
-
-	var ap netip.AddrPort
-
-	switch target := target.(type) {
-	case string:
-		if tc, err := netip.ParseAddrPort(target); err == nil {
-			ap = tc
-			break
-		}
-
-		var hostname, port string
-		var castPort uint64
-
-		if hostname, port, err = net.SplitHostPort(target); err != nil {
-			break
-		}
-
-		if castPort, err = strconv.ParseUint(port, 10, 16); err != nil {
-			break
-		}
-
-		req.AddressType = s5.AddressTypeDomainName
-		req.Destination = &s5.RequestV5DestDomainName{Address: unsafe.Slice(unsafe.StringData(hostname), len(hostname)), Port: uint16(castPort)}
-	case *net.TCPAddr:
-		ap = target.AddrPort()
-	case *net.UDPAddr:
-		ap = target.AddrPort()
+	if err != nil || c.TLSConfig == nil {
+		return
 	}
+	return c.handshakeTLS(ctx, conn)
+}
 
-	if ap.IsValid() {
-		if ap.Addr().Is4() {
-			req.AddressType = s5.AddressTypeIPv4
-			req.Destination = &s5.RequestV5DestIPv4{Address: ap.Addr().As4(), Port: ap.Port()}
-		} else if ap.Addr().Is6() {
-			req.AddressType = s5.AddressTypeIPv6
-			req.Destination = &s5.RequestV5DestIPv6{Address: ap.Addr().As16(), Port: ap.Port()}
+// aLongTimeAgo is a non-zero time in the past, used to interrupt blocked I/O.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// interruptOnDone interrupts the I/O on conn once ctx is done.
+// The returned func stops watching and clears the deadline.
+func interruptOnDone(ctx context.Context, conn net.Conn) (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(aLongTimeAgo)
+		case <-done:
 		}
-	}
-
-	if req.Destination == nil {
-		return nil, s5.ErrUnsupportedAddressType
-	}
-
-	if err = req.Pack(conn); err != nil {
-		return
-	}
+	}()
 
-	reply = new(s5.Reply)
-	if err = reply.Unpack(conn); err != nil {
-		return nil, err
-	} else if reply.Status != s5.ReplySuccess {
-		return nil, reply.Status.Error()
+	return func() {
+		close(done)
+		<-stopped
+		_ = conn.SetDeadline(time.Time{})
 	}
-	return
 }
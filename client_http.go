@@ -0,0 +1,121 @@
+package socks
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/kayabe/socks/s5"
+)
+
+// maxHTTPHeaderSize limits the size of the CONNECT response header.
+const maxHTTPHeaderSize = 64 << 10
+
+var errHTTPHeaderTooLarge = errors.New("http: response header too large")
+
+// replyStatusFromHTTP maps the status code of a CONNECT response onto a ReplyStatus.
+func replyStatusFromHTTP(code int) s5.ReplyStatus {
+	switch {
+	case code >= 200 && code < 300:
+		return s5.ReplySuccess
+	case code == http.StatusForbidden:
+		return s5.ReplyConnectionNotAllowed
+	case code == http.StatusBadRequest, code == http.StatusMethodNotAllowed, code == http.StatusNotImplemented:
+		return s5.ReplyCommandNotSupported
+	case code == http.StatusBadGateway, code == http.StatusNotFound:
+		return s5.ReplyHostUnreachable
+	case code == http.StatusServiceUnavailable:
+		return s5.ReplyNetworkUnreachable
+	case code == http.StatusGatewayTimeout:
+		return s5.ReplyTTLExpired
+	}
+	return s5.ReplyGeneralFailure
+}
+
+// Handshake implements Protocol, credentials are sent with the CONNECT request.
+func (ProtocolHTTP) Handshake(context.Context, *Client, net.Conn) error { return nil }
+
+// Connect implements Protocol, see ConnectHTTP. The bound address is unknown.
+func (ProtocolHTTP) Connect(_ context.Context, c *Client, conn net.Conn, address s5.Addr) (s5.Addr, error) {
+	if address.Type == s5.AddressTypeDomainName {
+		host, err := s5.ToASCII(address.Host)
+		if err != nil {
+			return s5.Addr{}, err
+		}
+		address.Host = host
+	}
+	_, err := c.ConnectHTTP(conn, address.String())
+	return s5.Addr{}, err
+}
+
+// Bind implements Protocol, HTTP proxies can't accept connections.
+func (ProtocolHTTP) Bind(context.Context, *Client, net.Conn, s5.Addr) (s5.Addr, func() (s5.Addr, error), error) {
+	return s5.Addr{}, nil, s5.ErrReplyCommandNotSupported
+}
+
+// Associate implements Protocol, HTTP proxies don't relay UDP.
+func (ProtocolHTTP) Associate(context.Context, *Client, net.Conn, s5.Addr) (s5.Addr, error) {
+	return s5.Addr{}, s5.ErrReplyCommandNotSupported
+}
+
+// ConnectHTTP asks an HTTP proxy to tunnel conn to the target host:port with the CONNECT method.
+// Credentials set with WithUserPW are sent as Basic proxy authentication.
+func (c *Client) ConnectHTTP(conn net.Conn, address string) (resp *http.Response, err error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+
+	if auth, ok := c.Authentication.(*s5.AuthUserPW); ok {
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString(
+			append(append(append([]byte{}, auth.Username...), ':'), auth.Password...)))
+	}
+
+	if err = req.Write(conn); err != nil {
+		return
+	}
+
+	header, err := readHTTPHeader(conn)
+	if err != nil {
+		return
+	}
+
+	if resp, err = http.ReadResponse(bufio.NewReader(bytes.NewReader(header)), req); err != nil {
+		return
+	}
+
+	switch status := replyStatusFromHTTP(resp.StatusCode); {
+	case resp.StatusCode == http.StatusProxyAuthRequired:
+		return nil, ErrAuthFailed
+	case status != s5.ReplySuccess:
+		return nil, status.Error()
+	}
+	return resp, nil
+}
+
+// readHTTPHeader reads up to and including the empty line ending the header,
+// byte by byte so that nothing past the header is consumed from conn.
+func readHTTPHeader(r io.Reader) ([]byte, error) {
+	var (
+		b   [1]byte
+		buf []byte
+	)
+	for !bytes.HasSuffix(buf, []byte("\r\n\r\n")) {
+		if len(buf) == maxHTTPHeaderSize {
+			return nil, errHTTPHeaderTooLarge
+		}
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, err
+		}
+		buf = append(buf, b[0])
+	}
+	return buf, nil
+}
@@ -0,0 +1,108 @@
+package socks
+
+import (
+	"context"
+	"net"
+	"net/netip"
+
+	"github.com/kayabe/socks/s4"
+	"github.com/kayabe/socks/s5"
+)
+
+// Handshake implements Protocol, SOCKS4 has none.
+func (ProtocolV4) Handshake(context.Context, *Client, net.Conn) error { return nil }
+
+// Connect implements Protocol, domain names are resolved by the client.
+func (ProtocolV4) Connect(ctx context.Context, c *Client, conn net.Conn, address s5.Addr) (s5.Addr, error) {
+	return c.requestV4(ctx, conn, s4.CommandConnect, address, false)
+}
+
+// Bind implements Protocol.
+func (ProtocolV4) Bind(ctx context.Context, c *Client, conn net.Conn, address s5.Addr) (s5.Addr, func() (s5.Addr, error), error) {
+	return c.bindV4(ctx, conn, address, false)
+}
+
+// Associate implements Protocol, SOCKS4 doesn't relay UDP.
+func (ProtocolV4) Associate(context.Context, *Client, net.Conn, s5.Addr) (s5.Addr, error) {
+	return s5.Addr{}, s5.ErrReplyCommandNotSupported
+}
+
+// Handshake implements Protocol, SOCKS4A has none.
+func (ProtocolV4A) Handshake(context.Context, *Client, net.Conn) error { return nil }
+
+// Connect implements Protocol, domain names are resolved by the server.
+func (ProtocolV4A) Connect(ctx context.Context, c *Client, conn net.Conn, address s5.Addr) (s5.Addr, error) {
+	return c.requestV4(ctx, conn, s4.CommandConnect, address, true)
+}
+
+// Bind implements Protocol.
+func (ProtocolV4A) Bind(ctx context.Context, c *Client, conn net.Conn, address s5.Addr) (s5.Addr, func() (s5.Addr, error), error) {
+	return c.bindV4(ctx, conn, address, true)
+}
+
+// Associate implements Protocol, SOCKS4A doesn't relay UDP.
+func (ProtocolV4A) Associate(context.Context, *Client, net.Conn, s5.Addr) (s5.Addr, error) {
+	return s5.Addr{}, s5.ErrReplyCommandNotSupported
+}
+
+func (c *Client) bindV4(ctx context.Context, conn net.Conn, address s5.Addr, v4a bool) (s5.Addr, func() (s5.Addr, error), error) {
+	bound, err := c.requestV4(ctx, conn, s4.CommandBind, address, v4a)
+	if err != nil {
+		return s5.Addr{}, nil, err
+	}
+	accept := func() (s5.Addr, error) {
+		var second s4.Reply
+		if err := second.Unpack(conn); err != nil {
+			return s5.Addr{}, err
+		}
+		if err := second.Status.Error(); err != nil {
+			return s5.Addr{}, err
+		}
+		return s5.AddrFromAddrPort(netip.AddrPortFrom(netip.AddrFrom4(second.IP), second.Port)), nil
+	}
+	return bound, accept, nil
+}
+
+// requestV4 sends a SOCKS4 request, or a SOCKS4A one for domain names if v4a is set, and reads the reply.
+// The username set with WithUserPW is sent as USERID.
+func (c *Client) requestV4(ctx context.Context, conn net.Conn, command uint8, address s5.Addr, v4a bool) (bound s5.Addr, err error) {
+	var req = &s4.Request{Command: command, Port: address.Port}
+
+	if auth, ok := c.Authentication.(*s5.AuthUserPW); ok {
+		req.UserID = auth.Username
+	}
+
+	switch address.Type {
+	case s5.AddressTypeIPv4:
+		req.IP = address.IP.As4()
+	case s5.AddressTypeDomainName:
+		if v4a {
+			host, err := s5.ToASCII(address.Host)
+			if err != nil {
+				return s5.Addr{}, err
+			}
+			req.IP, req.Domain = [4]byte{0, 0, 0, 1}, []byte(host)
+			break
+		}
+		ips, err := c.Dialer.Resolver.LookupNetIP(ctx, "ip4", address.Host)
+		if err != nil {
+			return s5.Addr{}, err
+		}
+		req.IP = ips[0].Unmap().As4()
+	default:
+		return s5.Addr{}, s5.ErrUnsupportedAddressType
+	}
+
+	if err = req.Pack(conn); err != nil {
+		return
+	}
+
+	var reply s4.Reply
+	if err = reply.Unpack(conn); err != nil {
+		return
+	}
+	if err = reply.Status.Error(); err != nil {
+		return
+	}
+	return s5.AddrFromAddrPort(netip.AddrPortFrom(netip.AddrFrom4(reply.IP), reply.Port)), nil
+}
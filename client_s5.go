@@ -0,0 +1,179 @@
+package socks
+
+import (
+	"context"
+	"net"
+	"net/netip"
+
+	"github.com/kayabe/socks/s5"
+)
+
+// Handshake implements Protocol, see HandshakeV5.
+func (ProtocolV5) Handshake(_ context.Context, c *Client, conn net.Conn) error {
+	return c.HandshakeV5(conn)
+}
+
+// Connect implements Protocol.
+func (ProtocolV5) Connect(_ context.Context, c *Client, conn net.Conn, address s5.Addr) (s5.Addr, error) {
+	reply, err := c.requestV5(conn, s5.CommandConnect, address)
+	if err != nil {
+		return s5.Addr{}, err
+	}
+	return reply.Addr(), nil
+}
+
+// Bind implements Protocol.
+func (ProtocolV5) Bind(_ context.Context, c *Client, conn net.Conn, address s5.Addr) (s5.Addr, func() (s5.Addr, error), error) {
+	reply, err := c.requestV5(conn, s5.CommandBind, address)
+	if err != nil {
+		return s5.Addr{}, nil, err
+	}
+	accept := func() (s5.Addr, error) {
+		var second s5.Reply
+		if err := second.Unpack(conn); err != nil {
+			return s5.Addr{}, err
+		}
+		if second.Status != s5.ReplySuccess {
+			return s5.Addr{}, second.Status.Error()
+		}
+		return second.Addr(), nil
+	}
+	return reply.Addr(), accept, nil
+}
+
+// Associate implements Protocol. The relay may be reported as an unspecified IP address,
+// in which case datagrams are sent to the address of the proxy server.
+func (ProtocolV5) Associate(_ context.Context, c *Client, conn net.Conn, address s5.Addr) (s5.Addr, error) {
+	if address.Type == 0 {
+		address = s5.Addr{Type: s5.AddressTypeIPv4, IP: netip.IPv4Unspecified()}
+	}
+	reply, err := c.requestV5(conn, s5.CommandAssociate, address)
+	if err != nil {
+		return s5.Addr{}, err
+	}
+	return reply.Addr(), nil
+}
+
+func (c *Client) HandshakeV5(conn net.Conn) (err error) {
+	defer func() {
+		if err != nil {
+			conn.Close()
+		}
+	}()
+
+	var handshake = new(s5.HandshakeRequest)
+
+	if c.Authentication == nil {
+		handshake.Methods = []s5.AuthMethod{s5.MethodAuthNone}
+	} else {
+		handshake.Methods = []s5.AuthMethod{s5.MethodAuthNone, s5.AuthMethod(c.Authentication.Method())}
+	}
+
+	if err = handshake.Pack(conn); err != nil {
+		return
+	}
+
+	var reply s5.HandshakeReply
+
+	if err = reply.Unpack(conn); err != nil {
+		return
+	}
+
+	switch reply.Method {
+	case s5.MethodAuthNone: // ignore
+	case s5.MethodAuthNoneAcceptable:
+		return s5.ErrAuthNoneAcceptable
+	case s5.MethodAuthUserPW:
+		if c.Authentication != nil {
+			if err = c.Authentication.Pack(conn); err != nil {
+				return
+			}
+			var auth *s5.AuthReply
+			if auth, err = s5.AuthReplyFromConn(conn, func(Version uint8) error {
+				if Version != c.Authentication.Ver() {
+					return s5.ErrAuthVersion
+				}
+				return nil
+			}); err != nil {
+				return
+			}
+			if auth.Status != s5.ReplySuccess {
+				return ErrAuthFailed
+			}
+		}
+	default:
+		return s5.ErrAuthUnknown
+	}
+
+	return nil
+}
+
+// ConnectV5 asks the server to connect conn to the "host:port" address, host being an IP address or a domain name.
+func (c *Client) ConnectV5(conn net.Conn, address string) (reply *s5.Reply, err error) {
+	addr, err := s5.ParseAddr(address)
+	if err != nil {
+		return nil, err
+	}
+	return c.requestV5(conn, s5.CommandConnect, addr)
+}
+
+// requestV5 sends the request for the given command and reads the first reply.
+func (c *Client) requestV5(conn net.Conn, command uint8, addr s5.Addr) (reply *s5.Reply, err error) {
+	if addr.Type == s5.AddressTypeDomainName {
+		if addr.Host, err = s5.ToASCII(addr.Host); err != nil {
+			return nil, err
+		}
+	}
+	if !addr.IsValid() {
+		return nil, s5.ErrUnsupportedAddressType
+	}
+
+	var req = &s5.Request{
+		Version:     s5.VERSION,
+		Command:     command,
+		AddressType: addr.Type,
+		Destination: &addr,
+	}
+
+	if err = req.Pack(conn); err != nil {
+		return
+	}
+
+	reply = new(s5.Reply)
+	if err = reply.Unpack(conn); err != nil {
+		return nil, err
+	} else if reply.Status != s5.ReplySuccess {
+		return nil, reply.Status.Error()
+	}
+	return
+}
+
+// Resolve asks the proxy server for the address of host with the Tor RESOLVE extension,
+// the proxy server has to speak SOCKS5.
+func (c *Client) Resolve(ctx context.Context, host string) (addr netip.Addr, err error) {
+	conn, err := c.dialProxy(ctx, "tcp")
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	stop := interruptOnDone(ctx, conn)
+	defer func() {
+		stop()
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+	}()
+
+	if err = c.HandshakeV5(conn); err != nil {
+		return
+	}
+	reply, err := c.requestV5(conn, s5.CommandResolve, s5.Addr{Type: s5.AddressTypeDomainName, Host: host})
+	if err != nil {
+		return
+	}
+	if reply.Bind.Type == s5.AddressTypeDomainName {
+		return netip.Addr{}, s5.ErrUnsupportedAddressType
+	}
+	return reply.Bind.IP, nil
+}
@@ -0,0 +1,110 @@
+package socks
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kayabe/socks/s5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientHTTP(t *testing.T) {
+	echo := startEcho(t)
+	addr := startServer(t, &Server{Credentials: StaticCredentials{"user": "pass"}})
+
+	client, _ := NewClient(addr, WithVersion(HTTP), WithUserPW("user", "pass"))
+	conn, err := client.DialContext(context.Background(), "tcp", echo.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	assertEcho(t, conn)
+}
+
+func TestClientHTTPErrors(t *testing.T) {
+	echo := startEcho(t)
+
+	addr := startServer(t, &Server{Credentials: StaticCredentials{"user": "pass"}})
+	client, _ := NewClient(addr, WithVersion(HTTP), WithUserPW("user", "wrong"))
+	_, err := client.Dial("tcp", echo.String())
+	assert.Equal(t, ErrAuthFailed, err)
+
+	addr = startServer(t, &Server{Rules: PermitNone})
+	client, _ = NewClient(addr, WithVersion(HTTP))
+	_, err = client.Dial("tcp", echo.String())
+	assert.Equal(t, s5.ErrReplyConnectionNotAllowed, err)
+}
+
+func TestClientHTTPIDN(t *testing.T) {
+	hosts := make(chan string, 1)
+	addr := startServer(t, &Server{Rules: RuleFunc(func(_ context.Context, r *Request) bool {
+		hosts <- r.Host
+		return false
+	})})
+
+	client, _ := NewClient(addr, WithVersion(HTTP))
+	_, err := client.Dial("tcp", "bücher.example:80")
+	assert.Equal(t, s5.ErrReplyConnectionNotAllowed, err)
+	assert.Equal(t, "xn--bcher-kva.example", <-hosts)
+}
+
+func TestClientHandshakeV5AuthFailed(t *testing.T) {
+	conn := pipeProxy(t, func(conn net.Conn) {
+		var handshake s5.HandshakeRequest
+		if handshake.Unpack(conn) != nil {
+			return
+		}
+		_ = (&s5.HandshakeReply{Version: s5.VERSION, Method: s5.MethodAuthUserPW}).Pack(conn)
+		var auth s5.AuthUserPW
+		if auth.Unpack(conn) != nil {
+			return
+		}
+		_ = (&s5.AuthReply{Version: s5.AuthUserPWVersion, Status: s5.ReplyGeneralFailure}).Pack(conn)
+	})
+
+	client, _ := NewClient("", WithUserPW("user", "wrong"))
+	assert.Equal(t, ErrAuthFailed, client.HandshakeV5(conn))
+}
+
+func TestClientChain(t *testing.T) {
+	echo := startEcho(t)
+	first := startServer(t, &Server{})
+	second := startServer(t, &Server{Credentials: StaticCredentials{"user": "pass"}})
+
+	hop, _ := NewClient(first)
+	client, _ := NewClient(second, WithVersion(HTTP), WithUserPW("user", "pass"), WithUpstream(hop))
+
+	conn, err := client.Dial("tcp", echo.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	assertEcho(t, conn)
+}
+
+func TestClientDialContextTimeout(t *testing.T) {
+	// a proxy that accepts connections but never answers
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	client, _ := NewClient(l.Addr().String())
+	_, err = client.DialContext(ctx, "tcp", "127.0.0.1:1")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,59 @@
+package socks
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+)
+
+// PinSHA256 returns the pin of a certificate, the SHA-256 of its SubjectPublicKeyInfo.
+func PinSHA256(cert *x509.Certificate) [sha256.Size]byte {
+	return sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+}
+
+// tlsConfig returns the configuration used to wrap the connection to the proxy server.
+// ServerName defaults to the host of ProxyAddr, and the pins are checked in addition to the
+// verification done by the config.
+func (c *Client) tlsConfig() *tls.Config {
+	config := c.TLSConfig.Clone()
+
+	if config.ServerName == "" {
+		if host, _, err := net.SplitHostPort(c.ProxyAddr); err == nil {
+			config.ServerName = host
+		}
+	}
+
+	if len(c.TLSPins) > 0 {
+		verify := config.VerifyConnection
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			if verify != nil {
+				if err := verify(cs); err != nil {
+					return err
+				}
+			}
+			if len(cs.PeerCertificates) > 0 {
+				pin := PinSHA256(cs.PeerCertificates[0])
+				for _, p := range c.TLSPins {
+					if p == pin {
+						return nil
+					}
+				}
+			}
+			return ErrTLSPinMismatch
+		}
+	}
+
+	return config
+}
+
+// handshakeTLS wraps conn in TLS, conn is closed if the handshake fails.
+func (c *Client) handshakeTLS(ctx context.Context, conn net.Conn) (net.Conn, error) {
+	tlsConn := tls.Client(conn, c.tlsConfig())
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
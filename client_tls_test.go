@@ -0,0 +1,108 @@
+package socks
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testCertificate creates a self-signed certificate for localhost.
+func testCertificate(t *testing.T) (tls.Certificate, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, cert
+}
+
+// startTLSServer serves s over TLS on a loopback listener.
+func startTLSServer(t *testing.T, s *Server) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = s.ServeTLS(l, "", "") }()
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String()
+}
+
+func TestClientTLS(t *testing.T) {
+	echo := startEcho(t)
+	cert, x509Cert := testCertificate(t)
+	addr := startTLSServer(t, &Server{
+		Credentials: StaticCredentials{"user": "pass"},
+		TLSConfig:   &tls.Config{Certificates: []tls.Certificate{cert}},
+	})
+
+	roots := x509.NewCertPool()
+	roots.AddCert(x509Cert)
+
+	for _, version := range []Protocol{V5, HTTP} {
+		client, _ := NewClient(addr, WithVersion(version), WithUserPW("user", "pass"), WithTLS(&tls.Config{RootCAs: roots}))
+		conn, err := client.Dial("tcp", echo.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEcho(t, conn)
+		conn.Close()
+	}
+
+	// DialProxy leaves the handshake to the caller, over TLS
+	client, _ := NewClient(addr, WithUserPW("user", "pass"), WithTLS(&tls.Config{RootCAs: roots}))
+	conn, err := client.DialProxy(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, client.HandshakeV5(conn))
+	conn.Close()
+
+	client, _ = NewClient(addr, WithTLS(nil))
+	_, err = client.Dial("tcp", echo.String())
+	assert.Error(t, err, "the self-signed certificate should not be trusted")
+}
+
+func TestClientTLSPins(t *testing.T) {
+	echo := startEcho(t)
+	cert, x509Cert := testCertificate(t)
+	addr := startTLSServer(t, &Server{TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}}})
+
+	client, _ := NewClient(addr, WithTLS(&tls.Config{InsecureSkipVerify: true}), WithTLSPins(PinSHA256(x509Cert)))
+	conn, err := client.Dial("tcp", echo.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEcho(t, conn)
+	conn.Close()
+
+	client, _ = NewClient(addr, WithTLS(&tls.Config{InsecureSkipVerify: true}), WithTLSPins([32]byte{}))
+	_, err = client.Dial("tcp", echo.String())
+	assert.ErrorIs(t, err, ErrTLSPinMismatch)
+}
@@ -0,0 +1,215 @@
+package socks
+
+import (
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kayabe/socks/s5"
+)
+
+// dialUDP associates with the proxy server and returns a connection relaying datagrams to address.
+func (c *Client) dialUDP(ctx context.Context, address string) (conn net.Conn, err error) {
+	addr, err := s5.ParseAddr(address)
+	if err != nil {
+		return
+	}
+
+	ctrl, err := c.dialProxy(ctx, "tcp")
+	if err != nil {
+		return
+	}
+
+	stop := interruptOnDone(ctx, ctrl)
+	defer func() {
+		stop()
+		if err != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		if err != nil {
+			ctrl.Close()
+			conn = nil
+		}
+	}()
+
+	if c.Protocol == nil {
+		return nil, s5.ErrUnsupportedVersion
+	}
+	if err = c.Protocol.Handshake(ctx, c, ctrl); err != nil {
+		return
+	}
+	relay, err := c.Protocol.Associate(ctx, c, ctrl, s5.Addr{})
+	if err != nil {
+		return
+	}
+
+	relayAddr := relay.String()
+	if relay.Type != s5.AddressTypeDomainName && relay.IP.IsUnspecified() {
+		host, _, err := net.SplitHostPort(ctrl.RemoteAddr().String())
+		if err != nil {
+			return nil, err
+		}
+		relayAddr = net.JoinHostPort(host, strconv.Itoa(int(relay.Port)))
+	}
+
+	udp, err := c.Dialer.DialContext(ctx, "udp", relayAddr)
+	if err != nil {
+		return
+	}
+	conn = newUDPConn(ctrl, udp, addr)
+	if c.UDPFragmentMTU > 0 {
+		uc := conn.(*udpConn)
+		uc.frag, uc.reasm = &s5.Fragmenter{MTU: c.UDPFragmentMTU}, new(s5.Reassembler)
+	}
+	return conn, nil
+}
+
+// udpConn is a datagram connection to a destination through a UDP ASSOCIATE,
+// the association lasts as long as the control connection.
+type udpConn struct {
+	net.Conn // connected to the relay
+	ctrl     net.Conn
+	dest     s5.Addr
+	once     sync.Once
+
+	// frag and reasm fragment and reassemble the datagrams, both nil when disabled.
+	frag   *s5.Fragmenter
+	reasm  *s5.Reassembler
+	mu     sync.Mutex  // guards reasm and expiry
+	expiry *time.Timer // expires the pending sequence of reasm
+
+	rmu  sync.Mutex // serializes the reads, guards rbuf
+	rbuf []byte     // the datagrams are read into, grown to the largest read
+}
+
+func newUDPConn(ctrl, udp net.Conn, dest s5.Addr) *udpConn {
+	c := &udpConn{Conn: udp, ctrl: ctrl, dest: dest}
+	go func() {
+		_, _ = io.Copy(io.Discard, ctrl)
+		c.Close()
+	}()
+	return c
+}
+
+// Read reads the payload of the next datagram, fragmented datagrams are reassembled
+// when fragmentation is enabled and dropped otherwise.
+func (c *udpConn) Read(b []byte) (int, error) {
+	size := len(b)
+	if c.reasm != nil {
+		size = s5.DefaultReassemblySize // fragments can be as large as the MTU of the server
+	}
+
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	if len(c.rbuf) < size+s5.MaxUDPHeaderSize {
+		c.rbuf = make([]byte, size+s5.MaxUDPHeaderSize)
+	}
+	buf := c.rbuf
+	for {
+		n, err := c.Conn.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		var h s5.UDPHeader
+		payload, err := h.Unwrap(buf[:n])
+		if err != nil {
+			continue
+		}
+		if c.reasm != nil {
+			if n, ok := c.reassemble(&h, payload, b); ok {
+				return n, nil
+			}
+			continue
+		}
+		if h.Frag != 0 {
+			continue
+		}
+		if len(payload) > len(b) {
+			payload = payload[:len(b)] // truncated, like a net.UDPConn does
+		}
+		return len(append(b[:0], payload...)), nil
+	}
+}
+
+// reassemble pushes a datagram to the reassembly queue, copying to b the complete datagram if any.
+func (c *udpConn) reassemble(h *s5.UDPHeader, payload, b []byte) (n int, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	datagram, done := c.reasm.Push(h, payload)
+	c.scheduleExpiry()
+	if !done {
+		return 0, false
+	}
+	if len(datagram) > len(b) {
+		datagram = datagram[:len(b)]
+	}
+	return len(append(b[:0], datagram...)), true
+}
+
+// scheduleExpiry starts the reassembly timer of the pending sequence, or stops it without one, c.mu is held.
+func (c *udpConn) scheduleExpiry() {
+	deadline, ok := c.reasm.Deadline()
+	switch {
+	case !ok && c.expiry != nil:
+		c.expiry.Stop()
+		c.expiry = nil
+	case ok && c.expiry == nil:
+		c.expiry = time.AfterFunc(time.Until(deadline), c.expire)
+	}
+}
+
+// expire drops the pending sequence once its timer expired, so that it isn't kept until the next datagram.
+// A sequence started since the timer was set is given its own timer.
+func (c *udpConn) expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reasm.Expire(time.Now())
+	c.expiry = nil
+	c.scheduleExpiry()
+}
+
+// Write sends b to the destination, as a FRAG sequence if fragmentation is enabled and b
+// doesn't fit in a datagram, as a single datagram otherwise.
+func (c *udpConn) Write(b []byte) (int, error) {
+	h := s5.UDPHeader{Destination: &c.dest}
+	if c.frag == nil {
+		if _, err := c.Conn.Write(h.Wrap(make([]byte, 0, h.Size()+len(b)), b)); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	datagrams, err := c.frag.Fragment(nil, &h, b)
+	if err != nil {
+		return 0, err
+	}
+	for _, d := range datagrams {
+		if _, err := c.Conn.Write(d); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// RemoteAddr returns the destination, as sent to the relay.
+func (c *udpConn) RemoteAddr() net.Addr { return c.dest }
+
+// Close ends the association.
+func (c *udpConn) Close() (err error) {
+	c.once.Do(func() {
+		if c.reasm != nil {
+			c.mu.Lock()
+			if c.expiry != nil {
+				c.expiry.Stop()
+			}
+			c.reasm.Reset()
+			c.mu.Unlock()
+		}
+		c.ctrl.Close()
+		err = c.Conn.Close()
+	})
+	return
+}
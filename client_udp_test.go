@@ -0,0 +1,217 @@
+package socks
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kayabe/socks/s5"
+	"github.com/stretchr/testify/assert"
+)
+
+// scriptProxy accepts connections on a loopback address, script plays the server after the SOCKS5 handshake.
+func scriptProxy(t *testing.T, script func(conn net.Conn, req *s5.Request)) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var handshake s5.HandshakeRequest
+				if handshake.Unpack(conn) != nil {
+					return
+				}
+				_ = (&s5.HandshakeReply{Version: s5.VERSION, Method: s5.MethodAuthNone}).Pack(conn)
+
+				var addr s5.Addr
+				req := s5.Request{Destination: &addr}
+				if req.Unpack(conn) != nil {
+					return
+				}
+				script(conn, &req)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestClientUDP(t *testing.T) {
+	proxy := scriptProxy(t, func(conn net.Conn, req *s5.Request) {
+		if req.Command != s5.CommandAssociate {
+			return
+		}
+		relay, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			return
+		}
+		defer relay.Close()
+
+		// an unspecified address stands for the address of the proxy server
+		port := uint16(relay.LocalAddr().(*net.UDPAddr).Port)
+		_ = (&s5.Reply{Status: s5.ReplySuccess, Bind: s5.AddrFromAddrPort(netip.AddrPortFrom(netip.IPv4Unspecified(), port))}).Pack(conn)
+
+		go func() {
+			buf := make([]byte, 2048)
+			for {
+				n, from, err := relay.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				var h s5.UDPHeader
+				payload, err := h.Unwrap(buf[:n])
+				if err != nil {
+					continue
+				}
+				// answer with a fragment first, which the client drops
+				reply := s5.UDPHeader{Destination: h.Destination}
+				_, _ = relay.WriteTo((&s5.UDPHeader{Frag: 1, Destination: h.Destination}).Wrap(nil, []byte("fragment")), from)
+				_, _ = relay.WriteTo(reply.Wrap(nil, append([]byte(h.Addr().String()+" "), payload...)), from)
+			}
+		}()
+		_, _ = conn.Read(make([]byte, 1)) // until the client ends the association
+	})
+
+	client, _ := NewClient(proxy)
+	conn, err := client.Dial("udp", "example.com:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	assert.Equal(t, "example.com:53", conn.RemoteAddr().String())
+
+	_, err = conn.Write([]byte("query"))
+	assert.NoError(t, err)
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com:53 query", string(buf[:n]))
+
+	// truncated to the buffer
+	_, _ = conn.Write([]byte("query"))
+	n, err = conn.Read(buf[:7])
+	assert.NoError(t, err)
+	assert.Equal(t, "example", string(buf[:n]))
+}
+
+func TestClientUDPFragmentation(t *testing.T) {
+	partial := make(chan struct{}, 1)
+	proxy := scriptProxy(t, func(conn net.Conn, req *s5.Request) {
+		relay, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			return
+		}
+		defer relay.Close()
+		_ = (&s5.Reply{Status: s5.ReplySuccess, Bind: s5.AddrFromAddrPort(relay.LocalAddr().(*net.UDPAddr).AddrPort())}).Pack(conn)
+
+		go func() {
+			var r s5.Reassembler
+			buf := make([]byte, 2048)
+			for {
+				n, from, err := relay.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				var h s5.UDPHeader
+				payload, err := h.Unwrap(buf[:n])
+				if err != nil {
+					continue
+				}
+				datagram, done := r.Push(&h, payload)
+				if !done {
+					continue
+				}
+				if string(datagram) == "partial" {
+					// a sequence whose end never comes
+					_, _ = relay.WriteTo((&s5.UDPHeader{Frag: 1, Destination: h.Destination}).Wrap(nil, []byte("lost")), from)
+					partial <- struct{}{}
+					continue
+				}
+				// echoed back in fragments
+				reply, _ := (&s5.Fragmenter{MTU: 32}).Fragment(nil, &s5.UDPHeader{Destination: h.Destination}, datagram)
+				for _, d := range reply {
+					_, _ = relay.WriteTo(d, from)
+				}
+			}
+		}()
+		_, _ = conn.Read(make([]byte, 1))
+	})
+
+	client, _ := NewClient(proxy, WithUDPFragmentation(48))
+	conn, err := client.Dial("udp", "192.0.2.1:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	payload := []byte(strings.Repeat("0123456789", 20))
+	n, err := conn.Write(payload)
+	assert.NoError(t, err)
+	assert.Equal(t, len(payload), n)
+	buf := make([]byte, 512)
+	n, err = conn.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, payload, buf[:n])
+
+	// the pending sequence is dropped once its timer expires, without waiting for another datagram
+	uc := conn.(*udpConn)
+	uc.mu.Lock()
+	uc.reasm.Timeout = 50 * time.Millisecond
+	uc.mu.Unlock()
+	_, _ = conn.Write([]byte("partial"))
+	<-partial
+	_ = conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, err = conn.Read(buf)
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	assert.Eventually(t, func() bool {
+		uc.mu.Lock()
+		defer uc.mu.Unlock()
+		return uc.reasm.Stats.Timeouts == 1 && !uc.reasm.Pending()
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestClientUDPUnsupported(t *testing.T) {
+	proxy := scriptProxy(t, func(conn net.Conn, req *s5.Request) {
+		_ = (&s5.Reply{Status: s5.ReplyCommandNotSupported}).Pack(conn)
+	})
+
+	client, _ := NewClient(proxy)
+	_, err := client.Dial("udp", "example.com:53")
+	assert.Equal(t, s5.ErrReplyCommandNotSupported, err)
+
+	client.Protocol = V4
+	_, err = client.Dial("udp", "127.0.0.1:53")
+	assert.Error(t, err)
+}
+
+func TestClientResolve(t *testing.T) {
+	proxy := scriptProxy(t, func(conn net.Conn, req *s5.Request) {
+		status := s5.ReplyHostUnreachable
+		if req.Command == s5.CommandResolve && req.Addr().Host == "example.com" {
+			status = s5.ReplySuccess
+		}
+		_ = (&s5.Reply{Status: status, Bind: s5.AddrFromAddrPort(netip.AddrPortFrom(netip.MustParseAddr("93.184.216.34"), 0))}).Pack(conn)
+	})
+
+	client, _ := NewClient(proxy)
+	addr, err := client.Resolve(context.Background(), "example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "93.184.216.34", addr.String())
+
+	_, err = client.Resolve(context.Background(), "nonexistent.invalid")
+	assert.Equal(t, s5.ErrReplyHostUnreachable, err)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/kayabe/socks"
+	"github.com/kayabe/socks/s5"
+	"github.com/kayabe/socks/sockstest"
+	"github.com/stretchr/testify/assert"
+)
+
+func startServer(t *testing.T, s *socks.Server) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = s.Serve(l) }()
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String()
+}
+
+func startEcho(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func socksCmd(stdin string, args ...string) (code int, stdout, stderr string) {
+	var out, errOut bytes.Buffer
+	code = run(args, strings.NewReader(stdin), &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+func TestConnect(t *testing.T) {
+	s := sockstest.NewServer(sockstest.Script{})
+	defer s.Close()
+	s.Handle("example.com:80", sockstest.HandlerFunc(func(conn net.Conn, _ *socks.Request) {
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		_, _ = conn.Write([]byte("got " + line))
+	}))
+
+	code, stdout, stderr := socksCmd("hello\n", "-proxy", s.Addr, "connect", "example.com:80")
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, "got hello\n", stdout)
+}
+
+func TestProbe(t *testing.T) {
+	echo := startEcho(t)
+	proxy := startServer(t, &socks.Server{
+		Credentials: socks.StaticCredentials{"user": "pass"},
+		Rules: socks.RuleFunc(func(_ context.Context, r *socks.Request) bool {
+			return r.Port != 25
+		}),
+	})
+
+	code, stdout, _ := socksCmd("", "-proxy", "socks5h://user:pass@"+proxy, "probe", echo, "127.0.0.1:25")
+	assert.Equal(t, 1, code)
+
+	lines := strings.Split(stdout, "\n")
+	assert.Regexp(t, `^method none +rejected`, lines[0])
+	assert.Regexp(t, `^method gssapi +rejected`, lines[1])
+	assert.Regexp(t, `^method userpw +accepted`, lines[2])
+	assert.Regexp(t, `^auth +succeeded`, lines[3])
+	assert.Regexp(t, `^`+echo+` +succeeded`, lines[4])
+	assert.Regexp(t, `^127.0.0.1:25 +connection not allowed by ruleset`, lines[5])
+
+	code, stdout, _ = socksCmd("", "-proxy", "socks5h://user:wrong@"+proxy, "probe")
+	assert.Equal(t, 1, code)
+	assert.Regexp(t, `auth +failed: authentication failed`, stdout)
+}
+
+func TestResolve(t *testing.T) {
+	s := sockstest.NewServer(sockstest.Script{
+		StatusFunc: func(r *socks.Request) s5.ReplyStatus {
+			if r.Command == s5.CommandResolve && r.Host == "example.com" {
+				return s5.ReplySuccess
+			}
+			return s5.ReplyHostUnreachable
+		},
+		Bind: s5.AddrFromAddrPort(netip.AddrPortFrom(netip.MustParseAddr("93.184.216.34"), 0)),
+	})
+	defer s.Close()
+
+	code, stdout, _ := socksCmd("", "-proxy", s.Addr, "resolve", "example.com", "nonexistent.invalid")
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "example.com\t93.184.216.34\nnonexistent.invalid\thost unreachable\n", stdout)
+}
+
+func TestUsage(t *testing.T) {
+	for _, args := range [][]string{
+		{"-proxy", "socks5h://127.0.0.1:1080"},
+		{"-proxy", "socks5h://127.0.0.1:1080", "unknown"},
+		{"-proxy", "socks5h://127.0.0.1:1080", "connect"},
+		{"-proxy", "ftp://127.0.0.1"},
+	} {
+		code, _, stderr := socksCmd("", args...)
+		assert.Equal(t, 2, code, args)
+		assert.NotEmpty(t, stderr)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kayabe/socks"
+	"github.com/kayabe/socks/s5"
+)
+
+// probed are the methods offered one at a time by probe.
+var probed = []struct {
+	name   string
+	method s5.AuthMethod
+}{
+	{"none", s5.MethodAuthNone},
+	{"gssapi", s5.MethodAuthGSSAPI},
+	{"userpw", s5.MethodAuthUserPW},
+}
+
+// probe reports the methods a SOCKS5 proxy server accepts, whether the client authenticates,
+// and the reply status of each destination, with timings.
+func (c *cli) probe(args []string) error {
+	client := c.dialer.Client
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	var failed error
+
+	if client.Protocol == socks.V5 {
+		for _, p := range probed {
+			start := time.Now()
+			result, err := c.probeMethod(p.method)
+			if err != nil {
+				result, failed = err.Error(), ErrFailed
+			}
+			fmt.Fprintf(w, "method %s\t%s\t%v\n", p.name, result, elapsed(start))
+		}
+
+		if client.Authentication != nil {
+			start := time.Now()
+			result := "succeeded"
+			if err := c.probeAuth(); err != nil {
+				result, failed = "failed: "+err.Error(), ErrFailed
+			}
+			fmt.Fprintf(w, "auth\t%s\t%v\n", result, elapsed(start))
+		}
+	}
+
+	for _, address := range args {
+		ctx, cancel := c.context()
+		start := time.Now()
+		conn, err := c.dialer.DialContext(ctx, "tcp", address)
+		cancel()
+		if err == nil {
+			conn.Close()
+		} else {
+			failed = ErrFailed
+		}
+		fmt.Fprintf(w, "%s\t%s\t%v\n", address, status(err), elapsed(start))
+	}
+	return failed
+}
+
+// probeMethod offers the single method, and returns whether the server selected it.
+func (c *cli) probeMethod(method s5.AuthMethod) (string, error) {
+	ctx, cancel := c.context()
+	defer cancel()
+
+	conn, err := c.dialer.Client.DialProxy(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if err = (&s5.HandshakeRequest{Methods: []s5.AuthMethod{method}}).Pack(conn); err != nil {
+		return "", err
+	}
+	var reply s5.HandshakeReply
+	if err = reply.Unpack(conn); err != nil {
+		return "", err
+	}
+	switch reply.Method {
+	case method:
+		return "accepted", nil
+	case s5.MethodAuthNoneAcceptable:
+		return "rejected", nil
+	}
+	return fmt.Sprintf("invalid selection %#02x", uint8(reply.Method)), nil
+}
+
+// probeAuth runs the handshake of the client, with its credentials.
+func (c *cli) probeAuth() error {
+	ctx, cancel := c.context()
+	defer cancel()
+
+	conn, err := c.dialer.Client.DialProxy(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	return c.dialer.Client.HandshakeV5(conn)
+}
+
+// status returns the reply status an error stands for, or the error itself.
+func status(err error) string {
+	if err == nil {
+		return s5.ReplySuccess.String()
+	}
+	for s := s5.ReplyGeneralFailure; s <= s5.ReplyAddressTypeNotSupported; s++ {
+		if errors.Is(err, s.Error()) {
+			return s.String()
+		}
+	}
+	return err.Error()
+}
+
+func elapsed(start time.Time) time.Duration {
+	return time.Since(start).Round(10 * time.Microsecond)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"time"
+)
+
+// udp sends each line of stdin as a datagram to address and prints each datagram received on a line,
+// until the wait duration after the end of stdin.
+func (c *cli) udp(args []string) error {
+	if len(args) != 1 {
+		return ErrUsage
+	}
+
+	ctx, cancel := c.context()
+	conn, err := c.dialer.DialContext(ctx, "udp", args[0])
+	cancel()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	received := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 0xFFFF)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				received <- err
+				return
+			}
+			fmt.Fprintf(c.stdout, "%s\n", buf[:n])
+		}
+	}()
+
+	scanner := bufio.NewScanner(c.stdin)
+	for scanner.Scan() {
+		if _, err := conn.Write(scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	select {
+	case <-time.After(c.wait):
+		conn.Close()
+		<-received
+		return nil
+	case err := <-received:
+		return err
+	}
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/kayabe/socks"
+	"github.com/kayabe/socks/dns"
+	"github.com/kayabe/socks/s5"
+)
+
+var (
+	ErrUnknownAction     = errors.New("unknown rule action, expected allow or deny")
+	ErrUnknownCommand    = errors.New("unknown rule command, expected connect, bind or associate")
+	ErrUnknownLogFormat  = errors.New("unknown log format, expected text or json")
+	ErrUnknownValidation = errors.New("unknown validation, expected lenient or strict")
+	ErrNoListen          = errors.New("no listen address")
+	ErrUnknownPreference = errors.New("unknown dns preference, expected ipv4 or ipv6")
+	ErrUnknownDNSScheme  = errors.New("unknown dns server scheme, expected udp, tcp, tls or https")
+	ErrUnknownBindReply  = errors.New("unknown bind reply, expected local, ipv4-mapped or hidden")
+	ErrAuthRestart       = errors.New("enabling or disabling authentication requires a restart")
+	ErrDNSRestart        = errors.New("enabling or disabling dns requires a restart")
+)
+
+// Config is the configuration of the daemon, read from a JSON file and overridden by the flags.
+type Config struct {
+	Listen  []string `json:"listen"`   // addresses to listen on, ":1080" if empty
+	TLSCert string   `json:"tls_cert"` // serves SOCKS over TLS when set with TLSKey
+	TLSKey  string   `json:"tls_key"`
+
+	// Users maps usernames to passwords, UsersFile holds "user:password" lines.
+	// Passwords are in clear or "sha256:" followed by the hex digest, authentication
+	// is required if either of them is set when the daemon starts.
+	Users     map[string]string `json:"users"`
+	UsersFile string            `json:"users_file"`
+
+	// Rules are checked in order, the first one matching a request decides,
+	// requests matching none are allowed unless Default is "deny".
+	Rules   []RuleConfig `json:"rules"`
+	Default string       `json:"default"`
+
+	// Upstream is the URL of a proxy server destinations are reached through, see socks.FromURL.
+	Upstream string `json:"upstream"`
+
+	// DNS makes the daemon resolve the domain names itself and check the rules again against
+	// their addresses, rather than leaving them to the upstream or to the system when dialing.
+	DNS *DNSConfig `json:"dns"`
+
+	LogFormat  string `json:"log_format"` // text or json
+	Metrics    string `json:"metrics"`    // address serving the metrics over HTTP, disabled if empty
+	Validation string `json:"validation"` // lenient or strict
+
+	// AttemptDelay is how long the connection attempts to the addresses of a name wait for each other
+	// before racing, such as "250ms", socks.DefaultAttemptDelay if empty.
+	AttemptDelay string `json:"attempt_delay"`
+
+	// HandshakeTimeout, IdleTimeout and MaxLifetime bound the time the clients have to send their
+	// requests, the time the relays can stay idle and the lifetime of the connections, such as "10s"
+	// or "24h", none if empty.
+	HandshakeTimeout string `json:"handshake_timeout"`
+	IdleTimeout      string `json:"idle_timeout"`
+	MaxLifetime      string `json:"max_lifetime"`
+
+	// BindReply is the address replied to CONNECT requests: local, the local address of the outbound
+	// connection, ipv4-mapped, the same with IPv4 addresses as IPv6, or hidden, 0.0.0.0:0.
+	BindReply string `json:"bind_reply"`
+
+	// TrustedProxies are the IP addresses or CIDRs of the load balancers whose connections may begin
+	// with a PROXY protocol header, telling the address of the client.
+	TrustedProxies []string `json:"trusted_proxies"`
+
+	// ProxyHeader are the destinations, in the NO_PROXY syntax of socks.ParseNoProxy, which are sent
+	// a PROXY protocol version 2 header with the address and the username of the client.
+	ProxyHeader []string `json:"proxy_header"`
+}
+
+// RuleConfig matches requests, an empty field matches any request.
+type RuleConfig struct {
+	Action       string   `json:"action"`       // allow or deny
+	Users        []string `json:"users"`        // authenticated usernames
+	Clients      []string `json:"clients"`      // IP addresses or CIDRs of the clients
+	Destinations []string `json:"destinations"` // destinations, in the NO_PROXY syntax of socks.ParseNoProxy
+	Commands     []string `json:"commands"`     // connect, bind or associate
+}
+
+// DNSConfig configures how the daemon resolves the domain names, the answers being cached.
+type DNSConfig struct {
+	Server string              `json:"server"` // URL of the DNS server, see dns.Client, the system resolver if empty
+	Users  map[string]string   `json:"users"`  // URLs of the DNS servers of some users, overriding Server
+	Prefer string              `json:"prefer"` // ipv4 or ipv6, in the order of the answers if empty
+	Hosts  map[string][]string `json:"hosts"`  // static addresses of names
+}
+
+// LoadConfig reads a JSON configuration file, unknown fields are rejected.
+func LoadConfig(path string) (c Config, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return c, err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(&c); err != nil {
+		return c, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// settings are built from a Config, and swapped on reload.
+type settings struct {
+	users    users
+	rules    []rule
+	deny     bool // default action
+	upstream socks.ContextDialer
+	resolver socks.Resolver // nil if the daemon doesn't resolve the names
+	json     bool
+}
+
+func (c *Config) build() (*settings, error) {
+	s := &settings{users: make(users, len(c.Users))}
+
+	for u, p := range c.Users {
+		s.users[u] = p
+	}
+	if c.UsersFile != "" {
+		if err := s.users.load(c.UsersFile); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, rc := range c.Rules {
+		r, err := rc.build()
+		if err != nil {
+			return nil, err
+		}
+		s.rules = append(s.rules, r)
+	}
+
+	switch c.Default {
+	case "", "allow":
+	case "deny":
+		s.deny = true
+	default:
+		return nil, ErrUnknownAction
+	}
+
+	if c.Upstream != "" {
+		d, err := socks.FromURL(c.Upstream)
+		if err != nil {
+			return nil, fmt.Errorf("upstream: %w", err)
+		}
+		s.upstream = d
+	}
+
+	if c.DNS != nil {
+		p, err := c.DNS.build()
+		if err != nil {
+			return nil, fmt.Errorf("dns: %w", err)
+		}
+		s.resolver = p
+	}
+
+	switch c.LogFormat {
+	case "", "text":
+	case "json":
+		s.json = true
+	default:
+		return nil, ErrUnknownLogFormat
+	}
+
+	if _, err := c.validation(); err != nil {
+		return nil, err
+	}
+	if _, err := c.attemptDelay(); err != nil {
+		return nil, err
+	}
+	if _, _, _, err := c.timeouts(); err != nil {
+		return nil, err
+	}
+	if _, err := c.bindReply(); err != nil {
+		return nil, err
+	}
+	if _, err := c.trustedProxies(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (c *Config) validation() (s5.Validation, error) {
+	switch c.Validation {
+	case "", "lenient":
+		return s5.Lenient, nil
+	case "strict":
+		return s5.Strict, nil
+	}
+	return 0, ErrUnknownValidation
+}
+
+func (c *Config) bindReply() (socks.BindReply, error) {
+	switch c.BindReply {
+	case "", "local":
+		return socks.BindLocal, nil
+	case "ipv4-mapped":
+		return socks.BindIPv4Mapped, nil
+	case "hidden":
+		return socks.BindHidden, nil
+	}
+	return 0, ErrUnknownBindReply
+}
+
+func (c *Config) trustedProxies() (prefixes []netip.Prefix, err error) {
+	for _, tp := range c.TrustedProxies {
+		p, err := parsePrefix(tp)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", tp, err)
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes, nil
+}
+
+func (c *Config) attemptDelay() (time.Duration, error) {
+	return parseDuration("attempt delay", c.AttemptDelay)
+}
+
+// timeouts returns the handshake timeout, the idle timeout and the maximum lifetime.
+func (c *Config) timeouts() (handshake, idle, lifetime time.Duration, err error) {
+	if handshake, err = parseDuration("handshake timeout", c.HandshakeTimeout); err != nil {
+		return
+	}
+	if idle, err = parseDuration("idle timeout", c.IdleTimeout); err != nil {
+		return
+	}
+	lifetime, err = parseDuration("max lifetime", c.MaxLifetime)
+	return
+}
+
+// parseDuration parses the duration of the named setting, zero if empty.
+func parseDuration(name, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return d, nil
+}
+
+func (dc *DNSConfig) build() (*socks.DNSPolicy, error) {
+	r, err := newResolver(dc.Server)
+	if err != nil {
+		return nil, err
+	}
+	p := &socks.DNSPolicy{Resolver: r}
+
+	if len(dc.Users) > 0 {
+		router := &socks.ResolverRouter{Default: r}
+		for user, server := range dc.Users {
+			if r, err = newResolver(server); err != nil {
+				return nil, err
+			}
+			router.Routes = append(router.Routes, socks.ResolverRoute{Users: []string{user}, Resolver: r})
+		}
+		p.Resolver = router
+	}
+
+	switch dc.Prefer {
+	case "":
+	case "ipv4":
+		p.Prefer = socks.PreferIPv4
+	case "ipv6":
+		p.Prefer = socks.PreferIPv6
+	default:
+		return nil, ErrUnknownPreference
+	}
+
+	if len(dc.Hosts) > 0 {
+		p.Hosts = make(map[string][]netip.Addr, len(dc.Hosts))
+		for name, addrs := range dc.Hosts {
+			name = strings.ToLower(strings.TrimSuffix(name, "."))
+			for _, a := range addrs {
+				addr, err := netip.ParseAddr(a)
+				if err != nil {
+					return nil, fmt.Errorf("host %q: %w", name, err)
+				}
+				p.Hosts[name] = append(p.Hosts[name], addr)
+			}
+		}
+	}
+	return p, nil
+}
+
+// newResolver returns a cache of the answers of a DNS server, or of the system resolver if server is empty.
+func newResolver(server string) (socks.Resolver, error) {
+	if server == "" {
+		return &dns.Cache{}, nil
+	}
+	if scheme, _, ok := strings.Cut(server, "://"); ok {
+		switch scheme {
+		case "udp", "tcp", "tls", "https":
+		default:
+			return nil, ErrUnknownDNSScheme
+		}
+	}
+	return &dns.Cache{Resolver: &dns.Client{Server: server}}, nil
+}
+
+// users is a socks.CredentialStore of clear or hashed passwords.
+type users map[string]string
+
+// Valid reports whether the password of username matches, stored in clear or as "sha256:" and its hex digest.
+func (u users) Valid(username, password string) bool {
+	stored, ok := u[username]
+	if !ok {
+		return false
+	}
+	if digest, ok := strings.CutPrefix(stored, "sha256:"); ok {
+		sum := sha256.Sum256([]byte(password))
+		password, stored = hex.EncodeToString(sum[:]), strings.ToLower(digest)
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+}
+
+// load adds the "user:password" lines of a file, blank lines and lines starting with # are skipped.
+func (u users) load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		username, password, ok := strings.Cut(text, ":")
+		if !ok || username == "" {
+			return fmt.Errorf("%s:%d: expected user:password", path, line)
+		}
+		u[username] = password
+	}
+	return scanner.Err()
+}
+
+// rule is a parsed RuleConfig.
+type rule struct {
+	deny         bool
+	users        map[string]bool
+	clients      []netip.Prefix
+	destinations socks.NoProxy
+	commands     map[uint8]bool
+}
+
+var commands = map[string]uint8{
+	"connect":   s5.CommandConnect,
+	"bind":      s5.CommandBind,
+	"associate": s5.CommandAssociate,
+}
+
+// parsePrefix parses an IP address or a CIDR.
+func parsePrefix(s string) (netip.Prefix, error) {
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return p, err
+		}
+		p = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+	}
+	return p.Masked(), nil
+}
+
+func (rc *RuleConfig) build() (r rule, err error) {
+	switch rc.Action {
+	case "allow":
+	case "deny":
+		r.deny = true
+	default:
+		return r, ErrUnknownAction
+	}
+
+	if len(rc.Users) > 0 {
+		r.users = make(map[string]bool, len(rc.Users))
+		for _, u := range rc.Users {
+			r.users[u] = true
+		}
+	}
+
+	for _, c := range rc.Clients {
+		p, err := parsePrefix(c)
+		if err != nil {
+			return r, fmt.Errorf("rule client %q: %w", c, err)
+		}
+		r.clients = append(r.clients, p)
+	}
+
+	r.destinations = socks.ParseNoProxy(strings.Join(rc.Destinations, ","))
+
+	if len(rc.Commands) > 0 {
+		r.commands = make(map[uint8]bool, len(rc.Commands))
+		for _, c := range rc.Commands {
+			command, ok := commands[strings.ToLower(c)]
+			if !ok {
+				return r, ErrUnknownCommand
+			}
+			r.commands[command] = true
+		}
+	}
+	return r, nil
+}
+
+// matches reports whether the rule applies to the request.
+func (r *rule) matches(req *socks.Request) bool {
+	if r.users != nil && !r.users[req.Username] {
+		return false
+	}
+	if r.commands != nil && !r.commands[req.Command] {
+		return false
+	}
+	if len(r.destinations) > 0 && !r.destinations.Excludes(req.Address()) &&
+		!(req.IP.IsValid() && r.destinations.Excludes(netip.AddrPortFrom(req.IP, req.Port).String())) {
+		return false
+	}
+	if len(r.clients) > 0 {
+		client := clientAddr(req)
+		for _, p := range r.clients {
+			if p.Contains(client) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// allow applies the rules to the request.
+func (s *settings) allow(req *socks.Request) bool {
+	for i := range s.rules {
+		if s.rules[i].matches(req) {
+			return !s.rules[i].deny
+		}
+	}
+	return !s.deny
+}
+
+// clientAddr returns the IP address of the client, invalid if unknown.
+func clientAddr(req *socks.Request) netip.Addr {
+	if req.RemoteAddr == nil {
+		return netip.Addr{}
+	}
+	ap, err := netip.ParseAddrPort(req.RemoteAddr.String())
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ap.Addr().Unmap()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kayabe/socks"
+	"github.com/kayabe/socks/s5"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeFile(t, "socksd.json", `{
+		"listen": ["127.0.0.1:1080"],
+		"users": {"alice": "secret"},
+		"rules": [{"action": "deny", "destinations": ["10.0.0.0/8"]}],
+		"default": "allow",
+		"log_format": "json"
+	}`)
+
+	c, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"127.0.0.1:1080"}, c.Listen)
+	assert.Equal(t, "secret", c.Users["alice"])
+	assert.Len(t, c.Rules, 1)
+
+	_, err = LoadConfig(writeFile(t, "bad.json", `{"listen": [":1080"], "unknown": true}`))
+	assert.Error(t, err)
+}
+
+func TestFlagsOverride(t *testing.T) {
+	path := writeFile(t, "socksd.json", `{"listen": [":1080"], "log_format": "json", "default": "deny"}`)
+
+	f, err := parseFlags([]string{"-config", path, "-listen", "127.0.0.1:1081,[::1]:1081", "-default", "allow"}, os.Stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := f.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"127.0.0.1:1081", "[::1]:1081"}, c.Listen)
+	assert.Equal(t, "allow", c.Default)
+	assert.Equal(t, "json", c.LogFormat, "not overridden")
+
+	f, _ = parseFlags(nil, os.Stderr)
+	c, _ = f.load()
+	assert.Equal(t, []string{":1080"}, c.Listen)
+}
+
+func TestTrustedProxies(t *testing.T) {
+	f, _ := parseFlags([]string{"-trusted-proxies", "10.0.0.1,192.0.2.7/24", "-proxy-header", "backend.internal:25"}, os.Stderr)
+	c, _ := f.load()
+	prefixes, err := c.trustedProxies()
+	assert.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("10.0.0.1/32"), netip.MustParsePrefix("192.0.2.0/24")}, prefixes)
+	assert.Equal(t, []string{"backend.internal:25"}, c.ProxyHeader)
+}
+
+func TestUsers(t *testing.T) {
+	path := writeFile(t, "users", "# comment\n\nbob:hunter2\n"+
+		"carol:sha256:2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b\n")
+
+	c := Config{Users: map[string]string{"alice": "secret"}, UsersFile: path}
+	s, err := c.build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, s.users.Valid("alice", "secret"))
+	assert.True(t, s.users.Valid("bob", "hunter2"))
+	assert.True(t, s.users.Valid("carol", "secret"))
+	assert.False(t, s.users.Valid("carol", "sha256:2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"))
+	assert.False(t, s.users.Valid("bob", "hunter3"))
+	assert.False(t, s.users.Valid("dave", ""))
+
+	c.UsersFile = writeFile(t, "users", "nopassword\n")
+	_, err = c.build()
+	assert.Error(t, err)
+}
+
+func TestRules(t *testing.T) {
+	c := Config{
+		Rules: []RuleConfig{
+			{Action: "allow", Users: []string{"admin"}},
+			{Action: "deny", Destinations: []string{"10.0.0.0/8", ".internal"}},
+			{Action: "deny", Commands: []string{"bind"}},
+			{Action: "allow", Clients: []string{"127.0.0.1", "192.168.0.0/16"}},
+		},
+		Default: "deny",
+	}
+	s, err := c.build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5000}
+	remote := &net.TCPAddr{IP: net.IPv4(8, 8, 8, 8), Port: 5000}
+
+	for _, tt := range []struct {
+		req     socks.Request
+		allowed bool
+	}{
+		{socks.Request{Command: s5.CommandConnect, Host: "10.1.1.1", Port: 80, Username: "admin", RemoteAddr: remote}, true},
+		{socks.Request{Command: s5.CommandConnect, Host: "10.1.1.1", Port: 80, RemoteAddr: local}, false},
+		{socks.Request{Command: s5.CommandConnect, Host: "db.internal", Port: 5432, RemoteAddr: local}, false},
+		{socks.Request{Command: s5.CommandBind, Host: "example.com", Port: 80, RemoteAddr: local}, false},
+		{socks.Request{Command: s5.CommandConnect, Host: "example.com", Port: 80, RemoteAddr: local}, true},
+		{socks.Request{Command: s5.CommandConnect, Host: "example.com", Port: 80, RemoteAddr: remote}, false},
+		{socks.Request{Command: s5.CommandConnect, Host: "example.com", Port: 80, RemoteAddr: local, IP: netip.MustParseAddr("192.0.2.1")}, true},
+		{socks.Request{Command: s5.CommandConnect, Host: "rebinding.example", Port: 80, RemoteAddr: local, IP: netip.MustParseAddr("10.1.1.1")}, false},
+	} {
+		assert.Equal(t, tt.allowed, s.allow(&tt.req), tt.req)
+	}
+
+	for _, c := range []Config{
+		{Rules: []RuleConfig{{Action: "permit"}}},
+		{Rules: []RuleConfig{{Action: "allow", Commands: []string{"udp"}}}},
+		{Rules: []RuleConfig{{Action: "allow", Clients: []string{"nope"}}}},
+		{Default: "maybe"},
+		{LogFormat: "xml"},
+		{Validation: "paranoid"},
+		{AttemptDelay: "soon"},
+		{HandshakeTimeout: "1x"},
+		{IdleTimeout: "forever"},
+		{MaxLifetime: "1 day"},
+		{BindReply: "ipv6"},
+		{TrustedProxies: []string{"lb.internal"}},
+		{Upstream: "ftp://proxy.test"},
+		{DNS: &DNSConfig{Server: "quic://dns.test"}},
+		{DNS: &DNSConfig{Users: map[string]string{"alice": "ftp://dns.test"}}},
+		{DNS: &DNSConfig{Prefer: "ipv5"}},
+		{DNS: &DNSConfig{Hosts: map[string][]string{"example.test": {"nope"}}}},
+	} {
+		_, err := c.build()
+		assert.Error(t, err, c)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kayabe/socks"
+	"github.com/kayabe/socks/internal/counting"
+)
+
+// daemon serves a socks.Server with reloadable settings, it is the
+// CredentialStore, Rule and Dialer of the server.
+type daemon struct {
+	flags           *flags
+	config          Config // as loaded at startup, the listeners aren't reloaded
+	shutdownTimeout time.Duration
+
+	settings atomic.Pointer[settings]
+	auth     bool        // whether authentication is required, fixed at startup
+	direct   *net.Dialer // dials without upstream, racing the addresses of the names with the attempt delay
+
+	out *logWriter
+	log *log.Logger
+
+	server    *socks.Server
+	listeners []net.Listener
+	metrics   *http.Server
+	metricsL  net.Listener
+}
+
+func newDaemon(args []string, output io.Writer) (*daemon, error) {
+	f, err := parseFlags(args, output)
+	if err != nil {
+		return nil, err
+	}
+	c, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	s, err := c.build()
+	if err != nil {
+		return nil, err
+	}
+	validation, _ := c.validation()
+	attemptDelay, _ := c.attemptDelay()
+	bindReply, _ := c.bindReply()
+	trustedProxies, _ := c.trustedProxies()
+	handshakeTimeout, idleTimeout, maxLifetime, _ := c.timeouts()
+
+	fallbackDelay := attemptDelay
+	if fallbackDelay == 0 {
+		fallbackDelay = socks.DefaultAttemptDelay
+	}
+
+	d := &daemon{flags: f, config: c, shutdownTimeout: f.shutdownTimeout, out: newLogWriter(output), auth: len(s.users) > 0}
+	d.direct = &net.Dialer{FallbackDelay: fallbackDelay}
+	d.log = log.New(d.out, "", 0)
+	d.out.json.Store(s.json)
+	d.settings.Store(s)
+
+	d.server = &socks.Server{
+		Rules:        d,
+		Dialer:       counting.Dialer{Dialer: d, C: &counters},
+		Validation:   validation,
+		AttemptDelay: attemptDelay,
+		BindReply:    bindReply,
+		Connected:    d.connected,
+		ErrorLog:     d.log,
+
+		HandshakeTimeout: handshakeTimeout,
+		IdleTimeout:      idleTimeout,
+		MaxLifetime:      maxLifetime,
+		Closed:           d.closed,
+
+		TrustedProxies: trustedProxies,
+		ProxyHeader:    socks.ParseNoProxy(strings.Join(c.ProxyHeader, ",")),
+	}
+	if d.auth {
+		d.server.Credentials = d
+	}
+	if s.resolver != nil {
+		d.server.Resolver = d
+	}
+	return d, nil
+}
+
+// listen opens the listeners and the metrics listener.
+func (d *daemon) listen() error {
+	for _, addr := range d.config.Listen {
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			d.closeListeners()
+			return err
+		}
+		d.listeners = append(d.listeners, counting.Listener{Listener: l, C: &counters})
+	}
+	if d.config.Metrics != "" {
+		l, err := net.Listen("tcp", d.config.Metrics)
+		if err != nil {
+			d.closeListeners()
+			return err
+		}
+		d.metrics = &http.Server{Handler: metricsHandler(), ReadHeaderTimeout: 10 * time.Second}
+		d.metricsL = l
+		d.log.Printf("socksd: metrics on %s", l.Addr())
+		go func() { _ = d.metrics.Serve(l) }()
+	}
+	return nil
+}
+
+func (d *daemon) closeListeners() {
+	for _, l := range d.listeners {
+		l.Close()
+	}
+	d.listeners = nil
+}
+
+// serve serves the listeners, the errors of the listeners failing are sent on the returned channel.
+func (d *daemon) serve() <-chan error {
+	errs := make(chan error, len(d.listeners))
+	for _, l := range d.listeners {
+		l := l
+		d.log.Printf("socksd: listening on %s", l.Addr())
+		go func() {
+			var err error
+			if d.config.TLSCert != "" || d.config.TLSKey != "" {
+				err = d.server.ServeTLS(l, d.config.TLSCert, d.config.TLSKey)
+			} else {
+				err = d.server.Serve(l)
+			}
+			if !errors.Is(err, socks.ErrServerClosed) {
+				errs <- err
+			}
+		}()
+	}
+	return errs
+}
+
+// shutdown gracefully shuts the servers down, and closes the remaining connections once ctx is done.
+func (d *daemon) shutdown(ctx context.Context) error {
+	if d.metrics != nil {
+		_ = d.metrics.Shutdown(ctx)
+	}
+	err := d.server.Shutdown(ctx)
+	if err != nil {
+		d.server.Close()
+	}
+	return err
+}
+
+// reload reads the configuration again, the previous settings are kept if it is invalid
+// or if it enables or disables authentication or dns, which are fixed at startup.
+func (d *daemon) reload() {
+	metrics.reloads.Add(1)
+
+	c, err := d.flags.load()
+	var s *settings
+	if err == nil {
+		s, err = c.build()
+	}
+	if err == nil && len(s.users) > 0 != d.auth {
+		err = ErrAuthRestart
+	}
+	if err == nil && (c.DNS != nil) != (d.config.DNS != nil) {
+		err = ErrDNSRestart
+	}
+	if err != nil {
+		metrics.reloadErrors.Add(1)
+		d.log.Printf("socksd: reload: %v", err)
+		return
+	}
+
+	if !equal(c.Listen, d.config.Listen) || c.Metrics != d.config.Metrics || c.TLSCert != d.config.TLSCert || c.TLSKey != d.config.TLSKey ||
+		c.Validation != d.config.Validation || c.AttemptDelay != d.config.AttemptDelay || c.BindReply != d.config.BindReply ||
+		!equal(c.TrustedProxies, d.config.TrustedProxies) || !equal(c.ProxyHeader, d.config.ProxyHeader) ||
+		c.HandshakeTimeout != d.config.HandshakeTimeout || c.IdleTimeout != d.config.IdleTimeout || c.MaxLifetime != d.config.MaxLifetime {
+		d.log.Printf("socksd: reload: listen, metrics, tls, validation, attempt delay, bind reply, proxy protocol and timeout changes require a restart")
+	}
+
+	d.out.json.Store(s.json)
+	d.settings.Store(s)
+	d.log.Printf("socksd: configuration reloaded")
+}
+
+// Valid implements socks.CredentialStore.
+func (d *daemon) Valid(username, password string) bool {
+	return d.settings.Load().users.Valid(username, password)
+}
+
+// Allow implements socks.Rule, and logs the requests.
+func (d *daemon) Allow(_ context.Context, req *socks.Request) bool {
+	allowed := d.settings.Load().allow(req)
+
+	if req.IP.IsValid() {
+		// checked again for an address the name resolved to, counted apart from the requests
+		// and only logged when denied
+		if !allowed {
+			metrics.addressesDenied.Add(1)
+			d.log.Printf("%s %s %s %s (%s) denied", req.RemoteAddr, logUser(req), req.Protocol, req.Address(), req.IP)
+		}
+		return allowed
+	}
+
+	verdict := "allowed"
+	if allowed {
+		metrics.allowed.Add(1)
+	} else {
+		metrics.denied.Add(1)
+		verdict = "denied"
+	}
+
+	d.log.Printf("%s %s %s %s %s", req.RemoteAddr, logUser(req), req.Protocol, req.Address(), verdict)
+	return allowed
+}
+
+// connected logs the address a request was connected to, and the local address of the connection.
+func (d *daemon) connected(req *socks.Request, target net.Conn) {
+	d.log.Printf("%s %s %s %s connected to %s from %s", req.RemoteAddr, logUser(req), req.Protocol, req.Address(), target.RemoteAddr(), target.LocalAddr())
+}
+
+// closed counts the connections closed by the timeouts, the server logging their reason.
+func (d *daemon) closed(_ net.Conn, err error) {
+	switch err {
+	case socks.ErrHandshakeTimeout:
+		metrics.handshakeTimeouts.Add(1)
+	case socks.ErrIdleTimeout:
+		metrics.idleTimeouts.Add(1)
+	case socks.ErrMaxLifetime:
+		metrics.maxLifetimes.Add(1)
+	}
+}
+
+func logUser(req *socks.Request) string {
+	if req.Username == "" {
+		return "-"
+	}
+	return req.Username
+}
+
+// DialContext implements socks.ContextDialer, through the upstream proxy server if any.
+func (d *daemon) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	var dialer socks.ContextDialer = d.settings.Load().upstream
+	if dialer == nil {
+		dialer = d.direct
+	}
+	return dialer.DialContext(ctx, network, address)
+}
+
+// LookupNetIP implements socks.Resolver, with the resolver of the settings.
+func (d *daemon) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	var r socks.Resolver = d.settings.Load().resolver
+	if r == nil {
+		r = net.DefaultResolver
+	}
+	return r.LookupNetIP(ctx, network, host)
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kayabe/socks"
+	"github.com/kayabe/socks/s5"
+	"github.com/stretchr/testify/assert"
+)
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+func startEcho(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func startDaemon(t *testing.T, logs io.Writer, args ...string) *daemon {
+	d, err := newDaemon(append([]string{"-listen", "127.0.0.1:0", "-metrics", "127.0.0.1:0"}, args...), logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.listen(); err != nil {
+		t.Fatal(err)
+	}
+	d.serve()
+	t.Cleanup(func() { _ = d.shutdown(context.Background()) })
+	return d
+}
+
+func TestDaemon(t *testing.T) {
+	echo := startEcho(t)
+	config := writeFile(t, "socksd.json", `{"users": {"alice": "secret"}}`)
+
+	var logs syncBuffer
+	d := startDaemon(t, &logs, "-config", config, "-deny", "127.0.0.2")
+	proxy := d.listeners[0].Addr().String()
+
+	client, _ := socks.NewClient(proxy, socks.WithUserPW("alice", "secret"))
+	conn, err := client.Dial("tcp", echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+	conn.Close()
+
+	_, err = client.Dial("tcp", "127.0.0.2:80")
+	assert.Equal(t, s5.ErrReplyConnectionNotAllowed, err)
+
+	wrong, _ := socks.NewClient(proxy, socks.WithUserPW("alice", "wrong"))
+	_, err = wrong.Dial("tcp", echo)
+	assert.Error(t, err)
+
+	assert.Contains(t, logs.String(), "alice socks5 "+echo+" allowed")
+	assert.Contains(t, logs.String(), "alice socks5 "+echo+" connected to "+echo+" from 127.0.0.1:")
+	assert.Contains(t, logs.String(), "alice socks5 127.0.0.2:80 denied")
+
+	// reload with another password and JSON logs
+	if err := os.WriteFile(config, []byte(`{"users": {"alice": "changed"}, "log_format": "json"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	d.reload()
+	_, err = client.Dial("tcp", echo)
+	assert.Error(t, err)
+	client, _ = socks.NewClient(proxy, socks.WithUserPW("alice", "changed"))
+	conn, err = client.Dial("tcp", echo)
+	assert.NoError(t, err)
+	conn.Close()
+	assert.Contains(t, logs.String(), `"msg":"`)
+
+	// an invalid configuration keeps the previous one
+	_ = os.WriteFile(config, []byte(`{"default": "maybe"}`), 0o600)
+	d.reload()
+	conn, err = client.Dial("tcp", echo)
+	assert.NoError(t, err)
+	conn.Close()
+	assert.Contains(t, logs.String(), "reload: "+ErrUnknownAction.Error())
+
+	// so does one enabling or disabling authentication or dns, fixed at startup
+	reloadErrors := metrics.reloadErrors.Value()
+	for _, tt := range []struct {
+		config string
+		err    error
+	}{
+		{`{}`, ErrAuthRestart},
+		{`{"users": {"alice": "changed"}, "dns": {}}`, ErrDNSRestart},
+	} {
+		_ = os.WriteFile(config, []byte(tt.config), 0o600)
+		d.reload()
+		conn, err = client.Dial("tcp", echo)
+		if assert.NoError(t, err) {
+			conn.Close()
+		}
+		assert.Contains(t, logs.String(), "reload: "+tt.err.Error())
+	}
+	assert.Equal(t, reloadErrors+2, metrics.reloadErrors.Value())
+
+	resp, err := http.Get("http://" + d.metricsL.Addr().String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Contains(t, string(body), "socksd_requests_denied ")
+	assert.Contains(t, string(body), "socksd_bytes_sent ")
+	assert.NotContains(t, string(body), "socksd_bytes_sent 0\n", "the ping was counted")
+}
+
+func TestDaemonAttemptDelay(t *testing.T) {
+	// without dns, the names are left to the direct dialer which races their addresses
+	d := startDaemon(t, io.Discard, "-attempt-delay", "50ms")
+	assert.Equal(t, 50*time.Millisecond, d.direct.FallbackDelay)
+
+	d = startDaemon(t, io.Discard)
+	assert.Equal(t, socks.DefaultAttemptDelay, d.direct.FallbackDelay)
+}
+
+func TestDaemonTimeouts(t *testing.T) {
+	var logs syncBuffer
+	d := startDaemon(t, &logs, "-handshake-timeout", "50ms", "-idle-timeout", "1m", "-max-lifetime", "1h")
+	assert.Equal(t, time.Minute, d.server.IdleTimeout)
+	assert.Equal(t, time.Hour, d.server.MaxLifetime)
+
+	before := metrics.handshakeTimeouts.Value()
+	conn, err := net.Dial("tcp", d.listeners[0].Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadAll(conn)
+	assert.NoError(t, err, "closed by the daemon")
+	assert.Equal(t, before+1, metrics.handshakeTimeouts.Value())
+	assert.Contains(t, logs.String(), socks.ErrHandshakeTimeout.Error())
+}
+
+func TestDaemonUpstream(t *testing.T) {
+	echo := startEcho(t)
+
+	first := startDaemon(t, io.Discard, "-deny", "*")
+	upstream := first.listeners[0].Addr().String()
+	second := startDaemon(t, io.Discard, "-upstream", "socks5h://"+upstream)
+
+	client, _ := socks.NewClient(second.listeners[0].Addr().String())
+	_, err := client.Dial("tcp", echo)
+	assert.Equal(t, s5.ErrReplyConnectionNotAllowed, err, "denied by the upstream")
+
+	first.flags.set["deny"] = false
+	first.flags.Rules = nil
+	first.reload()
+	conn, err := client.Dial("tcp", echo)
+	if assert.NoError(t, err) {
+		conn.Close()
+	}
+}
+
+func TestDaemonDNS(t *testing.T) {
+	echo := startEcho(t)
+	_, port, _ := net.SplitHostPort(echo)
+	config := writeFile(t, "socksd.json", `{"dns": {"hosts": {"echo.test": ["127.0.0.1"], "rebinding.test": ["127.0.0.2"]}}}`)
+
+	var logs syncBuffer
+	d := startDaemon(t, &logs, "-config", config, "-deny", "127.0.0.2")
+	client, _ := socks.NewClient(d.listeners[0].Addr().String())
+	allowed, denied, addressesDenied := metrics.allowed.Value(), metrics.denied.Value(), metrics.addressesDenied.Value()
+
+	conn, err := client.Dial("tcp", "echo.test:"+port)
+	if assert.NoError(t, err) {
+		conn.Close()
+	}
+	_, err = client.Dial("tcp", "rebinding.test:"+port)
+	assert.Equal(t, s5.ErrReplyConnectionNotAllowed, err)
+	_, err = client.Dial("tcp", "nowhere.invalid:"+port)
+	assert.Equal(t, s5.ErrReplyHostUnreachable, err)
+
+	assert.Contains(t, logs.String(), "- socks5 echo.test:"+port+" allowed")
+	assert.Contains(t, logs.String(), "- socks5 rebinding.test:"+port+" allowed")
+	assert.Contains(t, logs.String(), "- socks5 rebinding.test:"+port+" (127.0.0.2) denied")
+
+	// each request is counted once, the denied addresses apart
+	assert.Equal(t, allowed+3, metrics.allowed.Value())
+	assert.Equal(t, denied, metrics.denied.Value())
+	assert.Equal(t, addressesDenied+1, metrics.addressesDenied.Value())
+}
+
+func TestDaemonShutdown(t *testing.T) {
+	echo := startEcho(t)
+	d := startDaemon(t, io.Discard)
+	proxy := d.listeners[0].Addr().String()
+
+	client, _ := socks.NewClient(proxy)
+	conn, err := client.Dial("tcp", echo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, d.shutdown(ctx))
+
+	// the connection was closed after the deadline
+	_, err = io.ReadAll(conn)
+	assert.NoError(t, err)
+	_, err = client.Dial("tcp", echo)
+	assert.Error(t, err)
+}
+
+func TestFlagErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{"-user", "nocolon"},
+		{"-listen", ""},
+		{"extra"},
+		{"-config", "/nonexistent/socksd.json"},
+		{"-log-format", "xml"},
+	} {
+		_, err := newDaemon(args, io.Discard)
+		assert.Error(t, err, strings.Join(args, " "))
+	}
+}
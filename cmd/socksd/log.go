@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// logWriter writes the lines of a log.Logger without flags, timestamped, as text or as JSON objects.
+type logWriter struct {
+	json atomic.Bool
+
+	mu  sync.Mutex
+	out io.Writer
+	now func() time.Time
+}
+
+func newLogWriter(out io.Writer) *logWriter {
+	return &logWriter{out: out, now: time.Now}
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	t := w.now().UTC()
+	msg := strings.TrimSuffix(string(p), "\n")
+
+	var line []byte
+	if w.json.Load() {
+		line, _ = json.Marshal(struct {
+			Time string `json:"time"`
+			Msg  string `json:"msg"`
+		}{t.Format(time.RFC3339Nano), msg})
+		line = append(line, '\n')
+	} else {
+		line = append(t.AppendFormat(nil, time.RFC3339), ' ')
+		line = append(append(line, msg...), '\n')
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err := w.out.Write(line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
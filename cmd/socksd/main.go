@@ -0,0 +1,226 @@
+// Command socksd runs a SOCKS4, SOCKS4A, SOCKS5 and HTTP proxy server.
+//
+// It is configured with a JSON file, see Config, and flags overriding it:
+//
+//	socksd -listen :1080,[::1]:1080 -user alice:secret -deny 10.0.0.0/8 -metrics 127.0.0.1:9100
+//
+// SIGHUP reloads the configuration file on unix, the users, rules, upstream, DNS servers and log format
+// take effect for the following requests. SIGINT and SIGTERM shut the server down
+// gracefully, waiting up to -shutdown-timeout for the connections to finish.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+func main() {
+	d, err := newDaemon(os.Args[1:], os.Stderr)
+	if err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "socksd:", err)
+		}
+		os.Exit(2)
+	}
+
+	if err := d.listen(); err != nil {
+		fmt.Fprintln(os.Stderr, "socksd:", err)
+		os.Exit(1)
+	}
+	errs := d.serve()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, append([]os.Signal{syscall.SIGINT, syscall.SIGTERM}, reloadSignals...)...)
+
+	for {
+		select {
+		case sig := <-signals:
+			if isReload(sig) {
+				d.reload()
+				continue
+			}
+			d.log.Printf("socksd: %v, shutting down", sig)
+		case err := <-errs:
+			d.log.Printf("socksd: %v", err)
+		}
+		break
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.shutdownTimeout)
+	defer cancel()
+	if err := d.shutdown(ctx); err != nil {
+		d.log.Printf("socksd: shutdown: %v", err)
+		os.Exit(1)
+	}
+}
+
+func isReload(sig os.Signal) bool {
+	for _, s := range reloadSignals {
+		if sig == s {
+			return true
+		}
+	}
+	return false
+}
+
+// flags holds the command line, the flags which were set override the configuration file.
+type flags struct {
+	config          string
+	shutdownTimeout time.Duration
+	dns             string
+	Config
+	set map[string]bool
+}
+
+func parseFlags(args []string, output io.Writer) (*flags, error) {
+	f := &flags{set: make(map[string]bool)}
+
+	fs := flag.NewFlagSet("socksd", flag.ContinueOnError)
+	fs.SetOutput(output)
+	fs.StringVar(&f.config, "config", "", "JSON configuration `file`")
+	fs.DurationVar(&f.shutdownTimeout, "shutdown-timeout", 30*time.Second, "how long to wait for the connections to finish on shutdown")
+	fs.Func("listen", "comma separated `addresses` to listen on (default :1080)", func(s string) error {
+		f.Listen = append(f.Listen, strings.Split(s, ",")...)
+		return nil
+	})
+	fs.StringVar(&f.TLSCert, "tls-cert", "", "certificate `file` to serve SOCKS over TLS")
+	fs.StringVar(&f.TLSKey, "tls-key", "", "private key `file` to serve SOCKS over TLS")
+	fs.Func("user", "`user:password` accepted, can be repeated", func(s string) error {
+		username, password, ok := strings.Cut(s, ":")
+		if !ok || username == "" {
+			return errors.New("expected user:password")
+		}
+		if f.Users == nil {
+			f.Users = make(map[string]string)
+		}
+		f.Users[username] = password
+		return nil
+	})
+	fs.StringVar(&f.UsersFile, "users-file", "", "`file` of user:password lines")
+	for _, action := range []string{"allow", "deny"} {
+		action := action
+		fs.Func(action, action+" the comma separated `destinations`, rules are checked in order and can be repeated", func(s string) error {
+			f.Rules = append(f.Rules, RuleConfig{Action: action, Destinations: strings.Split(s, ",")})
+			return nil
+		})
+	}
+	fs.StringVar(&f.Default, "default", "", "action for the requests no rule matches, allow or deny (default allow)")
+	fs.StringVar(&f.Upstream, "upstream", "", "`URL` of a proxy server to reach the destinations through, such as socks5h://host:1080")
+	fs.StringVar(&f.dns, "dns", "", "`URL` of a DNS server to resolve the domain names with on the server, or system")
+	fs.StringVar(&f.LogFormat, "log-format", "", "log format, text or json (default text)")
+	fs.StringVar(&f.Metrics, "metrics", "", "`address` serving /metrics and /debug/vars over HTTP")
+	fs.StringVar(&f.Validation, "validation", "", "SOCKS5 validation, lenient or strict (default lenient)")
+	fs.StringVar(&f.BindReply, "bind-reply", "", "address replied to CONNECT requests, local, ipv4-mapped or hidden (default local)")
+	fs.Func("trusted-proxies", "comma separated IP addresses or `CIDRs` of the load balancers sending PROXY protocol headers", func(s string) error {
+		f.TrustedProxies = append(f.TrustedProxies, strings.Split(s, ",")...)
+		return nil
+	})
+	fs.Func("proxy-header", "comma separated `destinations` sent a PROXY protocol v2 header", func(s string) error {
+		f.ProxyHeader = append(f.ProxyHeader, strings.Split(s, ",")...)
+		return nil
+	})
+	fs.StringVar(&f.HandshakeTimeout, "handshake-timeout", "", "`duration` the clients have to negotiate and send their request (default none)")
+	fs.StringVar(&f.IdleTimeout, "idle-timeout", "", "`duration` after which the idle relays are closed (default none)")
+	fs.StringVar(&f.MaxLifetime, "max-lifetime", "", "`duration` after which the connections are closed (default none)")
+	fs.StringVar(&f.AttemptDelay, "attempt-delay", "", "`duration` the connection attempts to the addresses of a name wait for each other (default 250ms)")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments %q", fs.Args())
+	}
+	fs.Visit(func(fl *flag.Flag) { f.set[fl.Name] = true })
+	return f, nil
+}
+
+// load reads the configuration file if any, and applies the flags set.
+func (f *flags) load() (c Config, err error) {
+	if f.config != "" {
+		if c, err = LoadConfig(f.config); err != nil {
+			return c, err
+		}
+	}
+
+	if f.set["listen"] {
+		c.Listen = f.Listen
+	}
+	if f.set["tls-cert"] {
+		c.TLSCert = f.TLSCert
+	}
+	if f.set["tls-key"] {
+		c.TLSKey = f.TLSKey
+	}
+	if f.set["user"] {
+		c.Users = f.Users
+	}
+	if f.set["users-file"] {
+		c.UsersFile = f.UsersFile
+	}
+	if f.set["allow"] || f.set["deny"] {
+		c.Rules = f.Rules
+	}
+	if f.set["default"] {
+		c.Default = f.Default
+	}
+	if f.set["upstream"] {
+		c.Upstream = f.Upstream
+	}
+	if f.set["dns"] {
+		if c.DNS == nil {
+			c.DNS = &DNSConfig{}
+		}
+		c.DNS.Server = f.dns
+		if f.dns == "system" {
+			c.DNS.Server = ""
+		}
+	}
+	if f.set["log-format"] {
+		c.LogFormat = f.LogFormat
+	}
+	if f.set["metrics"] {
+		c.Metrics = f.Metrics
+	}
+	if f.set["validation"] {
+		c.Validation = f.Validation
+	}
+	if f.set["bind-reply"] {
+		c.BindReply = f.BindReply
+	}
+	if f.set["attempt-delay"] {
+		c.AttemptDelay = f.AttemptDelay
+	}
+	if f.set["handshake-timeout"] {
+		c.HandshakeTimeout = f.HandshakeTimeout
+	}
+	if f.set["idle-timeout"] {
+		c.IdleTimeout = f.IdleTimeout
+	}
+	if f.set["max-lifetime"] {
+		c.MaxLifetime = f.MaxLifetime
+	}
+	if f.set["trusted-proxies"] {
+		c.TrustedProxies = f.TrustedProxies
+	}
+	if f.set["proxy-header"] {
+		c.ProxyHeader = f.ProxyHeader
+	}
+
+	if len(c.Listen) == 0 {
+		c.Listen = []string{":1080"}
+	}
+	for _, addr := range c.Listen {
+		if addr == "" {
+			return c, ErrNoListen
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync/atomic"
+
+	"github.com/kayabe/socks/internal/counting"
+)
+
+// metrics are published with expvar under "socksd", and in the Prometheus text format on /metrics.
+var metrics = struct {
+	*expvar.Map
+	allowed, denied, addressesDenied, reloads, reloadErrors *expvar.Int
+	handshakeTimeouts, idleTimeouts, maxLifetimes           *expvar.Int
+}{Map: expvar.NewMap("socksd")}
+
+// counters count the connections of the listeners and of the dialer, published with metrics.
+var counters counting.Counters
+
+func init() {
+	for name, v := range map[string]**expvar.Int{
+		"requests_allowed":    &metrics.allowed,
+		"requests_denied":     &metrics.denied,
+		"addresses_denied":    &metrics.addressesDenied, // resolved addresses of allowed names denied
+		"reloads_total":       &metrics.reloads,
+		"reload_errors_total": &metrics.reloadErrors,
+
+		"closed_handshake_timeout_total": &metrics.handshakeTimeouts,
+		"closed_idle_timeout_total":      &metrics.idleTimeouts,
+		"closed_max_lifetime_total":      &metrics.maxLifetimes,
+	} {
+		*v = new(expvar.Int)
+		metrics.Set(name, *v)
+	}
+	for name, v := range map[string]*atomic.Int64{
+		"connections_total":  &counters.Accepted,
+		"connections_active": &counters.Active,
+		"dials_total":        &counters.Dials,
+		"dial_errors_total":  &counters.DialErrors,
+		"bytes_received":     &counters.Received,
+		"bytes_sent":         &counters.Sent,
+	} {
+		v := v
+		metrics.Set(name, expvar.Func(func() any { return v.Load() }))
+	}
+}
+
+// metricsHandler serves the metrics, /debug/vars serves every expvar.
+func metricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, _ *http.Request) {
+		var names []string
+		metrics.Do(func(kv expvar.KeyValue) { names = append(names, kv.Key) })
+		sort.Strings(names)
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		for _, name := range names {
+			fmt.Fprintf(w, "socksd_%s %s\n", name, metrics.Get(name).String())
+		}
+	})
+	return mux
+}
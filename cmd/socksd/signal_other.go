@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// reloadSignals reload the configuration file, none outside unix.
+var reloadSignals []os.Signal
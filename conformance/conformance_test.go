@@ -0,0 +1,55 @@
+package conformance
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/kayabe/socks"
+)
+
+func startServer(t *testing.T, s *socks.Server) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = s.Serve(l) }()
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String()
+}
+
+// refused returns an address nothing listens on.
+func refused(t *testing.T) Config {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	return Config{Refused: l.Addr().(*net.TCPAddr).AddrPort()}
+}
+
+func TestServer(t *testing.T) {
+	c := refused(t)
+	c.Echo = StartEcho(t)
+	RunServer(t, startServer(t, &socks.Server{}), ServerTranscripts(c))
+}
+
+func TestServerUserPW(t *testing.T) {
+	c := Config{Echo: StartEcho(t), Username: "user", Password: "pass"}
+	RunServer(t, startServer(t, &socks.Server{Credentials: socks.StaticCredentials{"user": "pass"}}), ServerTranscripts(c))
+}
+
+func TestClient(t *testing.T) {
+	dial := func(ctx context.Context, proxy, username, password, address string) (net.Conn, error) {
+		var options []func(*socks.Client)
+		if username != "" {
+			options = append(options, socks.WithUserPW(username, password))
+		}
+		client, err := socks.NewClient(proxy, options...)
+		if err != nil {
+			return nil, err
+		}
+		return client.DialContext(ctx, "tcp", address)
+	}
+	(&Runner{Errors: true}).Client(t, dial, ClientTranscripts())
+}
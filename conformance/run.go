@@ -0,0 +1,264 @@
+package conformance
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kayabe/socks/s5"
+)
+
+// DefaultTimeout bounds every transcript when Runner.Timeout is zero.
+const DefaultTimeout = 5 * time.Second
+
+// ClientDialer connects to address through the proxy server at proxy with the client under test,
+// authenticating with the username and password when set.
+type ClientDialer func(ctx context.Context, proxy, username, password, address string) (net.Conn, error)
+
+// Runner plays transcripts against an implementation, each one as a subtest.
+type Runner struct {
+	Timeout time.Duration // DefaultTimeout if zero
+
+	// Errors checks the client errors against Transcript.Err.
+	Errors bool
+}
+
+// RunServer plays the transcripts against the server listening at addr with the default Runner.
+func RunServer(t *testing.T, addr string, ts []Transcript) { new(Runner).Server(t, addr, ts) }
+
+// RunClient plays the transcripts against the client behind dial with the default Runner.
+func RunClient(t *testing.T, dial ClientDialer, ts []Transcript) { new(Runner).Client(t, dial, ts) }
+
+func (r *Runner) timeout() time.Duration {
+	if r.Timeout > 0 {
+		return r.Timeout
+	}
+	return DefaultTimeout
+}
+
+// Server plays the transcripts for ServerSide against the server listening at addr.
+func (r *Runner) Server(t *testing.T, addr string, ts []Transcript) {
+	for _, tr := range ts {
+		if tr.Side&ServerSide == 0 {
+			continue
+		}
+		tr := tr
+		t.Run(tr.Name, func(t *testing.T) {
+			conn, err := net.DialTimeout("tcp", addr, r.timeout())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			_ = conn.SetDeadline(time.Now().Add(r.timeout()))
+
+			for i, step := range tr.Steps {
+				if _, err := conn.Write(step.Send); err != nil {
+					t.Fatalf("step %d: send: %v", i, err)
+				}
+				if step.CloseWrite {
+					if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+						_ = cw.CloseWrite()
+					}
+				}
+				if err := expect(conn, step); err != nil {
+					t.Fatalf("step %d: %v", i, err)
+				}
+			}
+
+			if err := outcome(conn, tr.Outcome); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// Client plays the transcripts for ClientSide against the client behind dial,
+// the runner acting as the proxy server on a loopback address.
+func (r *Runner) Client(t *testing.T, dial ClientDialer, ts []Transcript) {
+	for _, tr := range ts {
+		if tr.Side&ClientSide == 0 {
+			continue
+		}
+		tr := tr
+		t.Run(tr.Name, func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+
+			ctx, cancel := context.WithTimeout(context.Background(), r.timeout())
+			defer cancel()
+
+			type result struct {
+				conn net.Conn
+				err  error
+			}
+			done := make(chan result, 1)
+			go func() {
+				conn, err := dial(ctx, l.Addr().String(), tr.Username, tr.Password, tr.Address)
+				done <- result{conn, err}
+			}()
+
+			server, err := l.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer server.Close()
+			_ = server.SetDeadline(time.Now().Add(r.timeout()))
+
+			for i, step := range tr.Steps {
+				got := make([]byte, len(step.Send))
+				if _, err := io.ReadFull(server, got); err != nil {
+					t.Fatalf("step %d: receive: %v, got % x", i, err, got)
+				}
+				if !bytes.Equal(got, step.Send) {
+					t.Fatalf("step %d: received % x, expected % x", i, got, step.Send)
+				}
+				if _, err := server.Write(answer(step)); err != nil {
+					t.Fatalf("step %d: answer: %v", i, err)
+				}
+			}
+
+			if tr.Outcome == Closed {
+				server.Close()
+			}
+
+			var res result
+			select {
+			case res = <-done:
+			case <-ctx.Done():
+				t.Fatal("the client didn't return")
+			}
+
+			switch {
+			case tr.Outcome == Closed && res.err == nil:
+				res.conn.Close()
+				t.Fatal("the client succeeded, expected a failure")
+			case tr.Outcome == Closed:
+				if r.Errors && tr.Err != nil && !errors.Is(res.err, tr.Err) {
+					t.Fatalf("error %v, expected %v", res.err, tr.Err)
+				}
+			case res.err != nil:
+				t.Fatalf("the client failed: %v", res.err)
+			default:
+				defer res.conn.Close()
+				go func() { _, _ = io.Copy(server, server) }()
+				_ = res.conn.SetDeadline(time.Now().Add(r.timeout()))
+				if err := outcome(res.conn, Relay); err != nil {
+					t.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// answer returns what the server writes for step.
+func answer(step Step) []byte {
+	switch {
+	case step.AuthFailure:
+		return []byte{s5.AuthUserPWVersion, 0x01}
+	case len(step.Reply) > 0:
+		return []byte{s5.VERSION, byte(step.Reply[0]), 0x00, s5.AddressTypeIPv4, 0, 0, 0, 0, 0, 0}
+	}
+	return step.Expect
+}
+
+// expect reads and checks the answer of the server to step.
+func expect(conn net.Conn, step Step) error {
+	switch {
+	case step.AuthFailure:
+		var got [2]byte
+		if _, err := io.ReadFull(conn, got[:]); err != nil {
+			return errors.New("expected an authentication failure: " + err.Error())
+		}
+		if got[0] != s5.AuthUserPWVersion || got[1] == 0x00 {
+			return errors.New("expected an authentication failure, got " + hex(got[:]))
+		}
+	case len(step.Reply) > 0:
+		var reply s5.Reply
+		if err := reply.Unpack(conn); err != nil {
+			return errors.New("expected a reply: " + err.Error())
+		}
+		for _, status := range step.Reply {
+			if reply.Status == status {
+				return nil
+			}
+		}
+		return errors.New("unexpected reply status " + reply.Status.String())
+	case len(step.Expect) > 0:
+		got := make([]byte, len(step.Expect))
+		if n, err := io.ReadFull(conn, got); err != nil {
+			return errors.New("expected " + hex(step.Expect) + ", got " + hex(got[:n]) + ": " + err.Error())
+		}
+		if !bytes.Equal(got, step.Expect) {
+			return errors.New("expected " + hex(step.Expect) + ", got " + hex(got))
+		}
+	}
+	return nil
+}
+
+// outcome checks that conn relays data to an echo server, or that it is closed.
+func outcome(conn net.Conn, o Outcome) error {
+	if o == Closed {
+		// whatever is written before closing is fine, a reset as well
+		_, err := io.Copy(io.Discard, conn)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return errors.New("the connection wasn't closed")
+		}
+		return nil
+	}
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		return errors.New("relay: " + err.Error())
+	}
+	got := make([]byte, 4)
+	if _, err := io.ReadFull(conn, got); err != nil {
+		return errors.New("relay: " + err.Error())
+	}
+	if string(got) != "ping" {
+		return errors.New("relay: got " + hex(got))
+	}
+	return nil
+}
+
+func hex(b []byte) string {
+	const digits = "0123456789abcdef"
+	s := make([]byte, 0, 3*len(b))
+	for i, c := range b {
+		if i > 0 {
+			s = append(s, ' ')
+		}
+		s = append(s, digits[c>>4], digits[c&0x0F])
+	}
+	return "[" + string(s) + "]"
+}
+
+// StartEcho starts a TCP echo server on a loopback address for Config.Echo, closed with the test.
+func StartEcho(t testing.TB) netip.AddrPort {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr).AddrPort()
+}
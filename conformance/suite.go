@@ -0,0 +1,306 @@
+package conformance
+
+import (
+	"net/netip"
+
+	"github.com/kayabe/socks/s5"
+)
+
+// Config describes the server under test.
+type Config struct {
+	// Echo is the address of a TCP echo server the server under test can reach, see StartEcho.
+	Echo netip.AddrPort
+
+	// Refused is an address where connections are refused, the transcript is skipped if invalid.
+	Refused netip.AddrPort
+
+	// Username and Password are the credentials a server requiring username/password
+	// authentication accepts, the server requires no authentication if Username is empty.
+	Username, Password string
+}
+
+var (
+	handshakeNone = Step{Send: []byte{s5.VERSION, 0x01, byte(s5.MethodAuthNone)}, Expect: []byte{s5.VERSION, byte(s5.MethodAuthNone)}}
+	noAcceptable  = []byte{s5.VERSION, byte(s5.MethodAuthNoneAcceptable)}
+	success       = statuses(s5.ReplySuccess)
+)
+
+// ServerTranscripts returns the transcripts checking a server configured as described by c.
+func ServerTranscripts(c Config) []Transcript {
+	if c.Username != "" {
+		return userPWTranscripts(c)
+	}
+
+	echo := c.Echo
+	ts := []Transcript{
+		{
+			Name:    "connect/ipv4",
+			Steps:   []Step{handshakeNone, {Send: request(s5.CommandConnect, ipv4(echo)), Reply: success}},
+			Outcome: Relay,
+		},
+		{
+			Name:    "connect/ipv6",
+			Steps:   []Step{handshakeNone, {Send: request(s5.CommandConnect, ipv6(echo)), Reply: success}},
+			Outcome: Relay,
+		},
+		{
+			Name:    "connect/domain",
+			Steps:   []Step{handshakeNone, {Send: request(s5.CommandConnect, domain("localhost", echo.Port())), Reply: success}},
+			Outcome: Relay,
+		},
+		{
+			Name: "method/several",
+			Steps: []Step{
+				{Send: []byte{s5.VERSION, 0x03, 0x80, byte(s5.MethodAuthGSSAPI), byte(s5.MethodAuthNone)}, Expect: []byte{s5.VERSION, byte(s5.MethodAuthNone)}},
+				{Send: request(s5.CommandConnect, ipv4(echo)), Reply: success},
+			},
+			Outcome: Relay,
+		},
+		{
+			Name:    "method/gssapi-only",
+			Steps:   []Step{{Send: []byte{s5.VERSION, 0x01, byte(s5.MethodAuthGSSAPI)}, Expect: noAcceptable}},
+			Outcome: Closed,
+		},
+		{
+			Name:    "method/userpw-only",
+			Steps:   []Step{{Send: []byte{s5.VERSION, 0x01, byte(s5.MethodAuthUserPW)}, Expect: noAcceptable}},
+			Outcome: Closed,
+		},
+		{
+			Name:    "method/unknown",
+			Steps:   []Step{{Send: []byte{s5.VERSION, 0x02, 0x09, 0xFE}, Expect: noAcceptable}},
+			Outcome: Closed,
+		},
+		{
+			Name:    "method/none-offered",
+			Steps:   []Step{{Send: []byte{s5.VERSION, 0x00}}},
+			Outcome: Closed,
+		},
+		{
+			Name:    "version/handshake",
+			Steps:   []Step{{Send: []byte{0x06, 0x01, byte(s5.MethodAuthNone)}}},
+			Outcome: Closed,
+		},
+		{
+			Name:    "version/request",
+			Steps:   []Step{handshakeNone, {Send: append([]byte{0x06, s5.CommandConnect, 0x00}, ipv4(echo)...)}},
+			Outcome: Closed,
+		},
+		{
+			Name:    "command/unknown",
+			Steps:   []Step{handshakeNone, {Send: request(0x09, ipv4(echo)), Reply: statuses(s5.ReplyCommandNotSupported)}},
+			Outcome: Closed,
+		},
+		{
+			Name:    "atyp/unknown",
+			Steps:   []Step{handshakeNone, {Send: request(s5.CommandConnect, []byte{0x02, 127, 0, 0, 1, 0, 80}), Reply: statuses(s5.ReplyAddressTypeNotSupported)}},
+			Outcome: Closed,
+		},
+		{
+			Name:    "atyp/empty-domain",
+			Steps:   []Step{handshakeNone, {Send: request(s5.CommandConnect, domain("", echo.Port()))}},
+			Outcome: Closed,
+		},
+		{
+			Name:    "status/unresolvable",
+			Steps:   []Step{handshakeNone, {Send: request(s5.CommandConnect, domain("nonexistent.invalid", 80)), Reply: failures}},
+			Outcome: Closed,
+		},
+		{
+			Name:    "truncated/handshake",
+			Steps:   []Step{{Send: []byte{s5.VERSION, 0x02, byte(s5.MethodAuthNone)}, CloseWrite: true}},
+			Outcome: Closed,
+		},
+		{
+			Name:    "truncated/request",
+			Steps:   []Step{handshakeNone, {Send: request(s5.CommandConnect, ipv4(echo))[:6], CloseWrite: true}},
+			Outcome: Closed,
+		},
+		{
+			Name:    "truncated/domain",
+			Steps:   []Step{handshakeNone, {Send: request(s5.CommandConnect, []byte{s5.AddressTypeDomainName, 20, 'l', 'o'}), CloseWrite: true}},
+			Outcome: Closed,
+		},
+	}
+
+	if c.Refused.IsValid() {
+		ts = append(ts, Transcript{
+			Name:    "status/refused",
+			Steps:   []Step{handshakeNone, {Send: request(s5.CommandConnect, ipv4(c.Refused)), Reply: failures}},
+			Outcome: Closed,
+		})
+	}
+
+	for i := range ts {
+		ts[i].Side = ServerSide
+	}
+	return ts
+}
+
+func userPWTranscripts(c Config) []Transcript {
+	handshake := Step{Send: []byte{s5.VERSION, 0x01, byte(s5.MethodAuthUserPW)}, Expect: []byte{s5.VERSION, byte(s5.MethodAuthUserPW)}}
+	accepted := Step{Send: auth(c.Username, c.Password), Expect: []byte{s5.AuthUserPWVersion, 0x00}}
+
+	ts := []Transcript{
+		{
+			Name:    "userpw/connect",
+			Steps:   []Step{handshake, accepted, {Send: request(s5.CommandConnect, ipv4(c.Echo)), Reply: success}},
+			Outcome: Relay,
+		},
+		{
+			Name: "userpw/several-methods",
+			Steps: []Step{
+				{Send: []byte{s5.VERSION, 0x02, byte(s5.MethodAuthNone), byte(s5.MethodAuthUserPW)}, Expect: []byte{s5.VERSION, byte(s5.MethodAuthUserPW)}},
+				accepted,
+				{Send: request(s5.CommandConnect, domain("localhost", c.Echo.Port())), Reply: success},
+			},
+			Outcome: Relay,
+		},
+		{
+			Name:    "userpw/wrong-password",
+			Steps:   []Step{handshake, {Send: auth(c.Username, c.Password+"x"), AuthFailure: true}},
+			Outcome: Closed,
+		},
+		{
+			Name:    "userpw/unknown-user",
+			Steps:   []Step{handshake, {Send: auth(c.Username+"x", c.Password), AuthFailure: true}},
+			Outcome: Closed,
+		},
+		{
+			Name:    "userpw/none-offered",
+			Steps:   []Step{{Send: []byte{s5.VERSION, 0x01, byte(s5.MethodAuthNone)}, Expect: noAcceptable}},
+			Outcome: Closed,
+		},
+		{
+			Name:    "userpw/version",
+			Steps:   []Step{handshake, {Send: append([]byte{0x02}, auth(c.Username, c.Password)[1:]...)}},
+			Outcome: Closed,
+		},
+		{
+			Name:    "userpw/truncated",
+			Steps:   []Step{handshake, {Send: auth(c.Username, c.Password)[:3], CloseWrite: true}},
+			Outcome: Closed,
+		},
+	}
+
+	for i := range ts {
+		ts[i].Side = ServerSide
+	}
+	return ts
+}
+
+// ClientTranscripts returns the transcripts checking a client, the runner plays the server.
+// The client is expected to offer only the methods it can use, no authentication
+// first, and to send the destination the way it was given.
+func ClientTranscripts() []Transcript {
+	local := netip.MustParseAddrPort("127.0.0.1:80")
+	local6 := netip.MustParseAddrPort("[::1]:80")
+	connect4 := Step{Send: request(s5.CommandConnect, ipv4(local)), Reply: success}
+
+	handshakeUserPW := Step{Send: []byte{s5.VERSION, 0x02, byte(s5.MethodAuthNone), byte(s5.MethodAuthUserPW)}, Expect: []byte{s5.VERSION, byte(s5.MethodAuthUserPW)}}
+
+	ts := []Transcript{
+		{
+			Name:    "connect/ipv4",
+			Address: local.String(),
+			Steps:   []Step{handshakeNone, connect4},
+			Outcome: Relay,
+		},
+		{
+			Name:    "connect/ipv6",
+			Address: local6.String(),
+			Steps:   []Step{handshakeNone, {Send: request(s5.CommandConnect, ipv6(local6)), Reply: success}},
+			Outcome: Relay,
+		},
+		{
+			Name:    "connect/domain",
+			Address: "example.com:443",
+			Steps:   []Step{handshakeNone, {Send: request(s5.CommandConnect, domain("example.com", 443)), Reply: success}},
+			Outcome: Relay,
+		},
+		{
+			Name:    "connect/bind-ipv6",
+			Address: local.String(),
+			Steps: []Step{handshakeNone, {
+				Send:   request(s5.CommandConnect, ipv4(local)),
+				Expect: []byte{s5.VERSION, 0x00, 0x00, s5.AddressTypeIPv6, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0x04, 0x38},
+			}},
+			Outcome: Relay,
+		},
+		{
+			Name:     "userpw/connect",
+			Address:  local.String(),
+			Username: "user", Password: "pass",
+			Steps:   []Step{handshakeUserPW, {Send: auth("user", "pass"), Expect: []byte{s5.AuthUserPWVersion, 0x00}}, connect4},
+			Outcome: Relay,
+		},
+		{
+			Name:     "userpw/none-selected",
+			Address:  local.String(),
+			Username: "user", Password: "pass",
+			Steps:   []Step{{Send: handshakeUserPW.Send, Expect: []byte{s5.VERSION, byte(s5.MethodAuthNone)}}, connect4},
+			Outcome: Relay,
+		},
+		{
+			Name:     "userpw/failure",
+			Address:  local.String(),
+			Username: "user", Password: "pass",
+			Steps:   []Step{handshakeUserPW, {Send: auth("user", "pass"), AuthFailure: true}},
+			Outcome: Closed,
+		},
+		{
+			Name:    "method/none-acceptable",
+			Address: local.String(),
+			Steps:   []Step{{Send: handshakeNone.Send, Expect: noAcceptable}},
+			Outcome: Closed,
+			Err:     s5.ErrAuthNoneAcceptable,
+		},
+		{
+			Name:    "method/not-offered",
+			Address: local.String(),
+			Steps:   []Step{{Send: handshakeNone.Send, Expect: []byte{s5.VERSION, byte(s5.MethodAuthGSSAPI)}}},
+			Outcome: Closed,
+		},
+		{
+			Name:    "version/handshake",
+			Address: local.String(),
+			Steps:   []Step{{Send: handshakeNone.Send, Expect: []byte{0x04, byte(s5.MethodAuthNone)}}},
+			Outcome: Closed,
+			Err:     s5.ErrUnsupportedVersion,
+		},
+		{
+			Name:    "version/reply",
+			Address: local.String(),
+			Steps:   []Step{handshakeNone, {Send: connect4.Send, Expect: []byte{0x04, 0x00, 0x00, s5.AddressTypeIPv4, 0, 0, 0, 0, 0, 0}}},
+			Outcome: Closed,
+			Err:     s5.ErrUnsupportedVersion,
+		},
+		{
+			Name:    "truncated/handshake",
+			Address: local.String(),
+			Steps:   []Step{{Send: handshakeNone.Send, Expect: []byte{s5.VERSION}}},
+			Outcome: Closed,
+		},
+		{
+			Name:    "truncated/reply",
+			Address: local.String(),
+			Steps:   []Step{handshakeNone, {Send: connect4.Send, Expect: []byte{s5.VERSION, 0x00, 0x00, s5.AddressTypeIPv4, 127}}},
+			Outcome: Closed,
+		},
+	}
+
+	for status := s5.ReplyGeneralFailure; status <= s5.ReplyAddressTypeNotSupported; status++ {
+		ts = append(ts, Transcript{
+			Name:    "status/" + status.String(),
+			Address: local.String(),
+			Steps:   []Step{handshakeNone, {Send: connect4.Send, Reply: statuses(status)}},
+			Outcome: Closed,
+			Err:     status.Error(),
+		})
+	}
+
+	for i := range ts {
+		ts[i].Side = ClientSide
+	}
+	return ts
+}
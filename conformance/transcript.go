@@ -0,0 +1,110 @@
+// Package conformance checks SOCKS5 clients and servers against RFC 1928, RFC 1929 and RFC 1961
+// with byte-level transcripts, over a local socket so that any implementation can be checked.
+package conformance
+
+import (
+	"encoding/binary"
+	"net/netip"
+
+	"github.com/kayabe/socks/s5"
+)
+
+// Side of the exchange a Transcript checks.
+type Side uint8
+
+const (
+	ServerSide Side = 1 << iota // the transcript is played against a server
+	ClientSide                  // the transcript is played against a client
+)
+
+// Outcome of a Transcript, once its steps were exchanged.
+type Outcome uint8
+
+const (
+	Relay  Outcome = iota // the connection relays data to the destination
+	Closed                // the server closes the connection, the client fails
+)
+
+func (o Outcome) String() string {
+	if o == Relay {
+		return "relay"
+	}
+	return "closed"
+}
+
+// Step is one message of the client and the answer of the server.
+type Step struct {
+	// Send is written by the client.
+	Send []byte
+
+	// CloseWrite half-closes the connection after Send, to truncate the client input.
+	CloseWrite bool
+
+	// Expect is written by the server, byte for byte.
+	Expect []byte
+
+	// AuthFailure expects an RFC 1929 reply with a non-zero status.
+	AuthFailure bool
+
+	// Reply expects an RFC 1928 reply with one of the statuses, whatever its BND.ADDR and BND.PORT.
+	// A client is sent the first status with BND.ADDR 0.0.0.0 and BND.PORT 0.
+	Reply []s5.ReplyStatus
+}
+
+// Transcript is a complete exchange between a client and a server.
+type Transcript struct {
+	Name string
+	Side Side
+
+	// Address is dialed through the proxy server by the client, with the credentials if set.
+	Address            string
+	Username, Password string
+
+	Steps   []Step
+	Outcome Outcome
+
+	// Err is the error expected from the client, only checked when Runner.Errors is set
+	// as other implementations report failures their own way.
+	Err error
+}
+
+// request returns a request for the command and a destination in wire format.
+func request(command uint8, dest []byte) []byte {
+	return append([]byte{s5.VERSION, command, 0x00}, dest...)
+}
+
+// ipv4 returns the ATYP, DST.ADDR and DST.PORT of an IPv4 destination.
+func ipv4(ap netip.AddrPort) []byte {
+	ip := ap.Addr().Unmap().As4()
+	return binary.BigEndian.AppendUint16(append([]byte{s5.AddressTypeIPv4}, ip[:]...), ap.Port())
+}
+
+// ipv6 returns the ATYP, DST.ADDR and DST.PORT of an IPv6 destination, IPv4 addresses are mapped.
+func ipv6(ap netip.AddrPort) []byte {
+	ip := ap.Addr().As16()
+	return binary.BigEndian.AppendUint16(append([]byte{s5.AddressTypeIPv6}, ip[:]...), ap.Port())
+}
+
+// domain returns the ATYP, DST.ADDR and DST.PORT of a domain name destination.
+func domain(host string, port uint16) []byte {
+	return binary.BigEndian.AppendUint16(append([]byte{s5.AddressTypeDomainName, byte(len(host))}, host...), port)
+}
+
+// auth returns an RFC 1929 username/password request.
+func auth(username, password string) []byte {
+	b := append([]byte{s5.AuthUserPWVersion, byte(len(username))}, username...)
+	return append(append(b, byte(len(password))), password...)
+}
+
+// statuses lists the given statuses.
+func statuses(s ...s5.ReplyStatus) []s5.ReplyStatus { return s }
+
+// failures lists every failure status, for failures whose cause servers report differently.
+var failures = statuses(
+	s5.ReplyGeneralFailure,
+	s5.ReplyConnectionNotAllowed,
+	s5.ReplyNetworkUnreachable,
+	s5.ReplyHostUnreachable,
+	s5.ReplyConnectionRefused,
+	s5.ReplyTTLExpired,
+)
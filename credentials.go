@@ -0,0 +1,18 @@
+package socks
+
+// CredentialStore validates the identity a client presents to the Server.
+//
+// SOCKS5 and HTTP clients present a username and a password, SOCKS4 clients
+// only carry a USERID which is validated with an empty password.
+type CredentialStore interface {
+	Valid(username, password string) bool
+}
+
+// StaticCredentials is a CredentialStore backed by a map of username to password.
+type StaticCredentials map[string]string
+
+// Valid reports whether username is in s with the given password.
+func (s StaticCredentials) Valid(username, password string) bool {
+	pw, ok := s[username]
+	return ok && pw == password
+}
@@ -0,0 +1,150 @@
+package socks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/kayabe/socks/s5"
+)
+
+// requestKey is the context key of the Request being served.
+type requestKey struct{}
+
+// RequestFromContext returns the Request the Server is serving, in the contexts it passes to its Rules
+// and Dialer, so that a Dialer such as Router can pick the upstream by user.
+func RequestFromContext(ctx context.Context) (*Request, bool) {
+	req, ok := ctx.Value(requestKey{}).(*Request)
+	return req, ok
+}
+
+// DirectDialer dials the destinations directly, optionally from a source IP address or interface.
+type DirectDialer struct {
+	// SourceIP optionally specifies the local IP address to dial from,
+	// the destinations of the other family are then unreachable.
+	SourceIP netip.Addr
+
+	// Interface optionally binds the connections to a network interface with SO_BINDTODEVICE,
+	// which requires Linux and CAP_NET_RAW.
+	Interface string
+
+	// Timeout bounds dialing, no timeout if zero.
+	Timeout time.Duration
+}
+
+// DialContext implements ContextDialer.
+func (d *DirectDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	nd := net.Dialer{Timeout: d.Timeout}
+	if d.SourceIP.IsValid() {
+		switch network {
+		case "udp", "udp4", "udp6":
+			nd.LocalAddr = &net.UDPAddr{IP: d.SourceIP.AsSlice()}
+		default:
+			nd.LocalAddr = &net.TCPAddr{IP: d.SourceIP.AsSlice()}
+		}
+	}
+	if d.Interface != "" {
+		nd.Control = func(_, _ string, c syscall.RawConn) error {
+			return bindToDevice(c, d.Interface)
+		}
+	}
+	return nd.DialContext(ctx, network, address)
+}
+
+// Route sends the requests matching all of its conditions through its Dialer.
+type Route struct {
+	// Destinations matches the destinations, in the NO_PROXY syntax of ParseNoProxy, any if empty.
+	Destinations NoProxy
+
+	// Users matches the authenticated identities, any if empty.
+	Users []string
+
+	// Dialer reaches the destinations, such as a *Client chaining to an upstream proxy server
+	// or a *DirectDialer, a zero net.Dialer if nil.
+	Dialer ContextDialer
+}
+
+// matchRoute reports whether a route to the destinations for the users applies to the address,
+// requested by user.
+func matchRoute(destinations NoProxy, users []string, address, user string) bool {
+	if len(destinations) > 0 && !destinations.Excludes(address) {
+		return false
+	}
+	if len(users) > 0 {
+		for _, u := range users {
+			if u == user {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Router dials through the first of its routes matching the destination and the user
+// of the request, as told by RequestFromContext. The destinations are matched against both
+// the dialed address and the requested one, which differ once a Server with a Resolver dials
+// the addresses a name resolved to.
+type Router struct {
+	Routes []Route
+
+	// Default reaches the destinations no route matches, a zero net.Dialer if nil.
+	Default ContextDialer
+}
+
+// DialContext implements ContextDialer.
+func (r *Router) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	var user, requested string
+	if req, ok := RequestFromContext(ctx); ok {
+		user, requested = req.Username, req.Address()
+	}
+
+	d := r.Default
+	for i := range r.Routes {
+		route := &r.Routes[i]
+		if matchRoute(route.Destinations, route.Users, address, user) ||
+			requested != "" && requested != address && matchRoute(route.Destinations, route.Users, requested, user) {
+			d = route.Dialer
+			break
+		}
+	}
+	if d == nil {
+		d = &net.Dialer{}
+	}
+	return d.DialContext(ctx, network, address)
+}
+
+// ReplyStatusOf returns the status replying to a request whose destination couldn't be reached
+// because of err: the system errors and the timeouts map to the statuses meaning the same,
+// the failures of an upstream proxy server keep their status.
+func ReplyStatusOf(err error) s5.ReplyStatus {
+	if err == nil {
+		return s5.ReplySuccess
+	}
+
+	if status, ok := errnoStatus(err); ok {
+		return status
+	}
+
+	for status := s5.ReplyGeneralFailure; status <= s5.ReplyAddressTypeNotSupported; status++ {
+		if errors.Is(err, status.Error()) {
+			return status
+		}
+	}
+
+	var timeout interface{ Timeout() bool }
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) ||
+		errors.As(err, &timeout) && timeout.Timeout() {
+		return s5.ReplyTTLExpired
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return s5.ReplyHostUnreachable
+	}
+	return s5.ReplyGeneralFailure
+}
@@ -0,0 +1,14 @@
+package socks
+
+import "syscall"
+
+// bindToDevice binds the socket to the network interface with SO_BINDTODEVICE.
+func bindToDevice(c syscall.RawConn, iface string) (err error) {
+	cerr := c.Control(func(fd uintptr) {
+		err = syscall.BindToDevice(int(fd), iface)
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package socks
+
+import "syscall"
+
+func bindToDevice(syscall.RawConn, string) error {
+	return ErrBindToDeviceUnsupported
+}
@@ -0,0 +1,142 @@
+package socks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/kayabe/socks/s5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerReplyStatus(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := l.Addr().String()
+	l.Close()
+
+	addr := startServer(t, &Server{})
+	client, _ := NewClient(addr)
+	_, err = client.Dial("tcp", refused)
+	assert.Equal(t, s5.ErrReplyConnectionRefused, err)
+}
+
+// recordingDialer records the addresses it dials, and fails.
+type recordingDialer struct {
+	name   string
+	dialed chan string
+}
+
+func (d recordingDialer) DialContext(_ context.Context, _, address string) (net.Conn, error) {
+	d.dialed <- d.name + " " + address
+	return nil, s5.ErrReplyConnectionNotAllowed
+}
+
+func TestRouter(t *testing.T) {
+	dialed := make(chan string, 4)
+	router := &Router{
+		Routes: []Route{
+			{Destinations: ParseNoProxy("internal.example, 10.0.0.0/8"), Dialer: recordingDialer{"office", dialed}},
+			{Users: []string{"alice"}, Dialer: recordingDialer{"alice", dialed}},
+		},
+		Default: recordingDialer{"default", dialed},
+	}
+	addr := startServer(t, &Server{Credentials: StaticCredentials{"alice": "a", "bob": "b"}, Dialer: router})
+
+	alice, _ := NewClient(addr, WithUserPW("alice", "a"))
+	bob, _ := NewClient(addr, WithUserPW("bob", "b"))
+	for _, dial := range []struct {
+		client  *Client
+		address string
+		route   string
+	}{
+		{alice, "db.internal.example:5432", "office"},
+		{bob, "10.1.2.3:22", "office"},
+		{alice, "example.com:443", "alice"},
+		{bob, "example.com:443", "default"},
+	} {
+		_, err := dial.client.Dial("tcp", dial.address)
+		assert.Equal(t, s5.ErrReplyConnectionNotAllowed, err)
+		assert.Equal(t, dial.route+" "+dial.address, <-dialed)
+	}
+}
+
+func TestRouterResolver(t *testing.T) {
+	dialed := make(chan string, 4)
+	router := &Router{
+		Routes:  []Route{{Destinations: ParseNoProxy("internal.example"), Dialer: recordingDialer{"office", dialed}}},
+		Default: recordingDialer{"default", dialed},
+	}
+	// the router only gets the resolved addresses, the routes still match the requested names
+	addr := startServer(t, &Server{
+		Resolver: staticResolver{
+			"db.internal.example": parseAddrs("192.0.2.1"),
+			"example.com":         parseAddrs("192.0.2.2"),
+		},
+		Dialer: router,
+	})
+
+	client, _ := NewClient(addr)
+	for _, dial := range []struct {
+		address, route string
+	}{
+		{"db.internal.example:5432", "office 192.0.2.1:5432"},
+		{"example.com:443", "default 192.0.2.2:443"},
+	} {
+		_, err := client.Dial("tcp", dial.address)
+		assert.Equal(t, s5.ErrReplyConnectionNotAllowed, err)
+		assert.Equal(t, dial.route, <-dialed)
+	}
+}
+
+func TestServerChain(t *testing.T) {
+	echo := startEcho(t)
+	upstream := startServer(t, &Server{Credentials: StaticCredentials{"user": "pass"}})
+	upstreamClient, _ := NewClient(upstream, WithUserPW("user", "pass"))
+	addr := startServer(t, &Server{Dialer: upstreamClient})
+
+	client, _ := NewClient(addr)
+	conn, err := client.Dial("tcp", echo.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	assertEcho(t, conn)
+}
+
+func TestDirectDialer(t *testing.T) {
+	echo := startEcho(t)
+
+	d := &DirectDialer{SourceIP: netip.MustParseAddr("127.0.0.1")}
+	conn, err := d.DialContext(context.Background(), "tcp", echo.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEcho(t, conn)
+	assert.Equal(t, "127.0.0.1", conn.LocalAddr().(*net.TCPAddr).IP.String())
+	conn.Close()
+
+	// the other family is unreachable from the source IP
+	_, err = d.DialContext(context.Background(), "tcp", "[::1]:1")
+	assert.Error(t, err)
+
+	d = &DirectDialer{Interface: "lo"}
+	conn, err = d.DialContext(context.Background(), "tcp", echo.String())
+	switch {
+	case runtime.GOOS != "linux":
+		assert.ErrorIs(t, err, ErrBindToDeviceUnsupported)
+	case errors.Is(err, syscall.EPERM):
+		t.Log("binding to an interface requires CAP_NET_RAW")
+	case err != nil:
+		t.Fatal(err)
+	default:
+		assertEcho(t, conn)
+		conn.Close()
+	}
+}
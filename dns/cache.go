@@ -0,0 +1,153 @@
+package dns
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults of a Cache.
+const (
+	DefaultTTL         = time.Minute
+	DefaultMaxTTL      = time.Hour
+	DefaultNegativeTTL = 30 * time.Second
+	DefaultMaxEntries  = 10000
+)
+
+// Cache caches the answers of a Resolver, for their TTL when it is a TTLResolver such as Client.
+// The names not found are cached too, the other failures aren't. It is safe for concurrent use.
+type Cache struct {
+	// Resolver answers the names missing from the cache, net.DefaultResolver if nil.
+	Resolver Resolver
+
+	// TTL is how long the answers of a Resolver which isn't a TTLResolver are cached, DefaultTTL if zero.
+	TTL time.Duration
+
+	// MaxTTL caps how long the answers are cached, DefaultMaxTTL if zero.
+	MaxTTL time.Duration
+
+	// NegativeTTL caps how long the names not found are cached, DefaultNegativeTTL if zero,
+	// negative disables the negative caching.
+	NegativeTTL time.Duration
+
+	// MaxEntries bounds the number of entries, DefaultMaxEntries if zero.
+	MaxEntries int
+
+	mu      sync.Mutex
+	entries map[cacheKey]cacheEntry
+	now     func() time.Time // time.Now if nil, for the tests
+}
+
+type cacheKey struct {
+	network, host string
+}
+
+type cacheEntry struct {
+	addrs   []netip.Addr
+	err     error
+	expires time.Time
+}
+
+// LookupNetIP implements Resolver.
+func (c *Cache) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	addrs, _, err := c.LookupTTL(ctx, network, host)
+	return addrs, err
+}
+
+// LookupTTL implements TTLResolver, the TTL being what remains of the cached one.
+func (c *Cache) LookupTTL(ctx context.Context, network, host string) ([]netip.Addr, time.Duration, error) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{ip}, 0, nil
+	}
+
+	key := cacheKey{network, strings.ToLower(strings.TrimSuffix(host, "."))}
+	now := c.clock()
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return append([]netip.Addr(nil), e.addrs...), e.expires.Sub(now), e.err
+	}
+
+	addrs, ttl, err := c.lookup(ctx, network, host)
+	if ttl > 0 {
+		c.store(key, cacheEntry{addrs: append([]netip.Addr(nil), addrs...), err: err, expires: now.Add(ttl)})
+	}
+	return addrs, ttl, err
+}
+
+// lookup resolves host, returning how long the answer can be cached.
+func (c *Cache) lookup(ctx context.Context, network, host string) ([]netip.Addr, time.Duration, error) {
+	var r Resolver = c.Resolver
+	if r == nil {
+		r = net.DefaultResolver
+	}
+
+	var addrs []netip.Addr
+	var err error
+	ttl := c.TTL
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	if tr, ok := r.(TTLResolver); ok {
+		addrs, ttl, err = tr.LookupTTL(ctx, network, host)
+	} else {
+		addrs, err = r.LookupNetIP(ctx, network, host)
+	}
+
+	maxTTL := c.MaxTTL
+	if err != nil {
+		if !isNotFound(err) || c.NegativeTTL < 0 {
+			return nil, 0, err
+		}
+		maxTTL = c.NegativeTTL
+		if maxTTL == 0 {
+			maxTTL = DefaultNegativeTTL
+		}
+	} else if maxTTL == 0 {
+		maxTTL = DefaultMaxTTL
+	}
+	if ttl > maxTTL {
+		ttl = maxTTL
+	}
+	return addrs, ttl, err
+}
+
+// store adds an entry, dropping the expired ones when the cache is full, or else any one.
+func (c *Cache) store(key cacheKey, e cacheEntry) {
+	maxEntries := c.MaxEntries
+	if maxEntries == 0 {
+		maxEntries = DefaultMaxEntries
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[cacheKey]cacheEntry)
+	}
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxEntries {
+		now := c.clock()
+		for k, old := range c.entries {
+			if !now.Before(old.expires) {
+				delete(c.entries, k)
+			}
+		}
+		for k := range c.entries {
+			if len(c.entries) < maxEntries {
+				break
+			}
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = e
+}
+
+func (c *Cache) clock() time.Time {
+	if c.now != nil {
+		return c.now()
+	}
+	return time.Now()
+}
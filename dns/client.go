@@ -0,0 +1,274 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTimeout bounds the lookups of a Client without Timeout.
+const DefaultTimeout = 5 * time.Second
+
+// maxMessageLen is the largest DNS message.
+const maxMessageLen = 0xFFFF
+
+// Client resolves names with a recursive DNS server, querying the A and AAAA records at once.
+type Client struct {
+	// Server is the URL of the DNS server, the scheme selecting the transport:
+	//
+	//	udp://192.0.2.53:53            UDP, retried over TCP when truncated, also a bare host:port
+	//	tcp://192.0.2.53:53            TCP
+	//	tls://dns.example:853          DNS over TLS
+	//	https://dns.example/dns-query  DNS over HTTPS
+	//
+	// The port defaults to 53, or 853 for tls.
+	Server string
+
+	// TLSConfig optionally configures DNS over TLS, the ServerName defaulting to the host of Server.
+	TLSConfig *tls.Config
+
+	// HTTPClient is used for DNS over HTTPS, http.DefaultClient if nil.
+	HTTPClient *http.Client
+
+	// Timeout bounds each lookup, DefaultTimeout if zero.
+	Timeout time.Duration
+}
+
+// LookupNetIP implements Resolver, network being "ip", "ip4" or "ip6".
+func (c *Client) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	addrs, _, err := c.LookupTTL(ctx, network, host)
+	return addrs, err
+}
+
+// LookupTTL implements TTLResolver, the TTL being the lowest of the records answering,
+// or from the SOA of the zone when the name isn't found.
+func (c *Client) LookupTTL(ctx context.Context, network, host string) ([]netip.Addr, time.Duration, error) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{ip}, 0, nil
+	}
+
+	var qtypes []uint16
+	switch network {
+	case "ip":
+		qtypes = []uint16{typeA, typeAAAA}
+	case "ip4":
+		qtypes = []uint16{typeA}
+	case "ip6":
+		qtypes = []uint16{typeAAAA}
+	default:
+		return nil, 0, net.UnknownNetworkError(network)
+	}
+
+	server, err := c.server()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	answers := make([]answer, len(qtypes))
+	errs := make([]error, len(qtypes))
+	var wg sync.WaitGroup
+	for i := range qtypes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			answers[i], errs[i] = c.lookup(ctx, server, host, qtypes[i])
+		}(i)
+	}
+	wg.Wait()
+
+	// the addresses of either type are an answer, the failures matter when both are missing
+	var addrs []netip.Addr
+	ttl := time.Duration(-1)
+	for i := range answers {
+		if errs[i] == nil {
+			addrs = append(addrs, answers[i].addrs...)
+		} else if err == nil || isNotFound(err) && !isNotFound(errs[i]) {
+			err = errs[i]
+		}
+		if (errs[i] == nil || isNotFound(errs[i])) && (ttl < 0 || answers[i].ttl < ttl) {
+			ttl = answers[i].ttl
+		}
+	}
+	if len(addrs) > 0 {
+		return addrs, ttl, nil
+	}
+	if !isNotFound(err) {
+		ttl = 0
+	}
+	return nil, ttl, err
+}
+
+// server parses the URL of the server, adding its default port.
+func (c *Client) server() (*url.URL, error) {
+	raw := c.Server
+	if !strings.Contains(raw, "://") {
+		raw = "udp://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	port := "53"
+	switch u.Scheme {
+	case "udp", "tcp":
+	case "tls":
+		port = "853"
+	case "https":
+		return u, nil
+	default:
+		return nil, ErrUnsupportedScheme
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), port)
+	}
+	return u, nil
+}
+
+// lookup queries the records of type qtype of name.
+func (c *Client) lookup(ctx context.Context, server *url.URL, name string, qtype uint16) (answer, error) {
+	query, err := newQuery(name, qtype)
+	if err != nil {
+		return answer{}, err
+	}
+
+	var a answer
+	switch server.Scheme {
+	case "udp":
+		a, err = c.exchangeUDP(ctx, server, query)
+		if errors.Is(err, errTruncated) {
+			a, err = c.exchangeStream(ctx, server, query)
+		}
+	case "https":
+		a, err = c.exchangeHTTPS(ctx, server, query)
+	default:
+		a, err = c.exchangeStream(ctx, server, query)
+	}
+	if err != nil {
+		return a, err
+	}
+	return a, answerError(a, name, server.Host)
+}
+
+func (c *Client) exchangeUDP(ctx context.Context, server *url.URL, query []byte) (answer, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", server.Host)
+	if err != nil {
+		return answer{}, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return answer{}, err
+	}
+	b := make([]byte, maxMessageLen)
+	for {
+		n, err := conn.Read(b)
+		if err != nil {
+			return answer{}, err
+		}
+		// late or spoofed responses are skipped
+		if a, err := parseResponse(b[:n], query); err != ErrIDMismatch && err != ErrQuestionMismatch {
+			return a, err
+		}
+	}
+}
+
+// exchangeStream exchanges the messages over TCP or TLS, each prefixed with its length.
+func (c *Client) exchangeStream(ctx context.Context, server *url.URL, query []byte) (answer, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", server.Host)
+	if err != nil {
+		return answer{}, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if server.Scheme == "tls" {
+		config := &tls.Config{}
+		if c.TLSConfig != nil {
+			config = c.TLSConfig.Clone()
+		}
+		if config.ServerName == "" {
+			config.ServerName = server.Hostname()
+		}
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return answer{}, err
+		}
+		conn = tlsConn
+	}
+
+	msg := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(query)), uint16(len(query)))
+	if _, err := conn.Write(append(msg, query...)); err != nil {
+		return answer{}, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return answer{}, err
+	}
+	b := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return answer{}, err
+	}
+	return parseResponse(b, query)
+}
+
+// exchangeHTTPS posts the query as RFC 8484 recommends, with a zero ID for the HTTP caches.
+func (c *Client) exchangeHTTPS(ctx context.Context, server *url.URL, query []byte) (answer, error) {
+	query[0], query[1] = 0, 0
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.String(), bytes.NewReader(query))
+	if err != nil {
+		return answer{}, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return answer{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return answer{}, fmt.Errorf("dns: %s: %s", server.Redacted(), resp.Status)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxMessageLen))
+	if err != nil {
+		return answer{}, err
+	}
+	return parseResponse(b, query)
+}
+
+// isNotFound reports whether err tells that the name has no address.
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
@@ -0,0 +1,43 @@
+// Package dns resolves domain names with a recursive DNS server over UDP, TCP, TLS (DNS over TLS)
+// or HTTPS (DNS over HTTPS), and caches the answers of any resolver for their TTL, so that
+// a socks.Server can resolve the names of the requests itself.
+package dns
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"time"
+)
+
+var (
+	// ErrInvalidName is returned for names which can't be queried.
+	ErrInvalidName = errors.New("dns: invalid domain name")
+
+	// ErrFormat is returned for malformed responses.
+	ErrFormat = errors.New("dns: malformed response")
+
+	// ErrIDMismatch is returned for responses to another query.
+	ErrIDMismatch = errors.New("dns: response to another query")
+
+	// ErrQuestionMismatch is returned for responses whose question isn't the one asked.
+	ErrQuestionMismatch = errors.New("dns: response to another question")
+
+	// ErrUnsupportedScheme is returned for server URLs with an unknown scheme.
+	ErrUnsupportedScheme = errors.New("dns: unsupported server scheme, expected udp, tcp, tls or https")
+
+	// errTruncated is returned for truncated UDP responses, retried over TCP.
+	errTruncated = errors.New("dns: truncated response")
+)
+
+// Resolver looks up the IP addresses of a host, *net.Resolver, *Client and *Cache implement it.
+type Resolver interface {
+	LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
+}
+
+// TTLResolver is a Resolver telling for how long its answers are valid, the names not found
+// being returned as a *net.DNSError with IsNotFound set along with how long they stay so.
+type TTLResolver interface {
+	Resolver
+	LookupTTL(ctx context.Context, network, host string) ([]netip.Addr, time.Duration, error)
+}
@@ -0,0 +1,405 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// record is a record of the stub zone.
+type record struct {
+	rtype uint16
+	ttl   uint32
+	rdata []byte
+	owner string // the name of the question if empty
+}
+
+var (
+	soa = record{typeSOA, 3600, append([]byte("\x02ns\x00\x05admin\x00"), make([]byte, 20)...), ""}
+
+	zone = map[string][]record{
+		"example.test":       {{typeA, 300, []byte{192, 0, 2, 1}, ""}, {typeAAAA, 60, netip.MustParseAddr("2001:db8::1").AsSlice(), ""}},
+		"v4.example.test":    {{typeA, 100, []byte{192, 0, 2, 4}, ""}},
+		"alias.example.test": {{typeCNAME, 30, []byte("\x07example\x04test\x00"), ""}, {typeA, 300, []byte{192, 0, 2, 1}, "example.test"}},
+	}
+)
+
+func init() {
+	// the SOA minimum
+	binary.BigEndian.PutUint32(soa.rdata[len(soa.rdata)-4:], 20)
+}
+
+// answerQuery answers a query from the stub zone, truncating the answers of big.example.test if udp.
+func answerQuery(query []byte, udp bool) []byte {
+	off, _ := skipName(query, headerLen)
+	qtype := binary.BigEndian.Uint16(query[off:])
+	var labels []string
+	for i := headerLen; query[i] != 0; i += 1 + int(query[i]) {
+		labels = append(labels, string(query[i+1:i+1+int(query[i])]))
+	}
+	name := strings.ToLower(strings.Join(labels, "."))
+
+	b := append([]byte(nil), query[:off+4]...)
+	flags := uint16(flagResponse | flagRecursionDesired | 1<<7)
+	if name == "big.example.test" {
+		if udp {
+			flags |= flagTruncated
+		}
+		name = "example.test"
+	}
+
+	records, ok := zone[name]
+	if !ok {
+		flags |= rcodeNameError
+	}
+	var answers, authorities int
+	found := false
+	for _, r := range records {
+		if r.rtype == qtype || r.rtype == typeCNAME {
+			b = appendRecord(b, r)
+			answers++
+			found = found || r.rtype == qtype
+		}
+	}
+	if !found {
+		b = appendRecord(b, soa)
+		authorities++
+	}
+	binary.BigEndian.PutUint16(b[2:], flags)
+	binary.BigEndian.PutUint16(b[6:], uint16(answers))
+	binary.BigEndian.PutUint16(b[8:], uint16(authorities))
+	return b
+}
+
+func appendRecord(b []byte, r record) []byte {
+	if r.owner == "" {
+		b = append(b, 0xC0, headerLen) // the name of the question
+	} else {
+		for _, label := range strings.Split(r.owner, ".") {
+			b = append(append(b, byte(len(label))), label...)
+		}
+		b = append(b, 0)
+	}
+	b = binary.BigEndian.AppendUint16(b, r.rtype)
+	b = binary.BigEndian.AppendUint16(b, classIN)
+	b = binary.BigEndian.AppendUint32(b, r.ttl)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(r.rdata)))
+	return append(b, r.rdata...)
+}
+
+// stubServers serves the stub zone over UDP and TCP on the same port, over TLS and over HTTPS,
+// returning their URLs and the client configurations trusting them.
+func stubServers(t *testing.T) (urls []string, tlsConfig *tls.Config, httpClient *http.Client) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	udp, err := net.ListenPacket("udp", tcp.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tcp.Close(); udp.Close() })
+
+	go func() {
+		b := make([]byte, maxMessageLen)
+		for {
+			n, addr, err := udp.ReadFrom(b)
+			if err != nil {
+				return
+			}
+			_, _ = udp.WriteTo(answerQuery(b[:n], true), addr)
+		}
+	}()
+	go serveStream(tcp)
+
+	h := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/dns-message" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		query, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(answerQuery(query, false))
+	}))
+	t.Cleanup(h.Close)
+
+	dot, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: h.TLS.Certificates})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dot.Close() })
+	go serveStream(dot)
+
+	httpClient = h.Client()
+	tlsConfig = httpClient.Transport.(*http.Transport).TLSClientConfig
+	return []string{
+		tcp.Addr().String(),
+		"tcp://" + tcp.Addr().String(),
+		"tls://" + dot.Addr().String(),
+		h.URL + "/dns-query",
+	}, tlsConfig, httpClient
+}
+
+func serveStream(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			var length [2]byte
+			for {
+				if _, err := io.ReadFull(conn, length[:]); err != nil {
+					return
+				}
+				query := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(conn, query); err != nil {
+					return
+				}
+				resp := answerQuery(query, false)
+				_, _ = conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(resp))), resp...))
+			}
+		}()
+	}
+}
+
+func TestClient(t *testing.T) {
+	urls, tlsConfig, httpClient := stubServers(t)
+	ctx := context.Background()
+
+	for _, server := range urls {
+		c := &Client{Server: server, TLSConfig: tlsConfig, HTTPClient: httpClient}
+
+		addrs, ttl, err := c.LookupTTL(ctx, "ip", "example.test")
+		if !assert.NoError(t, err, server) {
+			continue
+		}
+		assert.Equal(t, []netip.Addr{netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("2001:db8::1")}, addrs, server)
+		assert.Equal(t, 60*time.Second, ttl, server)
+
+		addrs, ttl, err = c.LookupTTL(ctx, "ip4", "Alias.Example.Test.")
+		assert.NoError(t, err, server)
+		assert.Equal(t, []netip.Addr{netip.MustParseAddr("192.0.2.1")}, addrs, server)
+		assert.Equal(t, 30*time.Second, ttl, "the TTL of the CNAME")
+
+		addrs, ttl, err = c.LookupTTL(ctx, "ip", "v4.example.test")
+		assert.NoError(t, err, server)
+		assert.Equal(t, []netip.Addr{netip.MustParseAddr("192.0.2.4")}, addrs, server)
+		assert.Equal(t, 20*time.Second, ttl, "the minimum of the SOA of the missing AAAA")
+
+		_, ttl, err = c.LookupTTL(ctx, "ip6", "v4.example.test")
+		assert.True(t, isNotFound(err), "%s: %v", server, err)
+		assert.Equal(t, 20*time.Second, ttl, server)
+
+		_, ttl, err = c.LookupTTL(ctx, "ip", "nowhere.test")
+		var dnsErr *net.DNSError
+		if assert.True(t, errors.As(err, &dnsErr), server) {
+			assert.True(t, dnsErr.IsNotFound)
+			assert.Equal(t, "nowhere.test", dnsErr.Name)
+		}
+		assert.Equal(t, 20*time.Second, ttl, server)
+	}
+
+	// truncated over UDP, retried over TCP
+	c := &Client{Server: "udp://" + urls[0]}
+	addrs, err := c.LookupNetIP(ctx, "ip4", "big.example.test")
+	assert.NoError(t, err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("192.0.2.1")}, addrs)
+
+	_, err = c.LookupNetIP(ctx, "ip", "bad..name")
+	assert.ErrorIs(t, err, ErrInvalidName)
+	_, err = (&Client{Server: "quic://127.0.0.1"}).LookupNetIP(ctx, "ip", "example.test")
+	assert.ErrorIs(t, err, ErrUnsupportedScheme)
+	addrs, _ = c.LookupNetIP(ctx, "ip", "192.0.2.9")
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("192.0.2.9")}, addrs)
+}
+
+func TestClientTimeout(t *testing.T) {
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	c := &Client{Server: silent.LocalAddr().String(), Timeout: 50 * time.Millisecond}
+	_, ttl, err := c.LookupTTL(context.Background(), "ip", "example.test")
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	assert.Zero(t, ttl, "not cached")
+}
+
+// response builds a response to query with the question q and the answer records.
+func response(query, q []byte, records ...record) []byte {
+	off, _ := skipName(query, headerLen)
+	b := append([]byte(nil), query[:headerLen]...)
+	if q == nil {
+		q = query[headerLen : off+4]
+	}
+	b = append(b, q...)
+	for _, r := range records {
+		b = appendRecord(b, r)
+	}
+	binary.BigEndian.PutUint16(b[2:], flagResponse)
+	if len(q) == 0 {
+		binary.BigEndian.PutUint16(b[4:], 0)
+	}
+	binary.BigEndian.PutUint16(b[6:], uint16(len(records)))
+	return b
+}
+
+func TestParseResponseSpoofed(t *testing.T) {
+	query, _ := newQuery("Victim.Test", typeA)
+	a, err := parseResponse(response(query, nil,
+		record{typeA, 60, []byte{203, 0, 113, 6}, "evil.test"},
+		record{typeA, 60, []byte{192, 0, 2, 1}, "victim.test"}), query)
+	assert.NoError(t, err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("192.0.2.1")}, a.addrs, "the records of other names are ignored")
+
+	// the CNAME chain, in any order
+	a, err = parseResponse(response(query, nil,
+		record{typeA, 60, []byte{192, 0, 2, 3}, "target.test"},
+		record{typeA, 60, []byte{203, 0, 113, 6}, "other.test"},
+		record{typeCNAME, 60, []byte("\x06target\x04test\x00"), "mid.test"},
+		record{typeCNAME, 60, []byte("\x03mid\x04test\x00"), ""}), query)
+	assert.NoError(t, err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("192.0.2.3")}, a.addrs)
+
+	other, _ := newQuery("evil.test", typeA)
+	aaaa, _ := newQuery("victim.test", typeAAAA)
+	for _, q := range [][]byte{other[headerLen:], aaaa[headerLen:], {}} {
+		_, err = parseResponse(response(query, q, record{typeA, 60, []byte{203, 0, 113, 6}, "victim.test"}), query)
+		assert.Equal(t, ErrQuestionMismatch, err)
+	}
+
+	// a compression pointer going forward, which could loop
+	loop := response(query, nil)
+	loop = append(loop, 0xC0, byte(len(loop)+2), 0x01, 'a', 0xC0, byte(len(loop)))
+	binary.BigEndian.PutUint16(loop[6:], 1)
+	_, err = parseResponse(loop, query)
+	assert.Equal(t, ErrFormat, err)
+
+	// counts claiming more records than the response holds, allocated before being parsed
+	huge := response(query, nil)
+	binary.BigEndian.PutUint16(huge[6:], 0xFFFF)
+	binary.BigEndian.PutUint16(huge[8:], 0xFFFF)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err = parseResponse(huge, query)
+	runtime.ReadMemStats(&after)
+	assert.Equal(t, ErrFormat, err)
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1<<20))
+}
+
+func TestClientSkipsSpoofed(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go func() {
+		b := make([]byte, 512)
+		n, from, err := server.ReadFrom(b)
+		if err != nil {
+			return
+		}
+		query := b[:n]
+		other, _ := newQuery("evil.test", typeA)
+		_, _ = server.WriteTo(response(query, other[headerLen:], record{typeA, 60, []byte{203, 0, 113, 6}, "evil.test"}), from)
+		_, _ = server.WriteTo(response(query, nil, record{typeA, 60, []byte{192, 0, 2, 1}, ""}), from)
+	}()
+
+	addrs, err := (&Client{Server: server.LocalAddr().String()}).LookupNetIP(context.Background(), "ip4", "victim.test")
+	assert.NoError(t, err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("192.0.2.1")}, addrs)
+}
+
+// countingResolver answers from a map, counting the lookups.
+type countingResolver struct {
+	addrs   map[string][]netip.Addr
+	lookups int
+}
+
+func (r *countingResolver) LookupNetIP(_ context.Context, _, host string) ([]netip.Addr, error) {
+	r.lookups++
+	switch addrs, ok := r.addrs[host]; {
+	case ok:
+		return addrs, nil
+	case host == "fail.test":
+		return nil, &net.DNSError{Err: "server misbehaving", Name: host, IsTemporary: true}
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+func TestCache(t *testing.T) {
+	now := time.Unix(0, 0)
+	r := &countingResolver{addrs: map[string][]netip.Addr{"example.test": {netip.MustParseAddr("192.0.2.1")}}}
+	c := &Cache{Resolver: r, MaxEntries: 2, now: func() time.Time { return now }}
+	ctx := context.Background()
+
+	for _, host := range []string{"example.test", "EXAMPLE.test."} {
+		addrs, err := c.LookupNetIP(ctx, "ip", host)
+		assert.NoError(t, err)
+		assert.Equal(t, []netip.Addr{netip.MustParseAddr("192.0.2.1")}, addrs)
+	}
+	assert.Equal(t, 1, r.lookups)
+
+	now = now.Add(DefaultTTL)
+	_, _ = c.LookupNetIP(ctx, "ip", "example.test")
+	assert.Equal(t, 2, r.lookups, "expired")
+
+	// negative caching
+	for i := 0; i < 2; i++ {
+		_, err := c.LookupNetIP(ctx, "ip", "nowhere.test")
+		assert.True(t, isNotFound(err))
+	}
+	assert.Equal(t, 3, r.lookups)
+	now = now.Add(DefaultNegativeTTL)
+	_, _ = c.LookupNetIP(ctx, "ip", "nowhere.test")
+	assert.Equal(t, 4, r.lookups)
+
+	// the other failures aren't cached
+	for i := 0; i < 2; i++ {
+		_, err := c.LookupNetIP(ctx, "ip", "fail.test")
+		assert.Error(t, err)
+	}
+	assert.Equal(t, 6, r.lookups)
+
+	// full, the expired entries are dropped first
+	now = now.Add(DefaultNegativeTTL)
+	_, _ = c.LookupNetIP(ctx, "ip4", "example.test")
+	_, _ = c.LookupNetIP(ctx, "ip", "example.test")
+	assert.Len(t, c.entries, 2)
+	assert.Equal(t, 8, r.lookups)
+}
+
+func TestCacheTTL(t *testing.T) {
+	urls, _, _ := stubServers(t)
+	now := time.Unix(0, 0)
+	c := &Cache{Resolver: &Client{Server: urls[1]}, MaxTTL: 45 * time.Second, now: func() time.Time { return now }}
+	ctx := context.Background()
+
+	_, ttl, err := c.LookupTTL(ctx, "ip4", "example.test")
+	assert.NoError(t, err)
+	assert.Equal(t, 45*time.Second, ttl, "capped")
+
+	now = now.Add(40 * time.Second)
+	_, ttl, _ = c.LookupTTL(ctx, "ip4", "example.test")
+	assert.Equal(t, 5*time.Second, ttl, "what remains")
+
+	_, ttl, err = c.LookupTTL(ctx, "ip", "nowhere.test")
+	assert.True(t, isNotFound(err))
+	assert.Equal(t, 20*time.Second, ttl, "from the SOA")
+}
@@ -0,0 +1,292 @@
+package dns
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Record types and class of the questions.
+const (
+	typeA     uint16 = 1
+	typeCNAME uint16 = 5
+	typeSOA   uint16 = 6
+	typeAAAA  uint16 = 28
+	classIN   uint16 = 1
+)
+
+// Header flags and response codes.
+const (
+	flagResponse         = 1 << 15
+	flagTruncated        = 1 << 9
+	flagRecursionDesired = 1 << 8
+
+	rcodeSuccess       = 0
+	rcodeServerFailure = 2
+	rcodeNameError     = 3
+)
+
+const (
+	headerLen    = 12
+	minRecordLen = 11 // a root owner name, the type, class, TTL and length
+)
+
+// newQuery packs a recursive query for the records of type qtype of name, with a random ID.
+func newQuery(name string, qtype uint16) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || len(name) > 253 {
+		return nil, ErrInvalidName
+	}
+
+	b := make([]byte, headerLen, headerLen+len(name)+6)
+	_, _ = rand.Read(b[:2])
+	binary.BigEndian.PutUint16(b[2:], flagRecursionDesired)
+	binary.BigEndian.PutUint16(b[4:], 1) // one question
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return nil, ErrInvalidName
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	b = append(b, 0)
+	b = binary.BigEndian.AppendUint16(b, qtype)
+	b = binary.BigEndian.AppendUint16(b, classIN)
+	return b, nil
+}
+
+// answer is a parsed response.
+type answer struct {
+	addrs []netip.Addr
+	ttl   time.Duration // of the addresses, or of the absence of records from the SOA
+	rcode int
+}
+
+// rr is a resource record of a response.
+type rr struct {
+	owner  string // lowercase, without the trailing dot
+	rtype  uint16
+	class  uint16
+	ttl    uint32
+	rdata  []byte
+	offset int // of rdata in the message, for the compressed names it holds
+}
+
+// parseResponse parses the response to query, keeping the addresses of the type asked owned by the name
+// asked or by the CNAMEs it leads to. The question of the response has to be the one of the query.
+func parseResponse(b, query []byte) (a answer, err error) {
+	if len(b) < headerLen || len(query) < headerLen {
+		return a, ErrFormat
+	}
+	if b[0] != query[0] || b[1] != query[1] {
+		return a, ErrIDMismatch
+	}
+	flags := binary.BigEndian.Uint16(b[2:])
+	if flags&flagResponse == 0 {
+		return a, ErrFormat
+	}
+	if flags&flagTruncated != 0 {
+		return a, errTruncated
+	}
+	a.rcode = int(flags & 0xF)
+
+	qname, qoff, err := readName(query, headerLen)
+	if err != nil || len(query) < qoff+4 {
+		return a, ErrFormat
+	}
+	qtype := binary.BigEndian.Uint16(query[qoff:])
+
+	questions := int(binary.BigEndian.Uint16(b[4:]))
+	answers := int(binary.BigEndian.Uint16(b[6:]))
+	authorities := int(binary.BigEndian.Uint16(b[8:]))
+
+	if questions != 1 {
+		return a, ErrQuestionMismatch
+	}
+	name, off, err := readName(b, headerLen)
+	if err != nil || len(b) < off+4 {
+		return a, ErrFormat
+	}
+	if name != qname || !bytes.Equal(b[off:off+4], query[qoff:qoff+4]) {
+		return a, ErrQuestionMismatch
+	}
+	off += 4
+
+	// the counts are bounded by the records the rest of the response could hold
+	if (answers+authorities)*minRecordLen > len(b)-off {
+		return a, ErrFormat
+	}
+	records := make([]rr, answers+authorities)
+	for i := range records {
+		if records[i], off, err = parseRecord(b, off); err != nil {
+			return a, err
+		}
+	}
+
+	// the names the CNAMEs lead to, whatever the order of the records
+	owners := map[string]bool{qname: true}
+	for grew := true; grew; {
+		grew = false
+		for _, r := range records[:answers] {
+			if r.rtype != typeCNAME || r.class != classIN || !owners[r.owner] {
+				continue
+			}
+			target, _, err := readName(b, r.offset)
+			if err != nil {
+				return a, err
+			}
+			if !owners[target] {
+				owners[target], grew = true, true
+			}
+		}
+	}
+
+	ttl := uint32(1<<32 - 1)
+	for i, r := range records {
+		if r.class != classIN {
+			continue
+		}
+		switch {
+		case i < answers && !owners[r.owner]:
+			// records of other names are ignored, not to poison the caches
+			continue
+		case i < answers && r.rtype == qtype && (r.rtype == typeA && len(r.rdata) == 4 || r.rtype == typeAAAA && len(r.rdata) == 16):
+			ip, _ := netip.AddrFromSlice(r.rdata)
+			a.addrs = append(a.addrs, ip)
+		case i < answers && r.rtype == typeCNAME:
+			// the CNAMEs leading to the addresses
+		default:
+			continue
+		}
+		if r.ttl < ttl {
+			ttl = r.ttl
+		}
+	}
+	if len(a.addrs) == 0 {
+		for _, r := range records[answers:] {
+			if r.rtype != typeSOA {
+				continue
+			}
+			// negative answers are cached for the minimum of the SOA, at most its TTL
+			rest, err := skipName(r.rdata, 0)
+			if err == nil {
+				rest, err = skipName(r.rdata, rest)
+			}
+			if err != nil || len(r.rdata) < rest+20 {
+				return a, ErrFormat
+			}
+			rttl := r.ttl
+			if minimum := binary.BigEndian.Uint32(r.rdata[rest+16:]); minimum < rttl {
+				rttl = minimum
+			}
+			if rttl < ttl {
+				ttl = rttl
+			}
+		}
+	}
+	if ttl != 1<<32-1 {
+		a.ttl = time.Duration(ttl) * time.Second
+	}
+	return a, nil
+}
+
+// parseRecord parses the resource record at off, returning the offset of the next one.
+func parseRecord(b []byte, off int) (r rr, next int, err error) {
+	if r.owner, off, err = readName(b, off); err != nil {
+		return
+	}
+	if len(b) < off+10 {
+		err = ErrFormat
+		return
+	}
+	r.rtype = binary.BigEndian.Uint16(b[off:])
+	r.class = binary.BigEndian.Uint16(b[off+2:])
+	r.ttl = binary.BigEndian.Uint32(b[off+4:])
+	length := int(binary.BigEndian.Uint16(b[off+8:]))
+	off += 10
+	if len(b) < off+length {
+		err = ErrFormat
+		return
+	}
+	r.rdata, r.offset = b[off:off+length], off
+	return r, off + length, nil
+}
+
+// readName reads the possibly compressed name at off, in lowercase without the trailing dot,
+// returning the offset following it.
+func readName(b []byte, off int) (name string, next int, err error) {
+	var labels []string
+	next = -1
+	for jumps := 0; ; {
+		if off >= len(b) {
+			return "", 0, ErrFormat
+		}
+		switch length := int(b[off]); {
+		case length == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.ToLower(strings.Join(labels, ".")), next, nil
+		case length&0xC0 == 0xC0:
+			if off+2 > len(b) {
+				return "", 0, ErrFormat
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			// pointers go backwards, a bounded number of times against loops
+			ptr := int(binary.BigEndian.Uint16(b[off:]) & 0x3FFF)
+			if jumps++; ptr >= off || jumps > 127 {
+				return "", 0, ErrFormat
+			}
+			off = ptr
+		case length > 63 || off+1+length > len(b):
+			return "", 0, ErrFormat
+		default:
+			labels = append(labels, string(b[off+1:off+1+length]))
+			off += 1 + length
+		}
+	}
+}
+
+// skipName returns the offset following the possibly compressed name at off.
+func skipName(b []byte, off int) (int, error) {
+	for {
+		if off >= len(b) {
+			return 0, ErrFormat
+		}
+		switch length := int(b[off]); {
+		case length == 0:
+			return off + 1, nil
+		case length&0xC0 == 0xC0:
+			if off+2 > len(b) {
+				return 0, ErrFormat
+			}
+			return off + 2, nil
+		case length > 63:
+			return 0, ErrFormat
+		default:
+			off += 1 + length
+		}
+	}
+}
+
+// answerError returns the error of a response code, as *net.DNSError like the net package does.
+func answerError(a answer, name, server string) error {
+	switch a.rcode {
+	case rcodeSuccess:
+		if len(a.addrs) > 0 {
+			return nil
+		}
+		return &net.DNSError{Err: "no such host", Name: name, Server: server, IsNotFound: true}
+	case rcodeNameError:
+		return &net.DNSError{Err: "no such host", Name: name, Server: server, IsNotFound: true}
+	case rcodeServerFailure:
+		return &net.DNSError{Err: "server misbehaving", Name: name, Server: server, IsTemporary: true}
+	}
+	return &net.DNSError{Err: "server refused the query", Name: name, Server: server}
+}
@@ -6,4 +6,31 @@ var (
 	// ErrServerClosed is returned by the Server's Serve, ServeTLS, ListenAndServe,
 	// and ListenAndServeTLS methods after a call to Shutdown or Close.
 	ErrServerClosed = errors.New("http: Server closed")
+
+	// ErrAuthFailed is returned when a client presents invalid credentials.
+	ErrAuthFailed = errors.New("authentication failed")
+
+	// ErrHandshakeTimeout closes the connections of the clients exceeding the HandshakeTimeout of the Server.
+	ErrHandshakeTimeout = errors.New("handshake timeout")
+
+	// ErrIdleTimeout closes the relays idle for the IdleTimeout of the Server.
+	ErrIdleTimeout = errors.New("idle timeout")
+
+	// ErrMaxLifetime closes the connections open for the MaxLifetime of the Server.
+	ErrMaxLifetime = errors.New("maximum connection lifetime exceeded")
+
+	// ErrNoProxy is returned when there is no proxy server to dial through.
+	ErrNoProxy = errors.New("no proxy server")
+
+	// ErrUnsupportedScheme is returned for proxy URLs with an unknown scheme.
+	ErrUnsupportedScheme = errors.New("unsupported proxy scheme")
+
+	// ErrTLSPinMismatch is returned when the proxy certificate matches none of the pins.
+	ErrTLSPinMismatch = errors.New("tls: certificate pin mismatch")
+
+	// ErrTLSNotSupported is returned by DialTCP when the client is configured with TLS.
+	ErrTLSNotSupported = errors.New("tls: not supported by DialTCP")
+
+	// ErrBindToDeviceUnsupported is returned by DirectDialer for an Interface outside Linux.
+	ErrBindToDeviceUnsupported = errors.New("binding to an interface is only supported on Linux")
 )
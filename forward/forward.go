@@ -0,0 +1,30 @@
+// Package forward forwards local connections through a proxy server, like ssh -L and ssh -D do:
+// Static forwards every connection to a fixed target, Redirect serves a local SOCKS server
+// forwarding every request to an upstream proxy server with its own credentials, Transparent
+// forwards the connections a firewall intercepted to their original destinations, and TUN forwards
+// the flows of the packets routed to a TUN device.
+package forward
+
+import "github.com/kayabe/socks/internal/counting"
+
+// Stats of a forward since it started.
+type Stats struct {
+	Accepted   int64 // connections accepted
+	Active     int64 // connections open
+	Dials      int64 // connections dialed through the proxy server
+	DialErrors int64 // dials which failed
+	Sent       int64 // bytes sent to the targets
+	Received   int64 // bytes received from the targets
+}
+
+// stats returns the Stats of the counters of a forward.
+func stats(c *counting.Counters) Stats {
+	return Stats{
+		Accepted:   c.Accepted.Load(),
+		Active:     c.Active.Load(),
+		Dials:      c.Dials.Load(),
+		DialErrors: c.DialErrors.Load(),
+		Sent:       c.Sent.Load(),
+		Received:   c.Received.Load(),
+	}
+}
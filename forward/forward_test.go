@@ -0,0 +1,196 @@
+package forward
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kayabe/socks"
+	"github.com/kayabe/socks/sockstest"
+	"github.com/stretchr/testify/assert"
+)
+
+func listen(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func assertEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "ping", string(buf))
+}
+
+func serve(t *testing.T, f *Static) string {
+	l := listen(t)
+	go func() { _ = f.Serve(l) }()
+	t.Cleanup(func() { f.Close() })
+	return l.Addr().String()
+}
+
+func TestStatic(t *testing.T) {
+	proxy := sockstest.NewServer(sockstest.Script{})
+	defer proxy.Close()
+	proxy.Handle("db.internal:5432", sockstest.EchoHandler)
+
+	f := &Static{Target: "db.internal:5432", Dialer: proxy.Client()}
+	conn, err := net.Dial("tcp", serve(t, f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEcho(t, conn)
+	conn.Close()
+
+	assert.Equal(t, "db.internal:5432", proxy.Requests()[0].Address())
+
+	assert.Eventually(t, func() bool { return f.Stats().Active == 0 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, Stats{Accepted: 1, Dials: 1, Sent: 4, Received: 4}, f.Stats())
+}
+
+func TestStaticRefused(t *testing.T) {
+	proxy := sockstest.NewServer(sockstest.Script{})
+	defer proxy.Close()
+
+	f := &Static{Target: "other.internal:22", Dialer: proxy.Client(), ErrorLog: log.New(io.Discard, "", 0)}
+	conn, err := net.Dial("tcp", serve(t, f))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+
+	assert.Eventually(t, func() bool { return f.Stats().Active == 0 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, Stats{Accepted: 1, Dials: 1, DialErrors: 1}, f.Stats())
+}
+
+func TestStaticShutdown(t *testing.T) {
+	proxy := sockstest.NewServer(sockstest.Script{Handler: sockstest.EchoHandler})
+	defer proxy.Close()
+
+	f := &Static{Target: "example.com:80", Dialer: proxy.Client()}
+	l := listen(t)
+	served := make(chan error, 1)
+	go func() { served <- f.Serve(l) }()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEcho(t, conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, f.Shutdown(ctx), "the connection is still open")
+	assert.Equal(t, socks.ErrServerClosed, <-served)
+
+	conn.Close()
+	assert.NoError(t, f.Shutdown(context.Background()))
+	assert.Equal(t, socks.ErrServerClosed, f.Serve(listen(t)))
+}
+
+func TestRedirect(t *testing.T) {
+	echo := listen(t)
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	upstream := &socks.Server{Credentials: socks.StaticCredentials{"upstream": "secret"}}
+	ul := listen(t)
+	go func() { _ = upstream.Serve(ul) }()
+	defer upstream.Close()
+
+	client, _ := socks.NewClient(ul.Addr().String(), socks.WithUserPW("upstream", "secret"))
+	r := &Redirect{
+		Server:   socks.Server{Credentials: socks.StaticCredentials{"local": "pass"}, ErrorLog: log.New(io.Discard, "", 0)},
+		Upstream: client,
+	}
+	l := listen(t)
+	go func() { _ = r.Serve(l) }()
+	defer r.Close()
+
+	for _, version := range []socks.Protocol{socks.V5, socks.HTTP} {
+		local, _ := socks.NewClient(l.Addr().String(), socks.WithVersion(version), socks.WithUserPW("local", "pass"))
+		conn, err := local.Dial("tcp", echo.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEcho(t, conn)
+		conn.Close()
+	}
+
+	// the upstream credentials aren't accepted locally
+	local, _ := socks.NewClient(l.Addr().String(), socks.WithUserPW("upstream", "secret"))
+	_, err := local.Dial("tcp", echo.Addr().String())
+	assert.Equal(t, socks.ErrAuthFailed, err)
+
+	assert.Eventually(t, func() bool { return r.Stats().Active == 0 }, 5*time.Second, 10*time.Millisecond)
+	stats := r.Stats()
+	assert.Equal(t, int64(3), stats.Accepted)
+	assert.Equal(t, int64(2), stats.Dials)
+	assert.Equal(t, int64(8), stats.Received)
+}
+
+func TestStaticHalfClose(t *testing.T) {
+	// a target replying once the client stopped writing
+	target := listen(t)
+	defer target.Close()
+	go func() {
+		conn, err := target.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b, _ := io.ReadAll(conn)
+		_, _ = conn.Write(b)
+	}()
+
+	upstream := &socks.Server{ErrorLog: log.New(io.Discard, "", 0)}
+	ul := listen(t)
+	go func() { _ = upstream.Serve(ul) }()
+	defer upstream.Close()
+	client, _ := socks.NewClient(ul.Addr().String())
+
+	conn, err := net.Dial("tcp", serve(t, &Static{Target: target.Addr().String(), Dialer: client}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, _ = conn.Write([]byte("request"))
+	assert.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+	reply, err := io.ReadAll(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, "request", string(reply))
+}
+
+func TestRedirectNoUpstream(t *testing.T) {
+	r := &Redirect{}
+	assert.Equal(t, ErrNoUpstream, r.Serve(listen(t)))
+	assert.Equal(t, ErrNoUpstream, r.ServeTLS(listen(t), "", ""))
+}
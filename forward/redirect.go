@@ -0,0 +1,87 @@
+package forward
+
+import (
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/kayabe/socks"
+	"github.com/kayabe/socks/internal/counting"
+)
+
+// ErrNoUpstream is returned by a Redirect without Upstream.
+var ErrNoUpstream = errors.New("no upstream proxy server")
+
+// Redirect is a local SOCKS server forwarding every request to the Upstream proxy server, like ssh -D does.
+// The clients authenticate with the Credentials of the local server while Upstream uses its own,
+// which makes Redirect an authentication-translating proxy.
+//
+// The Dialer of the local server is replaced by Upstream, its Rules still apply.
+type Redirect struct {
+	socks.Server
+
+	// Upstream is the proxy server the requests are forwarded to.
+	Upstream *socks.Client
+
+	counters counting.Counters
+	once     sync.Once
+}
+
+// init sets the Dialer of the local server, the listener is closed without Upstream.
+func (r *Redirect) init(l net.Listener) error {
+	if r.Upstream == nil {
+		l.Close()
+		return ErrNoUpstream
+	}
+	r.once.Do(func() {
+		r.Server.Dialer = counting.Dialer{Dialer: r.Upstream, C: &r.counters}
+	})
+	return nil
+}
+
+// ListenAndServe listens on the TCP network address r.Addr, ":1080" if empty, and then calls Serve.
+func (r *Redirect) ListenAndServe() error {
+	addr := r.Addr
+	if addr == "" {
+		addr = ":1080"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return r.Serve(l)
+}
+
+// Serve serves SOCKS clients on the listener, see socks.Server.Serve.
+func (r *Redirect) Serve(l net.Listener) error {
+	if err := r.init(l); err != nil {
+		return err
+	}
+	return r.Server.Serve(counting.Listener{Listener: l, C: &r.counters})
+}
+
+// ServeTLS serves SOCKS over TLS clients on the listener, see socks.Server.ServeTLS.
+func (r *Redirect) ServeTLS(l net.Listener, certFile, keyFile string) error {
+	if err := r.init(l); err != nil {
+		return err
+	}
+	return r.Server.ServeTLS(counting.Listener{Listener: l, C: &r.counters}, certFile, keyFile)
+}
+
+// ListenAndServeTLS listens on the TCP network address r.Addr, ":1080" if empty, and then calls ServeTLS.
+func (r *Redirect) ListenAndServeTLS(certFile, keyFile string) error {
+	addr := r.Addr
+	if addr == "" {
+		addr = ":1080"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return r.ServeTLS(l, certFile, keyFile)
+}
+
+// Stats returns the statistics of the forward.
+func (r *Redirect) Stats() Stats {
+	return stats(&r.counters)
+}
@@ -0,0 +1,77 @@
+package forward
+
+import (
+	"context"
+	"log"
+	"net"
+	"time"
+
+	"github.com/kayabe/socks"
+)
+
+// Static accepts connections locally and forwards each of them to Target through the proxy server,
+// like ssh -L does.
+type Static struct {
+	// Addr optionally specifies the TCP address to listen on, used by ListenAndServe.
+	Addr string
+
+	// Target is the host:port every connection is forwarded to.
+	Target string
+
+	// Dialer reaches Target, usually a *socks.Client.
+	Dialer socks.ContextDialer
+
+	// DialTimeout bounds dialing Target, no timeout if zero.
+	DialTimeout time.Duration
+
+	// ErrorLog specifies an optional logger for errors, the log package's standard logger is used if nil.
+	ErrorLog *log.Logger
+
+	tracker
+}
+
+// ListenAndServe listens on the TCP network address f.Addr and then calls Serve.
+func (f *Static) ListenAndServe() error {
+	if f.inShutdown.Load() {
+		return socks.ErrServerClosed
+	}
+	l, err := net.Listen("tcp", f.Addr)
+	if err != nil {
+		return err
+	}
+	return f.Serve(l)
+}
+
+// Serve accepts incoming connections on the listener, creating a new goroutine for each.
+// Serve always returns a non-nil error and closes l, socks.ErrServerClosed after Shutdown or Close.
+func (f *Static) Serve(l net.Listener) error {
+	return f.serve(l, f.forward)
+}
+
+func (f *Static) forward(conn net.Conn) {
+	ctx, cancel := dialContext(f.DialTimeout)
+	defer cancel()
+
+	target, err := f.dialer(f.Dialer).DialContext(ctx, "tcp", f.Target)
+	if err != nil {
+		logf(f.ErrorLog, "forward: %s: %s: %v", conn.RemoteAddr(), f.Target, err)
+		return
+	}
+	socks.Relay(conn, target)
+}
+
+// Shutdown gracefully shuts down the forward, it first closes all listeners
+// and then waits for the connections to finish or for the context to be done.
+func (f *Static) Shutdown(ctx context.Context) error {
+	return f.shutdown(ctx)
+}
+
+// Close immediately closes all listeners and connections.
+func (f *Static) Close() error {
+	return f.close()
+}
+
+// Stats returns the statistics of the forward.
+func (f *Static) Stats() Stats {
+	return stats(&f.counters)
+}
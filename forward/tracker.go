@@ -0,0 +1,151 @@
+package forward
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kayabe/socks"
+	"github.com/kayabe/socks/internal/counting"
+)
+
+// shutdownPollInterval is how often Shutdown checks for remaining connections.
+const shutdownPollInterval = 100 * time.Millisecond
+
+// tracker tracks the listeners and connections of a forward, for Shutdown and Close, and counts them.
+type tracker struct {
+	counters   counting.Counters
+	inShutdown atomic.Bool
+	mu         sync.Mutex
+	listeners  map[io.Closer]struct{}
+	conns      map[io.Closer]struct{}
+}
+
+// serve accepts connections on l and handles each of them in a new goroutine, which closes it.
+func (t *tracker) serve(l net.Listener, handle func(conn net.Conn)) error {
+	if !t.track(l, true) {
+		l.Close()
+		return socks.ErrServerClosed
+	}
+	defer t.track(l, false)
+	defer l.Close()
+
+	counted := counting.Listener{Listener: l, C: &t.counters}
+	for {
+		conn, err := counted.Accept()
+		if err != nil {
+			if t.inShutdown.Load() {
+				return socks.ErrServerClosed
+			}
+			return err
+		}
+		go func() {
+			t.trackConn(conn, true)
+			defer t.trackConn(conn, false)
+			defer conn.Close()
+			handle(conn)
+		}()
+	}
+}
+
+// dialer counts the dials of d.
+func (t *tracker) dialer(d socks.ContextDialer) socks.ContextDialer {
+	return counting.Dialer{Dialer: d, C: &t.counters}
+}
+
+// track adds or removes a listener, it reports false for a listener added after Shutdown.
+func (t *tracker) track(l io.Closer, add bool) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.listeners == nil {
+		t.listeners = make(map[io.Closer]struct{})
+	}
+	if add {
+		if t.inShutdown.Load() {
+			return false
+		}
+		t.listeners[l] = struct{}{}
+	} else {
+		delete(t.listeners, l)
+	}
+	return true
+}
+
+// trackConn adds or removes a connection, closed by Close.
+func (t *tracker) trackConn(c io.Closer, add bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conns == nil {
+		t.conns = make(map[io.Closer]struct{})
+	}
+	if add {
+		t.conns[c] = struct{}{}
+	} else {
+		delete(t.conns, c)
+	}
+}
+
+func (t *tracker) shutdown(ctx context.Context) error {
+	t.inShutdown.Store(true)
+
+	t.mu.Lock()
+	err := t.closeListenersLocked()
+	t.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		t.mu.Lock()
+		n := len(t.conns)
+		t.mu.Unlock()
+		if n == 0 {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (t *tracker) close() error {
+	t.inShutdown.Store(true)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	err := t.closeListenersLocked()
+	for c := range t.conns {
+		c.Close()
+	}
+	return err
+}
+
+func (t *tracker) closeListenersLocked() (err error) {
+	for l := range t.listeners {
+		if cerr := l.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return
+}
+
+// dialContext returns the context to dial with, bounded by timeout if positive.
+func dialContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+func logf(l *log.Logger, format string, args ...any) {
+	if l != nil {
+		l.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
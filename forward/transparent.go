@@ -0,0 +1,343 @@
+package forward
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/kayabe/socks"
+)
+
+var (
+	// ErrTransparentUnsupported is returned where transparent proxying isn't available, outside Linux.
+	ErrTransparentUnsupported = errors.New("transparent proxying is only supported on Linux")
+
+	// ErrUDPNeedsTProxy is returned by ListenAndServeUDP in NAT mode, which can't recover UDP destinations.
+	ErrUDPNeedsTProxy = errors.New("transparent UDP requires TProxy mode")
+
+	// ErrLoop is reported for connections whose original destination is the listener itself.
+	ErrLoop = errors.New("original destination is the listener")
+
+	// ErrNoOriginalDst is reported for datagrams received without their original destination.
+	ErrNoOriginalDst = errors.New("no original destination")
+)
+
+// DefaultUDPIdleTimeout ends the UDP flows without traffic, when Transparent.UDPIdleTimeout is zero.
+const DefaultUDPIdleTimeout = time.Minute
+
+// TransparentMode selects how Transparent recovers the original destinations.
+type TransparentMode uint8
+
+const (
+	NAT    TransparentMode = iota // iptables REDIRECT or DNAT, with SO_ORIGINAL_DST, TCP only
+	TProxy                        // iptables TPROXY, from the local address, TCP and UDP
+)
+
+// Transparent accepts the connections and datagrams a firewall intercepted for other destinations,
+// and forwards each of them to its original destination through the proxy server. It requires Linux.
+//
+// With NAT, the traffic is redirected with rules such as:
+//
+//	iptables -t nat -A OUTPUT -p tcp -m owner ! --uid-owner proxy -j REDIRECT --to-ports 1081
+//
+// With TProxy, the traffic is intercepted with rules such as:
+//
+//	iptables -t mangle -A PREROUTING -p tcp -j TPROXY --on-port 1081 --tproxy-mark 1
+//	iptables -t mangle -A PREROUTING -p udp -j TPROXY --on-port 1081 --tproxy-mark 1
+//	ip rule add fwmark 1 lookup 100
+//	ip route add local 0.0.0.0/0 dev lo table 100
+type Transparent struct {
+	// Addr optionally specifies the TCP and UDP address to listen on, used by ListenAndServe and ListenAndServeUDP.
+	Addr string
+
+	Mode TransparentMode
+
+	// Dialer reaches the original destinations, usually a *socks.Client. It has to support
+	// the udp network for ServeUDP, as socks.Client does through UDP ASSOCIATE.
+	Dialer socks.ContextDialer
+
+	// DialTimeout bounds dialing the destinations, no timeout if zero.
+	DialTimeout time.Duration
+
+	// UDPIdleTimeout ends the UDP flows without traffic, DefaultUDPIdleTimeout if zero.
+	UDPIdleTimeout time.Duration
+
+	// ErrorLog specifies an optional logger for errors, the log package's standard logger is used if nil.
+	ErrorLog *log.Logger
+
+	tracker
+}
+
+// ListenAndServe listens on the TCP network address f.Addr, transparently in TProxy mode, and then calls Serve.
+func (f *Transparent) ListenAndServe() error {
+	if f.inShutdown.Load() {
+		return socks.ErrServerClosed
+	}
+	lc := net.ListenConfig{}
+	if f.Mode == TProxy {
+		lc.Control = transparentControl
+	}
+	l, err := lc.Listen(context.Background(), "tcp", f.Addr)
+	if err != nil {
+		return err
+	}
+	return f.Serve(l)
+}
+
+// Serve accepts the intercepted connections on the listener, creating a new goroutine for each.
+// In TProxy mode, the listener has to be transparent, see ListenAndServe.
+// Serve always returns a non-nil error and closes l, socks.ErrServerClosed after Shutdown or Close.
+func (f *Transparent) Serve(l net.Listener) error {
+	listener := addrPort(l.Addr())
+	return f.serve(l, func(conn net.Conn) {
+		dst, err := f.destination(conn, listener)
+		if err != nil {
+			logf(f.ErrorLog, "transparent: %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+
+		ctx, cancel := dialContext(f.DialTimeout)
+		defer cancel()
+		target, err := f.dialer(f.Dialer).DialContext(ctx, "tcp", dst.String())
+		if err != nil {
+			logf(f.ErrorLog, "transparent: %s: %s: %v", conn.RemoteAddr(), dst, err)
+			return
+		}
+		socks.Relay(conn, target)
+	})
+}
+
+// destination returns the original destination of conn.
+func (f *Transparent) destination(conn net.Conn, listener netip.AddrPort) (dst netip.AddrPort, err error) {
+	if f.Mode == TProxy {
+		dst = addrPort(conn.LocalAddr())
+	} else if dst, err = originalDst(conn); err != nil {
+		return
+	}
+	if isLoop(dst, listener) {
+		return dst, ErrLoop
+	}
+	return dst, nil
+}
+
+// isLoop reports whether dst is the listener, which would forward to itself.
+func isLoop(dst, listener netip.AddrPort) bool {
+	if dst.Port() != listener.Port() {
+		return false
+	}
+	return dst.Addr() == listener.Addr() || dst.Addr().IsLoopback()
+}
+
+// ListenAndServeUDP listens transparently on the UDP network address f.Addr and then calls ServeUDP,
+// it requires TProxy mode.
+func (f *Transparent) ListenAndServeUDP() error {
+	if f.Mode != TProxy {
+		return ErrUDPNeedsTProxy
+	}
+	if f.inShutdown.Load() {
+		return socks.ErrServerClosed
+	}
+	conn, err := listenTransparentUDP(f.Addr)
+	if err != nil {
+		return err
+	}
+	return f.ServeUDP(conn)
+}
+
+// ServeUDP forwards the intercepted datagrams received on conn, each flow from a source to
+// an original destination being associated through the proxy server. The replies are sent
+// from the original destination. conn has to be transparent and report the original
+// destinations, see ListenAndServeUDP.
+// ServeUDP always returns a non-nil error and closes conn, socks.ErrServerClosed after Shutdown or Close.
+func (f *Transparent) ServeUDP(conn *net.UDPConn) error {
+	if !f.track(conn, true) {
+		conn.Close()
+		return socks.ErrServerClosed
+	}
+	defer f.track(conn, false)
+	defer conn.Close()
+
+	var mu sync.Mutex
+	flows := make(map[[2]netip.AddrPort]*udpFlow)
+
+	buf := make([]byte, 0xFFFF)
+	oob := make([]byte, 1024)
+	for {
+		n, oobn, _, src, err := conn.ReadMsgUDPAddrPort(buf, oob)
+		if err != nil {
+			if f.inShutdown.Load() {
+				return socks.ErrServerClosed
+			}
+			return err
+		}
+		dst, err := originalDstUDP(oob[:oobn])
+		if err != nil {
+			logf(f.ErrorLog, "transparent: %s: %v", src, err)
+			continue
+		}
+		src, dst = unmap(src), unmap(dst)
+
+		key := [2]netip.AddrPort{src, dst}
+		mu.Lock()
+		flow, ok := flows[key]
+		if !ok {
+			flow = &udpFlow{f: f, src: src, dst: dst}
+			flows[key] = flow
+			f.counters.Accepted.Add(1)
+			f.counters.Active.Add(1)
+			f.trackConn(flow, true)
+			go func() {
+				flow.run()
+				f.trackConn(flow, false)
+				f.counters.Active.Add(-1)
+				mu.Lock()
+				delete(flows, key)
+				mu.Unlock()
+			}()
+		}
+		mu.Unlock()
+
+		flow.send(append([]byte(nil), buf[:n]...))
+	}
+}
+
+// Shutdown gracefully shuts down the forward, it first closes all listeners and UDP connections
+// and then waits for the connections and UDP flows to finish or for the context to be done.
+func (f *Transparent) Shutdown(ctx context.Context) error {
+	return f.shutdown(ctx)
+}
+
+// Close immediately closes all listeners, connections and UDP flows.
+func (f *Transparent) Close() error {
+	return f.close()
+}
+
+// Stats returns the statistics of the forward, each UDP flow counting as a connection.
+func (f *Transparent) Stats() Stats {
+	return stats(&f.counters)
+}
+
+func (f *Transparent) udpIdleTimeout() time.Duration {
+	if f.UDPIdleTimeout > 0 {
+		return f.UDPIdleTimeout
+	}
+	return DefaultUDPIdleTimeout
+}
+
+// udpFlow relays the datagrams from a source to an original destination, and the replies back.
+type udpFlow struct {
+	f        *Transparent
+	src, dst netip.AddrPort
+
+	mu      sync.Mutex
+	pending [][]byte // datagrams received while dialing
+	up      net.Conn // to the destination through the proxy server
+	reply   net.Conn // from the destination to the source
+	closed  bool
+}
+
+func (u *udpFlow) send(datagram []byte) {
+	u.mu.Lock()
+	up := u.up
+	if up == nil {
+		if !u.closed && len(u.pending) < 16 {
+			u.pending = append(u.pending, datagram)
+		}
+		u.mu.Unlock()
+		return
+	}
+	u.mu.Unlock()
+	if _, err := up.Write(datagram); err == nil {
+		_ = up.SetReadDeadline(time.Now().Add(u.f.udpIdleTimeout()))
+	}
+}
+
+func (u *udpFlow) run() {
+	defer u.Close()
+	f := u.f
+
+	ctx, cancel := dialContext(f.DialTimeout)
+	up, err := f.dialer(f.Dialer).DialContext(ctx, "udp", u.dst.String())
+	cancel()
+	if err != nil {
+		logf(f.ErrorLog, "transparent: %s: %s: %v", u.src, u.dst, err)
+		return
+	}
+	reply, err := dialTransparentReply(u.dst, u.src)
+	if err != nil {
+		up.Close()
+		logf(f.ErrorLog, "transparent: %s: %s: %v", u.src, u.dst, err)
+		return
+	}
+
+	u.mu.Lock()
+	if u.closed {
+		u.mu.Unlock()
+		up.Close()
+		reply.Close()
+		return
+	}
+	u.up, u.reply = up, reply
+	pending := u.pending
+	u.pending = nil
+	u.mu.Unlock()
+
+	for _, datagram := range pending {
+		_, _ = up.Write(datagram)
+	}
+
+	// being connected, the reply socket receives the next datagrams of the flow instead of ServeUDP
+	go func() {
+		buf := make([]byte, 0xFFFF)
+		for {
+			n, err := reply.Read(buf)
+			if err != nil {
+				return
+			}
+			u.send(append([]byte(nil), buf[:n]...))
+		}
+	}()
+
+	buf := make([]byte, 0xFFFF)
+	for {
+		_ = up.SetReadDeadline(time.Now().Add(f.udpIdleTimeout()))
+		n, err := up.Read(buf)
+		if err != nil {
+			return
+		}
+		if _, err := reply.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
+
+// Close ends the flow.
+func (u *udpFlow) Close() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.closed = true
+	if u.up != nil {
+		u.up.Close()
+		u.reply.Close()
+	}
+	return nil
+}
+
+func addrPort(addr net.Addr) netip.AddrPort {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return unmap(a.AddrPort())
+	case *net.UDPAddr:
+		return unmap(a.AddrPort())
+	}
+	ap, _ := netip.ParseAddrPort(addr.String())
+	return unmap(ap)
+}
+
+func unmap(ap netip.AddrPort) netip.AddrPort {
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+}
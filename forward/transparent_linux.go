@@ -0,0 +1,153 @@
+package forward
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"syscall"
+	"unsafe"
+)
+
+// Linux constants missing from syscall.
+const (
+	soOriginalDst       = 80 // SO_ORIGINAL_DST, linux/netfilter_ipv4.h
+	ip6tSoOriginalDst   = 80 // IP6T_SO_ORIGINAL_DST, linux/netfilter_ipv6/ip6_tables.h
+	ipv6Transparent     = 75 // IPV6_TRANSPARENT
+	ipv6RecvOrigDstAddr = 74 // IPV6_RECVORIGDSTADDR, IPV6_ORIGDSTADDR in control messages
+)
+
+// originalDst returns the destination of a connection before REDIRECT or DNAT, with SO_ORIGINAL_DST.
+func originalDst(conn net.Conn) (dst netip.AddrPort, err error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return dst, ErrTransparentUnsupported
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return
+	}
+
+	ipv6 := !addrPort(conn.LocalAddr()).Addr().Is4()
+	cerr := raw.Control(func(fd uintptr) {
+		if ipv6 {
+			// the sockaddr_in6 fills the beginning of the IPv6MTUInfo
+			var info *syscall.IPv6MTUInfo
+			if info, err = syscall.GetsockoptIPv6MTUInfo(int(fd), syscall.SOL_IPV6, ip6tSoOriginalDst); err == nil {
+				dst = sockaddr6(&info.Addr)
+			}
+			return
+		}
+		// the sockaddr_in fills the beginning of the IPv6Mreq
+		var mreq *syscall.IPv6Mreq
+		if mreq, err = syscall.GetsockoptIPv6Mreq(int(fd), syscall.SOL_IP, soOriginalDst); err == nil {
+			dst = sockaddr4((*syscall.RawSockaddrInet4)(unsafe.Pointer(&mreq.Multiaddr)))
+		}
+	})
+	if cerr != nil {
+		return dst, cerr
+	}
+	return
+}
+
+func sockaddr4(sa *syscall.RawSockaddrInet4) netip.AddrPort {
+	port := binary.BigEndian.Uint16((*[2]byte)(unsafe.Pointer(&sa.Port))[:])
+	return netip.AddrPortFrom(netip.AddrFrom4(sa.Addr), port)
+}
+
+func sockaddr6(sa *syscall.RawSockaddrInet6) netip.AddrPort {
+	port := binary.BigEndian.Uint16((*[2]byte)(unsafe.Pointer(&sa.Port))[:])
+	return netip.AddrPortFrom(netip.AddrFrom16(sa.Addr), port)
+}
+
+// transparentControl sets IP_TRANSPARENT, and IPV6_TRANSPARENT on IPv6 sockets, it requires CAP_NET_ADMIN.
+func transparentControl(network, _ string, c syscall.RawConn) error {
+	return setsockopts(c, network, func(fd int, ipv6 bool) error {
+		if err := syscall.SetsockoptInt(fd, syscall.SOL_IP, syscall.IP_TRANSPARENT, 1); err != nil {
+			return err
+		}
+		if ipv6 {
+			return syscall.SetsockoptInt(fd, syscall.SOL_IPV6, ipv6Transparent, 1)
+		}
+		return nil
+	})
+}
+
+// setsockopts runs set on the socket, telling whether it is an IPv6 socket.
+func setsockopts(c syscall.RawConn, network string, set func(fd int, ipv6 bool) error) (err error) {
+	cerr := c.Control(func(fd uintptr) {
+		ipv6 := network[len(network)-1] != '4'
+		if sa, serr := syscall.Getsockname(int(fd)); serr == nil {
+			_, ipv6 = sa.(*syscall.SockaddrInet6)
+		}
+		err = set(int(fd), ipv6)
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return
+}
+
+// listenTransparentUDP listens on a transparent UDP socket reporting the original destinations.
+func listenTransparentUDP(address string) (*net.UDPConn, error) {
+	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		if err := transparentControl(network, address, c); err != nil {
+			return err
+		}
+		return setsockopts(c, network, func(fd int, ipv6 bool) error {
+			if err := syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1); err != nil {
+				return err
+			}
+			if err := syscall.SetsockoptInt(fd, syscall.SOL_IP, syscall.IP_RECVORIGDSTADDR, 1); err != nil {
+				return err
+			}
+			if ipv6 {
+				return syscall.SetsockoptInt(fd, syscall.SOL_IPV6, ipv6RecvOrigDstAddr, 1)
+			}
+			return nil
+		})
+	}}
+	conn, err := lc.ListenPacket(context.Background(), "udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+// dialTransparentReply binds a transparent UDP socket to the original destination and connects it
+// to the source, to send the replies from the destination and receive the next datagrams of the flow.
+func dialTransparentReply(dst, src netip.AddrPort) (net.Conn, error) {
+	network := "udp4"
+	if dst.Addr().Is6() {
+		network = "udp6"
+	}
+	d := net.Dialer{
+		LocalAddr: net.UDPAddrFromAddrPort(dst),
+		Control: func(network, address string, c syscall.RawConn) error {
+			if err := transparentControl(network, address, c); err != nil {
+				return err
+			}
+			return setsockopts(c, network, func(fd int, _ bool) error {
+				return syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
+			})
+		},
+	}
+	return d.Dial(network, src.String())
+}
+
+// originalDstUDP returns the original destination reported in the control messages of a datagram.
+func originalDstUDP(oob []byte) (netip.AddrPort, error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	for _, m := range msgs {
+		switch {
+		case m.Header.Level == syscall.SOL_IP && m.Header.Type == syscall.IP_ORIGDSTADDR && len(m.Data) >= syscall.SizeofSockaddrInet4:
+			return sockaddr4((*syscall.RawSockaddrInet4)(unsafe.Pointer(&m.Data[0]))), nil
+		case m.Header.Level == syscall.SOL_IPV6 && m.Header.Type == ipv6RecvOrigDstAddr && len(m.Data) >= syscall.SizeofSockaddrInet6:
+			return sockaddr6((*syscall.RawSockaddrInet6)(unsafe.Pointer(&m.Data[0]))), nil
+		}
+	}
+	return netip.AddrPort{}, ErrNoOriginalDst
+}
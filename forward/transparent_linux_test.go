@@ -0,0 +1,193 @@
+package forward
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kayabe/socks"
+	"github.com/kayabe/socks/sockstest"
+	"github.com/stretchr/testify/assert"
+)
+
+// inNetns runs the test again in a new network namespace, where it can change the routes and firewall.
+// It reports whether the caller is the namespaced run, which has to go on with the test.
+func inNetns(t *testing.T, setup ...[]string) bool {
+	if os.Getenv("FORWARD_NETNS") == t.Name() {
+		for _, args := range append([][]string{{"ip", "link", "set", "lo", "up"}}, setup...) {
+			if out, err := exec.Command(args[0], args[1:]...).CombinedOutput(); err != nil {
+				t.Fatalf("%v: %v: %s", args, err, out)
+			}
+		}
+		return true
+	}
+
+	if os.Geteuid() != 0 {
+		t.Skip("requires root for a network namespace")
+	}
+	for _, args := range setup {
+		if _, err := exec.LookPath(args[0]); err != nil {
+			t.Skipf("requires %s", args[0])
+		}
+	}
+	if out, err := exec.Command("unshare", "-n", "true").CombinedOutput(); err != nil {
+		t.Skipf("no network namespace: %v: %s", err, out)
+	}
+
+	cmd := exec.Command("unshare", "-n", os.Args[0], "-test.run=^"+t.Name()+"$", "-test.v")
+	cmd.Env = append(os.Environ(), "FORWARD_NETNS="+t.Name())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	return false
+}
+
+// fakeDialer records the addresses it dials, which it serves with an echo.
+type fakeDialer struct {
+	dialed chan string
+}
+
+func (d *fakeDialer) DialContext(_ context.Context, network, address string) (net.Conn, error) {
+	d.dialed <- network + " " + address
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		buf := make([]byte, 2048)
+		for {
+			n, err := server.Read(buf)
+			if err != nil {
+				return
+			}
+			if _, err := server.Write(append([]byte(address+" "), buf[:n]...)); err != nil {
+				return
+			}
+		}
+	}()
+	return client, nil
+}
+
+func TestTransparentTProxy(t *testing.T) {
+	// 192.0.2.0/24 is delivered locally, as TPROXY would deliver it to the transparent sockets
+	if !inNetns(t, []string{"ip", "route", "add", "local", "192.0.2.0/24", "dev", "lo"}) {
+		return
+	}
+
+	proxy := sockstest.NewServer(sockstest.Script{Handler: sockstest.EchoHandler})
+	defer proxy.Close()
+
+	f := &Transparent{Mode: TProxy, Addr: "0.0.0.0:0", Dialer: proxy.Client(), ErrorLog: log.New(io.Discard, "", 0)}
+	lc := net.ListenConfig{Control: transparentControl}
+	l, err := lc.Listen(context.Background(), "tcp4", f.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = f.Serve(l) }()
+	defer f.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+
+	dst := netip.AddrPortFrom(netip.MustParseAddr("192.0.2.7"), uint16(port))
+	conn, err := net.Dial("tcp", dst.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEcho(t, conn)
+	conn.Close()
+	assert.Equal(t, dst.String(), proxy.Requests()[0].Address())
+
+	// connecting to the listener itself is a loop
+	conn, err = net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	assert.Len(t, proxy.Requests(), 1)
+}
+
+func TestTransparentTProxyUDP(t *testing.T) {
+	if !inNetns(t, []string{"ip", "route", "add", "local", "192.0.2.0/24", "dev", "lo"}) {
+		return
+	}
+
+	d := &fakeDialer{dialed: make(chan string, 4)}
+	f := &Transparent{Mode: TProxy, Dialer: d, UDPIdleTimeout: 100 * time.Millisecond, ErrorLog: log.New(io.Discard, "", 0)}
+	conn, err := listenTransparentUDP("0.0.0.0:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = f.ServeUDP(conn) }()
+	defer f.Close()
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+
+	dst := netip.AddrPortFrom(netip.MustParseAddr("192.0.2.9"), uint16(port))
+	client, err := net.DialUDP("udp", nil, net.UDPAddrFromAddrPort(dst))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	_ = client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	for _, payload := range []string{"one", "two"} {
+		if _, err := client.Write([]byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 128)
+		n, from, err := client.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, dst.String()+" "+payload, string(buf[:n]))
+		assert.Equal(t, dst, from, "the reply comes from the original destination")
+	}
+	assert.Equal(t, "udp "+dst.String(), <-d.dialed)
+	assert.Len(t, d.dialed, 0, "a single flow")
+
+	assert.Eventually(t, func() bool { return f.Stats().Active == 0 }, 5*time.Second, 10*time.Millisecond, "the idle flow ends")
+	assert.Equal(t, int64(1), f.Stats().Accepted)
+}
+
+func TestTransparentNAT(t *testing.T) {
+	if !inNetns(t, []string{"iptables", "-t", "nat", "-A", "OUTPUT", "-p", "tcp", "-d", "192.0.2.0/24", "-j", "REDIRECT", "--to-ports", "10810"}) {
+		return
+	}
+
+	proxy := sockstest.NewServer(sockstest.Script{Handler: sockstest.EchoHandler})
+	defer proxy.Close()
+
+	f := &Transparent{Mode: NAT, Dialer: proxy.Client(), ErrorLog: log.New(io.Discard, "", 0)}
+	l, err := net.Listen("tcp", "127.0.0.1:10810")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = f.Serve(l) }()
+	defer f.Close()
+
+	conn, err := net.Dial("tcp", "192.0.2.7:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEcho(t, conn)
+	conn.Close()
+	assert.Equal(t, "192.0.2.7:80", proxy.Requests()[0].Address())
+}
+
+func TestTransparentUDPNeedsTProxy(t *testing.T) {
+	f := &Transparent{Mode: NAT, Dialer: socks.ContextDialer(&net.Dialer{})}
+	assert.Equal(t, ErrUDPNeedsTProxy, f.ListenAndServeUDP())
+}
+
+func TestIsLoop(t *testing.T) {
+	listener := netip.MustParseAddrPort("0.0.0.0:1081")
+	assert.True(t, isLoop(netip.MustParseAddrPort("127.0.0.1:1081"), listener))
+	assert.True(t, isLoop(listener, listener))
+	assert.False(t, isLoop(netip.MustParseAddrPort("192.0.2.1:1081"), listener))
+	assert.False(t, isLoop(netip.MustParseAddrPort("127.0.0.1:80"), listener))
+}
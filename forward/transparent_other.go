@@ -0,0 +1,29 @@
+//go:build !linux
+
+package forward
+
+import (
+	"net"
+	"net/netip"
+	"syscall"
+)
+
+func originalDst(net.Conn) (netip.AddrPort, error) {
+	return netip.AddrPort{}, ErrTransparentUnsupported
+}
+
+func transparentControl(string, string, syscall.RawConn) error {
+	return ErrTransparentUnsupported
+}
+
+func listenTransparentUDP(string) (*net.UDPConn, error) {
+	return nil, ErrTransparentUnsupported
+}
+
+func dialTransparentReply(netip.AddrPort, netip.AddrPort) (net.Conn, error) {
+	return nil, ErrTransparentUnsupported
+}
+
+func originalDstUDP([]byte) (netip.AddrPort, error) {
+	return netip.AddrPort{}, ErrTransparentUnsupported
+}
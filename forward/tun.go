@@ -0,0 +1,159 @@
+package forward
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/kayabe/socks"
+	"github.com/kayabe/socks/tun"
+)
+
+// TUN forwards the TCP connections and the UDP flows of the IP packets routed to a TUN device
+// to their destinations through the proxy server, like tun2socks does, so that a whole host or
+// network namespace is proxied without configuring each application.
+//
+// On Linux, a device can be set up with:
+//
+//	ip tuntap add mode tun dev tun0
+//	ip addr add 198.18.0.1/15 dev tun0
+//	ip link set dev tun0 up
+//	ip route add default dev tun0
+//
+// The traffic to the proxy server itself has to be routed elsewhere.
+type TUN struct {
+	// Name optionally specifies the TUN device to open, used by ListenAndServe.
+	Name string
+
+	// Stack terminates the flows of the packets, a *tun.Netstack if nil.
+	Stack tun.Stack
+
+	// Dialer reaches the destinations, usually a *socks.Client. It has to support
+	// the udp network for the UDP flows, as socks.Client does through UDP ASSOCIATE.
+	Dialer socks.ContextDialer
+
+	// DialTimeout bounds dialing the destinations, no timeout if zero.
+	DialTimeout time.Duration
+
+	// UDPIdleTimeout ends the UDP flows without traffic, DefaultUDPIdleTimeout if zero.
+	UDPIdleTimeout time.Duration
+
+	// ErrorLog specifies an optional logger for errors, the log package's standard logger is used if nil.
+	ErrorLog *log.Logger
+
+	tracker
+}
+
+// ListenAndServe opens the TUN device f.Name, which requires Linux, and then calls Serve.
+func (f *TUN) ListenAndServe() error {
+	if f.inShutdown.Load() {
+		return socks.ErrServerClosed
+	}
+	dev, err := tun.Open(f.Name)
+	if err != nil {
+		return err
+	}
+	return f.Serve(dev)
+}
+
+// Serve forwards the flows of the IP packets read from dev, one per Read, writing the packets
+// back to dev, one per Write, until reading fails.
+// Serve always returns a non-nil error and closes dev, socks.ErrServerClosed after Shutdown or Close.
+func (f *TUN) Serve(dev io.ReadWriteCloser) error {
+	if !f.track(dev, true) {
+		dev.Close()
+		return socks.ErrServerClosed
+	}
+	defer f.track(dev, false)
+	defer dev.Close()
+
+	stack := f.Stack
+	if stack == nil {
+		stack = &tun.Netstack{}
+	}
+	err := stack.Run(dev, tunHandler{f})
+	if f.inShutdown.Load() {
+		return socks.ErrServerClosed
+	}
+	return err
+}
+
+// Shutdown shuts down the forward, it closes all devices, which ends their flows,
+// and then waits for the flows to finish or for the context to be done.
+func (f *TUN) Shutdown(ctx context.Context) error {
+	return f.shutdown(ctx)
+}
+
+// Close immediately closes all devices and flows.
+func (f *TUN) Close() error {
+	return f.close()
+}
+
+// Stats returns the statistics of the forward, each flow counting as a connection.
+func (f *TUN) Stats() Stats {
+	return stats(&f.counters)
+}
+
+// tunHandler forwards the flows terminated by the stack.
+type tunHandler struct {
+	f *TUN
+}
+
+func (h tunHandler) HandleTCP(conn net.Conn) {
+	h.f.forward(conn, "tcp")
+}
+
+func (h tunHandler) HandleUDP(conn net.Conn) {
+	h.f.forward(conn, "udp")
+}
+
+func (f *TUN) forward(conn net.Conn, network string) {
+	f.counters.Accepted.Add(1)
+	f.counters.Active.Add(1)
+	f.trackConn(conn, true)
+	defer f.counters.Active.Add(-1)
+	defer f.trackConn(conn, false)
+	defer conn.Close()
+
+	dst := conn.LocalAddr().String()
+	ctx, cancel := dialContext(f.DialTimeout)
+	defer cancel()
+	target, err := f.dialer(f.Dialer).DialContext(ctx, network, dst)
+	if err != nil {
+		logf(f.ErrorLog, "tun: %s: %s %s: %v", conn.RemoteAddr(), network, dst, err)
+		return
+	}
+
+	if network == "udp" {
+		timeout := f.UDPIdleTimeout
+		if timeout <= 0 {
+			timeout = DefaultUDPIdleTimeout
+		}
+		idle := time.Now().Add(timeout)
+		_ = conn.SetReadDeadline(idle)
+		_ = target.SetReadDeadline(idle)
+		socks.Relay(idleConn{Conn: conn, peer: target, timeout: timeout}, idleConn{Conn: target, peer: conn, timeout: timeout})
+		return
+	}
+	socks.Relay(conn, target)
+}
+
+// idleConn extends the read deadlines of both conns of a UDP flow on each datagram it reads,
+// ending the flow once idle.
+type idleConn struct {
+	net.Conn
+	peer    net.Conn
+	timeout time.Duration
+}
+
+func (c idleConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err == nil {
+		idle := time.Now().Add(c.timeout)
+		_ = c.Conn.SetReadDeadline(idle)
+		_ = c.peer.SetReadDeadline(idle)
+	}
+	return n, err
+}
@@ -0,0 +1,96 @@
+package forward
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kayabe/socks/sockstest"
+	"github.com/kayabe/socks/tun"
+	"github.com/stretchr/testify/assert"
+)
+
+// tunSetup routes 192.0.2.0/24 through the device tun0.
+var tunSetup = [][]string{
+	{"ip", "tuntap", "add", "mode", "tun", "dev", "tun0"},
+	{"ip", "addr", "add", "10.0.0.1/24", "dev", "tun0"},
+	{"ip", "link", "set", "dev", "tun0", "up"},
+	{"ip", "route", "add", "192.0.2.0/24", "dev", "tun0"},
+}
+
+func serveTUN(t *testing.T, f *TUN) {
+	dev, err := tun.Open("tun0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "tun0", dev.Name())
+	go func() { _ = f.Serve(dev) }()
+	t.Cleanup(func() { f.Close() })
+}
+
+func TestTUNDevice(t *testing.T) {
+	if !inNetns(t, tunSetup...) {
+		return
+	}
+
+	proxy := sockstest.NewServer(sockstest.Script{Handler: sockstest.EchoHandler})
+	defer proxy.Close()
+	serveTUN(t, &TUN{Dialer: proxy.Client(), ErrorLog: log.New(io.Discard, "", 0)})
+
+	conn, err := net.DialTimeout("tcp", "192.0.2.7:80", 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	assertEcho(t, conn)
+	assert.Equal(t, "192.0.2.7:80", proxy.Requests()[0].Address())
+
+	// more than the windows, in both directions at once
+	data := make([]byte, 1<<20)
+	_, _ = rand.Read(data)
+	go func() { _, _ = conn.Write(data) }()
+	echoed := make([]byte, len(data))
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.ReadFull(conn, echoed); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(t, bytes.Equal(data, echoed))
+}
+
+func TestTUNDeviceUDP(t *testing.T) {
+	if !inNetns(t, tunSetup...) {
+		return
+	}
+
+	d := &fakeDialer{dialed: make(chan string, 4)}
+	f := &TUN{Dialer: d, UDPIdleTimeout: 100 * time.Millisecond, ErrorLog: log.New(io.Discard, "", 0)}
+	serveTUN(t, f)
+
+	conn, err := net.Dial("udp", "192.0.2.9:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	for _, payload := range []string{"one", "two"} {
+		if _, err := conn.Write([]byte(payload)); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 128)
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "192.0.2.9:53 "+payload, string(buf[:n]))
+	}
+	assert.Equal(t, "udp 192.0.2.9:53", <-d.dialed)
+	assert.Len(t, d.dialed, 0, "a single flow")
+
+	assert.Eventually(t, func() bool { return f.Stats().Active == 0 }, 5*time.Second, 10*time.Millisecond, "the idle flow ends")
+	assert.Equal(t, int64(1), f.Stats().Accepted)
+}
@@ -0,0 +1,64 @@
+package forward
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/kayabe/socks"
+	"github.com/kayabe/socks/sockstest"
+	"github.com/kayabe/socks/tun"
+	"github.com/stretchr/testify/assert"
+)
+
+// addrConn overrides the addresses of a pipe.
+type addrConn struct {
+	net.Conn
+	local, remote net.Addr
+}
+
+func (c addrConn) LocalAddr() net.Addr  { return c.local }
+func (c addrConn) RemoteAddr() net.Addr { return c.remote }
+
+// fakeStack hands its flows to the handler, and then reads the device until it is closed.
+type fakeStack struct {
+	tcp []net.Conn
+}
+
+func (s fakeStack) Run(dev io.ReadWriter, h tun.Handler) error {
+	for _, conn := range s.tcp {
+		go h.HandleTCP(conn)
+	}
+	_, err := dev.Read(make([]byte, 1))
+	return err
+}
+
+func TestTUN(t *testing.T) {
+	proxy := sockstest.NewServer(sockstest.Script{Handler: sockstest.EchoHandler})
+	defer proxy.Close()
+
+	dst := netip.MustParseAddrPort("192.0.2.7:80")
+	conn, flow := net.Pipe()
+	stack := fakeStack{tcp: []net.Conn{addrConn{
+		Conn:   flow,
+		local:  net.TCPAddrFromAddrPort(dst),
+		remote: net.TCPAddrFromAddrPort(netip.MustParseAddrPort("10.0.0.2:40000")),
+	}}}
+
+	f := &TUN{Stack: stack, Dialer: proxy.Client()}
+	dev, _ := net.Pipe()
+	served := make(chan error, 1)
+	go func() { served <- f.Serve(dev) }()
+
+	assertEcho(t, conn)
+	conn.Close()
+	assert.Equal(t, dst.String(), proxy.Requests()[0].Address())
+
+	assert.Eventually(t, func() bool { return f.Stats().Active == 0 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, Stats{Accepted: 1, Dials: 1, Sent: 4, Received: 4}, f.Stats())
+
+	f.Close()
+	assert.Equal(t, socks.ErrServerClosed, <-served)
+}
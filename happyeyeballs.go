@@ -0,0 +1,100 @@
+package socks
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// DefaultAttemptDelay is the Connection Attempt Delay of RFC 8305 of a Server without AttemptDelay.
+const DefaultAttemptDelay = 250 * time.Millisecond
+
+// interleave orders the addresses alternating their families, starting with the family
+// of the first one, as RFC 8305 section 4 does.
+func interleave(addrs []netip.Addr) []netip.Addr {
+	if len(addrs) == 0 {
+		return addrs
+	}
+	var first, second []netip.Addr
+	for _, addr := range addrs {
+		if addr.Unmap().Is4() == addrs[0].Unmap().Is4() {
+			first = append(first, addr)
+		} else {
+			second = append(second, addr)
+		}
+	}
+
+	ordered := make([]netip.Addr, 0, len(addrs))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			ordered = append(ordered, first[i])
+		}
+		if i < len(second) {
+			ordered = append(ordered, second[i])
+		}
+	}
+	return ordered
+}
+
+// dialParallel races the connections to the addresses as RFC 8305 does: each attempt starts
+// once the previous one failed or after delay, the first connection established wins and
+// the other attempts are canceled, or closed if they connected anyway. It returns the error
+// of the first attempt if they all fail.
+func dialParallel(ctx context.Context, d ContextDialer, addresses []string, delay time.Duration) (net.Conn, error) {
+	if len(addresses) == 1 {
+		return d.DialContext(ctx, "tcp", addresses[0])
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(addresses))
+	next, pending := 0, 0
+	var attempt <-chan time.Time
+	start := func() {
+		address := addresses[next]
+		next++
+		pending++
+		go func() {
+			conn, err := d.DialContext(ctx, "tcp", address)
+			results <- result{conn, err}
+		}()
+		attempt = nil
+		if next < len(addresses) {
+			attempt = time.After(delay)
+		}
+	}
+
+	var firstErr error
+	start()
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if r := <-results; r.conn != nil {
+							r.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if next < len(addresses) {
+				start()
+			}
+		case <-attempt:
+			start()
+		}
+	}
+	return nil, firstErr
+}
@@ -0,0 +1,85 @@
+package socks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kayabe/socks/s5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterleave(t *testing.T) {
+	assert.Equal(t,
+		parseAddrs("2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2", "192.0.2.3"),
+		interleave(parseAddrs("2001:db8::1", "2001:db8::2", "192.0.2.1", "192.0.2.2", "192.0.2.3")))
+	assert.Equal(t,
+		parseAddrs("192.0.2.1", "2001:db8::1", "192.0.2.2"),
+		interleave(parseAddrs("192.0.2.1", "192.0.2.2", "2001:db8::1")))
+	assert.Empty(t, interleave(nil))
+}
+
+func TestDialParallel(t *testing.T) {
+	refused := errors.New("refused")
+	var dialed []string
+	d := dialerFunc(func(_ context.Context, _, address string) (net.Conn, error) {
+		dialed = append(dialed, address)
+		return nil, refused
+	})
+
+	// each failure starts the next attempt without waiting
+	start := time.Now()
+	_, err := dialParallel(context.Background(), d, []string{"a", "b", "c"}, time.Hour)
+	assert.Equal(t, refused, err)
+	assert.Equal(t, []string{"a", "b", "c"}, dialed)
+	assert.Less(t, time.Since(start), time.Minute)
+}
+
+func TestServerHappyEyeballs(t *testing.T) {
+	echo := startEcho(t)
+	port := strconv.Itoa(echo.Port)
+
+	// IPv6 is broken, its attempts hang until canceled
+	canceled := make(chan error, 1)
+	connected := make(chan net.Conn, 1)
+	addr := startServer(t, &Server{
+		Resolver:     &DNSPolicy{Hosts: map[string][]netip.Addr{"dual.test": parseAddrs("2001:db8::1", "127.0.0.1")}},
+		AttemptDelay: 50 * time.Millisecond,
+		Dialer: dialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+			if strings.HasPrefix(address, "[2001:db8::1]") {
+				<-ctx.Done()
+				canceled <- ctx.Err()
+				return nil, ctx.Err()
+			}
+			return (&net.Dialer{}).DialContext(ctx, network, address)
+		}),
+		Connected: func(_ *Request, target net.Conn) { connected <- target },
+	})
+
+	client, _ := NewClient(addr)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err := client.HandshakeV5(conn); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	reply, err := client.requestV5(conn, s5.CommandConnect, s5.Addr{Type: s5.AddressTypeDomainName, Host: "dual.test", Port: uint16(echo.Port)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assertEcho(t, conn)
+
+	target := <-connected
+	assert.Equal(t, "127.0.0.1:"+port, target.RemoteAddr().String())
+	assert.Equal(t, target.LocalAddr().String(), reply.Bind.String(), "the bound address is replied")
+	assert.Equal(t, context.Canceled, <-canceled)
+}
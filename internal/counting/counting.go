@@ -0,0 +1,88 @@
+// Package counting counts the connections accepted from the clients of a proxy and dialed
+// to their targets, and the bytes exchanged with the targets.
+package counting
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kayabe/socks"
+)
+
+// Counters of a Listener and a Dialer, safe for concurrent use.
+type Counters struct {
+	Accepted   atomic.Int64 // connections accepted
+	Active     atomic.Int64 // accepted connections open
+	Dials      atomic.Int64 // connections dialed
+	DialErrors atomic.Int64 // dials which failed
+	Sent       atomic.Int64 // bytes written to the dialed connections
+	Received   atomic.Int64 // bytes read from the dialed connections
+}
+
+// Listener counts the accepted and the active connections.
+type Listener struct {
+	net.Listener
+	C *Counters
+}
+
+// Accept returns a connection counted as active until it is closed.
+func (l Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.C.Accepted.Add(1)
+	l.C.Active.Add(1)
+	return &acceptedConn{Conn: conn, c: l.C}, nil
+}
+
+type acceptedConn struct {
+	net.Conn
+	c    *Counters
+	once sync.Once
+}
+
+func (c *acceptedConn) NetConn() net.Conn { return c.Conn }
+
+func (c *acceptedConn) Close() error {
+	c.once.Do(func() { c.c.Active.Add(-1) })
+	return c.Conn.Close()
+}
+
+// Dialer counts the dials of Dialer, and the bytes exchanged with the dialed connections.
+type Dialer struct {
+	Dialer socks.ContextDialer
+	C      *Counters
+}
+
+// DialContext implements socks.ContextDialer.
+func (d Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.C.Dials.Add(1)
+	conn, err := d.Dialer.DialContext(ctx, network, address)
+	if err != nil {
+		d.C.DialErrors.Add(1)
+		return nil, err
+	}
+	return &dialedConn{Conn: conn, c: d.C}, nil
+}
+
+type dialedConn struct {
+	net.Conn
+	c *Counters
+}
+
+func (c *dialedConn) NetConn() net.Conn { return c.Conn }
+
+func (c *dialedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.c.Received.Add(int64(n))
+	return n, err
+}
+
+func (c *dialedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.c.Sent.Add(int64(n))
+	return n, err
+}
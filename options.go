@@ -1,6 +1,11 @@
 package socks
 
-import "github.com/kayabe/socks/s5"
+import (
+	"crypto/sha256"
+	"crypto/tls"
+
+	"github.com/kayabe/socks/s5"
+)
 
 func WithUserPW(username string, password string) func(*Client) {
 	return func(client *Client) {
@@ -8,8 +13,45 @@ func WithUserPW(username string, password string) func(*Client) {
 	}
 }
 
-func WithVersion(version any) func(*Client) {
+// WithVersion selects the protocol spoken with the proxy server, V4, V4A, V5, HTTP or a custom Protocol.
+func WithVersion(version Protocol) func(*Client) {
+	return func(client *Client) {
+		client.Protocol = version
+	}
+}
+
+// WithUpstream dials the proxy server through d, use another *Client to chain proxies.
+func WithUpstream(d ContextDialer) func(*Client) {
+	return func(client *Client) {
+		client.Upstream = d
+	}
+}
+
+// WithTLS wraps the connection to the proxy server in TLS, a nil config uses the defaults.
+func WithTLS(config *tls.Config) func(*Client) {
+	return func(client *Client) {
+		if config == nil {
+			config = &tls.Config{}
+		}
+		client.TLSConfig = config
+	}
+}
+
+// WithTLSPins only accepts proxy certificates with one of the given public key pins, see PinSHA256.
+func WithTLSPins(pins ...[sha256.Size]byte) func(*Client) {
+	return func(client *Client) {
+		client.TLSPins = append(client.TLSPins, pins...)
+	}
+}
+
+// WithUDPFragmentation fragments and reassembles the datagrams of UDP associations, the datagrams sent
+// being at most mtu bytes, s5.DefaultMTU if zero. Most servers drop fragments, only enable it for those
+// implementing them.
+func WithUDPFragmentation(mtu int) func(*Client) {
 	return func(client *Client) {
-		client.ProtocolVersion = version
+		if mtu <= 0 {
+			mtu = s5.DefaultMTU
+		}
+		client.UDPFragmentMTU = mtu
 	}
 }
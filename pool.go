@@ -0,0 +1,190 @@
+package socks
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kayabe/socks/s5"
+)
+
+// Strategy decides the order in which the proxy servers of a Pool are tried.
+type Strategy uint8
+
+const (
+	RoundRobin       Strategy = iota // rotate the first proxy server on every dial
+	Random                           // random order on every dial
+	LeastConnections                 // fewest active connections first
+	Priority                         // the order given to NewPool, later ones are only used on failover
+)
+
+const (
+	DefaultMaxFailures = 3
+	DefaultCooldown    = 30 * time.Second
+)
+
+// Pool dials through one of several proxy servers. Connection-level errors fail over to the
+// next proxy server, destination-level errors (see IsDestinationError) are returned as-is.
+//
+// Health is tracked passively: a proxy server is ejected after MaxFailures consecutive
+// failures and is tried again once Cooldown has passed.
+type Pool struct {
+	Strategy Strategy
+
+	// MaxFailures is the number of consecutive failures ejecting a proxy server, DefaultMaxFailures if 0.
+	MaxFailures int
+
+	// Cooldown is how long an ejected proxy server is skipped, DefaultCooldown if 0.
+	Cooldown time.Duration
+
+	proxies []*poolProxy
+	next    atomic.Uint32
+	mu      sync.Mutex
+}
+
+type poolProxy struct {
+	client *Client
+	active atomic.Int64
+
+	// guarded by Pool.mu
+	failures     int
+	ejectedUntil time.Time
+}
+
+// NewPool creates a Pool of clients, one per proxy address, each configured with the given options.
+func NewPool(proxyAddrs []string, strategy Strategy, options ...func(*Client)) (*Pool, error) {
+	if len(proxyAddrs) == 0 {
+		return nil, ErrNoProxy
+	}
+	p := &Pool{Strategy: strategy}
+	for _, addr := range proxyAddrs {
+		client, err := NewClient(addr, options...)
+		if err != nil {
+			return nil, err
+		}
+		p.proxies = append(p.proxies, &poolProxy{client: client})
+	}
+	return p, nil
+}
+
+// IsDestinationError reports whether err was caused by the destination rather than by the
+// proxy server, another proxy server would most likely fail the same way.
+func IsDestinationError(err error) bool {
+	for _, target := range []error{
+		s5.ErrReplyNetworkUnreachable,
+		s5.ErrReplyHostUnreachable,
+		s5.ErrReplyConnectionRefused,
+		s5.ErrReplyTTLExpired,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Dial connects to the given address via one of the proxy servers.
+func (p *Pool) Dial(network string, address string) (net.Conn, error) {
+	return p.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to the given address via one of the proxy servers using the provided context.
+func (p *Pool) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	var lastErr error = ErrNoProxy
+
+	for _, px := range p.candidates() {
+		conn, err := px.client.DialContext(ctx, network, address)
+		switch {
+		case err == nil:
+			p.report(px, true)
+			px.active.Add(1)
+			return &poolConn{Conn: conn, px: px}, nil
+		case ctx.Err() != nil:
+			return nil, err
+		case IsDestinationError(err):
+			p.report(px, true)
+			return nil, err
+		}
+		p.report(px, false)
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+// candidates returns the proxy servers in the order they should be tried. Ejected proxy servers
+// are left out, unless all of them are ejected, in which case all of them are tried.
+func (p *Pool) candidates() []*poolProxy {
+	list := append([]*poolProxy(nil), p.proxies...)
+
+	switch p.Strategy {
+	case RoundRobin:
+		n := int(uint(p.next.Add(1)-1) % uint(len(list)))
+		list = append(list[n:], list[:n]...)
+	case Random:
+		rand.Shuffle(len(list), func(i, j int) { list[i], list[j] = list[j], list[i] })
+	case LeastConnections:
+		sort.SliceStable(list, func(i, j int) bool { return list[i].active.Load() < list[j].active.Load() })
+	}
+
+	now := time.Now()
+	healthy := list[:0:0]
+
+	p.mu.Lock()
+	for _, px := range list {
+		if !now.Before(px.ejectedUntil) {
+			healthy = append(healthy, px)
+		}
+	}
+	p.mu.Unlock()
+
+	if len(healthy) == 0 {
+		return list
+	}
+	return healthy
+}
+
+// report records the outcome of a dial through px.
+func (p *Pool) report(px *poolProxy, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if ok {
+		px.failures = 0
+		px.ejectedUntil = time.Time{}
+		return
+	}
+
+	maxFailures := p.MaxFailures
+	if maxFailures <= 0 {
+		maxFailures = DefaultMaxFailures
+	}
+	cooldown := p.Cooldown
+	if cooldown <= 0 {
+		cooldown = DefaultCooldown
+	}
+
+	if px.failures++; px.failures >= maxFailures {
+		px.ejectedUntil = time.Now().Add(cooldown)
+	}
+}
+
+// poolConn keeps track of the active connections of a proxy server.
+type poolConn struct {
+	net.Conn
+	px   *poolProxy
+	once sync.Once
+}
+
+// NetConn returns the connection to the destination.
+func (c *poolConn) NetConn() net.Conn { return c.Conn }
+
+func (c *poolConn) Close() error {
+	c.once.Do(func() { c.px.active.Add(-1) })
+	return c.Conn.Close()
+}
@@ -0,0 +1,135 @@
+package socks
+
+import (
+	"context"
+	"math"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kayabe/socks/s5"
+	"github.com/stretchr/testify/assert"
+)
+
+// deadAddr returns the address of a closed listener.
+func deadAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	return l.Addr().String()
+}
+
+// countingRule counts the requests reaching a server.
+func countingRule(n *atomic.Int32) Rule {
+	return RuleFunc(func(context.Context, *Request) bool {
+		n.Add(1)
+		return true
+	})
+}
+
+func TestPoolFailover(t *testing.T) {
+	echo := startEcho(t)
+	dead := deadAddr(t)
+	live := startServer(t, &Server{})
+
+	pool, err := NewPool([]string{dead, live}, Priority)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := pool.Dial("tcp", echo.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	assertEcho(t, conn)
+}
+
+func TestPoolDestinationError(t *testing.T) {
+	dead := deadAddr(t)
+
+	var first, second atomic.Int32
+	pool, _ := NewPool([]string{
+		startServer(t, &Server{Rules: countingRule(&first)}),
+		startServer(t, &Server{Rules: countingRule(&second)}),
+	}, Priority)
+
+	_, err := pool.Dial("tcp", dead)
+	assert.True(t, IsDestinationError(err))
+	assert.Equal(t, int32(1), first.Load())
+	assert.Equal(t, int32(0), second.Load(), "destination errors should not fail over")
+
+	// connection not allowed is a proxy-level error
+	pool, _ = NewPool([]string{
+		startServer(t, &Server{Rules: PermitNone}),
+		startServer(t, &Server{Rules: countingRule(&second)}),
+	}, Priority)
+	conn, err := pool.Dial("tcp", startEcho(t).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	assert.Equal(t, int32(1), second.Load())
+	assert.False(t, IsDestinationError(s5.ErrReplyConnectionNotAllowed))
+}
+
+func TestPoolEjection(t *testing.T) {
+	echo := startEcho(t)
+
+	var n atomic.Int32
+	live := startServer(t, &Server{Rules: countingRule(&n)})
+
+	pool, _ := NewPool([]string{live, deadAddr(t)}, RoundRobin)
+	pool.MaxFailures = 1
+	pool.Cooldown = 50 * time.Millisecond
+
+	for i := 0; i < 4; i++ {
+		conn, err := pool.Dial("tcp", echo.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	}
+	assert.Equal(t, 1, len(pool.candidates()), "the dead proxy should be ejected")
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, 2, len(pool.candidates()), "the dead proxy should be back after the cooldown")
+	assert.Equal(t, int32(4), n.Load())
+}
+
+func TestPoolLeastConnections(t *testing.T) {
+	echo := startEcho(t)
+
+	var first, second atomic.Int32
+	pool, _ := NewPool([]string{
+		startServer(t, &Server{Rules: countingRule(&first)}),
+		startServer(t, &Server{Rules: countingRule(&second)}),
+	}, LeastConnections)
+
+	a, err := pool.Dial("tcp", echo.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	b, err := pool.Dial("tcp", echo.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	assert.Equal(t, int32(1), first.Load())
+	assert.Equal(t, int32(1), second.Load())
+}
+
+func TestPoolRoundRobinWraparound(t *testing.T) {
+	pool, _ := NewPool([]string{"127.0.0.1:1", "127.0.0.1:2", "127.0.0.1:3"}, RoundRobin)
+	pool.next.Store(math.MaxUint32 - 1)
+
+	// the counter wraps around without ever yielding a negative index
+	for _, want := range []int{2, 0, 0, 1} {
+		assert.Same(t, pool.proxies[want], pool.candidates()[0])
+	}
+}
@@ -0,0 +1,77 @@
+package socks
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kayabe/socks/s5"
+)
+
+// Protocol negotiates proxy connections on behalf of a Client. V4, V4A, V5 and HTTP are the built-in
+// implementations, others can be plugged in with WithVersion or registered with RegisterProtocol.
+//
+// Every method is called on a connection to the proxy server returned by Handshake's caller,
+// I/O is interrupted once ctx is done. Commands a protocol lacks return s5.ErrReplyCommandNotSupported.
+type Protocol interface {
+	// Handshake negotiates the version and authenticates, right after the proxy server was dialed.
+	Handshake(ctx context.Context, c *Client, conn net.Conn) error
+
+	// Connect asks the proxy server to connect conn to address, returning the address it bound if known.
+	Connect(ctx context.Context, c *Client, conn net.Conn, address s5.Addr) (bound s5.Addr, err error)
+
+	// Bind asks the proxy server to accept one connection from address, returning the address it listens on.
+	// accept waits for the connection and returns the address of the peer, conn then carries its data.
+	Bind(ctx context.Context, c *Client, conn net.Conn, address s5.Addr) (bound s5.Addr, accept func() (peer s5.Addr, err error), err error)
+
+	// Associate asks the proxy server to relay UDP datagrams sent from address, the zero Addr if unknown,
+	// returning the address of the relay. The association lasts as long as conn.
+	Associate(ctx context.Context, c *Client, conn net.Conn, address s5.Addr) (relay s5.Addr, err error)
+}
+
+var (
+	protocolsMu sync.RWMutex
+	protocols   = map[string]Protocol{
+		SchemeSOCKS4:  V4,
+		SchemeSOCKS4A: V4A,
+		SchemeSOCKS5:  V5,
+		SchemeHTTP:    HTTP,
+	}
+)
+
+// RegisterProtocol makes a protocol available by name, for example to FromEnvironment as a proxy URL scheme.
+// Names are case-insensitive, it panics if p is nil or the name is already registered.
+func RegisterProtocol(name string, p Protocol) {
+	protocolsMu.Lock()
+	defer protocolsMu.Unlock()
+	if p == nil {
+		panic("socks: RegisterProtocol protocol is nil")
+	}
+	name = strings.ToLower(name)
+	if _, dup := protocols[name]; dup {
+		panic("socks: RegisterProtocol called twice for protocol " + name)
+	}
+	protocols[name] = p
+}
+
+// LookupProtocol returns the protocol registered with the given name.
+func LookupProtocol(name string) (p Protocol, ok bool) {
+	protocolsMu.RLock()
+	defer protocolsMu.RUnlock()
+	p, ok = protocols[strings.ToLower(name)]
+	return
+}
+
+// Protocols returns the sorted names of the registered protocols.
+func Protocols() []string {
+	protocolsMu.RLock()
+	defer protocolsMu.RUnlock()
+	names := make([]string, 0, len(protocols))
+	for name := range protocols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
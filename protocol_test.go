@@ -0,0 +1,185 @@
+package socks
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/kayabe/socks/s4"
+	"github.com/kayabe/socks/s5"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingProtocol is a third-party protocol speaking SOCKS5 and counting its handshakes.
+type countingProtocol struct {
+	ProtocolV5
+	n atomic.Int32
+}
+
+func (p *countingProtocol) Handshake(ctx context.Context, c *Client, conn net.Conn) error {
+	p.n.Add(1)
+	return p.ProtocolV5.Handshake(ctx, c, conn)
+}
+
+var testProtocol = new(countingProtocol)
+
+func init() {
+	RegisterProtocol("Counting", testProtocol)
+}
+
+func TestClientV4(t *testing.T) {
+	echo := startEcho(t)
+	hosts := make(chan string, 4)
+	addr := startServer(t, &Server{Rules: RuleFunc(func(_ context.Context, r *Request) bool {
+		hosts <- r.Protocol + " " + r.Host
+		return true
+	})})
+
+	for _, tt := range []struct {
+		version Protocol
+		want    string
+	}{
+		{V4, "socks4 127.0.0.1"},
+		{V4A, "socks4a localhost"},
+	} {
+		client, _ := NewClient(addr, WithVersion(tt.version))
+		conn, err := client.Dial("tcp", net.JoinHostPort("localhost", strconv.Itoa(echo.Port)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEcho(t, conn)
+		conn.Close()
+		assert.Equal(t, tt.want, <-hosts)
+	}
+}
+
+func TestClientV4Rejected(t *testing.T) {
+	addr := startServer(t, &Server{Rules: PermitNone})
+
+	client, _ := NewClient(addr, WithVersion(V4))
+	_, err := client.Dial("tcp", "127.0.0.1:80")
+	assert.Equal(t, s4.ErrReplyRejected, err)
+
+	// IPv6 can't be expressed in SOCKS4
+	_, err = client.Dial("tcp", "[::1]:80")
+	assert.Equal(t, s5.ErrUnsupportedAddressType, err)
+}
+
+func TestClientInvalidAddress(t *testing.T) {
+	client, _ := NewClient(deadAddr(t))
+	for _, address := range []string{"localhost", "localhost:http", ":80"} {
+		_, err := client.Dial("tcp", address)
+		assert.Error(t, err, address)
+	}
+
+	client.Protocol = nil
+	_, err := client.Dial("tcp", "localhost:80")
+	assert.Error(t, err)
+}
+
+func TestRegisterProtocol(t *testing.T) {
+	p := testProtocol
+	n := p.n.Load()
+
+	got, ok := LookupProtocol("counting")
+	assert.True(t, ok)
+	assert.Equal(t, Protocol(p), got)
+	assert.Contains(t, Protocols(), "counting")
+	assert.Panics(t, func() { RegisterProtocol("counting", p) })
+	assert.Panics(t, func() { RegisterProtocol("nil", nil) })
+
+	echo := startEcho(t)
+	addr := startServer(t, &Server{})
+
+	t.Setenv("ALL_PROXY", "counting://"+addr)
+	d, err := FromEnvironment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := d.Dial("tcp", echo.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	assertEcho(t, conn)
+	assert.Equal(t, n+1, p.n.Load())
+}
+
+// pipeProxy runs the client side of a protocol command over a pipe, script plays the server.
+func pipeProxy(t *testing.T, script func(conn net.Conn)) net.Conn {
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	go func() {
+		defer server.Close()
+		script(server)
+	}()
+	return client
+}
+
+func TestProtocolV5Bind(t *testing.T) {
+	conn := pipeProxy(t, func(conn net.Conn) {
+		var req s5.Request
+		if err := req.Unpack(conn); err != nil || req.Command != s5.CommandBind {
+			return
+		}
+		_ = (&s5.Reply{Status: s5.ReplySuccess, Bind: s5.AddrFromAddrPort(netip.MustParseAddrPort("192.0.2.1:4000"))}).Pack(conn)
+		_ = (&s5.Reply{Status: s5.ReplySuccess, Bind: s5.AddrFromAddrPort(netip.MustParseAddrPort("198.51.100.1:5000"))}).Pack(conn)
+	})
+
+	client, _ := NewClient("")
+	bound, accept, err := V5.Bind(context.Background(), client, conn, s5.AddrFromAddrPort(netip.MustParseAddrPort("198.51.100.1:0")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "192.0.2.1:4000", bound.String())
+
+	peer, err := accept()
+	assert.NoError(t, err)
+	assert.Equal(t, "198.51.100.1:5000", peer.String())
+}
+
+func TestProtocolV5ConnectDomainBind(t *testing.T) {
+	// RFC 1928 allows a domain name as BND.ADDR
+	conn := pipeProxy(t, func(conn net.Conn) {
+		var req s5.Request
+		if err := req.Unpack(conn); err != nil {
+			return
+		}
+		_ = (&s5.Reply{Status: s5.ReplySuccess, Bind: s5.Addr{Type: s5.AddressTypeDomainName, Host: "egress.example", Port: 4000}}).Pack(conn)
+	})
+
+	client, _ := NewClient("")
+	bound, err := V5.Connect(context.Background(), client, conn, s5.AddrFromAddrPort(netip.MustParseAddrPort("198.51.100.1:80")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "egress.example:4000", bound.String())
+}
+
+func TestProtocolV5Associate(t *testing.T) {
+	conn := pipeProxy(t, func(conn net.Conn) {
+		var req s5.Request
+		if err := req.Unpack(conn); err != nil || req.Command != s5.CommandAssociate {
+			return
+		}
+		_ = (&s5.Reply{Status: s5.ReplySuccess, Bind: s5.AddrFromAddrPort(netip.MustParseAddrPort("192.0.2.1:4000"))}).Pack(conn)
+	})
+
+	client, _ := NewClient("")
+	relay, err := V5.Associate(context.Background(), client, conn, s5.Addr{})
+	assert.NoError(t, err)
+	assert.Equal(t, "192.0.2.1:4000", relay.String())
+}
+
+func TestProtocolUnsupportedCommand(t *testing.T) {
+	client, _ := NewClient("")
+	for _, p := range []Protocol{V4, V4A, HTTP} {
+		_, err := p.Associate(context.Background(), client, nil, s5.Addr{})
+		assert.Equal(t, s5.ErrReplyCommandNotSupported, err)
+	}
+	_, _, err := HTTP.Bind(context.Background(), client, nil, s5.Addr{})
+	assert.Equal(t, s5.ErrReplyCommandNotSupported, err)
+}
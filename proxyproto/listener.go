@@ -0,0 +1,88 @@
+package proxyproto
+
+import (
+	"bufio"
+	"net"
+	"net/netip"
+	"sync"
+)
+
+// Listener reads the optional header of the connections accepted from its trusted sources,
+// the other connections being returned as they are.
+type Listener struct {
+	net.Listener
+
+	// Trusted lists the sources, such as load balancers, whose headers are trusted.
+	Trusted []netip.Prefix
+}
+
+// Accept returns a *Conn for the connections from a trusted source.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &Conn{Conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+func (l *Listener) trusted(addr net.Addr) bool {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	ip := tcp.AddrPort().Addr().Unmap()
+	for _, p := range l.Trusted {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection which may begin with a header, read by the first Read or RemoteAddr
+// within the deadlines of the connection.
+type Conn struct {
+	net.Conn
+	r *bufio.Reader
+
+	once   sync.Once
+	header *Header
+	err    error
+}
+
+func (c *Conn) readHeader() {
+	c.once.Do(func() {
+		c.header, c.err = Read(c.r)
+	})
+}
+
+// Header returns the header of the connection, nil if it has none.
+func (c *Conn) Header() (*Header, error) {
+	c.readHeader()
+	return c.header, c.err
+}
+
+// Read reads the data following the header, the error of reading the header if it is invalid.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// NetConn returns the connection the header and the data are read from.
+func (c *Conn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// RemoteAddr returns the source of the header, or the address of the proxy without one.
+func (c *Conn) RemoteAddr() net.Addr {
+	if c.readHeader(); c.header != nil && c.header.Source.IsValid() {
+		return net.TCPAddrFromAddrPort(c.header.Source)
+	}
+	return c.Conn.RemoteAddr()
+}
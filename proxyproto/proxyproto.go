@@ -0,0 +1,241 @@
+// Package proxyproto reads and writes the headers of the HAProxy PROXY protocol, versions 1 and 2,
+// which load balancers prepend to the connections they forward to tell the address of the client.
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrInvalidHeader is returned for malformed headers.
+	ErrInvalidHeader = errors.New("proxyproto: invalid header")
+
+	// ErrUnsupportedVersion is returned for versions other than 1 and 2.
+	ErrUnsupportedVersion = errors.New("proxyproto: unsupported version")
+)
+
+// Command of a header.
+type Command uint8
+
+const (
+	CommandLocal Command = 0x0 // the connection was made by the proxy itself, such as a health check
+	CommandProxy Command = 0x1 // the connection is relayed for the client of Source
+)
+
+// TLV types of the version 2, TLVUsername being in the custom range.
+const (
+	TLVAuthority uint8 = 0x02 // host name the client connected to
+	TLVUniqueID  uint8 = 0x05 // unique identifier of the connection
+	TLVUsername  uint8 = 0xE0 // identity the client authenticated with
+)
+
+// signatures of the versions 1 and 2.
+var (
+	signatureV1 = []byte("PROXY ")
+	signatureV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const (
+	maxV1Len    = 107 // including the CRLF
+	headerV2Len = 16
+	familyIPv4  = 0x1
+	familyIPv6  = 0x2
+	familyUnix  = 0x3
+	protoStream = 0x1
+)
+
+// TLV is a type-length-value extension of a version 2 header.
+type TLV struct {
+	Type  uint8
+	Value []byte
+}
+
+// Header is a PROXY protocol header. Source and Destination are invalid when the addresses
+// are unknown or not IP ones, such as with CommandLocal or the UNKNOWN protocol of version 1.
+type Header struct {
+	Version     uint8 // 1 or 2
+	Command     Command
+	Source      netip.AddrPort
+	Destination netip.AddrPort
+	TLVs        []TLV // version 2 only
+}
+
+// TLV returns the value of the first TLV of type typ.
+func (h *Header) TLV(typ uint8) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == typ {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Read reads a header of either version from r, nil without error if the data doesn't begin with one.
+func Read(r *bufio.Reader) (*Header, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case signatureV1[0]:
+		if b, err := r.Peek(len(signatureV1)); err == nil && bytes.Equal(b, signatureV1) {
+			return readV1(r)
+		}
+	case signatureV2[0]:
+		if b, err := r.Peek(len(signatureV2)); err == nil && bytes.Equal(b, signatureV2) {
+			return readV2(r)
+		}
+	}
+	return nil, nil
+}
+
+// readV1 reads a "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n" line.
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= maxV1Len {
+			return nil, ErrInvalidHeader
+		}
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+	}
+
+	h := &Header{Version: 1, Command: CommandProxy}
+	fields := strings.Split(strings.TrimSuffix(string(line), "\r\n"), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return h, nil
+	}
+	if len(fields) != 6 || fields[1] != "TCP4" && fields[1] != "TCP6" {
+		return nil, ErrInvalidHeader
+	}
+	src, err1 := netip.ParseAddr(fields[2])
+	dst, err2 := netip.ParseAddr(fields[3])
+	srcPort, err3 := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, err4 := strconv.ParseUint(fields[5], 10, 16)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil || src.Is4() != (fields[1] == "TCP4") || dst.Is4() != src.Is4() {
+		return nil, ErrInvalidHeader
+	}
+	h.Source = netip.AddrPortFrom(src, uint16(srcPort))
+	h.Destination = netip.AddrPortFrom(dst, uint16(dstPort))
+	return h, nil
+}
+
+func readV2(r *bufio.Reader) (*Header, error) {
+	var fixed [headerV2Len]byte
+	if _, err := io.ReadFull(r, fixed[:]); err != nil {
+		return nil, err
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, ErrUnsupportedVersion
+	}
+	h := &Header{Version: 2, Command: Command(fixed[12] & 0xF)}
+	if h.Command != CommandLocal && h.Command != CommandProxy {
+		return nil, ErrInvalidHeader
+	}
+	rest := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, err
+	}
+
+	family := fixed[13] >> 4
+	var size, addrLen int
+	switch family {
+	case familyIPv4:
+		size, addrLen = 4, 2*4+2*2
+	case familyIPv6:
+		size, addrLen = 16, 2*16+2*2
+	case familyUnix:
+		addrLen = 2 * 108
+	}
+	if len(rest) < addrLen {
+		return nil, ErrInvalidHeader
+	}
+	// the addresses of the LOCAL connections are ignored
+	if h.Command == CommandProxy && size > 0 {
+		src, _ := netip.AddrFromSlice(rest[:size])
+		dst, _ := netip.AddrFromSlice(rest[size : 2*size])
+		h.Source = netip.AddrPortFrom(src, binary.BigEndian.Uint16(rest[2*size:]))
+		h.Destination = netip.AddrPortFrom(dst, binary.BigEndian.Uint16(rest[2*size+2:]))
+	}
+
+	tlvs := rest[addrLen:]
+	for len(tlvs) > 0 {
+		if len(tlvs) < 3 {
+			return nil, ErrInvalidHeader
+		}
+		length := int(binary.BigEndian.Uint16(tlvs[1:]))
+		if len(tlvs) < 3+length {
+			return nil, ErrInvalidHeader
+		}
+		h.TLVs = append(h.TLVs, TLV{Type: tlvs[0], Value: tlvs[3 : 3+length]})
+		tlvs = tlvs[3+length:]
+	}
+	return h, nil
+}
+
+// AppendBinary appends the header to dst in its Version, 2 if zero. Addresses of different
+// families are written as IPv6, invalid ones as UNKNOWN or UNSPEC.
+func (h *Header) AppendBinary(dst []byte) ([]byte, error) {
+	src, dstAddr := h.Source, h.Destination
+	known := h.Command == CommandProxy && src.IsValid() && dstAddr.IsValid()
+	v4 := known && src.Addr().Unmap().Is4() && dstAddr.Addr().Unmap().Is4()
+	if v4 {
+		src = netip.AddrPortFrom(src.Addr().Unmap(), src.Port())
+		dstAddr = netip.AddrPortFrom(dstAddr.Addr().Unmap(), dstAddr.Port())
+	} else if known {
+		src = netip.AddrPortFrom(netip.AddrFrom16(src.Addr().As16()), src.Port())
+		dstAddr = netip.AddrPortFrom(netip.AddrFrom16(dstAddr.Addr().As16()), dstAddr.Port())
+	}
+
+	switch h.Version {
+	case 1:
+		if !known {
+			return append(dst, "PROXY UNKNOWN\r\n"...), nil
+		}
+		protocol := "TCP6"
+		if v4 {
+			protocol = "TCP4"
+		}
+		return append(dst, "PROXY "+protocol+" "+src.Addr().String()+" "+dstAddr.Addr().String()+" "+
+			strconv.Itoa(int(src.Port()))+" "+strconv.Itoa(int(dstAddr.Port()))+"\r\n"...), nil
+	case 0, 2:
+	default:
+		return dst, ErrUnsupportedVersion
+	}
+
+	var payload []byte
+	var family byte
+	if known {
+		family = familyIPv6<<4 | protoStream
+		if v4 {
+			family = familyIPv4<<4 | protoStream
+		}
+		payload = append(payload, src.Addr().AsSlice()...)
+		payload = append(payload, dstAddr.Addr().AsSlice()...)
+		payload = binary.BigEndian.AppendUint16(payload, src.Port())
+		payload = binary.BigEndian.AppendUint16(payload, dstAddr.Port())
+	}
+	for _, tlv := range h.TLVs {
+		payload = append(payload, tlv.Type)
+		payload = binary.BigEndian.AppendUint16(payload, uint16(len(tlv.Value)))
+		payload = append(payload, tlv.Value...)
+	}
+	if len(payload) > 0xFFFF {
+		return dst, ErrInvalidHeader
+	}
+
+	dst = append(dst, signatureV2...)
+	dst = append(dst, 2<<4|byte(h.Command), family)
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(payload)))
+	return append(dst, payload...), nil
+}
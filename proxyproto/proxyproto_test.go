@@ -0,0 +1,174 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func read(t *testing.T, data string) (*Header, string, error) {
+	t.Helper()
+	r := bufio.NewReader(strings.NewReader(data))
+	h, err := Read(r)
+	rest, _ := io.ReadAll(r)
+	return h, string(rest), err
+}
+
+func TestReadV1(t *testing.T) {
+	h, rest, err := read(t, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET /")
+	assert.NoError(t, err)
+	assert.Equal(t, &Header{
+		Version:     1,
+		Command:     CommandProxy,
+		Source:      netip.MustParseAddrPort("192.0.2.1:56324"),
+		Destination: netip.MustParseAddrPort("198.51.100.1:443"),
+	}, h)
+	assert.Equal(t, "GET /", rest)
+
+	h, _, err = read(t, "PROXY TCP6 2001:db8::1 2001:db8::2 1 2\r\n")
+	assert.NoError(t, err)
+	assert.Equal(t, netip.MustParseAddrPort("[2001:db8::1]:1"), h.Source)
+
+	h, _, err = read(t, "PROXY UNKNOWN ffff::1 ffff::2 1 2\r\n")
+	assert.NoError(t, err)
+	assert.False(t, h.Source.IsValid())
+
+	for _, invalid := range []string{
+		"PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n",
+		"PROXY TCP4 2001:db8::1 2001:db8::2 1 2\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.1 65536 443\r\n",
+		"PROXY UDP4 192.0.2.1 198.51.100.1 1 2\r\n",
+		"PROXY " + strings.Repeat("A", maxV1Len) + "\r\n",
+	} {
+		_, _, err = read(t, invalid)
+		assert.Equal(t, ErrInvalidHeader, err, invalid)
+	}
+}
+
+func TestReadNone(t *testing.T) {
+	for _, data := range []string{"\x05\x01\x00", "PROXZ", "\r\n\r\nGET"} {
+		h, rest, err := read(t, data)
+		assert.NoError(t, err)
+		assert.Nil(t, h)
+		assert.Equal(t, data, rest, "the data is left unread")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, h := range []*Header{
+		{Version: 1, Command: CommandProxy, Source: netip.MustParseAddrPort("192.0.2.1:1"), Destination: netip.MustParseAddrPort("198.51.100.1:2")},
+		{Version: 1, Command: CommandProxy},
+		{Version: 2, Command: CommandProxy, Source: netip.MustParseAddrPort("192.0.2.1:1"), Destination: netip.MustParseAddrPort("198.51.100.1:2")},
+		{Version: 2, Command: CommandProxy, Source: netip.MustParseAddrPort("[2001:db8::1]:1"), Destination: netip.MustParseAddrPort("[2001:db8::2]:2"),
+			TLVs: []TLV{{Type: TLVUsername, Value: []byte("alice")}, {Type: TLVAuthority, Value: []byte("example.com")}}},
+		{Version: 2, Command: CommandLocal},
+	} {
+		b, err := h.AppendBinary(nil)
+		assert.NoError(t, err)
+		got, rest, err := read(t, string(b)+"data")
+		assert.NoError(t, err)
+		assert.Equal(t, h, got)
+		assert.Equal(t, "data", rest)
+	}
+
+	h, _, _ := read(t, string(must((&Header{Version: 2, Command: CommandProxy,
+		TLVs: []TLV{{Type: TLVUsername, Value: []byte("alice")}}}).AppendBinary(nil))))
+	username, ok := h.TLV(TLVUsername)
+	assert.True(t, ok)
+	assert.Equal(t, "alice", string(username))
+	_, ok = h.TLV(TLVUniqueID)
+	assert.False(t, ok)
+}
+
+func TestAppendBinaryMixedFamilies(t *testing.T) {
+	h := &Header{Command: CommandProxy, Source: netip.MustParseAddrPort("192.0.2.1:1"), Destination: netip.MustParseAddrPort("[2001:db8::2]:2")}
+	got, _, err := read(t, string(must(h.AppendBinary(nil))))
+	assert.NoError(t, err)
+	assert.Equal(t, netip.MustParseAddrPort("[::ffff:192.0.2.1]:1"), got.Source)
+
+	h.Version = 1
+	assert.Equal(t, "PROXY TCP6 ::ffff:192.0.2.1 2001:db8::2 1 2\r\n", string(must(h.AppendBinary(nil))))
+
+	h.Version = 3
+	_, err = h.AppendBinary(nil)
+	assert.Equal(t, ErrUnsupportedVersion, err)
+}
+
+func TestReadV2Invalid(t *testing.T) {
+	valid := must((&Header{Command: CommandProxy, Source: netip.MustParseAddrPort("192.0.2.1:1"), Destination: netip.MustParseAddrPort("198.51.100.1:2"),
+		TLVs: []TLV{{Type: TLVUniqueID, Value: []byte("id")}}}).AppendBinary(nil))
+
+	version := bytes.Clone(valid)
+	version[12] = 3<<4 | byte(CommandProxy)
+	_, _, err := read(t, string(version))
+	assert.Equal(t, ErrUnsupportedVersion, err)
+
+	command := bytes.Clone(valid)
+	command[12] = 2<<4 | 0xF
+	_, _, err = read(t, string(command))
+	assert.Equal(t, ErrInvalidHeader, err)
+
+	tlv := bytes.Clone(valid)
+	tlv[len(tlv)-3] = 0xFF // length of the TLV beyond the header
+	_, _, err = read(t, string(tlv))
+	assert.Equal(t, ErrInvalidHeader, err)
+
+	_, _, err = read(t, string(valid[:len(valid)-1]))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	header := must((&Header{Command: CommandProxy, Source: netip.MustParseAddrPort("192.0.2.1:1234"), Destination: netip.MustParseAddrPort("198.51.100.1:1080")}).AppendBinary(nil))
+	accept := func(pl *Listener) (net.Conn, string) {
+		t.Helper()
+		client, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		if _, err := client.Write(append(header, "data"...)); err != nil {
+			t.Fatal(err)
+		}
+		conn, err := pl.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return conn, client.LocalAddr().String()
+	}
+
+	conn, _ := accept(&Listener{Listener: l, Trusted: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}})
+	assert.Equal(t, "192.0.2.1:1234", conn.RemoteAddr().String())
+	data, _ := io.ReadAll(io.LimitReader(conn, 4))
+	assert.Equal(t, "data", string(data))
+	h, err := conn.(*Conn).Header()
+	assert.NoError(t, err)
+	assert.Equal(t, netip.MustParseAddrPort("198.51.100.1:1080"), h.Destination)
+
+	// the headers of untrusted sources are data
+	conn, local := accept(&Listener{Listener: l, Trusted: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}})
+	assert.Equal(t, local, conn.RemoteAddr().String())
+	data, _ = io.ReadAll(io.LimitReader(conn, int64(len(header))))
+	assert.Equal(t, header, data)
+}
+
+func must(b []byte, err error) []byte {
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
@@ -0,0 +1,142 @@
+package socks
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/kayabe/socks/s5"
+)
+
+// Resolver looks up the IP addresses of a host, *net.Resolver and the resolvers of the dns package implement it.
+type Resolver interface {
+	LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
+}
+
+// IPPreference orders the addresses a name resolves to.
+type IPPreference uint8
+
+const (
+	PreferAny  IPPreference = iota // in the order of the resolver
+	PreferIPv4                     // IPv4 addresses first
+	PreferIPv6                     // IPv6 addresses first
+)
+
+// DNSPolicy resolves names with static host overrides, ordering the addresses by preference.
+type DNSPolicy struct {
+	// Resolver resolves the names missing from Hosts, net.DefaultResolver if nil.
+	// A dns.Cache in front of a dns.Client caches the answers for their TTL.
+	Resolver Resolver
+
+	// Hosts maps names to their addresses, overriding the resolver like /etc/hosts does.
+	Hosts map[string][]netip.Addr
+
+	Prefer IPPreference
+}
+
+// LookupNetIP implements Resolver.
+func (p *DNSPolicy) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	var addrs []netip.Addr
+	if hosts, ok := p.Hosts[strings.ToLower(strings.TrimSuffix(host, "."))]; ok {
+		for _, addr := range hosts {
+			if network == "ip" || network == "ip4" && addr.Unmap().Is4() || network == "ip6" && addr.Is6() && !addr.Is4In6() {
+				addrs = append(addrs, addr)
+			}
+		}
+		if len(addrs) == 0 {
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+	} else {
+		var r Resolver = p.Resolver
+		if r == nil {
+			r = net.DefaultResolver
+		}
+		var err error
+		if addrs, err = r.LookupNetIP(ctx, network, host); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.Prefer != PreferAny {
+		sort.SliceStable(addrs, func(i, j int) bool {
+			return addrs[i].Unmap().Is4() == (p.Prefer == PreferIPv4) && addrs[j].Unmap().Is4() != (p.Prefer == PreferIPv4)
+		})
+	}
+	return addrs, nil
+}
+
+// ResolverRoute resolves the names of the requests matching all of its conditions with its Resolver.
+type ResolverRoute struct {
+	// Names matches the names, in the NO_PROXY syntax of ParseNoProxy, any if empty.
+	Names NoProxy
+
+	// Users matches the authenticated identities, any if empty.
+	Users []string
+
+	// Resolver resolves the names, net.DefaultResolver if nil.
+	Resolver Resolver
+}
+
+// ResolverRouter resolves with the first of its routes matching the name and the user
+// of the request, as told by RequestFromContext.
+type ResolverRouter struct {
+	Routes []ResolverRoute
+
+	// Default resolves the names no route matches, net.DefaultResolver if nil.
+	Default Resolver
+}
+
+// LookupNetIP implements Resolver.
+func (r *ResolverRouter) LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	var user string
+	if req, ok := RequestFromContext(ctx); ok {
+		user = req.Username
+	}
+
+	resolver := r.Default
+	for i := range r.Routes {
+		if matchRoute(r.Routes[i].Names, r.Routes[i].Users, host, user) {
+			resolver = r.Routes[i].Resolver
+			break
+		}
+	}
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return resolver.LookupNetIP(ctx, network, host)
+}
+
+// resolve resolves the domain name of the request with s.Resolver, and checks the Rules again
+// with each address as the IP of the request, so that a name can't resolve to a destination
+// they deny. The addresses they deny are dropped, the request is denied if none is left.
+func (s *Server) resolve(ctx context.Context, req *Request) ([]netip.Addr, s5.ReplyStatus) {
+	if ip, err := netip.ParseAddr(req.Host); err == nil {
+		return []netip.Addr{ip}, s5.ReplySuccess
+	}
+
+	addrs, err := s.Resolver.LookupNetIP(ctx, "ip", req.Host)
+	if err != nil {
+		return nil, ReplyStatusOf(err)
+	}
+	if len(addrs) == 0 {
+		return nil, s5.ReplyHostUnreachable
+	}
+	if s.Rules == nil {
+		return addrs, s5.ReplySuccess
+	}
+
+	var allowed []netip.Addr
+	for _, addr := range addrs {
+		r := *req
+		r.IP = addr
+		if s.Rules.Allow(ctx, &r) {
+			allowed = append(allowed, addr)
+		}
+	}
+	if len(allowed) == 0 {
+		return nil, s5.ReplyConnectionNotAllowed
+	}
+	return allowed, s5.ReplySuccess
+}
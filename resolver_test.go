@@ -0,0 +1,128 @@
+package socks
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strconv"
+	"testing"
+
+	"github.com/kayabe/socks/s5"
+	"github.com/stretchr/testify/assert"
+)
+
+// staticResolver resolves the names of a map.
+type staticResolver map[string][]netip.Addr
+
+func (r staticResolver) LookupNetIP(_ context.Context, _, host string) ([]netip.Addr, error) {
+	if addrs, ok := r[host]; ok {
+		return addrs, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+}
+
+// dialerFunc is an adapter to use a function as a ContextDialer.
+type dialerFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+func (f dialerFunc) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return f(ctx, network, address)
+}
+
+func parseAddrs(s ...string) (addrs []netip.Addr) {
+	for _, a := range s {
+		addrs = append(addrs, netip.MustParseAddr(a))
+	}
+	return
+}
+
+func TestDNSPolicy(t *testing.T) {
+	ctx := context.Background()
+	p := &DNSPolicy{
+		Resolver: staticResolver{"example.test": parseAddrs("2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2")},
+		Hosts:    map[string][]netip.Addr{"pinned.test": parseAddrs("192.0.2.9", "2001:db8::9")},
+	}
+
+	addrs, err := p.LookupNetIP(ctx, "ip", "example.test")
+	assert.NoError(t, err)
+	assert.Equal(t, parseAddrs("2001:db8::1", "192.0.2.1", "2001:db8::2", "192.0.2.2"), addrs)
+
+	p.Prefer = PreferIPv4
+	addrs, _ = p.LookupNetIP(ctx, "ip", "example.test")
+	assert.Equal(t, parseAddrs("192.0.2.1", "192.0.2.2", "2001:db8::1", "2001:db8::2"), addrs)
+
+	p.Prefer = PreferIPv6
+	addrs, _ = p.LookupNetIP(ctx, "ip", "Pinned.Test.")
+	assert.Equal(t, parseAddrs("2001:db8::9", "192.0.2.9"), addrs)
+
+	addrs, _ = p.LookupNetIP(ctx, "ip4", "pinned.test")
+	assert.Equal(t, parseAddrs("192.0.2.9"), addrs)
+	p.Hosts["v4.test"] = parseAddrs("192.0.2.4")
+	_, err = p.LookupNetIP(ctx, "ip6", "v4.test")
+	assert.Equal(t, s5.ReplyHostUnreachable, ReplyStatusOf(err))
+}
+
+func TestResolverRouter(t *testing.T) {
+	r := &ResolverRouter{
+		Routes: []ResolverRoute{
+			{Names: ParseNoProxy("internal.test"), Resolver: staticResolver{"db.internal.test": parseAddrs("10.0.0.1")}},
+			{Users: []string{"alice"}, Resolver: staticResolver{"example.test": parseAddrs("192.0.2.1")}},
+		},
+		Default: staticResolver{"example.test": parseAddrs("192.0.2.2")},
+	}
+	as := func(user string) context.Context {
+		return context.WithValue(context.Background(), requestKey{}, &Request{Username: user})
+	}
+
+	addrs, _ := r.LookupNetIP(as("bob"), "ip", "db.internal.test")
+	assert.Equal(t, parseAddrs("10.0.0.1"), addrs)
+	addrs, _ = r.LookupNetIP(as("alice"), "ip", "example.test")
+	assert.Equal(t, parseAddrs("192.0.2.1"), addrs)
+	addrs, _ = r.LookupNetIP(as("bob"), "ip", "example.test")
+	assert.Equal(t, parseAddrs("192.0.2.2"), addrs)
+	addrs, _ = r.LookupNetIP(context.Background(), "ip", "example.test")
+	assert.Equal(t, parseAddrs("192.0.2.2"), addrs)
+}
+
+func TestServerResolver(t *testing.T) {
+	echo := startEcho(t)
+	port := strconv.Itoa(echo.Port)
+
+	// 127.0.0.2 is denied, a name resolving to it is only dialed at its other addresses
+	denied := make(chan string, 8)
+	dialed := make(chan string, 8)
+	addr := startServer(t, &Server{
+		Resolver: &DNSPolicy{Hosts: map[string][]netip.Addr{
+			"echo.test":      parseAddrs("127.0.0.2", "127.0.0.1"),
+			"rebinding.test": parseAddrs("127.0.0.2"),
+		}},
+		Rules: RuleFunc(func(_ context.Context, req *Request) bool {
+			if req.IP == netip.MustParseAddr("127.0.0.2") {
+				denied <- req.Host
+				return false
+			}
+			return true
+		}),
+		Dialer: dialerFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+			dialed <- address
+			return (&net.Dialer{}).DialContext(ctx, network, address)
+		}),
+	})
+	client, _ := NewClient(addr)
+
+	conn, err := client.Dial("tcp", "echo.test:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEcho(t, conn)
+	conn.Close()
+	assert.Equal(t, "127.0.0.1:"+port, <-dialed)
+
+	_, err = client.Dial("tcp", "rebinding.test:"+port)
+	assert.Equal(t, s5.ErrReplyConnectionNotAllowed, err)
+	assert.Equal(t, "echo.test", <-denied)
+	assert.Equal(t, "rebinding.test", <-denied)
+
+	_, err = client.Dial("tcp", "nowhere.test:"+port)
+	assert.Equal(t, s5.ErrReplyHostUnreachable, err)
+	assert.Len(t, dialed, 0)
+}
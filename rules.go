@@ -0,0 +1,52 @@
+package socks
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+// Schemes of the protocols a Request can be received with.
+const (
+	SchemeSOCKS4  = "socks4"
+	SchemeSOCKS4A = "socks4a"
+	SchemeSOCKS5  = "socks5"
+	SchemeHTTP    = "http"
+)
+
+// Request describes a request received by the Server, independent of the protocol it was received with.
+type Request struct {
+	Protocol   string   // one of the Scheme constants
+	Command    uint8    // s5.CommandConnect, s5.CommandBind or s5.CommandAssociate
+	Host       string   // IP address or domain name
+	Port       uint16   // destination port
+	Username   string   // authenticated identity, empty if anonymous
+	RemoteAddr net.Addr // address of the client
+
+	// IP is an address the domain name resolved to, only set when a Server with a Resolver
+	// checks its Rules again for each of them.
+	IP netip.Addr
+}
+
+// Address returns the destination as host:port.
+func (r *Request) Address() string {
+	return net.JoinHostPort(r.Host, strconv.Itoa(int(r.Port)))
+}
+
+// Rule decides whether a Request is allowed.
+type Rule interface {
+	Allow(ctx context.Context, req *Request) bool
+}
+
+// RuleFunc is an adapter to use a function as a Rule.
+type RuleFunc func(ctx context.Context, req *Request) bool
+
+// Allow calls f(ctx, req).
+func (f RuleFunc) Allow(ctx context.Context, req *Request) bool { return f(ctx, req) }
+
+// PermitAll allows every request.
+var PermitAll Rule = RuleFunc(func(context.Context, *Request) bool { return true })
+
+// PermitNone denies every request.
+var PermitNone Rule = RuleFunc(func(context.Context, *Request) bool { return false })
@@ -0,0 +1,11 @@
+package s4
+
+const VERSION uint8 = 0x04
+
+// ReplyVersion is the version field of a reply, it is always null.
+const ReplyVersion uint8 = 0x00
+
+const (
+	CommandConnect uint8 = iota + 1
+	CommandBind
+)
@@ -0,0 +1,17 @@
+package s4
+
+import "errors"
+
+// General
+var (
+	ErrUnsupportedVersion = errors.New("unsupported version")
+	ErrFieldTooLong       = errors.New("field too long")
+	ErrInvalidDomain      = errors.New("invalid domain name")
+)
+
+// Reply
+var (
+	ErrReplyRejected         = errors.New("request rejected or failed")
+	ErrReplyIdentUnreachable = errors.New("request rejected, identd unreachable")
+	ErrReplyIdentMismatch    = errors.New("request rejected, user-id mismatch")
+)
@@ -0,0 +1,37 @@
+package s4
+
+import "io"
+
+// Reply is a reply structure for SOCKS V4 and SOCKS V4A.
+type Reply struct {
+	Version uint8 // unrequired, only used by Unpack
+	Status  ReplyStatus
+	Port    uint16
+	IP      [4]byte
+}
+
+// Pack writes the structure to the given writer as bytes.
+func (t *Reply) Pack(w io.Writer) (err error) {
+	_, err = w.Write([]byte{
+		ReplyVersion,
+		byte(t.Status),
+		byte(t.Port >> 8), byte(t.Port),
+		t.IP[0], t.IP[1], t.IP[2], t.IP[3],
+	})
+	return
+}
+
+// Unpack reads from the given reader into the structure.
+func (t *Reply) Unpack(r io.Reader) (err error) {
+	var buf [8]byte
+	if _, err = io.ReadFull(r, buf[:]); err != nil {
+		return
+	}
+	if t.Version = buf[0]; t.Version != ReplyVersion {
+		return ErrUnsupportedVersion
+	}
+	t.Status = ReplyStatus(buf[1])
+	t.Port = uint16(buf[2])<<8 | uint16(buf[3])
+	copy(t.IP[:], buf[4:])
+	return
+}
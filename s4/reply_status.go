@@ -0,0 +1,40 @@
+package s4
+
+import "strconv"
+
+// ReplyStatus is the CD field of a SOCKS4 reply.
+type ReplyStatus uint8
+
+const (
+	ReplyGranted          ReplyStatus = iota + 90 // request granted
+	ReplyRejected                                 // request rejected or failed
+	ReplyIdentUnreachable                         // request rejected, identd unreachable
+	ReplyIdentMismatch                            // request rejected, user-id mismatch
+)
+
+// Error returns the error of a rejected request, nil if it was granted.
+func (s ReplyStatus) Error() error {
+	switch s {
+	case ReplyGranted:
+		return nil
+	case ReplyIdentUnreachable:
+		return ErrReplyIdentUnreachable
+	case ReplyIdentMismatch:
+		return ErrReplyIdentMismatch
+	}
+	return ErrReplyRejected
+}
+
+func (s ReplyStatus) String() string {
+	switch s {
+	case ReplyGranted:
+		return "request granted"
+	case ReplyRejected:
+		return "request rejected or failed"
+	case ReplyIdentUnreachable:
+		return "request rejected, identd unreachable"
+	case ReplyIdentMismatch:
+		return "request rejected, user-id mismatch"
+	}
+	return "ReplyStatus(" + strconv.FormatInt(int64(s), 10) + ")"
+}
@@ -0,0 +1,42 @@
+package s4
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testReplyBuf = []byte{0x00, 0x5a, 0x1f, 0x90, 10, 0, 0, 1}
+
+func TestReplyStatus(t *testing.T) {
+	assert.Equal(t, "request granted", ReplyGranted.String())
+	assert.Nil(t, ReplyGranted.Error())
+	assert.Equal(t, ErrReplyRejected, ReplyRejected.Error())
+}
+
+func TestReplyPack(t *testing.T) {
+	var buf = bytes.NewBuffer(nil)
+
+	if err := (&Reply{Status: ReplyGranted, Port: 8080, IP: [4]byte{10, 0, 0, 1}}).Pack(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, testReplyBuf, buf.Bytes())
+}
+
+func TestReplyUnpack(t *testing.T) {
+	var r Reply
+
+	if err := r.Unpack(bytes.NewBuffer(testReplyBuf)); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, Reply{Version: ReplyVersion, Status: ReplyGranted, Port: 8080, IP: [4]byte{10, 0, 0, 1}}, r)
+}
+
+func TestReplyUnpackFail(t *testing.T) {
+	var r Reply
+
+	assert.Equal(t, ErrUnsupportedVersion, r.Unpack(bytes.NewBuffer([]byte{0x04, 0x5a, 0, 0, 0, 0, 0, 0})))
+}
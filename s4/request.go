@@ -0,0 +1,98 @@
+package s4
+
+import (
+	"io"
+)
+
+// MaxFieldLength limits USERID and DOMAIN, which are null-terminated and unbounded on the wire.
+const MaxFieldLength = 0xFF
+
+// Request is the request for SOCKS V4 and SOCKS V4A.
+//
+// A SOCKS4A request carries the Domain and an IP of the form 0.0.0.x where x is non-zero.
+type Request struct {
+	Version uint8 // unrequired, only used by Unpack
+	Command uint8
+	Port    uint16
+	IP      [4]byte
+	UserID  []byte
+	Domain  []byte // SOCKS4A only, IP is ignored by Pack when set
+}
+
+// IsV4A reports whether the request asks the server to resolve Domain.
+func (v *Request) IsV4A() bool {
+	return v.IP[0] == 0 && v.IP[1] == 0 && v.IP[2] == 0 && v.IP[3] != 0
+}
+
+// Pack writes the structure to the given writer as bytes.
+func (v *Request) Pack(w io.Writer) (err error) {
+	if len(v.UserID) > MaxFieldLength || len(v.Domain) > MaxFieldLength {
+		return ErrFieldTooLong
+	}
+
+	buf := make([]byte, 8, 8+len(v.UserID)+1+len(v.Domain)+1)
+	buf[0] = VERSION
+	buf[1] = v.Command
+	buf[2] = byte(v.Port >> 8)
+	buf[3] = byte(v.Port)
+	copy(buf[4:8], v.IP[:])
+	buf = append(append(buf, v.UserID...), 0)
+
+	if len(v.Domain) > 0 {
+		// 0.0.0.x tells the server to read the domain after USERID
+		buf[4], buf[5], buf[6], buf[7] = 0, 0, 0, 1
+		buf = append(append(buf, v.Domain...), 0)
+	}
+
+	_, err = w.Write(buf)
+	return
+}
+
+// Unpack reads from the given reader into the structure.
+func (v *Request) Unpack(r io.Reader) (err error) {
+	var buf [8]byte
+	if _, err = io.ReadFull(r, buf[:]); err != nil {
+		return
+	}
+	if v.Version = buf[0]; v.Version != VERSION {
+		return ErrUnsupportedVersion
+	}
+	v.Command = buf[1]
+	v.Port = uint16(buf[2])<<8 | uint16(buf[3])
+	copy(v.IP[:], buf[4:])
+
+	if v.UserID, err = readString(r); err != nil {
+		return
+	}
+
+	v.Domain = nil
+	if v.IsV4A() {
+		if v.Domain, err = readString(r); err != nil {
+			return
+		}
+		if len(v.Domain) == 0 {
+			return ErrInvalidDomain
+		}
+	}
+	return
+}
+
+// readString reads a null-terminated string of at most MaxFieldLength bytes.
+func readString(r io.Reader) ([]byte, error) {
+	var (
+		b   [1]byte
+		str []byte
+	)
+	for {
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return nil, err
+		}
+		if b[0] == 0 {
+			return str, nil
+		}
+		if len(str) == MaxFieldLength {
+			return nil, ErrFieldTooLong
+		}
+		str = append(str, b[0])
+	}
+}
@@ -0,0 +1,86 @@
+package s4
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testRequestBuf = []byte{0x04, 0x01, 0x00, 0x50, 127, 0, 0, 1, 'u', 's', 'e', 'r', 0x00}
+var testRequest4ABuf = []byte{0x04, 0x01, 0x00, 0x50, 0, 0, 0, 1, 0x00, 'g', 'o', 'o', 'g', 'l', 'e', '.', 'c', 'o', 'm', 0x00}
+
+func TestRequestPack(t *testing.T) {
+	var buf = bytes.NewBuffer(nil)
+
+	if err := (&Request{Command: CommandConnect, Port: 80, IP: [4]byte{127, 0, 0, 1}, UserID: []byte("user")}).Pack(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, testRequestBuf, buf.Bytes())
+}
+
+func TestRequest4APack(t *testing.T) {
+	var buf = bytes.NewBuffer(nil)
+
+	if err := (&Request{Command: CommandConnect, Port: 80, Domain: []byte("google.com")}).Pack(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, testRequest4ABuf, buf.Bytes())
+}
+
+func TestRequestPackFail(t *testing.T) {
+	var buf = bytes.NewBuffer(nil)
+
+	err := (&Request{Command: CommandConnect, Domain: make([]byte, MaxFieldLength+1)}).Pack(buf)
+	assert.Equal(t, ErrFieldTooLong, err)
+}
+
+func TestRequestUnpack(t *testing.T) {
+	var r Request
+
+	if err := r.Unpack(bytes.NewBuffer(testRequestBuf)); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, Request{Version: VERSION, Command: CommandConnect, Port: 80, IP: [4]byte{127, 0, 0, 1}, UserID: []byte("user")}, r)
+	assert.False(t, r.IsV4A())
+}
+
+func TestRequest4AUnpack(t *testing.T) {
+	var r Request
+
+	if err := r.Unpack(bytes.NewBuffer(testRequest4ABuf)); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, Request{Version: VERSION, Command: CommandConnect, Port: 80, IP: [4]byte{0, 0, 0, 1}, Domain: []byte("google.com")}, r)
+	assert.True(t, r.IsV4A())
+}
+
+func TestRequestUnpackFail(t *testing.T) {
+	var r Request
+
+	assert.Equal(t, ErrUnsupportedVersion, r.Unpack(bytes.NewBuffer([]byte{0x05, 0x01, 0x00, 0x50, 0, 0, 0, 0})))
+	assert.Equal(t, ErrFieldTooLong, r.Unpack(bytes.NewBuffer(append([]byte{0x04, 0x01, 0x00, 0x50, 127, 0, 0, 1}, bytes.Repeat([]byte{'a'}, 0x100)...))))
+	assert.Equal(t, ErrInvalidDomain, r.Unpack(bytes.NewBuffer([]byte{0x04, 0x01, 0x00, 0x50, 0, 0, 0, 1, 0x00, 0x00})))
+}
+
+func BenchmarkRequestPack(b *testing.B) {
+	var buf = bytes.NewBuffer(nil)
+	var r = Request{Command: CommandConnect, Port: 80, IP: [4]byte{127, 0, 0, 1}, UserID: []byte("user")}
+
+	for i := 0; i < b.N; i++ {
+		_ = r.Pack(buf)
+		buf.Reset()
+	}
+}
+
+func BenchmarkRequestUnpack(b *testing.B) {
+	var r Request
+
+	for i := 0; i < b.N; i++ {
+		_ = r.Unpack(bytes.NewBuffer(testRequest4ABuf))
+	}
+}
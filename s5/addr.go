@@ -0,0 +1,287 @@
+package s5
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+// Addr is a SOCKS address, an IPv4 or IPv6 address or a domain name, and a port.
+//
+// Addr implements net.Addr and Destination, so it can be used as the Destination of
+// a Request or an UDPHeader.
+type Addr struct {
+	Type uint8      // AddressTypeIPv4, AddressTypeIPv6 or AddressTypeDomainName
+	IP   netip.Addr // set for AddressTypeIPv4 and AddressTypeIPv6
+	Host string     // set for AddressTypeDomainName
+	Port uint16
+}
+
+// ParseAddr parses a "host:port" address, the host being an IP address or a domain name.
+func ParseAddr(s string) (Addr, error) {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return Addr{}, err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return Addr{}, err
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return AddrFromAddrPort(netip.AddrPortFrom(ip, uint16(p))), nil
+	}
+	if len(host) == 0 || len(host) > 0xFF {
+		return Addr{}, ErrInvalidHostnameLength
+	}
+	return Addr{Type: AddressTypeDomainName, Host: host, Port: uint16(p)}, nil
+}
+
+// AddrFromAddrPort returns the address of ap, IPv4-mapped IPv6 addresses are kept as IPv6.
+func AddrFromAddrPort(ap netip.AddrPort) Addr {
+	a := Addr{Type: AddressTypeIPv4, IP: ap.Addr().WithZone(""), Port: ap.Port()}
+	if a.IP.Is6() {
+		a.Type = AddressTypeIPv6
+	}
+	return a
+}
+
+// AddrFromNet converts a *net.TCPAddr, a *net.UDPAddr, an Addr, or any net.Addr whose String is "host:port".
+// As net.IP doesn't tell IPv4 apart from IPv4-mapped IPv6, the latter are converted to IPv4.
+func AddrFromNet(addr net.Addr) (Addr, error) {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return AddrFromAddrPort(unmap(addr.AddrPort())), nil
+	case *net.UDPAddr:
+		return AddrFromAddrPort(unmap(addr.AddrPort())), nil
+	case Addr:
+		return addr, nil
+	case *Addr:
+		return *addr, nil
+	case nil:
+		return Addr{}, ErrUnsupportedAddressType
+	}
+	return ParseAddr(addr.String())
+}
+
+func unmap(ap netip.AddrPort) netip.AddrPort {
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+}
+
+// AddrFromDestination converts any of the Destination implementations.
+func AddrFromDestination(d Destination) Addr {
+	switch d := d.(type) {
+	case *Addr:
+		return *d
+	case *RequestV5DestDomainName:
+		return Addr{Type: AddressTypeDomainName, Host: string(d.Address), Port: d.Port}
+	case *RequestV5DestIPv4:
+		return Addr{Type: AddressTypeIPv4, IP: netip.AddrFrom4(d.Address), Port: d.Port}
+	case *RequestV5DestIPv6:
+		return Addr{Type: AddressTypeIPv6, IP: netip.AddrFrom16(d.Address), Port: d.Port}
+	}
+	return Addr{}
+}
+
+// Network returns "socks", the address is only meaningful to a SOCKS server.
+func (a Addr) Network() string { return "socks" }
+
+// String returns the "host:port" form of the address.
+func (a Addr) String() string {
+	host := a.Host
+	if a.Type != AddressTypeDomainName {
+		host = a.IP.String()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(a.Port)))
+}
+
+// IsValid reports whether the address has a supported type and a host.
+func (a Addr) IsValid() bool {
+	switch a.Type {
+	case AddressTypeIPv4:
+		return a.IP.Is4()
+	case AddressTypeIPv6:
+		return a.IP.Is6()
+	case AddressTypeDomainName:
+		return len(a.Host) > 0 && len(a.Host) <= 0xFF
+	}
+	return false
+}
+
+// AddrPort returns the IP address and port, it is invalid for a domain name.
+func (a Addr) AddrPort() netip.AddrPort {
+	if a.Type == AddressTypeDomainName {
+		return netip.AddrPort{}
+	}
+	return netip.AddrPortFrom(a.IP, a.Port)
+}
+
+// AppendBinary appends the wire format, ATYP, DST.ADDR and DST.PORT, to dst.
+func (a *Addr) AppendBinary(dst []byte) []byte {
+	return a.appendAddress(append(dst, a.Kind()))
+}
+
+// Parse reads the ATYP, DST.ADDR and DST.PORT wire format from b and returns the number of bytes read.
+// If b is incomplete, ErrShortBuffer is returned and n is the minimum length of b.
+func (a *Addr) Parse(b []byte) (n int, err error) {
+	if len(b) < 1 {
+		return 1, ErrShortBuffer
+	}
+	a.Type = b[0]
+	if n, err = a.parseAddress(b[1:]); err != nil {
+		if err == ErrShortBuffer {
+			n++
+		}
+		return
+	}
+	return 1 + n, nil
+}
+
+// Kind returns the address type.
+func (a *Addr) Kind() uint8 {
+	if a.Type == 0 {
+		return AddressTypeIPv4
+	}
+	return a.Type
+}
+
+// Size returns binary length of the address and port, without the address type.
+func (a *Addr) Size() int {
+	switch a.Kind() {
+	case AddressTypeDomainName:
+		return 1 + len(a.Host) + 2
+	case AddressTypeIPv6:
+		return DestIPv6Size
+	}
+	return DestIPv4Size
+}
+
+// Put writes the address and port, without the address type, to buf.
+func (a *Addr) Put(buf []byte) error {
+	if a.Kind() == AddressTypeDomainName && (len(a.Host) == 0 || len(a.Host) > 0xFF) {
+		return ErrInvalidHostnameLength
+	}
+	if len(buf) < a.Size() {
+		return ErrInvalidBufferSize
+	}
+	a.appendAddress(buf[:0])
+	return nil
+}
+
+// Unpack reads the address and port from r, Type has to be set beforehand.
+func (a *Addr) Unpack(r io.Reader) (err error) {
+	var buf [1 + 0xFF + 2]byte
+	n := 0
+	switch a.Type {
+	case AddressTypeIPv4:
+		n = DestIPv4Size
+	case AddressTypeIPv6:
+		n = DestIPv6Size
+	case AddressTypeDomainName:
+		if _, err = io.ReadFull(r, buf[:1]); err != nil {
+			return
+		}
+		n = 1 + int(buf[0]) + 2
+	default:
+		return ErrUnsupportedAddressType
+	}
+	start := 0
+	if a.Type == AddressTypeDomainName {
+		start = 1
+	}
+	if _, err = io.ReadFull(r, buf[start:n]); err != nil {
+		return
+	}
+	_, err = a.parseAddress(buf[:n])
+	return
+}
+
+// appendAddress appends the address and port, without the address type.
+func (a *Addr) appendAddress(dst []byte) []byte {
+	switch a.Kind() {
+	case AddressTypeDomainName:
+		dst = append(dst, uint8(len(a.Host)))
+		dst = append(dst, a.Host...)
+	case AddressTypeIPv6:
+		ip := a.IP.As16()
+		dst = append(dst, ip[:]...)
+	default:
+		if a.IP.Is4() {
+			ip := a.IP.As4()
+			dst = append(dst, ip[:]...)
+		} else {
+			dst = append(dst, 0, 0, 0, 0)
+		}
+	}
+	return binary.BigEndian.AppendUint16(dst, a.Port)
+}
+
+// parseAddress parses the address and port of the type set in a.Type.
+func (a *Addr) parseAddress(b []byte) (n int, err error) {
+	switch a.Type {
+	case AddressTypeIPv4:
+		if n = DestIPv4Size; len(b) < n {
+			return n, ErrShortBuffer
+		}
+		a.IP, a.Host = netip.AddrFrom4([4]byte(b[:4])), ""
+	case AddressTypeIPv6:
+		if n = DestIPv6Size; len(b) < n {
+			return n, ErrShortBuffer
+		}
+		a.IP, a.Host = netip.AddrFrom16([16]byte(b[:16])), ""
+	case AddressTypeDomainName:
+		if len(b) < 1 {
+			return 1, ErrShortBuffer
+		}
+		length := int(b[0])
+		if n = 1 + length + 2; len(b) < n {
+			return n, ErrShortBuffer
+		}
+		host := b[1 : 1+length]
+		if err = ValidateHostname(host, Lenient); err != nil {
+			return 0, err
+		}
+		a.IP, a.Host = netip.Addr{}, string(host)
+	default:
+		return 0, ErrUnsupportedAddressType
+	}
+	a.Port = binary.BigEndian.Uint16(b[n-2:])
+	return n, nil
+}
+
+// Addr returns the destination of the request.
+func (v *Request) Addr() Addr {
+	return AddrFromDestination(v.Destination)
+}
+
+// Addr returns the destination of the datagram.
+func (h *UDPHeader) Addr() Addr {
+	return AddrFromDestination(h.Destination)
+}
+
+// Addr returns the bound address of the reply, the zero Bind as 0.0.0.0:0.
+func (t *Reply) Addr() Addr {
+	if t.Bind.Type != AddressTypeDomainName && !t.Bind.IP.IsValid() {
+		return Addr{Type: AddressTypeIPv4, IP: netip.IPv4Unspecified(), Port: t.Bind.Port}
+	}
+	return t.Bind
+}
+
+// Addr returns the bound address, the zero Address as 0.0.0.0.
+func (b *ReplyBind) Addr() Addr {
+	if !b.Address.IsValid() {
+		return Addr{Type: AddressTypeIPv4, IP: netip.IPv4Unspecified(), Port: b.Port}
+	}
+	return AddrFromAddrPort(netip.AddrPortFrom(b.Address, b.Port))
+}
+
+// BindFromAddr returns the ReplyBind of an IP address, domain names can't be bound.
+//
+// Deprecated: Reply.Bind is an Addr.
+func BindFromAddr(a Addr) (ReplyBind, error) {
+	if a.Type == AddressTypeDomainName {
+		return ReplyBind{}, ErrUnsupportedAddressType
+	}
+	return ReplyBind{Address: a.IP, Port: a.Port}, nil
+}
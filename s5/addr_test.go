@@ -0,0 +1,166 @@
+package s5
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAddr(t *testing.T) {
+	for s, want := range map[string]Addr{
+		"127.0.0.1:80":    {Type: AddressTypeIPv4, IP: netip.MustParseAddr("127.0.0.1"), Port: 80},
+		"[::1]:443":       {Type: AddressTypeIPv6, IP: netip.MustParseAddr("::1"), Port: 443},
+		"[fe80::1%1]:443": {Type: AddressTypeIPv6, IP: netip.MustParseAddr("fe80::1"), Port: 443},
+		"google.com:80":   {Type: AddressTypeDomainName, Host: "google.com", Port: 80},
+	} {
+		a, err := ParseAddr(s)
+		if err != nil {
+			t.Fatal(s, err)
+		}
+		assert.Equal(t, want, a, s)
+		assert.True(t, a.IsValid(), s)
+	}
+
+	for _, s := range []string{"google.com", "google.com:http", "google.com:65536", ":80"} {
+		_, err := ParseAddr(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestAddrString(t *testing.T) {
+	for _, s := range []string{"127.0.0.1:80", "[::1]:443", "google.com:80"} {
+		a, _ := ParseAddr(s)
+		assert.Equal(t, s, a.String())
+	}
+	assert.Equal(t, "socks", Addr{}.Network())
+}
+
+func TestAddrFromNet(t *testing.T) {
+	a, err := AddrFromNet(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, Addr{Type: AddressTypeIPv4, IP: netip.MustParseAddr("127.0.0.1"), Port: 80}, a)
+
+	a, err = AddrFromNet(&net.UDPAddr{IP: net.IPv6loopback, Port: 53})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, Addr{Type: AddressTypeIPv6, IP: netip.MustParseAddr("::1"), Port: 53}, a)
+
+	a, err = AddrFromNet(Addr{Type: AddressTypeDomainName, Host: "google.com", Port: 80})
+	assert.NoError(t, err)
+	assert.Equal(t, "google.com:80", a.String())
+
+	_, err = AddrFromNet(nil)
+	assert.Equal(t, ErrUnsupportedAddressType, err)
+}
+
+func TestAddrFromDestination(t *testing.T) {
+	assert.Equal(t, Addr{Type: AddressTypeDomainName, Host: "google.com", Port: 80}, AddrFromDestination(&RequestV5DestDomainName{Address: []byte("google.com"), Port: 80}))
+	assert.Equal(t, Addr{Type: AddressTypeIPv4, IP: netip.MustParseAddr("127.0.0.1"), Port: 80}, AddrFromDestination(&RequestV5DestIPv4{Address: [4]byte{127, 0, 0, 1}, Port: 80}))
+	assert.Equal(t, Addr{Type: AddressTypeIPv6, IP: netip.MustParseAddr("e0e::"), Port: 80}, AddrFromDestination(&RequestV5DestIPv6{Address: [16]byte{0xe, 0xe}, Port: 80}))
+	assert.False(t, AddrFromDestination(nil).IsValid())
+}
+
+func TestAddrAppendBinary(t *testing.T) {
+	a := Addr{Type: AddressTypeDomainName, Host: "google.com", Port: 80}
+	assert.Equal(t, []byte{0x03, 0x0A, 'g', 'o', 'o', 'g', 'l', 'e', '.', 'c', 'o', 'm', 0x00, 0x50}, a.AppendBinary(nil))
+
+	// the zero address is written as 0.0.0.0:0
+	assert.Equal(t, []byte{0x01, 0, 0, 0, 0, 0, 0}, (&Addr{}).AppendBinary(nil))
+
+	var got Addr
+	n, err := got.Parse(append(a.AppendBinary(nil), 0xAA))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 14, n)
+	assert.Equal(t, a, got)
+
+	n, err = got.Parse([]byte{0x04, 0x00})
+	assert.Equal(t, ErrShortBuffer, err)
+	assert.Equal(t, 1+DestIPv6Size, n)
+
+	_, err = got.Parse([]byte{0x02, 0x00})
+	assert.Equal(t, ErrUnsupportedAddressType, err)
+}
+
+func TestRequestAddr(t *testing.T) {
+	a, _ := ParseAddr("google.com:80")
+	var buf = bytes.NewBuffer(nil)
+
+	if err := (&Request{Command: CommandConnect, Destination: &a}).Pack(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []byte{0x5, 0x1, 0x0, 0x3, 0xa, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x63, 0x6f, 0x6d, 0x0, 0x50}, buf.Bytes())
+
+	// an *Addr destination is reused by Unpack and Parse
+	var got Addr
+	var req = Request{Destination: &got}
+	if err := req.Unpack(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, a, got)
+	assert.Equal(t, a, req.Addr())
+
+	got = Addr{}
+	if _, err := req.Parse([]byte{0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, 0x00, 0x50}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "127.0.0.1:80", got.String())
+
+	// the other destinations are converted
+	if err := req.Unpack(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, a, req.Addr())
+}
+
+func TestUDPHeaderAddr(t *testing.T) {
+	a, _ := ParseAddr("[::1]:53")
+	datagram := (&UDPHeader{Destination: &a}).Wrap(nil, []byte("ping"))
+
+	var h UDPHeader
+	payload, err := h.Unwrap(datagram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "ping", string(payload))
+	assert.Equal(t, a, h.Addr())
+}
+
+func TestReplyAddr(t *testing.T) {
+	r := Reply{Bind: AddrFromAddrPort(netip.AddrPortFrom(addrIPv4, 1884))}
+	assert.Equal(t, "127.0.0.1:1884", r.Addr().String())
+	assert.Equal(t, "0.0.0.0:0", (&Reply{}).Addr().String())
+
+	b, err := BindFromAddr(r.Addr())
+	assert.NoError(t, err)
+	assert.Equal(t, ReplyBind{Address: addrIPv4, Port: 1884}, b)
+
+	_, err = BindFromAddr(Addr{Type: AddressTypeDomainName, Host: "google.com"})
+	assert.Equal(t, ErrUnsupportedAddressType, err)
+}
+
+func TestAddrValidate(t *testing.T) {
+	assert.NoError(t, (&Addr{Type: AddressTypeIPv4, IP: netip.MustParseAddr("127.0.0.1")}).Validate(Strict))
+	assert.Equal(t, ErrUnsupportedAddressType, (&Addr{Type: AddressTypeIPv6, IP: netip.MustParseAddr("127.0.0.1")}).Validate(Lenient))
+	assert.Equal(t, ErrInvalidHostname, (&Addr{Type: AddressTypeDomainName, Host: "a b"}).Validate(Lenient))
+	assert.Equal(t, ErrInvalidHostname, (&Request{Destination: &Addr{Type: AddressTypeDomainName, Host: "a_b"}}).Validate(Strict))
+}
+
+///
+
+func BenchmarkAddrParse(b *testing.B) {
+	var a Addr
+	var data = []byte{0x01, 127, 0, 0, 1, 0x00, 0x50}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = a.Parse(data)
+	}
+}
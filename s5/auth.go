@@ -51,6 +51,26 @@ func (t *AuthReply) Unpack(r io.Reader) (err error) {
 	return
 }
 
+// AppendBinary appends the wire format to dst.
+func (t *AuthReply) AppendBinary(dst []byte) []byte {
+	return append(dst, t.Version, byte(t.Status))
+}
+
+// Parse reads the structure from b and returns the number of bytes read.
+// If b is incomplete, ErrShortBuffer is returned and n is the minimum length of b.
+func (t *AuthReply) Parse(b []byte) (n int, err error) {
+	if len(b) < 2 {
+		return 2, ErrShortBuffer
+	}
+	t.Version, t.Status = b[0], ReplyStatus(b[1])
+	if t.Validate != nil {
+		if err = t.Validate(t.Version); err != nil {
+			return 0, err
+		}
+	}
+	return 2, nil
+}
+
 // AuthReplyFromConn for client-side
 func AuthReplyFromConn(conn net.Conn, validateCb func(authVersion uint8) error) (_ *AuthReply, err error) {
 	var reply = &AuthReply{
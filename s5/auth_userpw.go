@@ -18,21 +18,47 @@ type AuthUserPW struct {
 
 // Pack writes the authentication method to the given writer.
 func (auth *AuthUserPW) Pack(w io.Writer) (err error) {
-	usernameLength := uint8(len(auth.Username))
-	passwordLength := uint8(len(auth.Password))
-
-	buf := make([]byte, 1+usernameLength+1+passwordLength+1)
-	buf[0] = AuthUserPWVersion
-	buf[1] = usernameLength                     // username length
-	copy(buf[2:], auth.Username)                // copy username to the buffer
-	buf[2+usernameLength] = passwordLength      // password length
-	copy(buf[3+usernameLength:], auth.Password) // copy password to the buffer
+	if err = auth.Validate(Lenient); err != nil {
+		return
+	}
 
 	// Write the entire buffer to the provided io.Writer
-	_, err = w.Write(buf)
+	_, err = w.Write(auth.AppendBinary(make([]byte, 0, 3+len(auth.Username)+len(auth.Password))))
 	return
 }
 
+// AppendBinary appends the wire format to dst, the lengths are not checked, see Validate.
+func (auth *AuthUserPW) AppendBinary(dst []byte) []byte {
+	dst = append(dst, AuthUserPWVersion, uint8(len(auth.Username)))
+	dst = append(dst, auth.Username...)
+	dst = append(dst, uint8(len(auth.Password)))
+	return append(dst, auth.Password...)
+}
+
+// Parse reads the structure from b and returns the number of bytes read.
+// If b is incomplete, ErrShortBuffer is returned and n is the minimum length of b.
+// Username and Password alias b.
+func (auth *AuthUserPW) Parse(b []byte) (n int, err error) {
+	if len(b) < 2 {
+		return 2, ErrShortBuffer
+	}
+	if auth.Version, auth.UsernameLength = b[0], b[1]; auth.Version != AuthUserPWVersion {
+		return 0, ErrAuthVersion
+	}
+	if n = 2 + int(auth.UsernameLength) + 1; len(b) < n {
+		return n, ErrShortBuffer
+	}
+	auth.Username = b[2 : n-1 : n-1]
+	auth.PasswordLength = b[n-1]
+
+	end := n + int(auth.PasswordLength)
+	if len(b) < end {
+		return end, ErrShortBuffer
+	}
+	auth.Password = b[n:end:end]
+	return end, nil
+}
+
 // Unpack reads the authentication method from the given reader.
 func (auth *AuthUserPW) Unpack(r io.Reader) (err error) {
 	// casting the auth pointer to an array of 2 bytes so we can directly read only into Version and UsernameLength.
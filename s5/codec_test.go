@@ -0,0 +1,197 @@
+package s5
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// codec is implemented by every structure with the byte-slice API.
+type codec interface {
+	AppendBinary(dst []byte) []byte
+	Parse(b []byte) (int, error)
+}
+
+var codecTests = []struct {
+	name   string
+	encode codec
+	decode codec
+	want   []byte
+	allocs float64 // of Parse
+}{
+	{
+		name:   "HandshakeRequest",
+		encode: &HandshakeRequest{Methods: []AuthMethod{MethodAuthNone, MethodAuthUserPW}},
+		decode: &HandshakeRequest{},
+		want:   []byte{0x05, 0x02, 0x00, 0x02},
+	},
+	{
+		name:   "HandshakeReply",
+		encode: &HandshakeReply{Version: VERSION, Method: MethodAuthUserPW},
+		decode: &HandshakeReply{},
+		want:   []byte{0x05, 0x02},
+	},
+	{
+		name:   "AuthUserPW",
+		encode: &AuthUserPW{Username: []byte("test"), Password: []byte("test")},
+		decode: &AuthUserPW{},
+		want:   authBuf,
+	},
+	{
+		name:   "AuthReply",
+		encode: &AuthReply{Version: AuthUserPWVersion, Status: ReplyGeneralFailure},
+		decode: &AuthReply{},
+		want:   []byte{AuthUserPWVersion, byte(ReplyGeneralFailure)},
+	},
+	{
+		name:   "RequestDomainName",
+		encode: &Request{Command: CommandConnect, Destination: &RequestV5DestDomainName{Address: []byte("google.com"), Port: 80}},
+		decode: &Request{},
+		want:   []byte{0x05, 0x01, 0x00, 0x03, 0x0A, 'g', 'o', 'o', 'g', 'l', 'e', '.', 'c', 'o', 'm', 0x00, 0x50},
+	},
+	{
+		name:   "RequestIPv4",
+		encode: &Request{Command: CommandConnect, Destination: &RequestV5DestIPv4{Address: [4]byte{127, 0, 0, 1}, Port: 0x1234}},
+		decode: &Request{},
+		want:   []byte{0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, 0x12, 0x34},
+	},
+	{
+		name:   "RequestIPv6",
+		encode: &Request{Command: CommandBind, Destination: &RequestV5DestIPv6{Address: [16]byte{0xe, 0xe}, Port: 80}},
+		decode: &Request{},
+		want:   []byte{0x05, 0x02, 0x00, 0x04, 0x0e, 0x0e, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x50},
+	},
+	{
+		name:   "ReplyIPv4",
+		encode: &Reply{Status: ReplySuccess, Bind: AddrFromAddrPort(netip.AddrPortFrom(addrIPv4, 1884))},
+		decode: &Reply{},
+		want:   testReplyIPv4Buf,
+	},
+	{
+		name:   "ReplyIPv6",
+		encode: &Reply{Status: ReplyHostUnreachable, Bind: AddrFromAddrPort(netip.AddrPortFrom(addrIPv6, 1884))},
+		decode: &Reply{},
+		want:   append([]byte{VERSION, byte(ReplyHostUnreachable), 0x00, AddressTypeIPv6}, testIPv6Buf...),
+	},
+	{
+		name:   "ReplyDomainName",
+		encode: &Reply{Status: ReplySuccess, Bind: Addr{Type: AddressTypeDomainName, Host: "google.com", Port: 53}},
+		decode: &Reply{},
+		want:   []byte{VERSION, byte(ReplySuccess), 0x00, AddressTypeDomainName, 0x0A, 'g', 'o', 'o', 'g', 'l', 'e', '.', 'c', 'o', 'm', 0x00, 0x35},
+		allocs: 1, // the host of an Addr is a string
+	},
+	{
+		name:   "UDPHeaderIPv4",
+		encode: &UDPHeader{Destination: &RequestV5DestIPv4{Address: [4]byte{127, 0, 0, 1}, Port: 53}},
+		decode: &UDPHeader{},
+		want:   []byte{0x00, 0x00, 0x00, 0x01, 127, 0, 0, 1, 0x00, 0x35},
+	},
+	{
+		name:   "UDPHeaderDomainName",
+		encode: &UDPHeader{Frag: 2, Destination: &RequestV5DestDomainName{Address: []byte("google.com"), Port: 53}},
+		decode: &UDPHeader{},
+		want:   []byte{0x00, 0x00, 0x02, 0x03, 0x0A, 'g', 'o', 'o', 'g', 'l', 'e', '.', 'c', 'o', 'm', 0x00, 0x35},
+	},
+}
+
+func TestCodecAppendBinary(t *testing.T) {
+	for _, tt := range codecTests {
+		assert.Equal(t, tt.want, tt.encode.AppendBinary(nil), tt.name)
+
+		// appending keeps the existing bytes
+		assert.Equal(t, append([]byte{0xAA}, tt.want...), tt.encode.AppendBinary([]byte{0xAA}), tt.name)
+	}
+}
+
+func TestCodecParse(t *testing.T) {
+	for _, tt := range codecTests {
+		n, err := tt.decode.Parse(append(tt.want, 0xAA))
+		if err != nil {
+			t.Fatal(tt.name, err)
+		}
+		assert.Equal(t, len(tt.want), n, tt.name)
+		assert.Equal(t, tt.want, tt.decode.AppendBinary(nil), tt.name)
+	}
+}
+
+func TestCodecParseShortBuffer(t *testing.T) {
+	for _, tt := range codecTests {
+		for i := 0; i < len(tt.want); i++ {
+			n, err := tt.decode.Parse(tt.want[:i])
+			assert.Equal(t, ErrShortBuffer, err, tt.name)
+			assert.Greater(t, n, i, tt.name)
+			assert.LessOrEqual(t, n, len(tt.want), tt.name)
+		}
+	}
+}
+
+func TestCodecParseFail(t *testing.T) {
+	_, err := (&HandshakeRequest{}).Parse([]byte{'H', 'T', 'T', 'P'})
+	assert.Equal(t, ErrUnsupportedVersion, err)
+
+	_, err = (&AuthUserPW{}).Parse([]byte{0x02, 0x00, 0x00})
+	assert.Equal(t, ErrAuthVersion, err)
+
+	_, err = (&Request{}).Parse([]byte{0x05, 0x01, 0x00, 0x02, 0x00})
+	assert.Equal(t, ErrUnsupportedAddressType, err)
+
+	_, err = (&Reply{}).Parse([]byte{0x05, 0x00, 0x00, 0x02, 0x00})
+	assert.Equal(t, ErrUnsupportedAddressType, err)
+}
+
+func TestReplyBindAppendBinaryZero(t *testing.T) {
+	assert.Equal(t, []byte{0, 0, 0, 0, 0, 0}, (&ReplyBind{}).AppendBinary(nil))
+
+	var r ReplyBind
+	if _, err := r.Parse(testIPv4Buf, false); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ReplyBind{Address: netip.MustParseAddr("127.0.0.1"), Port: 1884}, r)
+}
+
+func TestCodecAllocs(t *testing.T) {
+	buf := make([]byte, 0, 512)
+
+	for _, tt := range codecTests {
+		assert.Equal(t, 0.0, testing.AllocsPerRun(100, func() {
+			buf = tt.encode.AppendBinary(buf[:0])
+		}), tt.name+".AppendBinary")
+
+		data := tt.encode.AppendBinary(nil)
+		assert.Equal(t, tt.allocs, testing.AllocsPerRun(100, func() {
+			_, _ = tt.decode.Parse(data)
+		}), tt.name+".Parse")
+	}
+}
+
+func benchmarkAppendBinary(b *testing.B, c codec) {
+	buf := make([]byte, 0, 512)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf = c.AppendBinary(buf[:0])
+	}
+}
+
+func benchmarkParse(b *testing.B, c codec, data []byte) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = c.Parse(data)
+	}
+}
+
+func BenchmarkCodecAppendBinary(b *testing.B) {
+	for _, tt := range codecTests {
+		b.Run(tt.name, func(b *testing.B) { benchmarkAppendBinary(b, tt.encode) })
+	}
+}
+
+func BenchmarkCodecParse(b *testing.B) {
+	for _, tt := range codecTests {
+		data := tt.encode.AppendBinary(nil)
+		decode := tt.decode
+		b.Run(tt.name, func(b *testing.B) { benchmarkParse(b, decode, data) })
+	}
+}
@@ -8,6 +8,12 @@ const (
 	CommandAssociate
 )
 
+// Tor extensions, the reply to CommandResolve holds the address of the requested domain name.
+const (
+	CommandResolve    uint8 = 0xF0
+	CommandResolvePTR uint8 = 0xF1
+)
+
 const (
 	AddressTypeIPv4 uint8 = iota + 1
 	_
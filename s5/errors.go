@@ -10,12 +10,24 @@ var (
 	ErrUnknownNetwork         = errors.New("unknown network")
 	ErrAuthNoneAcceptable     = errors.New("auth none acceptable")
 	ErrInvalidHostnameLength  = errors.New("invalid hostname length")
+	ErrInvalidHostname        = errors.New("invalid hostname")
+	ErrInvalidPunycode        = errors.New("invalid punycode")
+	ErrNoMethods              = errors.New("no authentication methods")
+	ErrReservedNotZero        = errors.New("reserved field is not zero")
 )
 
-//
 var ErrMethodsLimit = errors.New("reached the limit of methods")
 var ErrInvalidBufferSize = errors.New("invalid buffer size")
 
+// ErrShortBuffer is returned by Parse when the input is incomplete.
+var ErrShortBuffer = errors.New("short buffer")
+
+// UDP fragmentation
+var (
+	ErrMTUTooSmall      = errors.New("MTU too small for the header")
+	ErrTooManyFragments = errors.New("too many fragments")
+)
+
 // Reply
 var (
 	ErrReplyGeneralFailure          = errors.New("general SOCKS server failure")
@@ -30,6 +42,8 @@ var (
 
 // Auth UserPW
 var (
-	ErrAuthVersion = errors.New("invalid version")
-	ErrAuthUnknown = errors.New("unknown authentication method")
+	ErrAuthVersion    = errors.New("invalid version")
+	ErrAuthUnknown    = errors.New("unknown authentication method")
+	ErrUsernameLength = errors.New("invalid username length")
+	ErrPasswordLength = errors.New("invalid password length")
 )
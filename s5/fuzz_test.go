@@ -0,0 +1,149 @@
+package s5
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// unpackCodec is implemented by every structure with both the io and the byte-slice API.
+type unpackCodec[T any] interface {
+	*T
+	codec
+	Unpack(r io.Reader) error
+}
+
+// fuzzUnpack checks that Unpack doesn't panic and agrees with Parse.
+func fuzzUnpack[T any, P unpackCodec[T]](f *testing.F, seeds ...[]byte) {
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var u, p T
+		errU := P(&u).Unpack(bytes.NewReader(data))
+		n, errP := P(&p).Parse(data)
+
+		if errP == ErrShortBuffer {
+			if errU != io.EOF && errU != io.ErrUnexpectedEOF {
+				t.Fatalf("Parse: short buffer, Unpack: %v", errU)
+			}
+			return
+		}
+		if errU != errP {
+			t.Fatalf("Unpack: %v, Parse: %v", errU, errP)
+		}
+		if errU != nil {
+			return
+		}
+		if n > len(data) {
+			t.Fatalf("Parse read %d bytes out of %d", n, len(data))
+		}
+		if a, b := P(&u).AppendBinary(nil), P(&p).AppendBinary(nil); !bytes.Equal(a, b) {
+			t.Fatalf("Unpack: %x, Parse: %x", a, b)
+		}
+	})
+}
+
+func FuzzHandshakeRequestUnpack(f *testing.F) {
+	fuzzUnpack[HandshakeRequest](f, []byte{0x05, 0x01, 0x00}, []byte{0x05, 0x00}, []byte{0x05, 0x02, 0x00})
+}
+
+func FuzzHandshakeReplyUnpack(f *testing.F) {
+	fuzzUnpack[HandshakeReply](f, []byte{0x05, 0x00}, []byte{0x04, 0x00})
+}
+
+func FuzzAuthUserPWUnpack(f *testing.F) {
+	fuzzUnpack[AuthUserPW](f, authBuf, []byte{0x01, 0x00, 0x00}, []byte{0x01, 0x05, 't'})
+}
+
+func FuzzAuthReplyUnpack(f *testing.F) {
+	fuzzUnpack[AuthReply](f, []byte{AuthUserPWVersion, 0x00})
+}
+
+func FuzzRequestUnpack(f *testing.F) {
+	fuzzUnpack[Request](f,
+		[]byte{0x05, 0x01, 0x00, 0x03, 0x0A, 'g', 'o', 'o', 'g', 'l', 'e', '.', 'c', 'o', 'm', 0x00, 0x50},
+		[]byte{0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, 0x00, 0x50},
+		[]byte{0x05, 0x01, 0x00, 0x04, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0x00, 0x50},
+		[]byte{0x05, 0x01, 0x00, 0x03, 0x00, 0x00, 0x50},
+	)
+}
+
+func FuzzReplyUnpack(f *testing.F) {
+	fuzzUnpack[Reply](f, testReplyIPv4Buf, []byte{0x05, 0x00, 0x00, 0x03, 0x00})
+}
+
+func FuzzDestDomainNameUnpack(f *testing.F) {
+	fuzzUnpack[RequestV5DestDomainName](f, []byte{0x0A, 'g', 'o', 'o', 'g', 'l', 'e', '.', 'c', 'o', 'm', 0x00, 0x50}, []byte{0x01, 0x00, 0x00, 0x50})
+}
+
+func FuzzDestIPv4Unpack(f *testing.F) {
+	fuzzUnpack[RequestV5DestIPv4](f, testIPv4Buf)
+}
+
+func FuzzDestIPv6Unpack(f *testing.F) {
+	fuzzUnpack[RequestV5DestIPv6](f, testIPv6Buf)
+}
+
+func FuzzReplyBindUnpack(f *testing.F) {
+	f.Add(testIPv4Buf, false)
+	f.Add(testIPv6Buf, true)
+	f.Fuzz(func(t *testing.T, data []byte, v6 bool) {
+		var u, p ReplyBind
+		errU := u.Unpack(bytes.NewReader(data), v6)
+		_, errP := p.Parse(data, v6)
+		if (errU == nil) != (errP == nil) {
+			t.Fatalf("Unpack: %v, Parse: %v", errU, errP)
+		}
+		if errU == nil && u != p {
+			t.Fatalf("Unpack: %v, Parse: %v", u, p)
+		}
+	})
+}
+
+func FuzzValidateHostname(f *testing.F) {
+	f.Add([]byte("google.com"))
+	f.Add([]byte("xn--bcher-kva.example"))
+	f.Fuzz(func(t *testing.T, host []byte) {
+		if ValidateHostname(host, Strict) == nil && ValidateHostname(host, Lenient) != nil {
+			t.Fatalf("%q is strictly valid but not leniently", host)
+		}
+	})
+}
+
+func FuzzUDPHeaderUnwrap(f *testing.F) {
+	f.Add([]byte{0x00, 0x00, 0x00, 0x01, 127, 0, 0, 1, 0x00, 0x35, 'p', 'i', 'n', 'g'})
+	f.Add([]byte{0x00, 0x00, 0x01, 0x03, 0x01, 'a', 0x00, 0x35})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var h UDPHeader
+		payload, err := h.Unwrap(data)
+		if err != nil {
+			return
+		}
+		// the header is re-encoded as is, except for the reserved field
+		if got := h.Wrap(nil, payload); !bytes.Equal(got[2:], data[2:]) {
+			t.Fatalf("Wrap: %x, datagram: %x", got, data)
+		}
+	})
+}
+
+func FuzzAddrParse(f *testing.F) {
+	f.Add([]byte{0x03, 0x0A, 'g', 'o', 'o', 'g', 'l', 'e', '.', 'c', 'o', 'm', 0x00, 0x50})
+	f.Add([]byte{0x01, 127, 0, 0, 1, 0x00, 0x50})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var a Addr
+		n, err := a.Parse(data)
+		if err != nil {
+			return
+		}
+		if got := a.AppendBinary(nil); !bytes.Equal(got, data[:n]) {
+			t.Fatalf("AppendBinary: %x, Parse: %x", got, data[:n])
+		}
+
+		// Unpack agrees with Parse
+		u := Addr{Type: data[0]}
+		if err := u.Unpack(bytes.NewReader(data[1:])); err != nil || u != a {
+			t.Fatalf("Unpack: %v %v, Parse: %v", u, err, a)
+		}
+	})
+}
@@ -19,17 +19,38 @@ func (h *HandshakeRequest) Pack(w io.Writer) (err error) {
 		return ErrMethodsLimit
 	}
 
-	buf := make([]byte, 2+nMethods)
-	buf[0] = VERSION
-	buf[1] = uint8(nMethods)
+	// Write the entire buffer to the provided io.Writer
+	_, err = w.Write(h.AppendBinary(make([]byte, 0, 2+nMethods)))
+	return
+}
 
-	if nMethods > 0 {
-		copy(buf[2:], *(*[]byte)(unsafe.Pointer(&h.Methods)))
+// AppendBinary appends the wire format to dst, the number of methods is not checked, see Validate.
+func (h *HandshakeRequest) AppendBinary(dst []byte) []byte {
+	dst = append(dst, VERSION, uint8(len(h.Methods)))
+	for _, m := range h.Methods {
+		dst = append(dst, byte(m))
 	}
+	return dst
+}
 
-	// Write the entire buffer to the provided io.Writer
-	_, err = w.Write(buf)
-	return
+// Parse reads the structure from b and returns the number of bytes read.
+// If b is incomplete, ErrShortBuffer is returned and n is the minimum length of b.
+// Methods reuses its capacity so that parsing into the same structure doesn't allocate.
+func (h *HandshakeRequest) Parse(b []byte) (n int, err error) {
+	if len(b) < 2 {
+		return 2, ErrShortBuffer
+	}
+	if h.Version, h.NMethods = b[0], b[1]; h.Version != VERSION {
+		return 0, ErrUnsupportedVersion
+	}
+	if n = 2 + int(h.NMethods); len(b) < n {
+		return n, ErrShortBuffer
+	}
+	h.Methods = h.Methods[:0]
+	for _, m := range b[2:n] {
+		h.Methods = append(h.Methods, AuthMethod(m))
+	}
+	return n, nil
 }
 
 func (h *HandshakeRequest) Unpack(r io.Reader) (err error) {
@@ -68,6 +89,23 @@ func (t *HandshakeReply) Pack(w io.Writer) (err error) {
 	return
 }
 
+// AppendBinary appends the wire format to dst.
+func (t *HandshakeReply) AppendBinary(dst []byte) []byte {
+	return append(dst, t.Version, byte(t.Method))
+}
+
+// Parse reads the structure from b and returns the number of bytes read.
+// If b is incomplete, ErrShortBuffer is returned and n is the minimum length of b.
+func (t *HandshakeReply) Parse(b []byte) (n int, err error) {
+	if len(b) < 2 {
+		return 2, ErrShortBuffer
+	}
+	if t.Version, t.Method = b[0], AuthMethod(b[1]); t.Version != VERSION {
+		return 0, ErrUnsupportedVersion
+	}
+	return 2, nil
+}
+
 func (t *HandshakeReply) Unpack(r io.Reader) (err error) {
 	// Read the first two bytes from the reader
 	if _, err = io.ReadFull(r, (*[2]byte)(unsafe.Pointer(t))[:]); err != nil {
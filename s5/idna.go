@@ -0,0 +1,230 @@
+package s5
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ACEPrefix marks a label encoded with punycode.
+const ACEPrefix = "xn--"
+
+// punycode parameters, RFC 3492 section 5.
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+	punyMaxInt      = 1<<31 - 1
+)
+
+// ToASCII converts the non-ASCII labels of an internationalized hostname into punycode,
+// labels are lowercased but not normalized. ASCII hostnames are returned as-is.
+func ToASCII(host string) (string, error) {
+	if isASCII(host) {
+		return host, nil
+	}
+	labels := strings.Split(host, ".")
+	for i, label := range labels {
+		if isASCII(label) {
+			continue
+		}
+		if !utf8.ValidString(label) {
+			return "", ErrInvalidHostname
+		}
+		encoded, err := punyEncode(strings.ToLower(label))
+		if err != nil {
+			return "", err
+		}
+		labels[i] = ACEPrefix + encoded
+	}
+	return strings.Join(labels, "."), nil
+}
+
+// ToUnicode converts the punycode labels of a hostname back into Unicode.
+func ToUnicode(host string) (string, error) {
+	labels := strings.Split(host, ".")
+	for i, label := range labels {
+		if !hasACEPrefix(label) {
+			continue
+		}
+		decoded, err := punyDecode(label[len(ACEPrefix):])
+		if err != nil {
+			return "", err
+		}
+		labels[i] = decoded
+	}
+	return strings.Join(labels, "."), nil
+}
+
+func hasACEPrefix(label string) bool {
+	return len(label) >= len(ACEPrefix) && strings.EqualFold(label[:len(ACEPrefix)], ACEPrefix)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+func punyAdapt(delta, numPoints int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / numPoints
+	k := 0
+	for delta > ((punyBase-punyTMin)*punyTMax)/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
+
+func punyThreshold(k, bias int) int {
+	switch t := k - bias; {
+	case t < punyTMin:
+		return punyTMin
+	case t > punyTMax:
+		return punyTMax
+	default:
+		return t
+	}
+}
+
+func punyEncodeDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+func punyDecodeDigit(c byte) (int, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c-'0') + 26, true
+	case c >= 'a' && c <= 'z':
+		return int(c - 'a'), true
+	case c >= 'A' && c <= 'Z':
+		return int(c - 'A'), true
+	}
+	return 0, false
+}
+
+// punyEncode encodes a label, RFC 3492 section 6.3.
+func punyEncode(s string) (string, error) {
+	runes := []rune(s)
+	out := make([]byte, 0, len(s)+8)
+	for _, r := range runes {
+		if r < utf8.RuneSelf {
+			out = append(out, byte(r))
+		}
+	}
+	basic := len(out)
+	handled := basic
+	if basic > 0 {
+		out = append(out, '-')
+	}
+
+	n, delta, bias := punyInitialN, 0, punyInitialBias
+	for handled < len(runes) {
+		m := punyMaxInt
+		for _, r := range runes {
+			if int(r) >= n && int(r) < m {
+				m = int(r)
+			}
+		}
+		if (m - n) > (punyMaxInt-delta)/(handled+1) {
+			return "", ErrInvalidPunycode
+		}
+		delta += (m - n) * (handled + 1)
+		n = m
+
+		for _, r := range runes {
+			if int(r) < n {
+				if delta++; delta == punyMaxInt {
+					return "", ErrInvalidPunycode
+				}
+			}
+			if int(r) != n {
+				continue
+			}
+			q := delta
+			for k := punyBase; ; k += punyBase {
+				t := punyThreshold(k, bias)
+				if q < t {
+					break
+				}
+				out = append(out, punyEncodeDigit(t+(q-t)%(punyBase-t)))
+				q = (q - t) / (punyBase - t)
+			}
+			out = append(out, punyEncodeDigit(q))
+			bias = punyAdapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+		delta++
+		n++
+	}
+	return string(out), nil
+}
+
+// punyDecode decodes a label, RFC 3492 section 6.2.
+func punyDecode(s string) (string, error) {
+	var output []rune
+	if pos := strings.LastIndexByte(s, '-'); pos >= 0 {
+		for i := 0; i < pos; i++ {
+			if s[i] >= utf8.RuneSelf {
+				return "", ErrInvalidPunycode
+			}
+			output = append(output, rune(s[i]))
+		}
+		s = s[pos+1:]
+	}
+
+	n, i, bias := punyInitialN, 0, punyInitialBias
+	for len(s) > 0 {
+		oldi, w := i, 1
+		for k := punyBase; ; k += punyBase {
+			if len(s) == 0 {
+				return "", ErrInvalidPunycode
+			}
+			digit, ok := punyDecodeDigit(s[0])
+			if !ok || digit > (punyMaxInt-i)/w {
+				return "", ErrInvalidPunycode
+			}
+			s = s[1:]
+			i += digit * w
+			t := punyThreshold(k, bias)
+			if digit < t {
+				break
+			}
+			if w > punyMaxInt/(punyBase-t) {
+				return "", ErrInvalidPunycode
+			}
+			w *= punyBase - t
+		}
+		length := len(output) + 1
+		bias = punyAdapt(i-oldi, length, oldi == 0)
+		if i/length > punyMaxInt-n {
+			return "", ErrInvalidPunycode
+		}
+		n += i / length
+		i %= length
+		if n > unicode.MaxRune || (n >= 0xD800 && n <= 0xDFFF) {
+			return "", ErrInvalidPunycode
+		}
+		output = append(output, 0)
+		copy(output[i+1:], output[i:])
+		output[i] = rune(n)
+		i++
+	}
+	return string(output), nil
+}
@@ -0,0 +1,49 @@
+package s5
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var punycodeTests = []struct{ unicode, ascii string }{
+	{"bücher", "bcher-kva"},
+	{"münchen", "mnchen-3ya"},
+	{"他们为什么不说中文", "ihqwcrb4cv8a8dqg056pqjye"},
+	{"ليهمابتكلموشعربي؟", "egbpdaj6bu4bxfgehfvwxn"},
+	{"3年b組金八先生", "3b-ww4c5e180e575a65lsy2b"},
+}
+
+func TestPunycode(t *testing.T) {
+	for _, tt := range punycodeTests {
+		encoded, err := punyEncode(tt.unicode)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.ascii, encoded)
+
+		decoded, err := punyDecode(tt.ascii)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.unicode, decoded)
+	}
+
+	_, err := punyDecode("bcher-kv!")
+	assert.Equal(t, ErrInvalidPunycode, err)
+	_, err = punyDecode("99999999999")
+	assert.Equal(t, ErrInvalidPunycode, err)
+}
+
+func TestToASCII(t *testing.T) {
+	host, err := ToASCII("www.Bücher.example")
+	assert.NoError(t, err)
+	assert.Equal(t, "www.xn--bcher-kva.example", host)
+
+	host, err = ToASCII("example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, "example.com", host)
+
+	_, err = ToASCII("b\xffcher.example")
+	assert.Equal(t, ErrInvalidHostname, err)
+
+	host, err = ToUnicode("www.xn--bcher-kva.example")
+	assert.NoError(t, err)
+	assert.Equal(t, "www.bücher.example", host)
+}
@@ -11,22 +11,44 @@ type Reply struct {
 	Status      ReplyStatus
 	Reserved    uint8 // unrequired, only used by Unpack
 	AddressType uint8
-	Bind        ReplyBind
+	Bind        Addr // BND.ADDR and BND.PORT, an IP address or a domain name, the zero Addr as 0.0.0.0:0
 }
 
 // Pack writes the structure to the given writer as bytes.
 func (t *Reply) Pack(w io.Writer) (err error) {
-	var size uint8 = 4 // Version, Status, Reserved, AddressType
-	var buf = make([]byte, size+t.Bind.Size())
-	buf[0] = VERSION
-	buf[1] = byte(t.Status)
-	buf[3] = t.Bind.Kind()
-	t.Bind.Pack(buf[4:])
-
-	_, err = w.Write(buf)
+	if t.Bind.Kind() == AddressTypeDomainName && (len(t.Bind.Host) == 0 || len(t.Bind.Host) > 0xFF) {
+		return ErrInvalidHostnameLength
+	}
+	var size = 4 // Version, Status, Reserved, AddressType
+	_, err = w.Write(t.AppendBinary(make([]byte, 0, size+t.Bind.Size())))
 	return
 }
 
+// AppendBinary appends the wire format to dst.
+func (t *Reply) AppendBinary(dst []byte) []byte {
+	dst = append(dst, VERSION, byte(t.Status), 0x00)
+	return t.Bind.AppendBinary(dst)
+}
+
+// Parse reads the structure from b and returns the number of bytes read.
+// If b is incomplete, ErrShortBuffer is returned and n is the minimum length of b.
+func (t *Reply) Parse(b []byte) (n int, err error) {
+	if len(b) < 4 {
+		return 4, ErrShortBuffer
+	}
+	t.Version, t.Status, t.Reserved, t.AddressType = b[0], ReplyStatus(b[1]), b[2], b[3]
+	if t.Version != VERSION {
+		return 0, ErrUnsupportedVersion
+	}
+	if n, err = t.Bind.Parse(b[3:]); err != nil {
+		if err == ErrShortBuffer {
+			n += 3
+		}
+		return
+	}
+	return 3 + n, nil
+}
+
 // Unpack reads from the given reader into the structure.
 func (t *Reply) Unpack(r io.Reader) (err error) {
 	// casting the t pointer to an array of 4 bytes
@@ -37,10 +59,6 @@ func (t *Reply) Unpack(r io.Reader) (err error) {
 	if t.Version != VERSION {
 		return ErrUnsupportedVersion
 	}
-	switch t.AddressType {
-	case AddressTypeIPv4, AddressTypeIPv6:
-	default:
-		return ErrUnsupportedAddressType
-	}
-	return t.Bind.Unpack(r, t.AddressType == AddressTypeIPv6)
+	t.Bind.Type = t.AddressType
+	return t.Bind.Unpack(r)
 }
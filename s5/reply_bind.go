@@ -4,33 +4,18 @@ import (
 	"encoding/binary"
 	"io"
 	"net/netip"
-	"unsafe"
 )
 
 // Bind structure for IPv4 or IPv6
+//
+// Deprecated: Reply.Bind is an Addr, which can also hold the domain names RFC 1928 allows as BND.ADDR.
 type ReplyBind struct {
 	Address netip.Addr
 	Port    uint16
 }
 
 func (b *ReplyBind) Pack(buf []byte) {
-	size := b.Size()
-
-	// reinterpret address structure as [2]uint64, // 0 is high, 1 is low
-	v := (*[2]uint64)(unsafe.Pointer(&b.Address))
-
-	if size == 18 {
-		// convert IPv6 long to [16]byte and add it to buf
-		binary.BigEndian.PutUint64(buf, v[0])
-		binary.BigEndian.PutUint64(buf[8:], v[1])
-	} else {
-		// convert IPv4 long to [4]byte and add it to buf
-		binary.BigEndian.PutUint32(buf, uint32(v[1]))
-	}
-
-	// convert uint16 port to [2]byte
-	buf[size-2] = byte(b.Port >> 8)
-	buf[size-1] = byte(b.Port)
+	b.AppendBinary(buf[:0])
 }
 
 func (b *ReplyBind) Unpack(r io.Reader, v6 bool) (err error) {
@@ -51,6 +36,39 @@ func (b *ReplyBind) Unpack(r io.Reader, v6 bool) (err error) {
 	return
 }
 
+// AppendBinary appends the wire format to dst, the zero Address is written as 0.0.0.0.
+func (b *ReplyBind) AppendBinary(dst []byte) []byte {
+	switch {
+	case b.Address.Is6():
+		a := b.Address.As16()
+		dst = append(dst, a[:]...)
+	case b.Address.Is4():
+		a := b.Address.As4()
+		dst = append(dst, a[:]...)
+	default:
+		dst = append(dst, 0, 0, 0, 0)
+	}
+	return append(dst, byte(b.Port>>8), byte(b.Port))
+}
+
+// Parse reads the structure from b and returns the number of bytes read.
+// If b is incomplete, ErrShortBuffer is returned and n is the minimum length of b.
+func (b *ReplyBind) Parse(buf []byte, v6 bool) (n int, err error) {
+	if n = DestIPv4Size; v6 {
+		n = DestIPv6Size
+	}
+	if len(buf) < n {
+		return n, ErrShortBuffer
+	}
+	if v6 {
+		b.Address = netip.AddrFrom16([16]byte(buf[:16]))
+	} else {
+		b.Address = netip.AddrFrom4([4]byte(buf[:4]))
+	}
+	b.Port = binary.BigEndian.Uint16(buf[n-2:])
+	return n, nil
+}
+
 // Kind ...
 func (b *ReplyBind) Kind() uint8 {
 	if b.Address.Is6() {
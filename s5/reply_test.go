@@ -2,6 +2,7 @@ package s5
 
 import (
 	"bytes"
+	"io"
 	"net/netip"
 	"testing"
 
@@ -26,21 +27,29 @@ func TestReplyUnpack(t *testing.T) {
 		Version:     VERSION,
 		Status:      ReplySuccess,
 		AddressType: AddressTypeIPv4,
-		Bind: ReplyBind{
-			Address: testReplyIPv4,
-			Port:    1884,
-		},
+		Bind:        AddrFromAddrPort(netip.AddrPortFrom(testReplyIPv4, 1884)),
 	}, r)
 }
 
+func TestReplyUnpackDomainName(t *testing.T) {
+	var r Reply
+	err := r.Unpack(bytes.NewReader([]byte{VERSION, byte(ReplySuccess), 0x00, AddressTypeDomainName, 4, 'h', 'o', 's', 't', 0x7, 0x5c}))
+	assert.NoError(t, err)
+	assert.Equal(t, Addr{Type: AddressTypeDomainName, Host: "host", Port: 1884}, r.Bind)
+	assert.Equal(t, "host:1884", r.Addr().String())
+
+	err = (&Reply{}).Unpack(bytes.NewReader([]byte{VERSION, byte(ReplySuccess), 0x00, 0x02, 0, 0}))
+	assert.Equal(t, ErrUnsupportedAddressType, err)
+
+	err = (&Reply{Bind: Addr{Type: AddressTypeDomainName}}).Pack(io.Discard)
+	assert.Equal(t, ErrInvalidHostnameLength, err)
+}
+
 func TestReplyPack(t *testing.T) {
 	var buf = bytes.NewBuffer(nil)
 	if err := (&Reply{
 		Status: ReplySuccess,
-		Bind: ReplyBind{
-			Address: testReplyIPv4,
-			Port:    1884,
-		},
+		Bind:   AddrFromAddrPort(netip.AddrPortFrom(testReplyIPv4, 1884)),
 	}).Pack(buf); err != nil {
 		t.Fatal(err)
 	}
@@ -53,10 +62,7 @@ func BenchmarkReplyPack(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		_ = (&Reply{
 			Status: ReplySuccess,
-			Bind: ReplyBind{
-				Address: testReplyIPv4,
-				Port:    1884,
-			},
+			Bind:   AddrFromAddrPort(netip.AddrPortFrom(testReplyIPv4, 1884)),
 		}).Pack(buf)
 		buf.Reset()
 	}
@@ -15,6 +15,9 @@ type Request struct {
 }
 
 func (v *Request) Pack(w io.Writer) (err error) {
+	if v.Destination == nil {
+		return ErrUnsupportedAddressType
+	}
 	buf := make([]byte, 4+v.Destination.Size())
 	buf[0] = VERSION
 	buf[1] = v.Command
@@ -26,6 +29,32 @@ func (v *Request) Pack(w io.Writer) (err error) {
 	return
 }
 
+// AppendBinary appends the wire format to dst.
+func (v *Request) AppendBinary(dst []byte) []byte {
+	dst = append(dst, VERSION, v.Command, 0x00, v.Destination.Kind())
+	return appendDestination(dst, v.Destination)
+}
+
+// Parse reads the structure from b and returns the number of bytes read.
+// If b is incomplete, ErrShortBuffer is returned and n is the minimum length of b.
+// Destination is reused when it matches the address type, a domain name aliases b.
+func (v *Request) Parse(b []byte) (n int, err error) {
+	if len(b) < 4 {
+		return 4, ErrShortBuffer
+	}
+	v.Version, v.Command, v.Reserved, v.AddressType = b[0], b[1], b[2], b[3]
+	if v.Version != VERSION {
+		return 0, ErrUnsupportedVersion
+	}
+	if v.Destination, n, err = parseDestination(v.Destination, v.AddressType, b[4:]); err != nil {
+		if err == ErrShortBuffer {
+			n += 4
+		}
+		return
+	}
+	return 4 + n, nil
+}
+
 func (v *Request) Unpack(r io.Reader) (err error) {
 	if _, err = io.ReadFull(r, (*[4]byte)(unsafe.Pointer(v))[:]); err != nil {
 		return
@@ -33,6 +62,10 @@ func (v *Request) Unpack(r io.Reader) (err error) {
 	if v.Version != VERSION {
 		return ErrUnsupportedVersion
 	}
+	if a, ok := v.Destination.(*Addr); ok {
+		a.Type = v.AddressType
+		return a.Unpack(r)
+	}
 	switch v.AddressType {
 	case AddressTypeDomainName:
 		v.Destination = new(RequestV5DestDomainName)
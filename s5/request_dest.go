@@ -1,8 +1,8 @@
 package s5
 
 import (
+	"encoding/binary"
 	"io"
-	"unsafe"
 )
 
 const (
@@ -15,7 +15,7 @@ type Destination interface {
 	Put([]byte) error
 
 	// Size returns binary length of the struct
-	Size() uint8
+	Size() int
 
 	// Unpack ...
 	Unpack(r io.Reader) error
@@ -34,17 +34,21 @@ type RequestV5DestDomainName struct {
 func (h *RequestV5DestDomainName) Kind() uint8 { return AddressTypeDomainName }
 
 // Size returns binary length of struct
-func (h *RequestV5DestDomainName) Size() uint8 { return 1 + uint8(len(h.Address)) + 2 }
+func (h *RequestV5DestDomainName) Size() int { return 1 + len(h.Address) + 2 }
 
 func (h *RequestV5DestDomainName) Put(buf []byte) error {
 	size := h.Size()
 
-	if len(buf) < int(size) {
+	if len(h.Address) == 0 || len(h.Address) > 0xFF {
+		return ErrInvalidHostnameLength
+	}
+
+	if len(buf) < size {
 		return ErrInvalidBufferSize
 	}
 
-	buf[0] = size - 3        // address length
-	copy(buf[1:], h.Address) // address
+	buf[0] = uint8(len(h.Address)) // address length
+	copy(buf[1:], h.Address)       // address
 
 	// port
 	buf[size-2] = byte(h.Port >> 8)
@@ -55,18 +59,45 @@ func (h *RequestV5DestDomainName) Put(buf []byte) error {
 
 // ReadFrom reads from the given reader into the structure.
 func (h *RequestV5DestDomainName) Unpack(r io.Reader) (err error) {
-	var length uint8
-	if _, err = io.ReadFull(r, (*[1]byte)(unsafe.Pointer(&length))[:]); err != nil {
+	var b [1]byte
+	if _, err = io.ReadFull(r, b[:]); err != nil {
 		return
 	}
+	length := int(b[0]) // an int, length+2 overflows uint8
 	buf := make([]byte, length+2)
 	// read domain and port
 	if _, err = io.ReadFull(r, buf); err != nil {
 		return
 	}
-	h.Address = buf[:length]
-	h.Port = uint16(buf[length+1]) | uint16(buf[length])<<8
-	return
+	h.Address = buf[:length:length]
+	h.Port = binary.BigEndian.Uint16(buf[length:])
+	return ValidateHostname(h.Address, Lenient)
+}
+
+// AppendBinary appends the wire format to dst, the length is not checked, see Validate.
+func (h *RequestV5DestDomainName) AppendBinary(dst []byte) []byte {
+	dst = append(dst, uint8(len(h.Address)))
+	dst = append(dst, h.Address...)
+	return append(dst, byte(h.Port>>8), byte(h.Port))
+}
+
+// Parse reads the structure from b and returns the number of bytes read.
+// If b is incomplete, ErrShortBuffer is returned and n is the minimum length of b.
+// Address aliases b.
+func (h *RequestV5DestDomainName) Parse(b []byte) (n int, err error) {
+	if len(b) < 1 {
+		return 1, ErrShortBuffer
+	}
+	length := int(b[0])
+	if n = 1 + length + 2; len(b) < n {
+		return n, ErrShortBuffer
+	}
+	h.Address = b[1 : 1+length : 1+length]
+	h.Port = binary.BigEndian.Uint16(b[1+length:])
+	if err = ValidateHostname(h.Address, Lenient); err != nil {
+		return 0, err
+	}
+	return n, nil
 }
 
 ///
@@ -81,7 +112,7 @@ type RequestV5DestIPv4 struct {
 func (h *RequestV5DestIPv4) Kind() uint8 { return AddressTypeIPv4 }
 
 // Size returns binary length of struct
-func (h *RequestV5DestIPv4) Size() uint8 { return DestIPv4Size }
+func (h *RequestV5DestIPv4) Size() int { return DestIPv4Size }
 
 // Put ..
 func (d *RequestV5DestIPv4) Put(buf []byte) error {
@@ -98,12 +129,31 @@ func (d *RequestV5DestIPv4) Put(buf []byte) error {
 }
 
 func (d *RequestV5DestIPv4) Unpack(r io.Reader) (err error) {
-	_, err = io.ReadFull(r, (*[6]byte)(unsafe.Pointer(d))[:])
-	// reorder endianess into little-endian
-	d.Port = (d.Port>>8)&0xFF | (d.Port<<8)&0xFF00
+	var buf [DestIPv4Size]byte
+	if _, err = io.ReadFull(r, buf[:]); err != nil {
+		return
+	}
+	_, err = d.Parse(buf[:])
 	return
 }
 
+// AppendBinary appends the wire format to dst.
+func (d *RequestV5DestIPv4) AppendBinary(dst []byte) []byte {
+	dst = append(dst, d.Address[:]...)
+	return append(dst, byte(d.Port>>8), byte(d.Port))
+}
+
+// Parse reads the structure from b and returns the number of bytes read.
+// If b is incomplete, ErrShortBuffer is returned and n is the minimum length of b.
+func (d *RequestV5DestIPv4) Parse(b []byte) (n int, err error) {
+	if len(b) < DestIPv4Size {
+		return DestIPv4Size, ErrShortBuffer
+	}
+	copy(d.Address[:], b)
+	d.Port = binary.BigEndian.Uint16(b[4:])
+	return DestIPv4Size, nil
+}
+
 // RequestV5DestinationIPv6 is a IPv6 destination.
 type RequestV5DestIPv6 struct {
 	Address [16]byte
@@ -114,7 +164,7 @@ type RequestV5DestIPv6 struct {
 func (h *RequestV5DestIPv6) Kind() uint8 { return AddressTypeIPv6 }
 
 // Size returns binary length of struct
-func (h *RequestV5DestIPv6) Size() uint8 { return DestIPv6Size }
+func (h *RequestV5DestIPv6) Size() int { return DestIPv6Size }
 
 func (h *RequestV5DestIPv6) Put(buf []byte) error {
 	if len(buf) < DestIPv6Size {
@@ -131,8 +181,79 @@ func (h *RequestV5DestIPv6) Put(buf []byte) error {
 }
 
 func (h *RequestV5DestIPv6) Unpack(r io.Reader) (err error) {
-	_, err = io.ReadFull(r, (*[18]byte)(unsafe.Pointer(h))[:])
-	// reorder endianess into little-endian
-	h.Port = (h.Port>>8)&0xFF | (h.Port<<8)&0xFF00
+	var buf [DestIPv6Size]byte
+	if _, err = io.ReadFull(r, buf[:]); err != nil {
+		return
+	}
+	_, err = h.Parse(buf[:])
 	return
 }
+
+// AppendBinary appends the wire format to dst.
+func (h *RequestV5DestIPv6) AppendBinary(dst []byte) []byte {
+	dst = append(dst, h.Address[:]...)
+	return append(dst, byte(h.Port>>8), byte(h.Port))
+}
+
+// Parse reads the structure from b and returns the number of bytes read.
+// If b is incomplete, ErrShortBuffer is returned and n is the minimum length of b.
+func (h *RequestV5DestIPv6) Parse(b []byte) (n int, err error) {
+	if len(b) < DestIPv6Size {
+		return DestIPv6Size, ErrShortBuffer
+	}
+	copy(h.Address[:], b)
+	h.Port = binary.BigEndian.Uint16(b[16:])
+	return DestIPv6Size, nil
+}
+
+// appendDestination appends the wire format of any Destination to dst.
+func appendDestination(dst []byte, d Destination) []byte {
+	switch d := d.(type) {
+	case *RequestV5DestDomainName:
+		return d.AppendBinary(dst)
+	case *RequestV5DestIPv4:
+		return d.AppendBinary(dst)
+	case *RequestV5DestIPv6:
+		return d.AppendBinary(dst)
+	case *Addr:
+		return d.appendAddress(dst)
+	}
+	n := len(dst)
+	dst = append(dst, make([]byte, d.Size())...)
+	_ = d.Put(dst[n:])
+	return dst
+}
+
+// parseDestination parses the destination of the given address type from b, reusing d if it has the matching type
+// or is an *Addr.
+func parseDestination(d Destination, addressType uint8, b []byte) (_ Destination, n int, err error) {
+	if a, ok := d.(*Addr); ok {
+		a.Type = addressType
+		n, err = a.parseAddress(b)
+		return a, n, err
+	}
+	switch addressType {
+	case AddressTypeDomainName:
+		dest, ok := d.(*RequestV5DestDomainName)
+		if !ok {
+			dest = new(RequestV5DestDomainName)
+		}
+		n, err = dest.Parse(b)
+		return dest, n, err
+	case AddressTypeIPv4:
+		dest, ok := d.(*RequestV5DestIPv4)
+		if !ok {
+			dest = new(RequestV5DestIPv4)
+		}
+		n, err = dest.Parse(b)
+		return dest, n, err
+	case AddressTypeIPv6:
+		dest, ok := d.(*RequestV5DestIPv6)
+		if !ok {
+			dest = new(RequestV5DestIPv6)
+		}
+		n, err = dest.Parse(b)
+		return dest, n, err
+	}
+	return d, 0, ErrUnsupportedAddressType
+}
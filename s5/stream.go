@@ -0,0 +1,160 @@
+package s5
+
+// Parser is implemented by every structure with the byte-slice API.
+type Parser interface {
+	Parse(b []byte) (n int, err error)
+}
+
+// Stream incrementally parses structures out of bytes written as they arrive,
+// for event-loop based servers and clients which can not block in io.ReadFull.
+//
+// Byte slices of the parsed structures alias the Stream and stay valid until the next Write.
+type Stream struct {
+	buf  []byte
+	off  int // start of the pending input in buf
+	need int // minimum length of the pending input before parsing is attempted again
+}
+
+// Write appends b to the pending input, it never fails.
+func (s *Stream) Write(b []byte) (int, error) {
+	if s.off > 0 && len(s.buf)+len(b) > cap(s.buf) {
+		// move the pending input to the front instead of growing
+		s.buf = s.buf[:copy(s.buf, s.buf[s.off:])]
+		s.off = 0
+	}
+	s.buf = append(s.buf, b...)
+	return len(b), nil
+}
+
+// Next parses the pending input into v. If the input is incomplete, ErrShortBuffer is returned
+// with the minimum number of bytes still missing, and nothing is consumed. Parsing is not attempted
+// again before that many bytes were written.
+func (s *Stream) Next(v Parser) (need int, err error) {
+	pending := s.buf[s.off:]
+	if len(pending) < s.need {
+		return s.need - len(pending), ErrShortBuffer
+	}
+
+	n, err := v.Parse(pending)
+	if err == ErrShortBuffer {
+		s.need = n
+		return n - len(pending), err
+	}
+
+	s.need = 0
+	if err != nil {
+		return 0, err
+	}
+	if s.off += n; s.off == len(s.buf) {
+		s.buf, s.off = s.buf[:0], 0
+	}
+	return 0, nil
+}
+
+// Buffered returns the pending input, for example the payload following a Request.
+func (s *Stream) Buffered() []byte {
+	return s.buf[s.off:]
+}
+
+// Reset discards the pending input.
+func (s *Stream) Reset() {
+	s.buf, s.off, s.need = s.buf[:0], 0, 0
+}
+
+// State of a Machine.
+type State uint8
+
+const (
+	StateHandshake State = iota // expecting HandshakeRequest or HandshakeReply
+	StateAuth                   // expecting AuthUserPW or AuthReply, depending on Method
+	StateRequest                // expecting Request or Reply
+	StateDone                   // negotiation is complete, Buffered holds the payload
+)
+
+// Machine drives the SOCKS5 negotiation of a connection over bytes written as they arrive.
+//
+// As a server it parses HandshakeRequest, AuthUserPW and Request, the server sets Method after
+// answering the handshake. As a client it parses HandshakeReply, AuthReply and Reply, and Method
+// is taken from the HandshakeReply. The statuses of AuthReply and Reply are left to the caller.
+//
+// Unlike with Stream, byte slices of the parsed structures are owned by the Machine, their
+// storage is reused so that a Machine doesn't allocate once warmed up.
+type Machine struct {
+	Stream
+
+	Client bool       // role of the machine
+	State  State      // current state
+	Method AuthMethod // selected authentication method
+
+	HandshakeRequest HandshakeRequest
+	HandshakeReply   HandshakeReply
+	AuthUserPW       AuthUserPW
+	AuthReply        AuthReply
+	Request          Request
+	Reply            Reply
+
+	username, password, domain []byte
+}
+
+// Next parses as much of the pending input as possible, advancing the state. ErrShortBuffer is
+// returned with the minimum number of bytes missing for the current state. It returns nil once
+// StateDone is reached, or when a server has to answer: after the HandshakeRequest, where it also
+// sets Method, and after AuthUserPW.
+func (m *Machine) Next() (need int, err error) {
+	for {
+		switch m.State {
+		case StateHandshake:
+			if m.Client {
+				if need, err = m.Stream.Next(&m.HandshakeReply); err != nil {
+					return
+				}
+				m.Method = m.HandshakeReply.Method
+			} else if need, err = m.Stream.Next(&m.HandshakeRequest); err != nil {
+				return
+			}
+			m.State = StateAuth
+			if !m.Client {
+				return 0, nil
+			}
+		case StateAuth:
+			switch m.Method {
+			case MethodAuthNone:
+			case MethodAuthUserPW:
+				if m.Client {
+					if need, err = m.Stream.Next(&m.AuthReply); err != nil {
+						return
+					}
+					break
+				}
+				if need, err = m.Stream.Next(&m.AuthUserPW); err != nil {
+					return
+				}
+				m.username = append(m.username[:0], m.AuthUserPW.Username...)
+				m.password = append(m.password[:0], m.AuthUserPW.Password...)
+				m.AuthUserPW.Username, m.AuthUserPW.Password = m.username, m.password
+				m.State = StateRequest
+				return 0, nil
+			case MethodAuthNoneAcceptable:
+				return 0, ErrAuthNoneAcceptable
+			default:
+				return 0, ErrAuthUnknown
+			}
+			m.State = StateRequest
+		case StateRequest:
+			if m.Client {
+				need, err = m.Stream.Next(&m.Reply)
+			} else if need, err = m.Stream.Next(&m.Request); err == nil {
+				if d, ok := m.Request.Destination.(*RequestV5DestDomainName); ok {
+					m.domain = append(m.domain[:0], d.Address...)
+					d.Address = m.domain
+				}
+			}
+			if err != nil {
+				return
+			}
+			m.State = StateDone
+		case StateDone:
+			return 0, nil
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package s5
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamNext(t *testing.T) {
+	data := []byte{0x05, 0x01, 0x00, 0x03, 0x0A, 'g', 'o', 'o', 'g', 'l', 'e', '.', 'c', 'o', 'm', 0x00, 0x50}
+
+	var (
+		s   Stream
+		req Request
+	)
+
+	need, err := s.Next(&req)
+	assert.Equal(t, ErrShortBuffer, err)
+	assert.Equal(t, 4, need)
+
+	_, _ = s.Write(data[:4])
+	need, err = s.Next(&req)
+	assert.Equal(t, ErrShortBuffer, err)
+	assert.Equal(t, 1, need)
+
+	_, _ = s.Write(data[4:6])
+	need, err = s.Next(&req)
+	assert.Equal(t, ErrShortBuffer, err)
+	assert.Equal(t, len(data)-6, need)
+
+	// parsing is not attempted again until enough bytes are written
+	_, _ = s.Write(data[6:10])
+	need, err = s.Next(&req)
+	assert.Equal(t, ErrShortBuffer, err)
+	assert.Equal(t, len(data)-10, need)
+
+	_, _ = s.Write(append(data[10:], 'x'))
+	if _, err = s.Next(&req); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, &RequestV5DestDomainName{Address: []byte("google.com"), Port: 80}, req.Destination)
+	assert.Equal(t, []byte{'x'}, s.Buffered())
+}
+
+func TestMachineServer(t *testing.T) {
+	data := []byte{0x05, 0x02, 0x00, 0x02}
+	data = append(data, authBuf...)
+	data = append(data, 0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, 0x00, 0x50, 'p', 'a', 'y')
+
+	var m Machine
+
+	// feed byte by byte
+	for i := 0; i < len(data); i++ {
+		_, _ = m.Write(data[i : i+1])
+		need, err := m.Next()
+		if err == ErrShortBuffer {
+			assert.Greater(t, need, 0)
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch m.State {
+		case StateAuth:
+			assert.Equal(t, []AuthMethod{MethodAuthNone, MethodAuthUserPW}, m.HandshakeRequest.Methods)
+			m.Method = MethodAuthUserPW
+		case StateRequest:
+			assert.Equal(t, []byte("test"), m.AuthUserPW.Username)
+		}
+	}
+
+	assert.Equal(t, StateDone, m.State)
+	assert.Equal(t, []byte("test"), m.AuthUserPW.Username)
+	assert.Equal(t, []byte("test"), m.AuthUserPW.Password)
+	assert.Equal(t, &RequestV5DestIPv4{Address: [4]byte{127, 0, 0, 1}, Port: 80}, m.Request.Destination)
+	assert.Equal(t, []byte("pay"), m.Buffered())
+}
+
+func TestMachineClient(t *testing.T) {
+	m := Machine{Client: true}
+
+	_, _ = m.Write([]byte{0x05, 0x00})
+	_, _ = m.Write(testReplyIPv4Buf[:5])
+	need, err := m.Next()
+	assert.Equal(t, ErrShortBuffer, err)
+	assert.Equal(t, len(testReplyIPv4Buf)-5, need)
+	assert.Equal(t, StateRequest, m.State)
+
+	_, _ = m.Write(testReplyIPv4Buf[5:])
+	if _, err = m.Next(); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, StateDone, m.State)
+	assert.Equal(t, ReplySuccess, m.Reply.Status)
+
+	m = Machine{Client: true}
+	_, _ = m.Write([]byte{0x05, 0xFF})
+	_, err = m.Next()
+	assert.Equal(t, ErrAuthNoneAcceptable, err)
+}
+
+func BenchmarkStreamNext(b *testing.B) {
+	data := []byte{0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, 0x00, 0x50}
+	var (
+		s   Stream
+		req Request
+	)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = s.Write(data[:5])
+		_, _ = s.Next(&req)
+		_, _ = s.Write(data[5:])
+		_, _ = s.Next(&req)
+	}
+}
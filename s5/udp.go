@@ -0,0 +1,65 @@
+package s5
+
+// MaxUDPHeaderSize is the size of the largest UDPHeader, with a 255 bytes domain name.
+const MaxUDPHeaderSize = 4 + 1 + 0xFF + 2
+
+// UDPHeader precedes every datagram relayed through an UDP ASSOCIATE, RFC 1928 section 7.
+//
+// Datagrams aren't streams, so the header only has the byte-slice API.
+type UDPHeader struct {
+	Reserved    uint16      // unrequired, only used by Parse
+	Frag        uint8       // fragment number, 0 for a standalone datagram
+	AddressType uint8       // unrequired, only used by Parse
+	Destination Destination // required only by AppendBinary
+}
+
+// Size returns binary length of the header.
+func (h *UDPHeader) Size() int {
+	return 4 + h.Destination.Size()
+}
+
+// AppendBinary appends the wire format to dst.
+func (h *UDPHeader) AppendBinary(dst []byte) []byte {
+	dst = append(dst, 0x00, 0x00, h.Frag, h.Destination.Kind())
+	return appendDestination(dst, h.Destination)
+}
+
+// Parse reads the header from b and returns the number of bytes read, the payload starts at b[n:].
+// If b is incomplete, ErrShortBuffer is returned and n is the minimum length of b.
+// Destination is reused when it matches the address type, a domain name aliases b.
+func (h *UDPHeader) Parse(b []byte) (n int, err error) {
+	if len(b) < 4 {
+		return 4, ErrShortBuffer
+	}
+	h.Reserved, h.Frag, h.AddressType = uint16(b[0])<<8|uint16(b[1]), b[2], b[3]
+	if h.Destination, n, err = parseDestination(h.Destination, h.AddressType, b[4:]); err != nil {
+		if err == ErrShortBuffer {
+			n += 4
+		}
+		return
+	}
+	return 4 + n, nil
+}
+
+// Validate checks the header and its destination.
+func (h *UDPHeader) Validate(mode Validation) error {
+	if mode == Strict && h.Reserved != 0 {
+		return ErrReservedNotZero
+	}
+	return validateDestination(h.Destination, mode)
+}
+
+// Wrap appends the header followed by payload to dst, ready to be sent to the relay.
+func (h *UDPHeader) Wrap(dst, payload []byte) []byte {
+	return append(h.AppendBinary(dst), payload...)
+}
+
+// Unwrap parses the header of a received datagram and returns its payload, which aliases datagram.
+// A truncated header is reported as ErrShortBuffer.
+func (h *UDPHeader) Unwrap(datagram []byte) (payload []byte, err error) {
+	n, err := h.Parse(datagram)
+	if err != nil {
+		return nil, err
+	}
+	return datagram[n:], nil
+}
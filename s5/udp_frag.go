@@ -0,0 +1,201 @@
+package s5
+
+import "time"
+
+// FragEnd is the high-order bit of UDPHeader.Frag marking the last fragment of a sequence,
+// the remaining bits hold the position of the fragment, from 1 to MaxFragments.
+const FragEnd uint8 = 0x80
+
+// MaxFragments is the largest number of fragments in a sequence.
+const MaxFragments = 0x7F
+
+const (
+	// DefaultReassemblyTimeout is used by a Reassembler without Timeout, the minimum required by RFC 1928.
+	DefaultReassemblyTimeout = 5 * time.Second
+
+	// DefaultReassemblySize is used by a Reassembler without MaxSize, the largest UDP payload.
+	DefaultReassemblySize = 0xFFFF
+
+	// DefaultMTU is used by a Fragmenter without MTU, an Ethernet frame minus the IPv4 and UDP headers.
+	DefaultMTU = 1500 - 20 - 8
+)
+
+// ReassemblyStats counts the fragment sequences handled by a Reassembler.
+type ReassemblyStats struct {
+	Completed uint64 // sequences reassembled
+	Timeouts  uint64 // sequences abandoned because the timer expired
+	Overflows uint64 // sequences abandoned because they exceeded MaxSize
+	Restarts  uint64 // sequences abandoned because a lower FRAG or a standalone datagram arrived
+	Gaps      uint64 // sequences abandoned because a fragment was missing
+}
+
+// Abandoned returns the number of sequences that were dropped.
+func (s ReassemblyStats) Abandoned() uint64 {
+	return s.Timeouts + s.Overflows + s.Restarts + s.Gaps
+}
+
+// Reassembler implements the reassembly queue and timer of RFC 1928 section 7 for one association.
+//
+// Fragments are expected in order, as the RFC mandates, a sequence with a missing fragment is abandoned.
+// A Reassembler is not safe for concurrent use.
+type Reassembler struct {
+	Timeout time.Duration // DefaultReassemblyTimeout if zero
+	MaxSize int           // maximum buffered payload, DefaultReassemblySize if zero
+
+	Stats ReassemblyStats
+
+	buf      []byte
+	last     uint8 // position of the last queued fragment, 0 when the queue is empty
+	deadline time.Time
+	skipping bool // dropping the fragments following a gap
+
+	now func() time.Time // time.Now if nil, overridden by tests
+}
+
+// Push queues the payload of a received datagram. The complete datagram is returned with done set
+// when h is a standalone datagram or the last fragment of a sequence, it aliases either payload
+// or the queue and stays valid until the next Push. The destination is the one of h.
+func (r *Reassembler) Push(h *UDPHeader, payload []byte) (datagram []byte, done bool) {
+	if h.Frag == 0 {
+		r.skipping = false
+		if r.last != 0 {
+			r.abandon(&r.Stats.Restarts)
+		}
+		return payload, true
+	}
+
+	now := r.clock()
+	if r.last != 0 && now.After(r.deadline) {
+		r.abandon(&r.Stats.Timeouts)
+	}
+
+	switch pos := h.Frag &^ FragEnd; {
+	case pos == r.last+1:
+	case r.last != 0 && pos <= r.last:
+		r.abandon(&r.Stats.Restarts)
+		if pos != 1 {
+			r.skipping = h.Frag&FragEnd == 0
+			return nil, false
+		}
+	default:
+		// a fragment was lost, the rest of the sequence is dropped and counted once
+		if r.last != 0 {
+			r.abandon(&r.Stats.Gaps)
+		} else if !r.skipping {
+			r.Stats.Gaps++
+		}
+		r.skipping = h.Frag&FragEnd == 0
+		return nil, false
+	}
+	r.skipping = false
+
+	if r.last == 0 {
+		timeout := r.Timeout
+		if timeout <= 0 {
+			timeout = DefaultReassemblyTimeout
+		}
+		r.deadline = now.Add(timeout)
+	}
+
+	maxSize := r.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultReassemblySize
+	}
+	if len(r.buf)+len(payload) > maxSize {
+		r.abandon(&r.Stats.Overflows)
+		r.skipping = h.Frag&FragEnd == 0
+		return nil, false
+	}
+
+	r.buf = append(r.buf, payload...)
+	r.last = h.Frag &^ FragEnd
+
+	if h.Frag&FragEnd == 0 {
+		return nil, false
+	}
+	r.Stats.Completed++
+	datagram = r.buf
+	r.buf, r.last = r.buf[:0], 0
+	return datagram, true
+}
+
+// Pending reports whether a sequence is being reassembled.
+func (r *Reassembler) Pending() bool {
+	return r.last != 0
+}
+
+// Deadline returns when the reassembly timer of the pending sequence expires, ok is false without one.
+func (r *Reassembler) Deadline() (deadline time.Time, ok bool) {
+	return r.deadline, r.last != 0
+}
+
+// Expire abandons the pending sequence if its reassembly timer expired by now, counting it in
+// Stats.Timeouts and releasing the queue, so that an association gone quiet doesn't keep the
+// fragments until its next datagram. It reports whether a sequence was abandoned.
+func (r *Reassembler) Expire(now time.Time) bool {
+	if r.last == 0 || !now.After(r.deadline) {
+		return false
+	}
+	r.abandon(&r.Stats.Timeouts)
+	r.buf = nil
+	return true
+}
+
+// Reset drops the pending sequence without counting it, and releases the queue.
+func (r *Reassembler) Reset() {
+	r.buf, r.last, r.skipping = nil, 0, false
+}
+
+func (r *Reassembler) abandon(counter *uint64) {
+	*counter++
+	r.buf, r.last = r.buf[:0], 0
+}
+
+func (r *Reassembler) clock() time.Time {
+	if r.now != nil {
+		return r.now()
+	}
+	return time.Now()
+}
+
+// Fragmenter splits payloads too large for a single datagram into a FRAG sequence.
+type Fragmenter struct {
+	MTU int // largest datagram, header included, DefaultMTU if zero
+}
+
+// Fragment appends to dst the datagrams carrying payload to the destination of h, a single
+// standalone datagram when it fits. The Frag field of h is ignored.
+func (f *Fragmenter) Fragment(dst [][]byte, h *UDPHeader, payload []byte) ([][]byte, error) {
+	mtu := f.MTU
+	if mtu <= 0 {
+		mtu = DefaultMTU
+	}
+
+	hdr := *h
+	size := mtu - hdr.Size()
+	if size <= 0 {
+		return dst, ErrMTUTooSmall
+	}
+	if len(payload) <= size {
+		hdr.Frag = 0
+		return append(dst, hdr.Wrap(make([]byte, 0, hdr.Size()+len(payload)), payload)), nil
+	}
+	if (len(payload)+size-1)/size > MaxFragments {
+		return dst, ErrTooManyFragments
+	}
+
+	for pos := uint8(1); len(payload) > 0; pos++ {
+		chunk := payload
+		if len(chunk) > size {
+			chunk = chunk[:size]
+		}
+		payload = payload[len(chunk):]
+
+		hdr.Frag = pos
+		if len(payload) == 0 {
+			hdr.Frag |= FragEnd
+		}
+		dst = append(dst, hdr.Wrap(make([]byte, 0, hdr.Size()+len(chunk)), chunk))
+	}
+	return dst, nil
+}
@@ -0,0 +1,175 @@
+package s5
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testUDPDest = &RequestV5DestIPv4{Address: [4]byte{127, 0, 0, 1}, Port: 53}
+
+// pushAll parses and pushes the datagrams, returning the reassembled ones.
+func pushAll(t *testing.T, r *Reassembler, datagrams [][]byte) (out [][]byte) {
+	t.Helper()
+	for _, d := range datagrams {
+		var h UDPHeader
+		payload, err := h.Unwrap(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if full, done := r.Push(&h, payload); done {
+			out = append(out, append([]byte(nil), full...))
+		}
+	}
+	return
+}
+
+func TestFragmenter(t *testing.T) {
+	f := Fragmenter{MTU: 10 + 4}
+	payload := []byte("0123456789")
+
+	datagrams, err := f.Fragment(nil, &UDPHeader{Destination: testUDPDest}, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, [][]byte{
+		{0x00, 0x00, 0x01, 0x01, 127, 0, 0, 1, 0x00, 0x35, '0', '1', '2', '3'},
+		{0x00, 0x00, 0x02, 0x01, 127, 0, 0, 1, 0x00, 0x35, '4', '5', '6', '7'},
+		{0x00, 0x00, 0x83, 0x01, 127, 0, 0, 1, 0x00, 0x35, '8', '9'},
+	}, datagrams)
+
+	// a payload which fits isn't fragmented
+	datagrams, err = f.Fragment(nil, &UDPHeader{Frag: 5, Destination: testUDPDest}, payload[:4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, [][]byte{{0x00, 0x00, 0x00, 0x01, 127, 0, 0, 1, 0x00, 0x35, '0', '1', '2', '3'}}, datagrams)
+}
+
+func TestFragmenterFail(t *testing.T) {
+	_, err := (&Fragmenter{MTU: 10}).Fragment(nil, &UDPHeader{Destination: testUDPDest}, []byte("ping"))
+	assert.Equal(t, ErrMTUTooSmall, err)
+
+	_, err = (&Fragmenter{MTU: 11}).Fragment(nil, &UDPHeader{Destination: testUDPDest}, make([]byte, MaxFragments+1))
+	assert.Equal(t, ErrTooManyFragments, err)
+}
+
+func TestReassembler(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789"), 100)
+	datagrams, err := (&Fragmenter{MTU: 100}).Fragment(nil, &UDPHeader{Destination: testUDPDest}, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var r Reassembler
+	assert.Equal(t, [][]byte{payload}, pushAll(t, &r, datagrams))
+	assert.False(t, r.Pending())
+	assert.Equal(t, ReassemblyStats{Completed: 1}, r.Stats)
+
+	// standalone datagrams are passed through
+	assert.Equal(t, [][]byte{[]byte("ping")}, pushAll(t, &r, [][]byte{(&UDPHeader{Destination: testUDPDest}).Wrap(nil, []byte("ping"))}))
+}
+
+func TestReassemblerAbandon(t *testing.T) {
+	datagrams, err := (&Fragmenter{MTU: 12}).Fragment(nil, &UDPHeader{Destination: testUDPDest}, []byte("0123456789"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	standalone := (&UDPHeader{Destination: testUDPDest}).Wrap(nil, []byte("ping"))
+
+	// a lost fragment drops the rest of the sequence
+	var r Reassembler
+	assert.Empty(t, pushAll(t, &r, [][]byte{datagrams[0], datagrams[2], datagrams[3], datagrams[4]}))
+	assert.Equal(t, ReassemblyStats{Gaps: 1}, r.Stats)
+
+	// a lost first fragment as well
+	r = Reassembler{}
+	assert.Empty(t, pushAll(t, &r, datagrams[1:]))
+	assert.Equal(t, ReassemblyStats{Gaps: 1}, r.Stats)
+
+	// a standalone datagram restarts the queue
+	r = Reassembler{}
+	assert.Equal(t, [][]byte{[]byte("ping"), []byte("0123456789")}, pushAll(t, &r, append([][]byte{datagrams[0], standalone}, datagrams...)))
+	assert.Equal(t, ReassemblyStats{Completed: 1, Restarts: 1}, r.Stats)
+
+	// a sequence larger than MaxSize
+	r = Reassembler{MaxSize: 4}
+	assert.Empty(t, pushAll(t, &r, datagrams))
+	assert.Equal(t, ReassemblyStats{Overflows: 1}, r.Stats)
+	assert.False(t, r.Pending())
+}
+
+func TestReassemblerTimeout(t *testing.T) {
+	datagrams, err := (&Fragmenter{MTU: 12}).Fragment(nil, &UDPHeader{Destination: testUDPDest}, []byte("0123456789"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	r := Reassembler{now: func() time.Time { return now }}
+
+	assert.Empty(t, pushAll(t, &r, datagrams[:2]))
+	assert.True(t, r.Pending())
+
+	now = now.Add(DefaultReassemblyTimeout + time.Millisecond)
+	assert.Empty(t, pushAll(t, &r, datagrams[2:]))
+	assert.Equal(t, ReassemblyStats{Timeouts: 1, Gaps: 1}, r.Stats)
+
+	// a new sequence after the timeout
+	assert.Equal(t, [][]byte{[]byte("0123456789")}, pushAll(t, &r, datagrams))
+	assert.Equal(t, uint64(2), r.Stats.Abandoned())
+}
+
+func TestReassemblerExpire(t *testing.T) {
+	datagrams, err := (&Fragmenter{MTU: 12}).Fragment(nil, &UDPHeader{Destination: testUDPDest}, []byte("0123456789"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	r := Reassembler{Timeout: time.Second, now: func() time.Time { return now }}
+	assert.False(t, r.Expire(now.Add(time.Hour)), "nothing pending")
+	_, ok := r.Deadline()
+	assert.False(t, ok)
+
+	assert.Empty(t, pushAll(t, &r, datagrams[:2]))
+	deadline, ok := r.Deadline()
+	assert.True(t, ok)
+	assert.Equal(t, now.Add(time.Second), deadline)
+
+	// the association goes quiet, the sequence is dropped without waiting for another datagram
+	assert.False(t, r.Expire(deadline))
+	assert.True(t, r.Pending())
+	assert.True(t, r.Expire(deadline.Add(time.Millisecond)))
+	assert.False(t, r.Pending())
+	assert.Nil(t, r.buf, "the queue is released")
+	assert.Equal(t, ReassemblyStats{Timeouts: 1}, r.Stats)
+	assert.False(t, r.Expire(deadline.Add(time.Hour)), "counted once")
+
+	// the remaining fragments of the expired sequence are dropped
+	now = deadline.Add(time.Millisecond)
+	assert.Empty(t, pushAll(t, &r, datagrams[2:]))
+	assert.Equal(t, [][]byte{[]byte("0123456789")}, pushAll(t, &r, datagrams))
+	assert.Equal(t, ReassemblyStats{Completed: 1, Timeouts: 1, Gaps: 1}, r.Stats)
+}
+
+///
+
+func BenchmarkReassembler(b *testing.B) {
+	payload := make([]byte, 4096)
+	datagrams, _ := (&Fragmenter{}).Fragment(nil, &UDPHeader{Destination: testUDPDest}, payload)
+
+	var r Reassembler
+	var h UDPHeader
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, d := range datagrams {
+			p, _ := h.Unwrap(d)
+			r.Push(&h, p)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package s5
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUDPHeaderAppendBinary(t *testing.T) {
+	h := &UDPHeader{Destination: &RequestV5DestDomainName{Address: []byte("google.com"), Port: 53}}
+
+	assert.Equal(t, []byte{0x0, 0x0, 0x0, 0x3, 0xa, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x63, 0x6f, 0x6d, 0x0, 0x35}, h.AppendBinary(nil))
+	assert.Equal(t, 17, h.Size())
+
+	h = &UDPHeader{Frag: 1, Destination: &RequestV5DestIPv6{Address: [16]byte{0xe, 0xe}, Port: 53}}
+
+	assert.Equal(t, []byte{0x0, 0x0, 0x1, 0x4, 0x0e, 0x0e, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x35}, h.AppendBinary(nil))
+	assert.Equal(t, 22, h.Size())
+}
+
+func TestUDPHeaderParse(t *testing.T) {
+	var h UDPHeader
+
+	n, err := h.Parse([]byte{0x00, 0x00, 0x00, 0x01, 127, 0, 0, 1, 0x00, 0x35})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 10, n)
+	assert.Equal(t, UDPHeader{
+		AddressType: AddressTypeIPv4,
+		Destination: &RequestV5DestIPv4{Address: [4]byte{127, 0, 0, 1}, Port: 53},
+	}, h, "the header should be equal")
+
+	_, err = h.Parse([]byte{0x00, 0x00, 0x00, 0x02, 0x00})
+	assert.Equal(t, ErrUnsupportedAddressType, err)
+
+	_, err = h.Parse([]byte{0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x35})
+	assert.Equal(t, ErrInvalidHostnameLength, err)
+}
+
+func TestUDPHeaderWrap(t *testing.T) {
+	h := &UDPHeader{Destination: &RequestV5DestIPv4{Address: [4]byte{127, 0, 0, 1}, Port: 53}}
+	datagram := h.Wrap([]byte{0xAA}, []byte("ping"))
+
+	assert.Equal(t, []byte{0xAA, 0x00, 0x00, 0x00, 0x01, 127, 0, 0, 1, 0x00, 0x35, 'p', 'i', 'n', 'g'}, datagram)
+
+	var got UDPHeader
+	payload, err := got.Unwrap(datagram[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, "ping", string(payload))
+	assert.Equal(t, h.Destination, got.Destination)
+
+	// an empty payload is allowed
+	payload, err = got.Unwrap(datagram[1:11])
+	assert.NoError(t, err)
+	assert.Empty(t, payload)
+
+	_, err = got.Unwrap(datagram[1:8])
+	assert.Equal(t, ErrShortBuffer, err)
+}
+
+func TestUDPHeaderValidate(t *testing.T) {
+	h := &UDPHeader{Reserved: 1, Destination: &RequestV5DestIPv4{Port: 53}}
+
+	assert.NoError(t, h.Validate(Lenient))
+	assert.Equal(t, ErrReservedNotZero, h.Validate(Strict))
+	assert.Equal(t, ErrUnsupportedAddressType, (&UDPHeader{}).Validate(Lenient))
+	assert.Equal(t, ErrInvalidHostname, (&UDPHeader{Destination: &RequestV5DestDomainName{Address: []byte("a_b"), Port: 53}}).Validate(Strict))
+}
+
+///
+
+func BenchmarkUDPHeaderWrap(b *testing.B) {
+	var buf = make([]byte, 0, 512)
+	var h = UDPHeader{Destination: &RequestV5DestIPv4{Address: [4]byte{127, 0, 0, 1}, Port: 53}}
+	var payload = make([]byte, 64)
+
+	for i := 0; i < b.N; i++ {
+		buf = h.Wrap(buf[:0], payload)
+	}
+}
+
+func BenchmarkUDPHeaderUnwrap(b *testing.B) {
+	var h UDPHeader
+	var datagram = (&UDPHeader{Destination: &RequestV5DestIPv4{Address: [4]byte{127, 0, 0, 1}, Port: 53}}).Wrap(nil, make([]byte, 64))
+
+	for i := 0; i < b.N; i++ {
+		_, _ = h.Unwrap(datagram)
+	}
+}
@@ -0,0 +1,157 @@
+package s5
+
+import "unicode/utf8"
+
+// Validation selects how strictly structures are checked.
+//
+// Unpack and Parse always apply the Lenient rules, Strict has to be asked for with Validate.
+type Validation uint8
+
+const (
+	// Lenient accepts what can be handled safely: hostnames have to be non-empty valid UTF-8
+	// without control characters or spaces, and lengths have to fit in their length fields.
+	Lenient Validation = iota
+
+	// Strict additionally enforces RFC 1928/1929: at least one method, reserved fields set to zero,
+	// non-empty username and password, and hostnames made of LDH labels, punycode for IDNs.
+	Strict
+)
+
+// MaxHostnameLength is the longest hostname, without the trailing dot, accepted by Strict.
+const MaxHostnameLength = 253
+
+// Validate checks the structure.
+func (h *HandshakeRequest) Validate(mode Validation) error {
+	if len(h.Methods) > 0xFF {
+		return ErrMethodsLimit
+	}
+	if mode == Strict && len(h.Methods) == 0 {
+		return ErrNoMethods
+	}
+	return nil
+}
+
+// Validate checks the structure.
+func (auth *AuthUserPW) Validate(mode Validation) error {
+	switch {
+	case len(auth.Username) > 0xFF, mode == Strict && len(auth.Username) == 0:
+		return ErrUsernameLength
+	case len(auth.Password) > 0xFF, mode == Strict && len(auth.Password) == 0:
+		return ErrPasswordLength
+	}
+	return nil
+}
+
+// Validate checks the structure and its destination.
+func (v *Request) Validate(mode Validation) error {
+	if mode == Strict && v.Reserved != 0 {
+		return ErrReservedNotZero
+	}
+	return validateDestination(v.Destination, mode)
+}
+
+// validateDestination checks the hostname of a domain name destination.
+func validateDestination(d Destination, mode Validation) error {
+	switch d := d.(type) {
+	case nil:
+		return ErrUnsupportedAddressType
+	case *RequestV5DestDomainName:
+		return d.Validate(mode)
+	case *Addr:
+		return d.Validate(mode)
+	}
+	return nil
+}
+
+// Validate checks the structure.
+func (t *Reply) Validate(mode Validation) error {
+	if mode == Strict && t.Reserved != 0 {
+		return ErrReservedNotZero
+	}
+	return nil
+}
+
+// Validate checks the hostname.
+func (h *RequestV5DestDomainName) Validate(mode Validation) error {
+	return ValidateHostname(h.Address, mode)
+}
+
+// Validate checks the address type and the hostname.
+func (a *Addr) Validate(mode Validation) error {
+	switch a.Type {
+	case AddressTypeDomainName:
+		return ValidateHostname([]byte(a.Host), mode)
+	case AddressTypeIPv4, AddressTypeIPv6:
+		if !a.IsValid() {
+			return ErrUnsupportedAddressType
+		}
+		return nil
+	}
+	return ErrUnsupportedAddressType
+}
+
+// ValidateHostname checks a hostname as received in a request.
+func ValidateHostname(host []byte, mode Validation) error {
+	if len(host) == 0 || len(host) > 0xFF {
+		return ErrInvalidHostnameLength
+	}
+	if mode == Strict {
+		return validateHostnameStrict(host)
+	}
+	for _, c := range host {
+		if c <= ' ' || c == 0x7F {
+			return ErrInvalidHostname
+		}
+	}
+	if !utf8.Valid(host) {
+		return ErrInvalidHostname
+	}
+	return nil
+}
+
+func validateHostnameStrict(host []byte) error {
+	if host[len(host)-1] == '.' {
+		host = host[:len(host)-1]
+	}
+	if len(host) == 0 || len(host) > MaxHostnameLength {
+		return ErrInvalidHostnameLength
+	}
+
+	start := 0
+	for i := 0; i <= len(host); i++ {
+		if i < len(host) && host[i] != '.' {
+			continue
+		}
+		if err := validateLabel(host[start:i]); err != nil {
+			return err
+		}
+		start = i + 1
+	}
+	return nil
+}
+
+// validateLabel checks the letters, digits and hyphens rule, and decodes punycode labels.
+func validateLabel(label []byte) error {
+	if len(label) == 0 || len(label) > 63 {
+		return ErrInvalidHostname
+	}
+	if label[0] == '-' || label[len(label)-1] == '-' {
+		return ErrInvalidHostname
+	}
+	for _, c := range label {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-':
+		default:
+			return ErrInvalidHostname
+		}
+	}
+	if s := string(label); hasACEPrefix(s) {
+		if _, err := punyDecode(s[len(ACEPrefix):]); err != nil {
+			return err
+		}
+	} else if len(label) >= 4 && label[2] == '-' && label[3] == '-' {
+		// reserved for other ACE prefixes, RFC 5891 section 4.2.3.1
+		return ErrInvalidHostname
+	}
+	return nil
+}
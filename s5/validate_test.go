@@ -0,0 +1,84 @@
+package s5
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateHostname(t *testing.T) {
+	long := strings.Repeat("a", 63)
+	for _, tt := range []struct {
+		host            string
+		lenient, strict error
+	}{
+		{"google.com", nil, nil},
+		{"google.com.", nil, nil},
+		{"127.0.0.1", nil, nil},
+		{"xn--bcher-kva.example", nil, nil},
+		{"bücher.example", nil, ErrInvalidHostname},
+		{"", ErrInvalidHostnameLength, ErrInvalidHostnameLength},
+		{"goo\x00gle.com", ErrInvalidHostname, ErrInvalidHostname},
+		{"goo gle.com", ErrInvalidHostname, ErrInvalidHostname},
+		{"b\xffcher.example", ErrInvalidHostname, ErrInvalidHostname},
+		{"::1", nil, ErrInvalidHostname},
+		{"-google.com", nil, ErrInvalidHostname},
+		{"google-.com", nil, ErrInvalidHostname},
+		{"google..com", nil, ErrInvalidHostname},
+		{"under_score.com", nil, ErrInvalidHostname},
+		{"ab--cd.com", nil, ErrInvalidHostname},
+		{"xn--bcher-kv!.example", nil, ErrInvalidHostname},
+		{"xn--99999999999.example", nil, ErrInvalidPunycode},
+		{long + ".com", nil, nil},
+		{long + "a.com", nil, ErrInvalidHostname},
+		{strings.Repeat(long+".", 3) + strings.Repeat("a", 62), nil, ErrInvalidHostnameLength},
+	} {
+		assert.Equal(t, tt.lenient, ValidateHostname([]byte(tt.host), Lenient), "lenient %q", tt.host)
+		assert.Equal(t, tt.strict, ValidateHostname([]byte(tt.host), Strict), "strict %q", tt.host)
+	}
+}
+
+func TestValidateStrict(t *testing.T) {
+	assert.NoError(t, (&HandshakeRequest{}).Validate(Lenient))
+	assert.Equal(t, ErrNoMethods, (&HandshakeRequest{}).Validate(Strict))
+
+	req := Request{Reserved: 1, Destination: &RequestV5DestIPv4{}}
+	assert.NoError(t, req.Validate(Lenient))
+	assert.Equal(t, ErrReservedNotZero, req.Validate(Strict))
+
+	assert.NoError(t, (&AuthUserPW{}).Validate(Lenient))
+	assert.Equal(t, ErrUsernameLength, (&AuthUserPW{Password: []byte("test")}).Validate(Strict))
+	assert.Equal(t, ErrPasswordLength, (&AuthUserPW{Username: []byte("test")}).Validate(Strict))
+}
+
+func TestPackOversized(t *testing.T) {
+	var buf = bytes.NewBuffer(nil)
+
+	assert.Equal(t, ErrUsernameLength, (&AuthUserPW{Username: make([]byte, 0x100), Password: []byte("test")}).Pack(buf))
+	assert.Equal(t, ErrPasswordLength, (&AuthUserPW{Username: []byte("test"), Password: make([]byte, 0x100)}).Pack(buf))
+	assert.Equal(t, 0, buf.Len(), "nothing should be written")
+
+	assert.Equal(t, ErrInvalidHostnameLength, (&Request{
+		Command:     CommandConnect,
+		Destination: &RequestV5DestDomainName{Address: bytes.Repeat([]byte{'a'}, 0x100), Port: 80},
+	}).Pack(buf))
+
+	// the longest hostname still fits
+	assert.NoError(t, (&Request{
+		Command:     CommandConnect,
+		Destination: &RequestV5DestDomainName{Address: bytes.Repeat([]byte{'a'}, 0xFF), Port: 80},
+	}).Pack(buf))
+	assert.Equal(t, 4+1+0xFF+2, buf.Len())
+}
+
+func TestUnpackInvalidHostname(t *testing.T) {
+	var d RequestV5DestDomainName
+
+	assert.Equal(t, ErrInvalidHostnameLength, d.Unpack(bytes.NewBuffer([]byte{0x00, 0x00, 0x50})))
+	assert.Equal(t, ErrInvalidHostname, d.Unpack(bytes.NewBuffer([]byte{0x02, 'a', 0x00, 0x00, 0x50})))
+
+	_, err := d.Parse([]byte{0x00, 0x00, 0x50})
+	assert.Equal(t, ErrInvalidHostnameLength, err)
+}
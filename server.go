@@ -0,0 +1,526 @@
+package socks
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kayabe/socks/proxyproto"
+	"github.com/kayabe/socks/s4"
+	"github.com/kayabe/socks/s5"
+)
+
+// ContextDialer dials a network address with a context, net.Dialer implements it.
+type ContextDialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Server is a proxy server serving SOCKS4, SOCKS4A, SOCKS5 and HTTP CONNECT/forward-proxy
+// clients on the same listener. The protocol is detected from the first byte of each connection,
+// all of them share the same Credentials, Rules and relay.
+type Server struct {
+	// Addr optionally specifies the TCP address to listen on, ":1080" if empty.
+	Addr string
+
+	// Credentials validates client identities, nil disables authentication.
+	Credentials CredentialStore
+
+	// Rules decides whether a request is allowed, nil allows every request.
+	Rules Rule
+
+	// Dialer is used to reach destinations, a zero net.Dialer is used if nil. It can be a *Client
+	// chaining to an upstream proxy server, a *DirectDialer, or a *Router picking one of them,
+	// the Request being available from the context with RequestFromContext.
+	Dialer ContextDialer
+
+	// Resolver optionally resolves the domain names on the server, the Rules being checked again
+	// against each address and the Dialer dialing the addresses, in order, rather than the names.
+	// It can be a *DNSPolicy, a *ResolverRouter or a *dns.Cache. Nil leaves the names to the Dialer.
+	Resolver Resolver
+
+	// AttemptDelay is how long the connection attempts to the addresses of a name wait for
+	// each other before racing, as the Happy Eyeballs of RFC 8305 do, DefaultAttemptDelay if zero.
+	// The Server only races the addresses itself with a Resolver, otherwise the names are left
+	// to the Dialer, and AttemptDelay is the FallbackDelay of the net.Dialer used without one.
+	AttemptDelay time.Duration
+
+	// Connected is optionally called with the connection to the destination of each request
+	// before replying to the client, such as to log the address which was connected.
+	Connected func(req *Request, target net.Conn)
+
+	// HandshakeTimeout bounds the time a client has to negotiate, authenticate and send its request,
+	// and HTTP clients each of their requests, before ErrHandshakeTimeout closes the connection.
+	// Zero means no timeout.
+	HandshakeTimeout time.Duration
+
+	// IdleTimeout closes the relayed connections with ErrIdleTimeout once no data went through
+	// either direction for this long. Zero means no timeout.
+	IdleTimeout time.Duration
+
+	// MaxLifetime closes the client connections with ErrMaxLifetime this long after they were
+	// accepted, whatever they are doing, the resolution and the dialing of their request being
+	// canceled with ErrMaxLifetime as the cause of the context. Zero means no limit.
+	MaxLifetime time.Duration
+
+	// Closed is optionally called once each client connection is done with the error it ended with,
+	// ErrHandshakeTimeout, ErrIdleTimeout or ErrMaxLifetime when one of the timeouts closed it,
+	// nil when it ended normally.
+	Closed func(conn net.Conn, err error)
+
+	// BindReply selects the address replied to CONNECT requests, BindLocal by default.
+	BindReply BindReply
+
+	// TrustedProxies lists the load balancers whose connections may begin with a PROXY protocol
+	// header, version 1 or 2, the address it carries becoming the RemoteAddr of the client.
+	TrustedProxies []netip.Prefix
+
+	// ProxyHeader matches the destinations, in the NO_PROXY syntax of ParseNoProxy, which are sent
+	// a PROXY protocol version 2 header carrying the address of the client and its username
+	// as a proxyproto.TLVUsername TLV. Nil sends none.
+	ProxyHeader NoProxy
+
+	// Validation selects how strictly SOCKS5 messages are checked, s5.Lenient by default.
+	Validation s5.Validation
+
+	// TLSConfig optionally provides a TLS configuration for use by ServeTLS and ListenAndServeTLS.
+	TLSConfig *tls.Config
+
+	// ErrorLog specifies an optional logger for errors, the log package's standard logger is used if nil.
+	ErrorLog *log.Logger
+
+	inShutdown atomic.Bool
+	mu         sync.Mutex
+	listeners  map[*net.Listener]struct{}
+	conns      map[net.Conn]struct{}
+}
+
+// BindReply selects the address a Server replies to CONNECT requests with as BND.ADDR and BND.PORT,
+// which RFC 1928 defines as the address the server bound for the outbound connection.
+type BindReply uint8
+
+const (
+	BindLocal      BindReply = iota // the local address of the outbound connection, IPv4-mapped ones as IPv4
+	BindIPv4Mapped                  // the local address, IPv4 ones as IPv4-mapped IPv6 for SOCKS5
+	BindHidden                      // 0.0.0.0:0, not to disclose the addresses of the server to its clients
+)
+
+// shutdownPollInterval is how often Shutdown checks for remaining connections.
+const shutdownPollInterval = 100 * time.Millisecond
+
+// ListenAndServe listens on the TCP network address s.Addr and then calls Serve.
+func (s *Server) ListenAndServe() error {
+	if s.shuttingDown() {
+		return ErrServerClosed
+	}
+	addr := s.Addr
+	if addr == "" {
+		addr = ":1080"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// ListenAndServeTLS listens on the TCP network address s.Addr and then calls ServeTLS.
+func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	if s.shuttingDown() {
+		return ErrServerClosed
+	}
+	addr := s.Addr
+	if addr == "" {
+		addr = ":1080"
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.ServeTLS(l, certFile, keyFile)
+}
+
+// ServeTLS accepts incoming connections on the listener and serves them over TLS.
+// The certificate and key files are loaded unless s.TLSConfig already holds certificates
+// or a GetCertificate func, in which case both can be empty.
+func (s *Server) ServeTLS(l net.Listener, certFile, keyFile string) error {
+	var config *tls.Config
+	if s.TLSConfig != nil {
+		config = s.TLSConfig.Clone()
+	} else {
+		config = &tls.Config{}
+	}
+
+	if len(config.Certificates) == 0 && config.GetCertificate == nil || certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			l.Close()
+			return err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return s.serve(tls.NewListener(s.proxyListener(l), config))
+}
+
+// Serve accepts incoming connections on the listener, creating a new goroutine for each.
+// Serve always returns a non-nil error and closes l, ErrServerClosed after Shutdown or Close.
+func (s *Server) Serve(l net.Listener) error {
+	return s.serve(s.proxyListener(l))
+}
+
+// proxyListener reads the PROXY protocol headers of the TrustedProxies, below TLS.
+func (s *Server) proxyListener(l net.Listener) net.Listener {
+	if len(s.TrustedProxies) == 0 {
+		return l
+	}
+	return &proxyproto.Listener{Listener: l, Trusted: s.TrustedProxies}
+}
+
+func (s *Server) serve(l net.Listener) error {
+	if !s.trackListener(&l, true) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer s.trackListener(&l, false)
+	defer l.Close()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// Shutdown gracefully shuts down the server, it first closes all listeners
+// and then waits for the connections to finish or for the context to be done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.inShutdown.Store(true)
+
+	s.mu.Lock()
+	err := s.closeListenersLocked()
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.numConns() == 0 {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close immediately closes all listeners and connections.
+func (s *Server) Close() error {
+	s.inShutdown.Store(true)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.closeListenersLocked()
+	for c := range s.conns {
+		c.Close()
+	}
+	return err
+}
+
+func (s *Server) shuttingDown() bool {
+	return s.inShutdown.Load()
+}
+
+func (s *Server) trackListener(l *net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listeners == nil {
+		s.listeners = make(map[*net.Listener]struct{})
+	}
+	if add {
+		if s.shuttingDown() {
+			return false
+		}
+		s.listeners[l] = struct{}{}
+	} else {
+		delete(s.listeners, l)
+	}
+	return true
+}
+
+func (s *Server) trackConn(c net.Conn, add bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+	if add {
+		s.conns[c] = struct{}{}
+	} else {
+		delete(s.conns, c)
+	}
+}
+
+func (s *Server) numConns() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+func (s *Server) closeListenersLocked() (err error) {
+	for l := range s.listeners {
+		if cerr := (*l).Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// bufConn is a net.Conn reading through the buffered reader used to sniff the protocol.
+type bufConn struct {
+	net.Conn
+	r *bufio.Reader
+
+	// ctx is canceled with the reason the connection expired, aborting the resolution and the dialing
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	mu     sync.Mutex
+	reason error // why the server closed the connection
+}
+
+func (c *bufConn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+// NetConn returns the connection read through the buffer.
+func (c *bufConn) NetConn() net.Conn { return c.Conn }
+
+// expire closes the connection for reason, unless it already expired.
+func (c *bufConn) expire(reason error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.reason == nil {
+		c.reason = reason
+		c.cancel(reason)
+		c.Conn.Close()
+	}
+}
+
+// expired returns the reason the connection was closed for, nil if it didn't expire.
+func (c *bufConn) expired() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reason
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	s.trackConn(conn, true)
+	defer s.trackConn(conn, false)
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	c := &bufConn{Conn: conn, r: bufio.NewReader(conn), ctx: ctx, cancel: cancel}
+	if s.MaxLifetime > 0 {
+		lifetime := time.AfterFunc(s.MaxLifetime, func() { c.expire(ErrMaxLifetime) })
+		defer lifetime.Stop()
+	}
+
+	s.startHandshake(c)
+	err := s.serveProtocol(c)
+	if reason := c.expired(); reason != nil {
+		err = reason
+	} else if s.HandshakeTimeout > 0 && errors.Is(err, os.ErrDeadlineExceeded) {
+		err = ErrHandshakeTimeout
+	}
+
+	if s.Closed != nil {
+		s.Closed(conn, err)
+	}
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		s.logf("socks: %s: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// serveProtocol serves the protocol detected from the first byte of the connection.
+func (s *Server) serveProtocol(c *bufConn) error {
+	head, err := c.r.Peek(1)
+	if err != nil {
+		if errors.Is(err, proxyproto.ErrInvalidHeader) || errors.Is(err, proxyproto.ErrUnsupportedVersion) || errors.Is(err, os.ErrDeadlineExceeded) {
+			return err
+		}
+		return nil
+	}
+
+	switch v := head[0]; {
+	case v == s4.VERSION:
+		return s.serveV4(c)
+	case v == s5.VERSION:
+		return s.serveV5(c)
+	case v >= 'A' && v <= 'Z': // HTTP methods are uppercase tokens
+		return s.serveHTTP(c)
+	}
+	return s5.ErrUnsupportedVersion
+}
+
+// startHandshake gives the client HandshakeTimeout to send its request.
+func (s *Server) startHandshake(c net.Conn) {
+	if s.HandshakeTimeout > 0 {
+		_ = c.SetDeadline(time.Now().Add(s.HandshakeTimeout))
+	}
+}
+
+// endHandshake clears the deadline of startHandshake once the request is read.
+func (s *Server) endHandshake(c net.Conn) {
+	if s.HandshakeTimeout > 0 {
+		_ = c.SetDeadline(time.Time{})
+	}
+}
+
+// connect checks the request against the rules and dials the destination, or races the addresses
+// it resolves to, the failures being reported with the status of ReplyStatusOf.
+func (s *Server) connect(ctx context.Context, req *Request) (net.Conn, s5.ReplyStatus) {
+	ctx = context.WithValue(ctx, requestKey{}, req)
+	if s.Rules != nil && !s.Rules.Allow(ctx, req) {
+		return nil, s5.ReplyConnectionNotAllowed
+	}
+
+	delay := s.AttemptDelay
+	if delay == 0 {
+		delay = DefaultAttemptDelay
+	}
+
+	addresses := []string{req.Address()}
+	if s.Resolver != nil {
+		addrs, status := s.resolve(ctx, req)
+		if status != s5.ReplySuccess {
+			return nil, status
+		}
+		addresses = addresses[:0]
+		for _, addr := range interleave(addrs) {
+			addresses = append(addresses, netip.AddrPortFrom(addr.Unmap(), req.Port).String())
+		}
+	}
+
+	var d ContextDialer = s.Dialer
+	if d == nil {
+		d = &net.Dialer{FallbackDelay: delay}
+	}
+
+	conn, err := dialParallel(ctx, d, addresses, delay)
+	if err != nil {
+		return nil, ReplyStatusOf(err)
+	}
+	if len(s.ProxyHeader) > 0 && (s.ProxyHeader.Excludes(req.Address()) || s.ProxyHeader.Excludes(conn.RemoteAddr().String())) {
+		if err := writeProxyHeader(conn, req); err != nil {
+			conn.Close()
+			return nil, s5.ReplyGeneralFailure
+		}
+	}
+	if s.Connected != nil {
+		s.Connected(req, conn)
+	}
+	return conn, s5.ReplySuccess
+}
+
+// writeProxyHeader sends the client address and username of the request to its destination.
+func writeProxyHeader(target net.Conn, req *Request) error {
+	h := &proxyproto.Header{Version: 2, Command: proxyproto.CommandProxy}
+	if src, ok := req.RemoteAddr.(*net.TCPAddr); ok {
+		h.Source = src.AddrPort()
+	}
+	if ip, err := netip.ParseAddr(req.Host); err == nil {
+		h.Destination = netip.AddrPortFrom(ip, req.Port)
+	} else if dst, ok := target.RemoteAddr().(*net.TCPAddr); ok {
+		h.Destination = dst.AddrPort()
+	}
+	if req.Username != "" {
+		h.TLVs = append(h.TLVs, proxyproto.TLV{Type: proxyproto.TLVUsername, Value: []byte(req.Username)})
+	}
+	b, err := h.AppendBinary(nil)
+	if err != nil {
+		return err
+	}
+	_, err = target.Write(b)
+	return err
+}
+
+// bindAddr returns the address replied to a CONNECT request whose outbound connection is target,
+// 0.0.0.0:0 if hidden or unknown.
+func (s *Server) bindAddr(target net.Conn) netip.AddrPort {
+	hidden := netip.AddrPortFrom(netip.IPv4Unspecified(), 0)
+	if target == nil || s.BindReply == BindHidden {
+		return hidden
+	}
+	bind, err := s5.AddrFromNet(target.LocalAddr())
+	if err != nil || bind.Type == s5.AddressTypeDomainName {
+		return hidden
+	}
+	if s.BindReply == BindIPv4Mapped && bind.IP.Is4() {
+		bind.IP = netip.AddrFrom16(bind.IP.As16())
+	}
+	return netip.AddrPortFrom(bind.IP, bind.Port)
+}
+
+// valid reports whether the credentials are accepted, every identity is accepted without a CredentialStore.
+func (s *Server) valid(username, password string) bool {
+	return s.Credentials == nil || s.Credentials.Valid(username, password)
+}
+
+// relay copies data between the client and the destination until both directions are done, see Relay,
+// or until neither of them read anything for IdleTimeout.
+func (s *Server) relay(c *bufConn, target net.Conn) {
+	if s.IdleTimeout > 0 {
+		idle := time.Now().Add(s.IdleTimeout)
+		_ = c.SetReadDeadline(idle)
+		_ = target.SetReadDeadline(idle)
+		Relay(&idleConn{Conn: c, peer: target, client: c, timeout: s.IdleTimeout},
+			&idleConn{Conn: target, peer: c, client: c, timeout: s.IdleTimeout})
+		return
+	}
+	Relay(c, target)
+}
+
+// idleConn extends the read deadlines of both conns of a relay on each read,
+// expiring the client connection with ErrIdleTimeout once they are exceeded.
+type idleConn struct {
+	net.Conn
+	peer    net.Conn
+	client  *bufConn
+	timeout time.Duration
+}
+
+// NetConn returns the wrapped connection.
+func (c *idleConn) NetConn() net.Conn { return c.Conn }
+
+func (c *idleConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err == nil {
+		idle := time.Now().Add(c.timeout)
+		_ = c.Conn.SetReadDeadline(idle)
+		_ = c.peer.SetReadDeadline(idle)
+	} else if errors.Is(err, os.ErrDeadlineExceeded) {
+		c.client.expire(ErrIdleTimeout)
+	}
+	return n, err
+}
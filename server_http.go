@@ -0,0 +1,202 @@
+package socks
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/kayabe/socks/s5"
+)
+
+// hopHeaders are removed from requests and responses passing through the forward proxy.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// maxDiscard bounds the body of a request discarded before the next one is read,
+// as net/http does for the bodies left unread by handlers.
+const maxDiscard = 256 << 10
+
+// httpStatus maps a ReplyStatus onto the status code sent to HTTP clients.
+func httpStatus(status s5.ReplyStatus) int {
+	switch status {
+	case s5.ReplySuccess:
+		return http.StatusOK
+	case s5.ReplyConnectionNotAllowed:
+		return http.StatusForbidden
+	case s5.ReplyTTLExpired:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
+// writeHTTPStatus writes an empty response with the given status code.
+func writeHTTPStatus(w io.Writer, code int, header http.Header) error {
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Set("Content-Length", "0")
+	return (&http.Response{
+		StatusCode: code,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     header,
+	}).Write(w)
+}
+
+// proxyAuth returns the identity carried by the Proxy-Authorization header.
+func (s *Server) proxyAuth(req *http.Request) (username string, ok bool) {
+	if s.Credentials == nil {
+		return "", true
+	}
+	username, password, ok := parseBasicAuth(req.Header.Get("Proxy-Authorization"))
+	if !ok || !s.valid(username, password) {
+		return "", false
+	}
+	return username, true
+}
+
+// parseBasicAuth parses a Basic authorization header value, the same way net/http does for Authorization.
+func parseBasicAuth(auth string) (username, password string, ok bool) {
+	r := http.Request{Header: http.Header{"Authorization": {auth}}}
+	return r.BasicAuth()
+}
+
+func (s *Server) serveHTTP(c *bufConn) error {
+	for {
+		s.startHandshake(c)
+		req, err := http.ReadRequest(c.r)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		s.endHandshake(c)
+
+		username, ok := s.proxyAuth(req)
+		if !ok {
+			if err = writeHTTPStatus(c, http.StatusProxyAuthRequired, http.Header{
+				"Proxy-Authenticate": {`Basic realm="socks"`},
+			}); err != nil || req.Close {
+				return err
+			}
+			if !discardBody(req) {
+				return nil
+			}
+			continue
+		}
+
+		if req.Method == http.MethodConnect {
+			return s.serveHTTPConnect(c, req, username)
+		}
+
+		if keepAlive, err := s.serveHTTPForward(c, req, username); err != nil || !keepAlive {
+			return err
+		}
+	}
+}
+
+// discardBody discards the body of a request the next one follows, it reports false if
+// the body is too long or unreadable, the connection is then to be closed.
+func discardBody(req *http.Request) bool {
+	n, err := io.Copy(io.Discard, io.LimitReader(req.Body, maxDiscard+1))
+	return err == nil && n <= maxDiscard
+}
+
+// httpRequest converts the target of an HTTP request into a Request.
+func httpRequest(c net.Conn, host, defaultPort, username string) (*Request, bool) {
+	h, p, err := net.SplitHostPort(host)
+	if err != nil {
+		h, p = host, defaultPort
+	}
+	port, err := strconv.ParseUint(p, 10, 16)
+	if err != nil || h == "" {
+		return nil, false
+	}
+	return &Request{
+		Protocol:   SchemeHTTP,
+		Command:    s5.CommandConnect,
+		Host:       strings.TrimSuffix(strings.TrimPrefix(h, "["), "]"),
+		Port:       uint16(port),
+		Username:   username,
+		RemoteAddr: c.RemoteAddr(),
+	}, true
+}
+
+func (s *Server) serveHTTPConnect(c *bufConn, req *http.Request, username string) error {
+	r, ok := httpRequest(c, req.Host, "443", username)
+	if !ok {
+		return writeHTTPStatus(c, http.StatusBadRequest, nil)
+	}
+
+	target, status := s.connect(c.ctx, r)
+	if status != s5.ReplySuccess {
+		if err := writeHTTPStatus(c, httpStatus(status), nil); err != nil {
+			return err
+		}
+		return status.Error()
+	}
+
+	if _, err := io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		target.Close()
+		return err
+	}
+
+	s.relay(c, target)
+	return nil
+}
+
+// serveHTTPForward proxies a request in absolute-form to the origin server, a new upstream connection is used per request.
+func (s *Server) serveHTTPForward(c *bufConn, req *http.Request, username string) (keepAlive bool, err error) {
+	if req.URL.Scheme != "http" || req.URL.Host == "" {
+		return false, writeHTTPStatus(c, http.StatusBadRequest, nil)
+	}
+
+	r, ok := httpRequest(c, req.URL.Host, "80", username)
+	if !ok {
+		return false, writeHTTPStatus(c, http.StatusBadRequest, nil)
+	}
+
+	target, status := s.connect(c.ctx, r)
+	if status != s5.ReplySuccess {
+		if err = writeHTTPStatus(c, httpStatus(status), nil); err != nil {
+			return
+		}
+		return !req.Close && discardBody(req), nil
+	}
+	defer target.Close()
+
+	for _, h := range hopHeaders {
+		req.Header.Del(h)
+	}
+	if err = req.Write(target); err != nil {
+		return
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(target), req)
+	if err != nil {
+		return false, writeHTTPStatus(c, http.StatusBadGateway, nil)
+	}
+	defer resp.Body.Close()
+
+	for _, h := range hopHeaders {
+		resp.Header.Del(h)
+	}
+	resp.Close = resp.Close || req.Close
+	if err = resp.Write(c); err != nil {
+		return
+	}
+	return !resp.Close, nil
+}
@@ -0,0 +1,62 @@
+package socks
+
+import (
+	"net/netip"
+
+	"github.com/kayabe/socks/s4"
+	"github.com/kayabe/socks/s5"
+)
+
+func (s *Server) serveV4(c *bufConn) (err error) {
+	var req s4.Request
+	if err = req.Unpack(c); err != nil {
+		return
+	}
+	s.endHandshake(c)
+
+	r := &Request{
+		Protocol:   SchemeSOCKS4,
+		Command:    req.Command,
+		Host:       netip.AddrFrom4(req.IP).String(),
+		Port:       req.Port,
+		Username:   string(req.UserID),
+		RemoteAddr: c.RemoteAddr(),
+	}
+	if req.IsV4A() {
+		r.Protocol, r.Host = SchemeSOCKS4A, string(req.Domain)
+	}
+
+	reply := s4.Reply{Status: s4.ReplyRejected}
+
+	// SOCKS4 can not carry a password, the USERID is validated on its own
+	if !s.valid(r.Username, "") {
+		_ = reply.Pack(c)
+		return ErrAuthFailed
+	}
+
+	if r.Command != s4.CommandConnect {
+		_ = reply.Pack(c)
+		return s5.ErrReplyCommandNotSupported
+	}
+
+	target, status := s.connect(c.ctx, r)
+	if status == s5.ReplySuccess {
+		reply.Status = s4.ReplyGranted
+		// only an IPv4 address fits
+		if bind := s.bindAddr(target); bind.Addr().Unmap().Is4() {
+			reply.IP, reply.Port = bind.Addr().Unmap().As4(), bind.Port()
+		}
+	}
+	if err = reply.Pack(c); err != nil || status != s5.ReplySuccess {
+		if target != nil {
+			target.Close()
+		}
+		if err == nil {
+			err = status.Error()
+		}
+		return
+	}
+
+	s.relay(c, target)
+	return nil
+}
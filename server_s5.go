@@ -0,0 +1,106 @@
+package socks
+
+import "github.com/kayabe/socks/s5"
+
+// selectMethod picks the authentication method out of the methods offered by the client.
+func (s *Server) selectMethod(methods []s5.AuthMethod) s5.AuthMethod {
+	want := s5.MethodAuthNone
+	if s.Credentials != nil {
+		want = s5.MethodAuthUserPW
+	}
+	for _, m := range methods {
+		if m == want {
+			return m
+		}
+	}
+	return s5.MethodAuthNoneAcceptable
+}
+
+func (s *Server) serveV5(c *bufConn) (err error) {
+	var handshake s5.HandshakeRequest
+	if err = handshake.Unpack(c); err != nil {
+		return
+	}
+	if err = handshake.Validate(s.Validation); err != nil {
+		return
+	}
+
+	method := s.selectMethod(handshake.Methods)
+	if err = (&s5.HandshakeReply{Version: s5.VERSION, Method: method}).Pack(c); err != nil {
+		return
+	}
+
+	var username string
+
+	switch method {
+	case s5.MethodAuthNoneAcceptable:
+		return s5.ErrAuthNoneAcceptable
+	case s5.MethodAuthUserPW:
+		var auth s5.AuthUserPW
+		if err = auth.Unpack(c); err != nil {
+			return
+		}
+		reply := s5.AuthReply{Version: s5.AuthUserPWVersion, Status: s5.ReplySuccess}
+		if auth.Validate(s.Validation) != nil || !s.valid(string(auth.Username), string(auth.Password)) {
+			reply.Status = s5.ReplyGeneralFailure
+		}
+		if err = reply.Pack(c); err != nil {
+			return
+		}
+		if reply.Status != s5.ReplySuccess {
+			return ErrAuthFailed
+		}
+		username = string(auth.Username)
+	}
+
+	var addr s5.Addr
+	var req = s5.Request{Destination: &addr}
+	if err = req.Unpack(c); err != nil {
+		if err == s5.ErrUnsupportedAddressType {
+			_ = (&s5.Reply{Status: s5.ReplyAddressTypeNotSupported}).Pack(c)
+		}
+		return
+	}
+	s.endHandshake(c)
+	if err = req.Validate(s.Validation); err != nil {
+		_ = (&s5.Reply{Status: s5.ReplyGeneralFailure}).Pack(c)
+		return
+	}
+
+	r := &Request{
+		Protocol:   SchemeSOCKS5,
+		Command:    req.Command,
+		Username:   username,
+		RemoteAddr: c.RemoteAddr(),
+	}
+
+	r.Host, r.Port = addr.IP.String(), addr.Port
+	if addr.Type == s5.AddressTypeDomainName {
+		// internationalized names are dialed in their punycode form
+		if r.Host, err = s5.ToASCII(addr.Host); err != nil {
+			_ = (&s5.Reply{Status: s5.ReplyGeneralFailure}).Pack(c)
+			return
+		}
+	}
+
+	if r.Command != s5.CommandConnect {
+		_ = (&s5.Reply{Status: s5.ReplyCommandNotSupported}).Pack(c)
+		return s5.ErrReplyCommandNotSupported
+	}
+
+	target, status := s.connect(c.ctx, r)
+	bind := s.bindAddr(target)
+	reply := &s5.Reply{Status: status, Bind: s5.AddrFromAddrPort(bind)}
+	if err = reply.Pack(c); err != nil || status != s5.ReplySuccess {
+		if target != nil {
+			target.Close()
+		}
+		if err == nil {
+			err = status.Error()
+		}
+		return
+	}
+
+	s.relay(c, target)
+	return nil
+}
@@ -0,0 +1,554 @@
+package socks
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kayabe/socks/proxyproto"
+	"github.com/kayabe/socks/s4"
+	"github.com/kayabe/socks/s5"
+	"github.com/stretchr/testify/assert"
+)
+
+// startEcho starts a TCP server echoing everything it reads.
+func startEcho(t *testing.T) *net.TCPAddr {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				_, _ = io.Copy(c, c)
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr)
+}
+
+// startServer serves s on a loopback listener.
+func startServer(t *testing.T, s *Server) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ErrorLog == nil {
+		s.ErrorLog = log.New(io.Discard, "", 0)
+	}
+	go func() { _ = s.Serve(l) }()
+	t.Cleanup(func() { s.Close() })
+	return l.Addr().String()
+}
+
+func assertEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "ping", string(buf))
+}
+
+func TestServerV5(t *testing.T) {
+	echo := startEcho(t)
+	addr := startServer(t, &Server{})
+
+	client, _ := NewClient(addr)
+	conn, err := client.Dial("tcp", echo.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	assertEcho(t, conn)
+}
+
+// startReplyAtEOF starts a TCP server sending back what it read once the client stopped writing.
+func startReplyAtEOF(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				b, _ := io.ReadAll(conn)
+				_, _ = conn.Write(b)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestServerHalfClose(t *testing.T) {
+	target := startReplyAtEOF(t)
+
+	for _, s := range []*Server{{}, {IdleTimeout: time.Minute}} {
+		client, _ := NewClient(startServer(t, s))
+		conn, err := client.Dial("tcp", target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, _ = conn.Write([]byte("request"))
+		assert.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+		// the end of the request reaches the target, whose reply still comes back
+		reply, err := io.ReadAll(conn)
+		assert.NoError(t, err)
+		assert.Equal(t, "request", string(reply))
+		conn.Close()
+	}
+}
+
+func TestServerV5UserPW(t *testing.T) {
+	echo := startEcho(t)
+
+	var got *Request
+	addr := startServer(t, &Server{
+		Credentials: StaticCredentials{"user": "pass"},
+		Rules: RuleFunc(func(_ context.Context, req *Request) bool {
+			got = req
+			return true
+		}),
+	})
+
+	client, _ := NewClient(addr, WithUserPW("user", "pass"))
+	conn, err := client.Dial("tcp", echo.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	assertEcho(t, conn)
+
+	assert.Equal(t, SchemeSOCKS5, got.Protocol)
+	assert.Equal(t, "user", got.Username)
+	assert.Equal(t, echo.String(), got.Address())
+
+	client, _ = NewClient(addr)
+	_, err = client.Dial("tcp", echo.String())
+	assert.Equal(t, s5.ErrAuthNoneAcceptable, err)
+
+	client, _ = NewClient(addr, WithUserPW("user", "wrong"))
+	_, err = client.Dial("tcp", echo.String())
+	assert.Equal(t, ErrAuthFailed, err)
+}
+
+func TestServerV5NotAllowed(t *testing.T) {
+	echo := startEcho(t)
+	addr := startServer(t, &Server{Rules: PermitNone})
+
+	client, _ := NewClient(addr)
+	_, err := client.Dial("tcp", echo.String())
+	assert.Equal(t, s5.ErrReplyConnectionNotAllowed, err)
+}
+
+func TestServerV5IDN(t *testing.T) {
+	hosts := make(chan string, 1)
+	addr := startServer(t, &Server{Rules: RuleFunc(func(_ context.Context, r *Request) bool {
+		hosts <- r.Host
+		return false
+	})})
+
+	client, _ := NewClient(addr)
+	_, err := client.Dial("tcp", "bücher.example:80")
+	assert.Equal(t, s5.ErrReplyConnectionNotAllowed, err)
+	assert.Equal(t, "xn--bcher-kva.example", <-hosts)
+}
+
+func TestServerV5Strict(t *testing.T) {
+	addr := startServer(t, &Server{Validation: s5.Strict})
+
+	client, _ := NewClient(addr)
+	_, err := client.Dial("tcp", "under_score.example:80")
+	assert.Equal(t, s5.ErrReplyGeneralFailure, err)
+}
+
+func TestServerV4(t *testing.T) {
+	echo := startEcho(t)
+	addr := startServer(t, &Server{})
+
+	for _, req := range []*s4.Request{
+		{Command: s4.CommandConnect, Port: uint16(echo.Port), IP: [4]byte{127, 0, 0, 1}, UserID: []byte("user")},
+		{Command: s4.CommandConnect, Port: uint16(echo.Port), Domain: []byte("localhost")},
+	} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = req.Pack(conn); err != nil {
+			t.Fatal(err)
+		}
+		var reply s4.Reply
+		if err = reply.Unpack(conn); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, s4.ReplyGranted, reply.Status)
+		assertEcho(t, conn)
+		conn.Close()
+	}
+}
+
+func TestServerV4Rejected(t *testing.T) {
+	echo := startEcho(t)
+	addr := startServer(t, &Server{Credentials: StaticCredentials{"user": "pass"}})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err = (&s4.Request{Command: s4.CommandConnect, Port: uint16(echo.Port), IP: [4]byte{127, 0, 0, 1}, UserID: []byte("user")}).Pack(conn); err != nil {
+		t.Fatal(err)
+	}
+	var reply s4.Reply
+	if err = reply.Unpack(conn); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, s4.ReplyRejected, reply.Status)
+}
+
+func TestServerBindReply(t *testing.T) {
+	echo := startEcho(t)
+	for _, mode := range []BindReply{BindLocal, BindIPv4Mapped, BindHidden} {
+		connected := make(chan net.Conn, 2)
+		addr := startServer(t, &Server{BindReply: mode, Connected: func(_ *Request, target net.Conn) { connected <- target }})
+
+		client, _ := NewClient(addr)
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.HandshakeV5(conn); err != nil {
+			t.Fatal(err)
+		}
+		reply, err := client.requestV5(conn, s5.CommandConnect, s5.AddrFromAddrPort(echo.AddrPort()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+		local := (<-connected).LocalAddr().(*net.TCPAddr).AddrPort()
+		bind := reply.Bind.AddrPort()
+
+		switch mode {
+		case BindLocal:
+			assert.Equal(t, local, bind)
+		case BindIPv4Mapped:
+			assert.Equal(t, "[::ffff:127.0.0.1]:"+strconv.Itoa(int(local.Port())), bind.String())
+		case BindHidden:
+			assert.Equal(t, "0.0.0.0:0", bind.String())
+		}
+
+		// SOCKS4 replies the IPv4 addresses
+		conn, err = net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = (&s4.Request{Command: s4.CommandConnect, Port: uint16(echo.Port), IP: [4]byte{127, 0, 0, 1}}).Pack(conn); err != nil {
+			t.Fatal(err)
+		}
+		var v4 s4.Reply
+		if err = v4.Unpack(conn); err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+		local = (<-connected).LocalAddr().(*net.TCPAddr).AddrPort()
+		if mode == BindHidden {
+			assert.Equal(t, "0.0.0.0:0", netip.AddrPortFrom(netip.AddrFrom4(v4.IP), v4.Port).String())
+		} else {
+			assert.Equal(t, local, netip.AddrPortFrom(netip.AddrFrom4(v4.IP), v4.Port))
+		}
+	}
+}
+
+func TestServerHTTPConnect(t *testing.T) {
+	echo := startEcho(t)
+	addr := startServer(t, &Server{Credentials: StaticCredentials{"user": "pass"}})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	req, _ := http.NewRequest(http.MethodConnect, "", nil)
+	req.Host = echo.String()
+	req.SetBasicAuth("user", "pass")
+	req.Header["Proxy-Authorization"] = req.Header["Authorization"]
+	if err = req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assertEcho(t, &bufConn{Conn: conn, r: br})
+}
+
+func TestServerHTTPForward(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Path", r.URL.Path)
+		_, _ = io.WriteString(w, "hello")
+	}))
+	defer origin.Close()
+
+	addr := startServer(t, &Server{Credentials: StaticCredentials{"user": "pass"}})
+
+	proxyURL, _ := url.Parse("http://user:pass@" + addr)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	resp, err := client.Get(origin.URL + "/path")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "/path", resp.Header.Get("X-Path"))
+	assert.Equal(t, "hello", string(body))
+}
+
+func TestServerHTTPForwardRefused(t *testing.T) {
+	addr := startServer(t, &Server{Rules: PermitNone})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// the body of the refused request isn't read as the next request
+	br := bufio.NewReader(conn)
+	for _, body := range []string{"not a request\r\n", ""} {
+		req, _ := http.NewRequest(http.MethodPost, "http://127.0.0.1:1/", strings.NewReader(body))
+		if err = req.WriteProxy(conn); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.ReadResponse(br, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	}
+}
+
+func TestServerHTTPProxyAuthRequired(t *testing.T) {
+	addr := startServer(t, &Server{Credentials: StaticCredentials{"user": "pass"}})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	req, _ := http.NewRequest(http.MethodConnect, "", nil)
+	req.Host = "127.0.0.1:1"
+	if err = req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, http.StatusProxyAuthRequired, resp.StatusCode)
+	assert.Equal(t, `Basic realm="socks"`, resp.Header.Get("Proxy-Authenticate"))
+
+	// the body of the refused request is discarded before the next one
+	for _, body := range []string{"not a request\r\n", ""} {
+		req, _ = http.NewRequest(http.MethodPost, "http://127.0.0.1:1/", strings.NewReader(body))
+		if err = req.Write(conn); err != nil {
+			t.Fatal(err)
+		}
+		resp, err = http.ReadResponse(br, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, http.StatusProxyAuthRequired, resp.StatusCode)
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(l) }()
+
+	time.Sleep(10 * time.Millisecond)
+	assert.NoError(t, s.Shutdown(context.Background()))
+	assert.Equal(t, ErrServerClosed, <-done)
+	assert.Equal(t, ErrServerClosed, s.ListenAndServe())
+}
+
+func TestServerProxyProtocol(t *testing.T) {
+	remote := make(chan net.Addr, 1)
+	addr := startServer(t, &Server{
+		TrustedProxies: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+		Rules:          RuleFunc(func(_ context.Context, req *Request) bool { remote <- req.RemoteAddr; return false }),
+	})
+
+	// the address of the client is told by the trusted load balancer
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("PROXY TCP4 192.0.2.1 127.0.0.1 56324 1080\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	client, _ := NewClient(addr)
+	if err := client.HandshakeV5(conn); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = client.requestV5(conn, s5.CommandConnect, s5.AddrFromAddrPort(netip.MustParseAddrPort("198.51.100.1:80")))
+	assert.Equal(t, "192.0.2.1:56324", (<-remote).String())
+}
+
+func TestServerProxyHeader(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	headers := make(chan *proxyproto.Header, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		r := bufio.NewReader(c)
+		h, _ := proxyproto.Read(r)
+		headers <- h
+		_, _ = io.Copy(c, r)
+	}()
+
+	addr := startServer(t, &Server{Credentials: StaticCredentials{"alice": "a"}, ProxyHeader: ParseNoProxy("127.0.0.1")})
+	client, _ := NewClient(addr, WithUserPW("alice", "a"))
+	conn, err := client.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	assertEcho(t, conn)
+
+	h := <-headers
+	if assert.NotNil(t, h) {
+		assert.Equal(t, proxyproto.CommandProxy, h.Command)
+		assert.Equal(t, conn.LocalAddr().String(), h.Source.String())
+		assert.Equal(t, l.Addr().String(), h.Destination.String())
+		username, _ := h.TLV(proxyproto.TLVUsername)
+		assert.Equal(t, "alice", string(username))
+	}
+}
+
+func TestServerTimeouts(t *testing.T) {
+	echo := startEcho(t)
+	closed := make(chan error, 1)
+	dial := func(s *Server) net.Conn {
+		t.Helper()
+		s.Closed = func(_ net.Conn, err error) { closed <- err }
+		addr := startServer(t, s)
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	connect := func(s *Server) net.Conn {
+		t.Helper()
+		conn := dial(s)
+		client, _ := NewClient(conn.RemoteAddr().String())
+		if err := client.HandshakeV5(conn); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.requestV5(conn, s5.CommandConnect, s5.AddrFromAddrPort(echo.AddrPort())); err != nil {
+			t.Fatal(err)
+		}
+		return conn
+	}
+	assertClosed := func(conn net.Conn, reason error) {
+		t.Helper()
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err := io.ReadAll(conn)
+		assert.NoError(t, err, "closed by the server")
+		assert.Equal(t, reason, <-closed)
+	}
+
+	// the handshake is dribbled
+	conn := dial(&Server{HandshakeTimeout: 50 * time.Millisecond})
+	_, _ = conn.Write([]byte{s5.VERSION})
+	assertClosed(conn, ErrHandshakeTimeout)
+
+	// the handshake deadline doesn't apply to the relay, the idle timeout is reset by the traffic
+	conn = connect(&Server{HandshakeTimeout: 100 * time.Millisecond, IdleTimeout: 200 * time.Millisecond})
+	for i := 0; i < 6; i++ {
+		assertEcho(t, conn)
+		time.Sleep(50 * time.Millisecond)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	assertClosed(conn, ErrIdleTimeout)
+
+	conn = connect(&Server{MaxLifetime: 200 * time.Millisecond})
+	assertEcho(t, conn)
+	assertClosed(conn, ErrMaxLifetime)
+
+	// the lifetime also aborts the dialing
+	canceled := make(chan error, 1)
+	conn = dial(&Server{MaxLifetime: 100 * time.Millisecond, Dialer: dialerFunc(func(ctx context.Context, _, _ string) (net.Conn, error) {
+		<-ctx.Done()
+		canceled <- context.Cause(ctx)
+		return nil, ctx.Err()
+	})})
+	client, _ := NewClient(conn.RemoteAddr().String())
+	if err := client.HandshakeV5(conn); err != nil {
+		t.Fatal(err)
+	}
+	addr := s5.AddrFromAddrPort(echo.AddrPort())
+	_ = (&s5.Request{Command: s5.CommandConnect, Destination: &addr}).Pack(conn)
+	assertClosed(conn, ErrMaxLifetime)
+	assert.Equal(t, ErrMaxLifetime, <-canceled)
+
+	// without timeouts, the connections end normally
+	conn = connect(&Server{})
+	assertEcho(t, conn)
+	conn.Close()
+	assert.NoError(t, <-closed)
+}
@@ -0,0 +1,106 @@
+package sockstest
+
+import (
+	"net"
+	"time"
+
+	"github.com/kayabe/socks"
+	"github.com/kayabe/socks/s5"
+)
+
+// Stage of a SOCKS connection, at which a Script can misbehave.
+type Stage uint8
+
+const (
+	StageNone      Stage = iota // never
+	StageHandshake              // the method selection
+	StageAuth                   // the username/password authentication
+	StageRequest                // the request and its reply
+	StageRelay                  // the data relayed to the Handler
+)
+
+// Script describes how a Server behaves, the zero Script is a well-behaved server
+// without authentication which replies ReplyHostUnreachable to requests without Handler.
+type Script struct {
+	// Method is the authentication method selected whatever the client offers,
+	// s5.MethodAuthNoneAcceptable rejects the client.
+	Method s5.AuthMethod
+
+	// Credentials are checked with s5.MethodAuthUserPW, any username and password are accepted if nil.
+	Credentials map[string]string
+
+	// Status is the reply to every request when set, or the result of StatusFunc when set.
+	// Otherwise requests with a Handler succeed and the others fail with ReplyHostUnreachable.
+	Status     s5.ReplyStatus
+	StatusFunc func(r *socks.Request) s5.ReplyStatus
+
+	// Bind is the BND.ADDR and BND.PORT of the replies.
+	Bind s5.Addr
+
+	// Delay is waited before writing every reply.
+	Delay time.Duration
+
+	// ChunkSize splits the replies into partial writes of at most this many bytes, ChunkDelay apart.
+	ChunkSize  int
+	ChunkDelay time.Duration
+
+	// Malformed corrupts the version field of the reply written at this stage.
+	Malformed Stage
+
+	// Disconnect closes the connection at this stage, instead of replying. At StageRelay,
+	// the connection is closed once DisconnectAfter bytes were relayed in either direction.
+	Disconnect      Stage
+	DisconnectAfter int
+
+	// Handler serves CONNECTs to addresses without a handler registered with Server.Handle.
+	Handler Handler
+}
+
+// status returns the reply status for the request, h being the handler found for it.
+func (sc *Script) status(r *socks.Request, h Handler) s5.ReplyStatus {
+	switch {
+	case sc.StatusFunc != nil:
+		return sc.StatusFunc(r)
+	case sc.Status != s5.ReplySuccess:
+		return sc.Status
+	case h == nil:
+		return s5.ReplyHostUnreachable
+	}
+	return s5.ReplySuccess
+}
+
+// valid checks the credentials.
+func (sc *Script) valid(username, password string) bool {
+	if sc.Credentials == nil {
+		return true
+	}
+	pw, ok := sc.Credentials[username]
+	return ok && pw == password
+}
+
+// Handler serves the connections a Server accepted the CONNECT of, in place of the destination.
+type Handler interface {
+	ServeSOCKS(conn net.Conn, r *socks.Request)
+}
+
+// HandlerFunc adapts a function to a Handler.
+type HandlerFunc func(conn net.Conn, r *socks.Request)
+
+// ServeSOCKS calls f(conn, r).
+func (f HandlerFunc) ServeSOCKS(conn net.Conn, r *socks.Request) { f(conn, r) }
+
+// EchoHandler writes back everything it reads.
+var EchoHandler Handler = HandlerFunc(func(conn net.Conn, _ *socks.Request) {
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := conn.Read(buf)
+		if n > 0 {
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+})
@@ -0,0 +1,380 @@
+// Package sockstest provides a scriptable SOCKS server for testing code which dials through socks.Client,
+// the way net/http/httptest does for HTTP.
+package sockstest
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/kayabe/socks"
+	"github.com/kayabe/socks/s4"
+	"github.com/kayabe/socks/s5"
+)
+
+// Server is a SOCKS5, SOCKS4 and SOCKS4A server following a Script, listening on a loopback address.
+// It can be reached in memory as well, through DialContext.
+type Server struct {
+	Addr     string // address of the listener, host:port
+	Listener net.Listener
+
+	mu       sync.Mutex
+	script   Script
+	handlers map[string]Handler
+	requests []socks.Request
+	conns    map[net.Conn]struct{}
+	closed   bool
+
+	wg sync.WaitGroup
+}
+
+// NewServer starts a Server on a loopback address, the caller has to Close it.
+func NewServer(script Script) *Server {
+	s := NewUnstartedServer(script)
+	s.Start()
+	return s
+}
+
+// NewUnstartedServer returns a Server listening on a loopback address, which doesn't accept
+// connections until Start is called. It can be reached in memory meanwhile.
+func NewUnstartedServer(script Script) *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		if l, err = net.Listen("tcp6", "[::1]:0"); err != nil {
+			panic("sockstest: failed to listen on a port: " + err.Error())
+		}
+	}
+	return &Server{
+		Addr:     l.Addr().String(),
+		Listener: l,
+		script:   script,
+		handlers: make(map[string]Handler),
+		conns:    make(map[net.Conn]struct{}),
+	}
+}
+
+// Start accepts connections on the listener.
+func (s *Server) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := s.Listener.Accept()
+			if err != nil {
+				return
+			}
+			s.serve(conn)
+		}
+	}()
+}
+
+// Close closes the listener and the connections, and waits for the handlers to return.
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closed = true
+	s.Listener.Close()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// SetScript changes the behavior for the connections accepted afterwards.
+func (s *Server) SetScript(script Script) {
+	s.mu.Lock()
+	s.script = script
+	s.mu.Unlock()
+}
+
+// Handle serves the CONNECTs to address, a "host:port" as sent by the client, with h.
+func (s *Server) Handle(address string, h Handler) {
+	s.mu.Lock()
+	s.handlers[address] = h
+	s.mu.Unlock()
+}
+
+// Requests returns the requests received so far, in order.
+func (s *Server) Requests() []socks.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]socks.Request(nil), s.requests...)
+}
+
+// DialContext connects to the server in memory, the network and address are ignored.
+// It lets a socks.Client reach the server through WithUpstream.
+func (s *Server) DialContext(ctx context.Context, _, _ string) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	client, server := net.Pipe()
+	s.serve(server)
+	return client, nil
+}
+
+// Client returns a SOCKS5 client reaching the server in memory, options are applied afterwards.
+func (s *Server) Client(options ...func(*socks.Client)) *socks.Client {
+	client, _ := socks.NewClient(s.Addr, append([]func(*socks.Client){socks.WithUpstream(s)}, options...)...)
+	return client
+}
+
+// serve serves conn in a new goroutine, unless the server is closed.
+func (s *Server) serve(conn net.Conn) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.conns[conn] = struct{}{}
+	sc := s.script
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+			conn.Close()
+		}()
+
+		c := &scriptConn{Conn: conn, r: bufio.NewReader(conn), script: &sc}
+		version, err := c.r.Peek(1)
+		if err != nil {
+			return
+		}
+		switch version[0] {
+		case s5.VERSION:
+			s.serveV5(c)
+		case s4.VERSION:
+			s.serveV4(c)
+		}
+	}()
+}
+
+// record stores the request and returns its handler.
+func (s *Server) record(r *socks.Request, sc *Script) Handler {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, *r)
+	if h, ok := s.handlers[r.Address()]; ok {
+		return h
+	}
+	return sc.Handler
+}
+
+func (s *Server) serveV5(c *scriptConn) {
+	sc := c.script
+
+	var handshake s5.HandshakeRequest
+	if handshake.Unpack(c.r) != nil || sc.Disconnect == StageHandshake {
+		return
+	}
+	if !c.reply(StageHandshake, (&s5.HandshakeReply{Version: s5.VERSION, Method: sc.Method}).AppendBinary(nil)) {
+		return
+	}
+
+	var username string
+
+	switch sc.Method {
+	case s5.MethodAuthNone:
+	case s5.MethodAuthUserPW:
+		var auth s5.AuthUserPW
+		if auth.Unpack(c.r) != nil || sc.Disconnect == StageAuth {
+			return
+		}
+		reply := s5.AuthReply{Version: s5.AuthUserPWVersion, Status: s5.ReplySuccess}
+		if !sc.valid(string(auth.Username), string(auth.Password)) {
+			reply.Status = s5.ReplyGeneralFailure
+		}
+		if !c.reply(StageAuth, reply.AppendBinary(nil)) || reply.Status != s5.ReplySuccess {
+			return
+		}
+		username = string(auth.Username)
+	default:
+		return
+	}
+
+	var addr s5.Addr
+	var req = s5.Request{Destination: &addr}
+	if err := req.Unpack(c.r); err != nil {
+		if err == s5.ErrUnsupportedAddressType {
+			c.reply(StageRequest, (&s5.Reply{Status: s5.ReplyAddressTypeNotSupported}).AppendBinary(nil))
+		}
+		return
+	}
+
+	r := &socks.Request{
+		Protocol:   socks.SchemeSOCKS5,
+		Command:    req.Command,
+		Host:       addr.Host,
+		Port:       addr.Port,
+		Username:   username,
+		RemoteAddr: c.RemoteAddr(),
+	}
+	if addr.Type != s5.AddressTypeDomainName {
+		r.Host = addr.IP.String()
+	}
+
+	h := s.record(r, sc)
+	if sc.Disconnect == StageRequest {
+		return
+	}
+	if r.Command != s5.CommandConnect {
+		h = nil
+	}
+
+	status := sc.status(r, h)
+	if !c.reply(StageRequest, (&s5.Reply{Status: status, Bind: sc.Bind}).AppendBinary(nil)) || status != s5.ReplySuccess || h == nil {
+		return
+	}
+	c.relay(h, r)
+}
+
+func (s *Server) serveV4(c *scriptConn) {
+	sc := c.script
+
+	var req s4.Request
+	if req.Unpack(c.r) != nil {
+		return
+	}
+
+	r := &socks.Request{
+		Protocol:   socks.SchemeSOCKS4,
+		Command:    req.Command,
+		Host:       netip.AddrFrom4(req.IP).String(),
+		Port:       req.Port,
+		Username:   string(req.UserID),
+		RemoteAddr: c.RemoteAddr(),
+	}
+	if req.IsV4A() {
+		r.Protocol, r.Host = socks.SchemeSOCKS4A, string(req.Domain)
+	}
+
+	h := s.record(r, sc)
+	if sc.Disconnect == StageRequest {
+		return
+	}
+	if r.Command != s4.CommandConnect {
+		h = nil
+	}
+
+	reply := s4.Reply{Status: s4.ReplyRejected, Port: sc.Bind.Port}
+	if sc.Bind.IP.Is4() {
+		reply.IP = sc.Bind.IP.As4()
+	}
+	if sc.status(r, h) == s5.ReplySuccess {
+		reply.Status = s4.ReplyGranted
+	}
+
+	var frame = []byte{s4.ReplyVersion, byte(reply.Status), byte(reply.Port >> 8), byte(reply.Port)}
+	if !c.reply(StageRequest, append(frame, reply.IP[:]...)) || reply.Status != s4.ReplyGranted || h == nil {
+		return
+	}
+	c.relay(h, r)
+}
+
+// scriptConn applies the Script to the replies and relayed data.
+type scriptConn struct {
+	net.Conn
+	r      *bufio.Reader
+	script *Script
+
+	mu      sync.Mutex
+	relayed int // bytes relayed in either direction
+}
+
+// reply writes a reply frame as scripted, it reports whether the connection should go on.
+func (c *scriptConn) reply(stage Stage, frame []byte) bool {
+	sc := c.script
+	if sc.Delay > 0 {
+		time.Sleep(sc.Delay)
+	}
+	if sc.Malformed == stage {
+		frame[0] = 0xFF
+	}
+
+	chunk := sc.ChunkSize
+	if chunk <= 0 {
+		chunk = len(frame)
+	}
+	for len(frame) > 0 {
+		n := chunk
+		if n > len(frame) {
+			n = len(frame)
+		}
+		if _, err := c.Conn.Write(frame[:n]); err != nil {
+			return false
+		}
+		if frame = frame[n:]; len(frame) > 0 && sc.ChunkDelay > 0 {
+			time.Sleep(sc.ChunkDelay)
+		}
+	}
+	return sc.Malformed != stage
+}
+
+// relay hands the connection over to h.
+func (c *scriptConn) relay(h Handler, r *socks.Request) {
+	if c.script.Disconnect == StageRelay && c.script.DisconnectAfter <= 0 {
+		return
+	}
+	h.ServeSOCKS(c, r)
+}
+
+// Read reads the client data, buffered ones included.
+func (c *scriptConn) Read(b []byte) (int, error) {
+	b = b[:c.allow(len(b))]
+	if len(b) == 0 {
+		return 0, net.ErrClosed
+	}
+	n, err := c.r.Read(b)
+	c.count(n)
+	return n, err
+}
+
+// Write writes data to the client.
+func (c *scriptConn) Write(b []byte) (int, error) {
+	allowed := c.allow(len(b))
+	n, err := c.Conn.Write(b[:allowed])
+	c.count(n)
+	if err == nil && allowed < len(b) {
+		err = net.ErrClosed
+	}
+	return n, err
+}
+
+// allow returns how many of n bytes can be relayed before the scripted disconnect.
+func (c *scriptConn) allow(n int) int {
+	if c.script.Disconnect != StageRelay {
+		return n
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	left := c.script.DisconnectAfter - c.relayed
+	switch {
+	case left < 0:
+		return 0
+	case n > left:
+		return left
+	}
+	return n
+}
+
+// count adds n relayed bytes, and closes the connection once the scripted limit is reached.
+func (c *scriptConn) count(n int) {
+	if c.script.Disconnect != StageRelay {
+		return
+	}
+	c.mu.Lock()
+	c.relayed += n
+	done := c.relayed >= c.script.DisconnectAfter
+	c.mu.Unlock()
+	if done {
+		c.Conn.Close()
+	}
+}
@@ -0,0 +1,189 @@
+package sockstest
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/kayabe/socks"
+	"github.com/kayabe/socks/s4"
+	"github.com/kayabe/socks/s5"
+	"github.com/stretchr/testify/assert"
+)
+
+func assertEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "ping", string(buf))
+}
+
+func TestServerInMemory(t *testing.T) {
+	s := NewServer(Script{Handler: EchoHandler})
+	defer s.Close()
+
+	conn, err := s.Client().Dial("tcp", "example.com:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	assertEcho(t, conn)
+
+	requests := s.Requests()
+	assert.Len(t, requests, 1)
+	assert.Equal(t, socks.SchemeSOCKS5, requests[0].Protocol)
+	assert.Equal(t, s5.CommandConnect, requests[0].Command)
+	assert.Equal(t, "example.com:80", requests[0].Address())
+}
+
+func TestServerLoopback(t *testing.T) {
+	s := NewServer(Script{Handler: EchoHandler})
+	defer s.Close()
+
+	for _, version := range []socks.Protocol{socks.V5, socks.V4, socks.V4A} {
+		client, _ := socks.NewClient(s.Addr, socks.WithVersion(version))
+		conn, err := client.Dial("tcp", "127.0.0.1:80")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEcho(t, conn)
+		conn.Close()
+	}
+	assert.Len(t, s.Requests(), 3)
+}
+
+func TestServerHandle(t *testing.T) {
+	s := NewServer(Script{})
+	defer s.Close()
+
+	s.Handle("example.com:443", HandlerFunc(func(conn net.Conn, r *socks.Request) {
+		_, _ = conn.Write([]byte(r.Host))
+	}))
+
+	conn, err := s.Client().Dial("tcp", "example.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := io.ReadAll(conn)
+	assert.Equal(t, "example.com", string(got))
+
+	// without a handler
+	_, err = s.Client().Dial("tcp", "example.com:80")
+	assert.Equal(t, s5.ErrReplyHostUnreachable, err)
+}
+
+func TestServerUserPW(t *testing.T) {
+	s := NewServer(Script{Method: s5.MethodAuthUserPW, Credentials: map[string]string{"user": "pass"}, Handler: EchoHandler})
+	defer s.Close()
+
+	_, err := s.Client(socks.WithUserPW("user", "wrong")).Dial("tcp", "example.com:80")
+	assert.Error(t, err)
+
+	conn, err := s.Client(socks.WithUserPW("user", "pass")).Dial("tcp", "example.com:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	assert.Equal(t, "user", s.Requests()[0].Username)
+
+	s.SetScript(Script{Method: s5.MethodAuthNoneAcceptable})
+	_, err = s.Client().Dial("tcp", "example.com:80")
+	assert.Equal(t, s5.ErrAuthNoneAcceptable, err)
+}
+
+func TestServerStatus(t *testing.T) {
+	s := NewServer(Script{Status: s5.ReplyConnectionRefused, Handler: EchoHandler})
+	defer s.Close()
+
+	_, err := s.Client().Dial("tcp", "example.com:80")
+	assert.Equal(t, s5.ErrReplyConnectionRefused, err)
+
+	client, _ := socks.NewClient(s.Addr, socks.WithVersion(socks.V4))
+	_, err = client.Dial("tcp", "127.0.0.1:80")
+	assert.Equal(t, s4.ErrReplyRejected, err)
+
+	s.SetScript(Script{StatusFunc: func(r *socks.Request) s5.ReplyStatus {
+		if r.Port == 80 {
+			return s5.ReplyConnectionNotAllowed
+		}
+		return s5.ReplySuccess
+	}, Handler: EchoHandler})
+
+	_, err = s.Client().Dial("tcp", "example.com:80")
+	assert.Equal(t, s5.ErrReplyConnectionNotAllowed, err)
+	conn, err := s.Client().Dial("tcp", "example.com:443")
+	assert.NoError(t, err)
+	conn.Close()
+}
+
+func TestServerMalformed(t *testing.T) {
+	s := NewServer(Script{Handler: EchoHandler})
+	defer s.Close()
+
+	for _, stage := range []Stage{StageHandshake, StageRequest} {
+		s.SetScript(Script{Malformed: stage, Handler: EchoHandler})
+		_, err := s.Client().Dial("tcp", "example.com:80")
+		assert.Equal(t, s5.ErrUnsupportedVersion, err, stage)
+	}
+
+	s.SetScript(Script{Malformed: StageAuth, Method: s5.MethodAuthUserPW, Handler: EchoHandler})
+	_, err := s.Client(socks.WithUserPW("user", "pass")).Dial("tcp", "example.com:80")
+	assert.Equal(t, s5.ErrAuthVersion, err)
+}
+
+func TestServerPartialWrites(t *testing.T) {
+	s := NewServer(Script{ChunkSize: 1, ChunkDelay: time.Millisecond, Method: s5.MethodAuthUserPW, Handler: EchoHandler})
+	defer s.Close()
+
+	conn, err := s.Client(socks.WithUserPW("user", "pass")).Dial("tcp", "example.com:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	assertEcho(t, conn)
+}
+
+func TestServerDelay(t *testing.T) {
+	s := NewServer(Script{Delay: 300 * time.Millisecond, Handler: EchoHandler})
+	defer s.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := s.Client().DialContext(ctx, "tcp", "example.com:80")
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestServerDisconnect(t *testing.T) {
+	s := NewServer(Script{})
+	defer s.Close()
+
+	for _, stage := range []Stage{StageHandshake, StageRequest} {
+		s.SetScript(Script{Disconnect: stage, Handler: EchoHandler})
+		_, err := s.Client().Dial("tcp", "example.com:80")
+		assert.Error(t, err, stage)
+	}
+	assert.Len(t, s.Requests(), 1, "the request is recorded before disconnecting")
+
+	// mid-stream, after "pi" was read and "pi" echoed
+	s.SetScript(Script{Disconnect: StageRelay, DisconnectAfter: 4, Handler: EchoHandler})
+	conn, err := s.Client().Dial("tcp", "example.com:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	go func() { _, _ = conn.Write([]byte("pi")) }()
+	got, err := io.ReadAll(conn)
+	assert.NoError(t, err)
+	assert.Equal(t, "pi", string(got))
+}
@@ -0,0 +1,39 @@
+//go:build !plan9
+
+package socks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/kayabe/socks/s5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplyStatusOf(t *testing.T) {
+	opError := func(err error) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", err)}
+	}
+	for _, tt := range []struct {
+		err    error
+		status s5.ReplyStatus
+	}{
+		{nil, s5.ReplySuccess},
+		{opError(syscall.ECONNREFUSED), s5.ReplyConnectionRefused},
+		{opError(syscall.ENETUNREACH), s5.ReplyNetworkUnreachable},
+		{opError(syscall.EHOSTUNREACH), s5.ReplyHostUnreachable},
+		{opError(syscall.ETIMEDOUT), s5.ReplyTTLExpired},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}, s5.ReplyTTLExpired},
+		{context.DeadlineExceeded, s5.ReplyTTLExpired},
+		{&net.DNSError{Err: "no such host", Name: "nowhere.invalid", IsNotFound: true}, s5.ReplyHostUnreachable},
+		{fmt.Errorf("upstream: %w", s5.ErrReplyConnectionNotAllowed), s5.ReplyConnectionNotAllowed},
+		{errors.New("other"), s5.ReplyGeneralFailure},
+	} {
+		assert.Equal(t, tt.status, ReplyStatusOf(tt.err), "%v", tt.err)
+	}
+}
@@ -0,0 +1,8 @@
+package socks
+
+import "github.com/kayabe/socks/s5"
+
+// errnoStatus maps nothing, Plan 9 reports its errors as strings.
+func errnoStatus(error) (s5.ReplyStatus, bool) {
+	return 0, false
+}
@@ -0,0 +1,30 @@
+//go:build js || wasip1
+
+package socks
+
+import (
+	"errors"
+	"syscall"
+
+	"github.com/kayabe/socks/s5"
+)
+
+// errnoStatuses maps the system errors of dialing to the reply statuses, without ENETDOWN
+// and EHOSTDOWN which js and wasip1 don't define.
+var errnoStatuses = map[syscall.Errno]s5.ReplyStatus{
+	syscall.ECONNREFUSED: s5.ReplyConnectionRefused,
+	syscall.ENETUNREACH:  s5.ReplyNetworkUnreachable,
+	syscall.EHOSTUNREACH: s5.ReplyHostUnreachable,
+	syscall.ETIMEDOUT:    s5.ReplyTTLExpired,
+	syscall.EACCES:       s5.ReplyConnectionNotAllowed,
+	syscall.EPERM:        s5.ReplyConnectionNotAllowed,
+}
+
+func errnoStatus(err error) (s5.ReplyStatus, bool) {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return 0, false
+	}
+	status, ok := errnoStatuses[errno]
+	return status, ok
+}
@@ -0,0 +1,28 @@
+package socks
+
+import (
+	"errors"
+	"syscall"
+
+	"github.com/kayabe/socks/s5"
+)
+
+// errnoStatuses maps the Winsock errors of dialing, which syscall doesn't name, to the reply statuses.
+var errnoStatuses = map[syscall.Errno]s5.ReplyStatus{
+	10061: s5.ReplyConnectionRefused,    // WSAECONNREFUSED
+	10051: s5.ReplyNetworkUnreachable,   // WSAENETUNREACH
+	10050: s5.ReplyNetworkUnreachable,   // WSAENETDOWN
+	10065: s5.ReplyHostUnreachable,      // WSAEHOSTUNREACH
+	10064: s5.ReplyHostUnreachable,      // WSAEHOSTDOWN
+	10060: s5.ReplyTTLExpired,           // WSAETIMEDOUT
+	10013: s5.ReplyConnectionNotAllowed, // WSAEACCES
+}
+
+func errnoStatus(err error) (s5.ReplyStatus, bool) {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return 0, false
+	}
+	status, ok := errnoStatuses[errno]
+	return status, ok
+}
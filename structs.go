@@ -1,13 +1,15 @@
 package socks
 
 type (
-	ProtocolV4  uint8
-	ProtocolV4A uint8
-	ProtocolV5  uint8
+	ProtocolV4   uint8
+	ProtocolV4A  uint8
+	ProtocolV5   uint8
+	ProtocolHTTP uint8
 )
 
 const (
-	V4  ProtocolV4  = 0x04
-	V4A ProtocolV4A = 0x04
-	V5  ProtocolV5  = 0x05
+	V4   ProtocolV4   = 0x04
+	V4A  ProtocolV4A  = 0x04
+	V5   ProtocolV5   = 0x05
+	HTTP ProtocolHTTP = 0x01 // HTTP/1.1 CONNECT
 )
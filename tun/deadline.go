@@ -0,0 +1,63 @@
+package tun
+
+import (
+	"sync"
+	"time"
+)
+
+// deadline is a read or write deadline, whose channel is closed once it is exceeded.
+type deadline struct {
+	mu       sync.Mutex
+	timer    *time.Timer
+	exceeded chan struct{}
+}
+
+func makeDeadline() deadline {
+	return deadline{exceeded: make(chan struct{})}
+}
+
+// set sets the deadline, none if t is zero.
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.exceeded // the timer closed it
+	}
+	d.timer = nil
+
+	closed := isClosed(d.exceeded)
+	if t.IsZero() {
+		if closed {
+			d.exceeded = make(chan struct{})
+		}
+		return
+	}
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.exceeded = make(chan struct{})
+		}
+		exceeded := d.exceeded
+		d.timer = time.AfterFunc(dur, func() { close(exceeded) })
+		return
+	}
+	if !closed {
+		close(d.exceeded)
+	}
+}
+
+// wait returns the channel closed once the deadline is exceeded.
+func (d *deadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.exceeded
+}
+
+func isClosed(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
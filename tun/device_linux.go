@@ -0,0 +1,59 @@
+package tun
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Device is a TUN device, reading and writing an IP packet at a time.
+type Device struct {
+	f    *os.File
+	name string
+}
+
+// Open attaches to the TUN device name, creating it if needed, which requires CAP_NET_ADMIN.
+// The kernel names a new device if name is empty or contains %d, as in "tun%d".
+func Open(name string) (*Device, error) {
+	if len(name) >= syscall.IFNAMSIZ {
+		return nil, os.NewSyscallError("ioctl", syscall.EINVAL)
+	}
+	fd, err := syscall.Open("/dev/net/tun", syscall.O_RDWR|syscall.O_CLOEXEC|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("open", err)
+	}
+
+	// struct ifreq, the name and then the flags
+	var ifr [40]byte
+	copy(ifr[:], name)
+	*(*uint16)(unsafe.Pointer(&ifr[syscall.IFNAMSIZ])) = syscall.IFF_TUN | syscall.IFF_NO_PI
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TUNSETIFF, uintptr(unsafe.Pointer(&ifr[0]))); errno != 0 {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("ioctl", errno)
+	}
+	name = string(ifr[:bytes.IndexByte(ifr[:syscall.IFNAMSIZ], 0)])
+
+	// being non-blocking, the file uses the runtime poller, and Close interrupts Read
+	return &Device{f: os.NewFile(uintptr(fd), "/dev/net/tun"), name: name}, nil
+}
+
+// Name returns the name of the device.
+func (d *Device) Name() string {
+	return d.name
+}
+
+// Read reads an IP packet.
+func (d *Device) Read(b []byte) (int, error) {
+	return d.f.Read(b)
+}
+
+// Write writes an IP packet.
+func (d *Device) Write(b []byte) (int, error) {
+	return d.f.Write(b)
+}
+
+// Close detaches from the device, which the kernel removes unless it is persistent.
+func (d *Device) Close() error {
+	return d.f.Close()
+}
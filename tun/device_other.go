@@ -0,0 +1,31 @@
+//go:build !linux
+
+package tun
+
+// Device is a TUN device, reading and writing an IP packet at a time.
+type Device struct{}
+
+// Open attaches to the TUN device name, it is only supported on Linux.
+func Open(string) (*Device, error) {
+	return nil, ErrUnsupported
+}
+
+// Name returns the name of the device.
+func (d *Device) Name() string {
+	return ""
+}
+
+// Read reads an IP packet.
+func (d *Device) Read([]byte) (int, error) {
+	return 0, ErrUnsupported
+}
+
+// Write writes an IP packet.
+func (d *Device) Write([]byte) (int, error) {
+	return 0, ErrUnsupported
+}
+
+// Close detaches from the device.
+func (d *Device) Close() error {
+	return ErrUnsupported
+}
@@ -0,0 +1,141 @@
+package tun
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+// IP protocol numbers.
+const (
+	protoTCP = 6
+	protoUDP = 17
+)
+
+const (
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+	tcpHeaderLen  = 20
+	udpHeaderLen  = 8
+	defaultTTL    = 64
+)
+
+// packet is an IP packet carrying TCP or UDP.
+type packet struct {
+	src, dst netip.Addr
+	proto    uint8
+	payload  []byte // the transport header and data
+}
+
+// parseIP parses an IPv4 or IPv6 packet, it reports false for the fragments, the IPv6 extension
+// headers, and the malformed packets.
+func parseIP(b []byte) (p packet, ok bool) {
+	if len(b) == 0 {
+		return
+	}
+	switch b[0] >> 4 {
+	case 4:
+		if len(b) < ipv4HeaderLen {
+			return
+		}
+		hlen := int(b[0]&0x0F) * 4
+		total := int(binary.BigEndian.Uint16(b[2:]))
+		if hlen < ipv4HeaderLen || total < hlen || total > len(b) {
+			return
+		}
+		// more fragments or a fragment offset
+		if binary.BigEndian.Uint16(b[6:])&0x3FFF != 0 {
+			return
+		}
+		p.proto = b[9]
+		p.src = netip.AddrFrom4([4]byte(b[12:16]))
+		p.dst = netip.AddrFrom4([4]byte(b[16:20]))
+		p.payload = b[hlen:total]
+	case 6:
+		if len(b) < ipv6HeaderLen {
+			return
+		}
+		total := ipv6HeaderLen + int(binary.BigEndian.Uint16(b[4:]))
+		if total > len(b) {
+			return
+		}
+		p.proto = b[6]
+		p.src = netip.AddrFrom16([16]byte(b[8:24]))
+		p.dst = netip.AddrFrom16([16]byte(b[24:40]))
+		p.payload = b[ipv6HeaderLen:total]
+	default:
+		return
+	}
+	return p, p.proto == protoTCP || p.proto == protoUDP
+}
+
+// appendIP appends an IP packet from src to dst carrying transport, whose checksum at
+// csumOffset it computes, IPv6 if the addresses are.
+func appendIP(b []byte, id uint16, src, dst netip.Addr, proto uint8, transport []byte, csumOffset int) []byte {
+	if src.Is4() {
+		h := make([]byte, ipv4HeaderLen)
+		h[0] = 0x45
+		binary.BigEndian.PutUint16(h[2:], uint16(ipv4HeaderLen+len(transport)))
+		binary.BigEndian.PutUint16(h[4:], id)
+		h[6] = 0x40 // don't fragment
+		h[8] = defaultTTL
+		h[9] = proto
+		a4, b4 := src.As4(), dst.As4()
+		copy(h[12:], a4[:])
+		copy(h[16:], b4[:])
+		binary.BigEndian.PutUint16(h[10:], finish(sum(0, h)))
+		b = append(b, h...)
+	} else {
+		h := make([]byte, ipv6HeaderLen)
+		h[0] = 0x60
+		binary.BigEndian.PutUint16(h[4:], uint16(len(transport)))
+		h[6] = proto
+		h[7] = defaultTTL
+		a16, b16 := src.As16(), dst.As16()
+		copy(h[8:], a16[:])
+		copy(h[24:], b16[:])
+		b = append(b, h...)
+	}
+
+	start := len(b)
+	b = append(b, transport...)
+	t := b[start:]
+	t[csumOffset], t[csumOffset+1] = 0, 0
+	csum := finish(sum(pseudoSum(src, dst, proto, len(t)), t))
+	if csum == 0 && proto == protoUDP {
+		csum = 0xFFFF
+	}
+	binary.BigEndian.PutUint16(t[csumOffset:], csum)
+	return b
+}
+
+// checksumValid reports whether the checksum of transport, the header and data carried by p, is right.
+func (p packet) checksumValid(transport []byte) bool {
+	return finish(sum(pseudoSum(p.src, p.dst, p.proto, len(transport)), transport)) == 0
+}
+
+// pseudoSum sums the pseudo header of the transport checksums.
+func pseudoSum(src, dst netip.Addr, proto uint8, length int) uint32 {
+	s := sum(0, src.AsSlice())
+	s = sum(s, dst.AsSlice())
+	return s + uint32(proto) + uint32(length)
+}
+
+// sum adds b to the ones' complement sum s.
+func sum(s uint32, b []byte) uint32 {
+	for len(b) >= 2 {
+		s += uint32(b[0])<<8 | uint32(b[1])
+		b = b[2:]
+	}
+	if len(b) == 1 {
+		s += uint32(b[0]) << 8
+	}
+	return s
+}
+
+// finish folds the sum s into a checksum.
+func finish(s uint32) uint16 {
+	for s > 0xFFFF {
+		s = s>>16 + s&0xFFFF
+	}
+	return ^uint16(s)
+}
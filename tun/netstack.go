@@ -0,0 +1,123 @@
+package tun
+
+import (
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultMTU is the MTU of the devices, when Netstack.MTU is zero.
+const DefaultMTU = 1500
+
+// DefaultMaxPending is the number of half-open TCP connections, when Netstack.MaxPending is zero.
+const DefaultMaxPending = 128
+
+// Netstack is a minimal userspace TCP/IP stack. It terminates TCP, without window scaling nor
+// selective acknowledgments, and UDP, over IPv4 and IPv6. It drops the other protocols, the
+// fragments and the segments or datagrams whose checksum is wrong, and resets the TCP segments
+// of unknown connections.
+type Netstack struct {
+	// MTU of the device, DefaultMTU if zero, which bounds the TCP segments.
+	MTU int
+
+	// MaxPending bounds the TCP connections waiting for the acknowledgment of their SYN-ACK,
+	// DefaultMaxPending if zero. The SYNs beyond it are reset.
+	MaxPending int
+}
+
+// Run implements Stack.
+func (s *Netstack) Run(dev io.ReadWriter, h Handler) error {
+	mtu := s.MTU
+	if mtu <= 0 {
+		mtu = DefaultMTU
+	}
+	maxPending := s.MaxPending
+	if maxPending <= 0 {
+		maxPending = DefaultMaxPending
+	}
+	st := &stack{
+		dev:        dev,
+		h:          h,
+		mtu:        mtu,
+		maxPending: maxPending,
+		tcp:        make(map[flowID]*tcpConn),
+		udp:        make(map[flowID]*udpConn),
+	}
+
+	buf := make([]byte, 0xFFFF)
+	for {
+		n, err := dev.Read(buf)
+		if err != nil {
+			st.close()
+			return err
+		}
+		st.input(buf[:n])
+	}
+}
+
+// flowID identifies a flow, by its source and destination.
+type flowID struct {
+	src, dst netip.AddrPort
+}
+
+// stack is the state of a running Netstack.
+type stack struct {
+	dev        io.Writer
+	h          Handler
+	mtu        int
+	maxPending int
+	id         atomic.Uint32 // of the IPv4 packets
+
+	wmu sync.Mutex // serializes the writes to dev
+
+	mu      sync.Mutex
+	tcp     map[flowID]*tcpConn
+	udp     map[flowID]*udpConn
+	pending int // the TCP connections in SYN-RECEIVED
+	closed  bool
+}
+
+func (s *stack) input(b []byte) {
+	p, ok := parseIP(b)
+	if !ok {
+		return
+	}
+	switch p.proto {
+	case protoTCP:
+		s.inputTCP(p)
+	case protoUDP:
+		s.inputUDP(p)
+	}
+}
+
+// write writes the IP packet carrying transport from src to dst.
+func (s *stack) write(src, dst netip.Addr, proto uint8, transport []byte, csumOffset int) {
+	b := appendIP(nil, uint16(s.id.Add(1)), src, dst, proto, transport, csumOffset)
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	_, _ = s.dev.Write(b)
+}
+
+// close ends all the flows, once reading the device failed.
+func (s *stack) close() {
+	s.mu.Lock()
+	s.closed = true
+	tcp := make([]*tcpConn, 0, len(s.tcp))
+	for _, c := range s.tcp {
+		tcp = append(tcp, c)
+	}
+	udp := make([]*udpConn, 0, len(s.udp))
+	for _, c := range s.udp {
+		udp = append(udp, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range tcp {
+		c.abort(net.ErrClosed, false)
+	}
+	for _, c := range udp {
+		c.Close()
+	}
+}
@@ -0,0 +1,379 @@
+package tun
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	client = netip.MustParseAddrPort("10.0.0.2:40000")
+	server = netip.MustParseAddrPort("192.0.2.7:80")
+)
+
+// fakeTUN is the kernel side of a device backed by a pipe, exchanging the packets with a Netstack.
+type fakeTUN struct {
+	t       *testing.T
+	kernel  net.Conn
+	peer    *stack // builds the packets of the kernel
+	packets chan packet
+	done    chan error
+}
+
+func runStack(t *testing.T, h Handler) *fakeTUN {
+	return runNetstack(t, &Netstack{}, h)
+}
+
+func runNetstack(t *testing.T, ns *Netstack, h Handler) *fakeTUN {
+	dev, kernel := net.Pipe()
+	k := &fakeTUN{t: t, kernel: kernel, peer: &stack{dev: kernel}, packets: make(chan packet, 1024), done: make(chan error, 1)}
+	go func() { k.done <- ns.Run(dev, h) }()
+	go func() {
+		defer close(k.packets)
+		for {
+			buf := make([]byte, 0xFFFF)
+			n, err := kernel.Read(buf)
+			if err != nil {
+				return
+			}
+			p, ok := parseIP(buf[:n])
+			if !ok {
+				t.Errorf("invalid packet % x", buf[:n])
+				continue
+			}
+			k.packets <- p
+		}
+	}()
+	t.Cleanup(func() {
+		kernel.Close()
+		dev.Close()
+	})
+	return k
+}
+
+// sendTCP sends a segment from src to dst.
+func (k *fakeTUN) sendTCP(src, dst netip.AddrPort, seq, ack uint32, flags uint8, data []byte, opts ...byte) {
+	k.peer.writeTCP(flowID{src: dst, dst: src}, seq, ack, flags, 0xFFFF, opts, data)
+}
+
+// next returns the next packet from the stack, after checking its checksum.
+func (k *fakeTUN) next() packet {
+	k.t.Helper()
+	select {
+	case p, ok := <-k.packets:
+		if !ok {
+			k.t.Fatal("device closed")
+		}
+		assert.Equal(k.t, uint16(0), finish(sum(pseudoSum(p.src, p.dst, p.proto, len(p.payload)), p.payload)), "checksum")
+		return p
+	case <-time.After(5 * time.Second):
+		k.t.Fatal("no packet")
+	}
+	return packet{}
+}
+
+// nextTCP returns the next TCP segment from the stack, skipping the bare acknowledgments if data.
+func (k *fakeTUN) nextTCP(data bool) tcpSegment {
+	k.t.Helper()
+	for {
+		p := k.next()
+		assert.Equal(k.t, uint8(protoTCP), p.proto)
+		assert.Equal(k.t, server.Addr(), p.src)
+		assert.Equal(k.t, client.Addr(), p.dst)
+		seg, ok := parseTCP(p.payload)
+		assert.True(k.t, ok)
+		if !data || len(seg.data) > 0 || seg.flags&^tcpACK != 0 {
+			return seg
+		}
+	}
+}
+
+// connect opens a connection from client to server, it returns the ISS of the stack.
+func (k *fakeTUN) connect() uint32 {
+	k.t.Helper()
+	k.sendTCP(client, server, 1000, 0, tcpSYN, nil, 2, 4, 0x05, 0xB4)
+	synAck := k.nextTCP(false)
+	assert.Equal(k.t, uint8(tcpSYN|tcpACK), synAck.flags)
+	assert.Equal(k.t, uint32(1001), synAck.ack)
+	assert.Equal(k.t, uint16(1460), synAck.mss)
+	k.sendTCP(client, server, 1001, synAck.seq+1, tcpACK, nil)
+	return synAck.seq
+}
+
+// handler hands the flows to the tests.
+type handler struct {
+	tcp, udp chan net.Conn
+}
+
+func newHandler() *handler {
+	return &handler{tcp: make(chan net.Conn, 4), udp: make(chan net.Conn, 4)}
+}
+
+func (h *handler) HandleTCP(conn net.Conn) { h.tcp <- conn }
+func (h *handler) HandleUDP(conn net.Conn) { h.udp <- conn }
+
+func accept(t *testing.T, c chan net.Conn) net.Conn {
+	t.Helper()
+	select {
+	case conn := <-c:
+		return conn
+	case <-time.After(5 * time.Second):
+		t.Fatal("no flow")
+	}
+	return nil
+}
+
+func TestTCP(t *testing.T) {
+	h := newHandler()
+	k := runStack(t, h)
+	iss := k.connect()
+
+	conn := accept(t, h.tcp)
+	assert.Equal(t, server.String(), conn.LocalAddr().String())
+	assert.Equal(t, client.String(), conn.RemoteAddr().String())
+	go func() {
+		_, _ = io.Copy(conn, conn)
+		conn.Close()
+	}()
+
+	k.sendTCP(client, server, 1001, iss+1, tcpPSH|tcpACK, []byte("hello"))
+	seg := k.nextTCP(true)
+	assert.Equal(t, "hello", string(seg.data))
+	assert.Equal(t, iss+1, seg.seq)
+	assert.Equal(t, uint32(1006), seg.ack)
+	k.sendTCP(client, server, 1006, iss+6, tcpACK, nil)
+
+	// the client closes, and then the echo
+	k.sendTCP(client, server, 1006, iss+6, tcpFIN|tcpACK, nil)
+	seg = k.nextTCP(true)
+	assert.Equal(t, uint8(tcpFIN|tcpACK), seg.flags)
+	assert.Equal(t, iss+6, seg.seq)
+	assert.Equal(t, uint32(1007), seg.ack)
+	k.sendTCP(client, server, 1007, iss+7, tcpACK, nil)
+
+	// the connection ended
+	assert.Eventually(t, func() bool {
+		k.sendTCP(client, server, 1007, iss+7, tcpACK, nil)
+		return k.nextTCP(false).flags&tcpRST != 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestTCPLargeWrite(t *testing.T) {
+	h := newHandler()
+	k := runStack(t, h)
+	iss := k.connect()
+
+	conn := accept(t, h.tcp)
+	data := make([]byte, 3*tcpBufferSize)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	written := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(data)
+		written <- err
+	}()
+
+	var received []byte
+	for len(received) < len(data) {
+		seg := k.nextTCP(true)
+		assert.LessOrEqual(t, len(seg.data), 1460, "the MSS")
+		if seg.seq == iss+1+uint32(len(received)) {
+			received = append(received, seg.data...)
+			k.sendTCP(client, server, 1001, seg.seq+uint32(len(seg.data)), tcpACK, nil)
+		}
+	}
+	assert.Equal(t, data, received)
+	assert.NoError(t, <-written)
+}
+
+func TestTCPRetransmit(t *testing.T) {
+	h := newHandler()
+	k := runStack(t, h)
+	iss := k.connect()
+
+	conn := accept(t, h.tcp)
+	_, err := conn.Write([]byte("data"))
+	assert.NoError(t, err)
+
+	first := k.nextTCP(true)
+	again := k.nextTCP(true)
+	assert.Equal(t, first.seq, again.seq)
+	assert.Equal(t, "data", string(again.data))
+	k.sendTCP(client, server, 1001, iss+5, tcpACK, nil)
+}
+
+func TestTCPReset(t *testing.T) {
+	h := newHandler()
+	k := runStack(t, h)
+
+	// an unknown connection
+	k.sendTCP(client, server, 1000, 12345, tcpACK, []byte("data"))
+	seg := k.nextTCP(false)
+	assert.Equal(t, uint8(tcpRST), seg.flags)
+	assert.Equal(t, uint32(12345), seg.seq)
+
+	// the peer resets
+	iss := k.connect()
+	conn := accept(t, h.tcp)
+	k.sendTCP(client, server, 1001, iss+1, tcpRST, nil)
+	_, err := conn.Read(make([]byte, 1))
+	assert.Equal(t, ErrReset, err)
+}
+
+func TestTCPMaxPending(t *testing.T) {
+	h := newHandler()
+	k := runNetstack(t, &Netstack{MaxPending: 1}, h)
+	other := netip.AddrPortFrom(client.Addr(), client.Port()+1)
+
+	k.sendTCP(client, server, 1000, 0, tcpSYN, nil)
+	synAck := k.nextTCP(false)
+	assert.Equal(t, uint8(tcpSYN|tcpACK), synAck.flags)
+
+	// a second half-open connection is reset
+	k.sendTCP(other, server, 5000, 0, tcpSYN, nil)
+	p := k.next()
+	seg, _ := parseTCP(p.payload)
+	assert.Equal(t, other.Port(), binary.BigEndian.Uint16(p.payload[2:]))
+	assert.Equal(t, uint8(tcpRST|tcpACK), seg.flags)
+	assert.Equal(t, uint32(5001), seg.ack)
+
+	// until the first one is established
+	k.sendTCP(client, server, 1001, synAck.seq+1, tcpACK, nil)
+	accept(t, h.tcp)
+	k.sendTCP(other, server, 5000, 0, tcpSYN, nil)
+	p = k.next()
+	seg, _ = parseTCP(p.payload)
+	assert.Equal(t, other.Port(), binary.BigEndian.Uint16(p.payload[2:]))
+	assert.Equal(t, uint8(tcpSYN|tcpACK), seg.flags)
+}
+
+func TestChecksum(t *testing.T) {
+	h := newHandler()
+	k := runStack(t, h)
+	corrupt := func(src, dst netip.Addr, proto uint8, transport []byte, csumOffset int) {
+		b := appendIP(nil, 0, src, dst, proto, transport, csumOffset)
+		b[len(b)-len(transport)+csumOffset] ^= 0xFF
+		_, _ = k.kernel.Write(b)
+	}
+
+	// a SYN whose checksum is wrong is dropped, the segment after it is reset
+	syn := make([]byte, tcpHeaderLen)
+	binary.BigEndian.PutUint16(syn[0:], client.Port())
+	binary.BigEndian.PutUint16(syn[2:], server.Port())
+	binary.BigEndian.PutUint32(syn[4:], 1000)
+	syn[12], syn[13] = tcpHeaderLen/4<<4, tcpSYN
+	corrupt(client.Addr(), server.Addr(), protoTCP, syn, 16)
+	k.sendTCP(client, server, 1000, 12345, tcpACK, nil)
+	assert.Equal(t, uint8(tcpRST), k.nextTCP(false).flags)
+
+	// so is a datagram, while a zero checksum means none over IPv4
+	datagram := func(payload string) []byte {
+		d := make([]byte, udpHeaderLen+len(payload))
+		binary.BigEndian.PutUint16(d[0:], client.Port())
+		binary.BigEndian.PutUint16(d[2:], 53)
+		binary.BigEndian.PutUint16(d[4:], uint16(len(d)))
+		copy(d[udpHeaderLen:], payload)
+		return d
+	}
+	corrupt(client.Addr(), server.Addr(), protoUDP, datagram("corrupt"), 6)
+	none := datagram("none")
+	b := appendIP(nil, 0, client.Addr(), server.Addr(), protoUDP, none, 6)
+	binary.BigEndian.PutUint16(b[len(b)-len(none)+6:], 0)
+	_, _ = k.kernel.Write(b)
+
+	conn := accept(t, h.udp)
+	buf := make([]byte, 16)
+	n, err := conn.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "none", string(buf[:n]))
+}
+
+func TestTCPDeadline(t *testing.T) {
+	h := newHandler()
+	k := runStack(t, h)
+	k.connect()
+
+	conn := accept(t, h.tcp)
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	_, err := conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+func TestUDP(t *testing.T) {
+	h := newHandler()
+	k := runStack(t, h)
+
+	src := netip.MustParseAddrPort("[fd00::2]:5000")
+	dst := netip.MustParseAddrPort("[2001:db8::1]:53")
+	sendUDP := func(payload string) {
+		d := make([]byte, udpHeaderLen+len(payload))
+		binary.BigEndian.PutUint16(d[0:], src.Port())
+		binary.BigEndian.PutUint16(d[2:], dst.Port())
+		binary.BigEndian.PutUint16(d[4:], uint16(len(d)))
+		copy(d[udpHeaderLen:], payload)
+		k.peer.write(src.Addr(), dst.Addr(), protoUDP, d, 6)
+	}
+
+	sendUDP("one")
+	conn := accept(t, h.udp)
+	assert.Equal(t, dst.String(), conn.LocalAddr().String())
+	assert.Equal(t, src.String(), conn.RemoteAddr().String())
+	sendUDP("two")
+
+	buf := make([]byte, 128)
+	for _, payload := range []string{"one", "two"} {
+		n, err := conn.Read(buf)
+		assert.NoError(t, err)
+		assert.Equal(t, payload, string(buf[:n]))
+	}
+	assert.Len(t, h.udp, 0, "a single flow")
+
+	_, err := conn.Write([]byte("reply"))
+	assert.NoError(t, err)
+	p := k.next()
+	assert.Equal(t, uint8(protoUDP), p.proto)
+	assert.Equal(t, dst.Addr(), p.src)
+	assert.Equal(t, src.Addr(), p.dst)
+	assert.Equal(t, dst.Port(), binary.BigEndian.Uint16(p.payload[0:]))
+	assert.Equal(t, src.Port(), binary.BigEndian.Uint16(p.payload[2:]))
+	assert.Equal(t, "reply", string(p.payload[udpHeaderLen:]))
+
+	// closing the device ends the flows
+	k.kernel.Close()
+	assert.Equal(t, io.EOF, <-k.done)
+	_, err = conn.Read(buf)
+	assert.Equal(t, net.ErrClosed, err)
+}
+
+func TestParseIP(t *testing.T) {
+	// an IPv4 header carrying a UDP header
+	b := []byte{
+		0x45, 0x00, 0x00, 0x1c, 0x00, 0x00, 0x40, 0x00, 0x40, 0x11, 0x00, 0x00, 0xc0, 0xa8, 0x00, 0x01, 0xc0, 0xa8, 0x00, 0xc7,
+		0x13, 0x88, 0x00, 0x35, 0x00, 0x08, 0x00, 0x00,
+	}
+	p, ok := parseIP(b)
+	assert.True(t, ok)
+	assert.Equal(t, netip.MustParseAddr("192.168.0.1"), p.src)
+	assert.Equal(t, netip.MustParseAddr("192.168.0.199"), p.dst)
+	assert.Equal(t, uint8(protoUDP), p.proto)
+	assert.Len(t, p.payload, udpHeaderLen)
+
+	out := appendIP(nil, 0, p.src, p.dst, protoUDP, p.payload, 6)
+	assert.Equal(t, uint16(0xb8b8), binary.BigEndian.Uint16(out[10:]), "the header checksum")
+
+	// fragments
+	binary.BigEndian.PutUint16(b[6:], 0x2000)
+	_, ok = parseIP(b)
+	assert.False(t, ok)
+
+	// truncated
+	_, ok = parseIP(b[:10])
+	assert.False(t, ok)
+}
@@ -0,0 +1,606 @@
+package tun
+
+import (
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+)
+
+// TCP flags.
+const (
+	tcpFIN = 1 << iota
+	tcpSYN
+	tcpRST
+	tcpPSH
+	tcpACK
+)
+
+const (
+	tcpBufferSize = 0xFFFF // of the received and the sent data, the largest window without scaling
+	tcpInitialRTO = 200 * time.Millisecond
+	tcpMaxRTO     = 10 * time.Second
+	tcpMaxRetries = 10
+	tcpLinger     = 30 * time.Second // how long a closed connection waits for the FIN of its peer
+)
+
+// tcpSegment is a parsed TCP segment.
+type tcpSegment struct {
+	srcPort, dstPort uint16
+	seq, ack         uint32
+	flags            uint8
+	window           uint16
+	mss              uint16 // the MSS option of SYN segments
+	data             []byte
+}
+
+func parseTCP(b []byte) (seg tcpSegment, ok bool) {
+	if len(b) < tcpHeaderLen {
+		return
+	}
+	off := int(b[12]>>4) * 4
+	if off < tcpHeaderLen || off > len(b) {
+		return
+	}
+	seg.srcPort = binary.BigEndian.Uint16(b[0:])
+	seg.dstPort = binary.BigEndian.Uint16(b[2:])
+	seg.seq = binary.BigEndian.Uint32(b[4:])
+	seg.ack = binary.BigEndian.Uint32(b[8:])
+	seg.flags = b[13]
+	seg.window = binary.BigEndian.Uint16(b[14:])
+	seg.data = b[off:]
+
+	for opts := b[tcpHeaderLen:off]; len(opts) > 0 && opts[0] != 0; {
+		if opts[0] == 1 { // no-operation
+			opts = opts[1:]
+			continue
+		}
+		if len(opts) < 2 || opts[1] < 2 || int(opts[1]) > len(opts) {
+			break
+		}
+		if opts[0] == 2 && opts[1] == 4 {
+			seg.mss = binary.BigEndian.Uint16(opts[2:])
+		}
+		opts = opts[opts[1]:]
+	}
+	return seg, true
+}
+
+// seqLT reports whether the sequence number a is before b.
+func seqLT(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+func seqLEQ(a, b uint32) bool {
+	return int32(a-b) <= 0
+}
+
+func (s *stack) inputTCP(p packet) {
+	seg, ok := parseTCP(p.payload)
+	if !ok || !p.checksumValid(p.payload) {
+		return
+	}
+	id := flowID{src: netip.AddrPortFrom(p.src, seg.srcPort), dst: netip.AddrPortFrom(p.dst, seg.dstPort)}
+
+	s.mu.Lock()
+	c := s.tcp[id]
+	// the SYNs beyond the half-open connections allowed are reset
+	if c == nil && seg.flags&(tcpSYN|tcpACK|tcpRST) == tcpSYN && !s.closed && s.pending < s.maxPending {
+		c = newTCPConn(s, id, seg)
+		s.tcp[id] = c
+		s.pending++
+	}
+	s.mu.Unlock()
+
+	if c == nil {
+		if seg.flags&tcpRST == 0 {
+			s.reset(id, seg)
+		}
+		return
+	}
+	c.input(seg)
+}
+
+// reset answers a segment of an unknown connection.
+func (s *stack) reset(id flowID, seg tcpSegment) {
+	if seg.flags&tcpACK != 0 {
+		s.writeTCP(id, seg.ack, 0, tcpRST, 0, nil, nil)
+		return
+	}
+	ack := seg.seq + uint32(len(seg.data))
+	if seg.flags&tcpSYN != 0 {
+		ack++
+	}
+	if seg.flags&tcpFIN != 0 {
+		ack++
+	}
+	s.writeTCP(id, 0, ack, tcpRST|tcpACK, 0, nil, nil)
+}
+
+// writeTCP writes a segment of the flow id, from its destination back to its source.
+func (s *stack) writeTCP(id flowID, seq, ack uint32, flags uint8, window uint16, opts, data []byte) {
+	hlen := tcpHeaderLen + len(opts)
+	b := make([]byte, hlen+len(data))
+	binary.BigEndian.PutUint16(b[0:], id.dst.Port())
+	binary.BigEndian.PutUint16(b[2:], id.src.Port())
+	binary.BigEndian.PutUint32(b[4:], seq)
+	binary.BigEndian.PutUint32(b[8:], ack)
+	b[12] = byte(hlen/4) << 4
+	b[13] = flags
+	binary.BigEndian.PutUint16(b[14:], window)
+	copy(b[tcpHeaderLen:], opts)
+	copy(b[hlen:], data)
+	s.write(id.dst.Addr(), id.src.Addr(), protoTCP, b, 16)
+}
+
+type tcpState uint8
+
+const (
+	tcpSynReceived tcpState = iota
+	tcpEstablished
+	tcpClosed
+)
+
+// tcpConn is a TCP connection accepted by a Netstack, from its source to its destination.
+// Its data are sent with go-back-N retransmissions.
+type tcpConn struct {
+	s  *stack
+	id flowID
+
+	mu     sync.Mutex
+	state  tcpState
+	err    error // why the connection was aborted
+	closed bool  // by Close
+
+	// sending
+	iss, sndUna, sndNxt, sndMax uint32
+	sndWnd                      uint32
+	mss                         int
+	sendBuf                     []byte // the data from sndUna, the FIN following them once queued
+	finQueued, finAcked         bool
+	rto                         time.Duration
+	retries                     int
+	timer, linger               *time.Timer
+	timerGen                    int
+
+	// receiving
+	rcvNxt      uint32
+	recvBuf     []byte
+	finReceived bool
+	lastWnd     uint16 // the last window advertised
+
+	readable, writable          chan struct{}
+	readDeadline, writeDeadline deadline
+}
+
+func newTCPConn(s *stack, id flowID, syn tcpSegment) *tcpConn {
+	// the default MSS when the peer doesn't tell
+	mss := 536
+	if id.src.Addr().Is6() {
+		mss = 1220
+	}
+	if syn.mss != 0 {
+		mss = int(syn.mss)
+	}
+	if limit := s.mss(id); mss > limit {
+		mss = limit
+	}
+
+	iss := rand.Uint32()
+	return &tcpConn{
+		s:             s,
+		id:            id,
+		iss:           iss,
+		sndUna:        iss,
+		sndNxt:        iss,
+		sndMax:        iss,
+		sndWnd:        uint32(syn.window),
+		mss:           mss,
+		rto:           tcpInitialRTO,
+		rcvNxt:        syn.seq + 1,
+		readable:      make(chan struct{}, 1),
+		writable:      make(chan struct{}, 1),
+		readDeadline:  makeDeadline(),
+		writeDeadline: makeDeadline(),
+	}
+}
+
+// mss returns the largest segment of the device for the flow id.
+func (s *stack) mss(id flowID) int {
+	if id.src.Addr().Is4() {
+		return s.mtu - ipv4HeaderLen - tcpHeaderLen
+	}
+	return s.mtu - ipv6HeaderLen - tcpHeaderLen
+}
+
+func (c *tcpConn) input(seg tcpSegment) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == tcpClosed {
+		return
+	}
+
+	if seg.flags&tcpRST != 0 {
+		// only within the window, against blind resets
+		if seqLEQ(c.rcvNxt, seg.seq) && seqLEQ(seg.seq, c.rcvNxt+uint32(c.window())) {
+			c.endLocked(ErrReset)
+		}
+		return
+	}
+	if seg.flags&tcpSYN != 0 {
+		if c.state == tcpSynReceived && seg.seq+1 == c.rcvNxt {
+			c.sendSynAck()
+		} else {
+			c.sendACK()
+		}
+		return
+	}
+	if seg.flags&tcpACK == 0 {
+		return
+	}
+
+	if c.state == tcpSynReceived {
+		if seg.ack != c.iss+1 {
+			c.s.reset(c.id, seg)
+			return
+		}
+		c.state = tcpEstablished
+		c.s.mu.Lock()
+		c.s.pending--
+		c.s.mu.Unlock()
+		c.sndUna = seg.ack
+		c.sndWnd = uint32(seg.window)
+		c.retries, c.rto = 0, tcpInitialRTO
+		c.stopTimer()
+		go c.s.h.HandleTCP(c)
+	} else if seqLEQ(c.sndUna, seg.ack) && seqLEQ(seg.ack, c.sndMax) {
+		if acked := int(seg.ack - c.sndUna); acked > 0 {
+			if acked > len(c.sendBuf) {
+				c.finAcked = true
+				acked = len(c.sendBuf)
+			}
+			c.sendBuf = c.sendBuf[acked:]
+			c.sndUna = seg.ack
+			if seqLT(c.sndNxt, c.sndUna) {
+				c.sndNxt = c.sndUna
+			}
+			c.rto = tcpInitialRTO
+			c.stopTimer()
+			notify(c.writable)
+		}
+		c.sndWnd = uint32(seg.window)
+		c.retries = 0
+	}
+
+	if len(seg.data) > 0 || seg.flags&tcpFIN != 0 {
+		c.receive(seg)
+	}
+	c.output(false)
+	c.checkDone()
+}
+
+// receive receives the data and the FIN of seg, in order only.
+func (c *tcpConn) receive(seg tcpSegment) {
+	data, seq, fin := seg.data, seg.seq, seg.flags&tcpFIN != 0
+	if c.finReceived {
+		c.sendACK()
+		return
+	}
+	if seqLT(seq, c.rcvNxt) {
+		d := c.rcvNxt - seq
+		if d > uint32(len(data)) {
+			c.sendACK()
+			return
+		}
+		data, seq = data[d:], c.rcvNxt
+	}
+	if seq != c.rcvNxt {
+		// out of order, the peer retransmits after the duplicate acknowledgment
+		c.sendACK()
+		return
+	}
+	if c.closed && len(data) > 0 {
+		// nobody reads anymore
+		c.sendRST()
+		c.endLocked(nil)
+		return
+	}
+
+	if room := tcpBufferSize - len(c.recvBuf); len(data) > room {
+		data, fin = data[:room], false
+	}
+	c.recvBuf = append(c.recvBuf, data...)
+	c.rcvNxt += uint32(len(data))
+	if fin {
+		c.rcvNxt++
+		c.finReceived = true
+	}
+	notify(c.readable)
+	c.sendACK()
+}
+
+// output sends the data the window allows, and the FIN after them. A probe sends a byte
+// even if the window is closed.
+func (c *tcpConn) output(probe bool) {
+	if c.state != tcpEstablished {
+		return
+	}
+	for {
+		off := int(c.sndNxt - c.sndUna)
+		if off > len(c.sendBuf) {
+			break // the FIN was sent
+		}
+		n := len(c.sendBuf) - off
+		wnd := int(c.sndWnd) - off
+		if probe && off == 0 && wnd < 1 {
+			wnd = 1
+		}
+		if n > wnd {
+			n = wnd
+		}
+		if n > c.mss {
+			n = c.mss
+		}
+		if n <= 0 {
+			if off == len(c.sendBuf) && c.finQueued {
+				c.send(tcpFIN|tcpACK, c.sndNxt, nil)
+				c.sndNxt++
+			}
+			break
+		}
+		c.send(tcpPSH|tcpACK, c.sndNxt, c.sendBuf[off:off+n])
+		c.sndNxt += uint32(n)
+	}
+	if seqLT(c.sndMax, c.sndNxt) {
+		c.sndMax = c.sndNxt
+	}
+	// waiting for an acknowledgment, or for the window to open
+	if c.sndNxt != c.sndUna || len(c.sendBuf) > 0 {
+		c.startTimer()
+	}
+}
+
+func (c *tcpConn) send(flags uint8, seq uint32, data []byte) {
+	c.lastWnd = c.window()
+	c.s.writeTCP(c.id, seq, c.rcvNxt, flags, c.lastWnd, nil, data)
+}
+
+func (c *tcpConn) sendACK() {
+	c.send(tcpACK, c.sndNxt, nil)
+}
+
+func (c *tcpConn) sendRST() {
+	c.s.writeTCP(c.id, c.sndNxt, c.rcvNxt, tcpRST|tcpACK, 0, nil, nil)
+}
+
+func (c *tcpConn) sendSynAck() {
+	mss := c.s.mss(c.id)
+	c.lastWnd = c.window()
+	c.s.writeTCP(c.id, c.iss, c.rcvNxt, tcpSYN|tcpACK, c.lastWnd, []byte{2, 4, byte(mss >> 8), byte(mss)}, nil)
+	c.sndNxt, c.sndMax = c.iss+1, c.iss+1
+	c.startTimer()
+}
+
+func (c *tcpConn) window() uint16 {
+	return uint16(tcpBufferSize - len(c.recvBuf))
+}
+
+func (c *tcpConn) startTimer() {
+	if c.timer != nil {
+		return
+	}
+	c.timerGen++
+	gen := c.timerGen
+	c.timer = time.AfterFunc(c.rto, func() { c.timeout(gen) })
+}
+
+func (c *tcpConn) stopTimer() {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+}
+
+// timeout retransmits what wasn't acknowledged, the SYN-ACK or the segments from sndUna.
+func (c *tcpConn) timeout(gen int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer == nil || gen != c.timerGen || c.state == tcpClosed {
+		return
+	}
+	c.timer = nil
+
+	c.retries++
+	if c.retries > tcpMaxRetries {
+		c.sendRST()
+		c.endLocked(ErrTimedOut)
+		return
+	}
+	if c.rto *= 2; c.rto > tcpMaxRTO {
+		c.rto = tcpMaxRTO
+	}
+	if c.state == tcpSynReceived {
+		c.sendSynAck()
+		return
+	}
+	c.sndNxt = c.sndUna
+	c.output(true)
+}
+
+// checkDone ends the connection once both FINs are acknowledged, or once its peer
+// lingered too long after Close.
+func (c *tcpConn) checkDone() {
+	if c.state != tcpEstablished {
+		return
+	}
+	if c.finAcked && c.finReceived {
+		c.endLocked(nil)
+		return
+	}
+	if c.closed && c.finAcked && c.linger == nil {
+		c.linger = time.AfterFunc(tcpLinger, func() { c.abort(nil, false) })
+	}
+}
+
+// abort ends the connection with err, resetting it if rst.
+func (c *tcpConn) abort(err error, rst bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.state == tcpClosed {
+		return
+	}
+	if rst {
+		c.sendRST()
+	}
+	c.endLocked(err)
+}
+
+func (c *tcpConn) endLocked(err error) {
+	pending := c.state == tcpSynReceived
+	c.state = tcpClosed
+	if c.err == nil {
+		c.err = err
+	}
+	c.stopTimer()
+	if c.linger != nil {
+		c.linger.Stop()
+	}
+
+	c.s.mu.Lock()
+	if c.s.tcp[c.id] == c {
+		delete(c.s.tcp, c.id)
+	}
+	if pending {
+		c.s.pending--
+	}
+	c.s.mu.Unlock()
+
+	notify(c.readable)
+	notify(c.writable)
+}
+
+func (c *tcpConn) Read(b []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return 0, net.ErrClosed
+		}
+		if len(c.recvBuf) > 0 {
+			n := copy(b, c.recvBuf)
+			c.recvBuf = c.recvBuf[n:]
+			// tell the peer once the window reopened
+			if c.state == tcpEstablished && c.lastWnd < tcpBufferSize/2 && c.window() >= tcpBufferSize/2 {
+				c.sendACK()
+			}
+			c.mu.Unlock()
+			return n, nil
+		}
+		if c.finReceived {
+			c.mu.Unlock()
+			return 0, io.EOF
+		}
+		if c.err != nil {
+			c.mu.Unlock()
+			return 0, c.err
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-c.readable:
+		case <-c.readDeadline.wait():
+			return 0, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+func (c *tcpConn) Write(b []byte) (n int, err error) {
+	for {
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return n, net.ErrClosed
+		}
+		if c.err != nil {
+			c.mu.Unlock()
+			return n, c.err
+		}
+		if len(b) == 0 {
+			c.mu.Unlock()
+			return n, nil
+		}
+		if room := tcpBufferSize - len(c.sendBuf); room > 0 {
+			if room > len(b) {
+				room = len(b)
+			}
+			c.sendBuf = append(c.sendBuf, b[:room]...)
+			b = b[room:]
+			n += room
+			c.output(false)
+			c.mu.Unlock()
+			continue
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-c.writable:
+		case <-c.writeDeadline.wait():
+			return n, os.ErrDeadlineExceeded
+		}
+	}
+}
+
+// Close sends the FIN after the data written, the connection ending once its peer closes it too.
+func (c *tcpConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	c.closed = true
+	c.recvBuf = nil
+	if c.state == tcpEstablished {
+		c.finQueued = true
+		c.output(false)
+		c.checkDone()
+	}
+	notify(c.readable)
+	notify(c.writable)
+	return nil
+}
+
+func (c *tcpConn) LocalAddr() net.Addr {
+	return net.TCPAddrFromAddrPort(c.id.dst)
+}
+
+func (c *tcpConn) RemoteAddr() net.Addr {
+	return net.TCPAddrFromAddrPort(c.id.src)
+}
+
+func (c *tcpConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *tcpConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *tcpConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// notify wakes up a reader or writer waiting on c.
+func notify(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
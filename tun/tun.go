@@ -0,0 +1,43 @@
+// Package tun terminates in userspace the TCP connections and the UDP flows of the IP packets
+// read from a TUN device, handing each of them as a net.Conn, which forward.TUN forwards through
+// a proxy server. The packet stack is pluggable, Netstack being a minimal one.
+package tun
+
+import (
+	"errors"
+	"io"
+	"net"
+)
+
+var (
+	// ErrUnsupported is returned by Open outside Linux.
+	ErrUnsupported = errors.New("TUN devices are only supported on Linux")
+
+	// ErrReset is returned by the connections reset by their peer.
+	ErrReset = errors.New("connection reset by peer")
+
+	// ErrTimedOut is returned by the connections whose peer stopped acknowledging their data.
+	ErrTimedOut = errors.New("connection timed out")
+
+	// ErrTooLarge is returned by the UDP flows for datagrams larger than an IP packet.
+	ErrTooLarge = errors.New("datagram too large")
+)
+
+// Stack terminates the flows of the IP packets read from a device.
+type Stack interface {
+	// Run reads the IP packets from dev, one per Read, and writes the packets of the flows to dev,
+	// one per Write, handing each new flow to h. It returns the error of reading dev, after
+	// ending all the flows.
+	Run(dev io.ReadWriter, h Handler) error
+}
+
+// Handler handles the flows terminated by a Stack, each in its own goroutine.
+// The local address of a flow is its destination, the remote address its source.
+type Handler interface {
+	// HandleTCP handles a TCP connection, it has to close conn.
+	HandleTCP(conn net.Conn)
+
+	// HandleUDP handles a UDP flow, each Read returning a datagram from the source and each
+	// Write sending one back. It has to close conn, usually once the flow is idle.
+	HandleUDP(conn net.Conn)
+}
@@ -0,0 +1,140 @@
+package tun
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+)
+
+// udpQueueLen is how many datagrams a UDP flow queues for its reader, dropping the next ones.
+const udpQueueLen = 64
+
+func (s *stack) inputUDP(p packet) {
+	if len(p.payload) < udpHeaderLen {
+		return
+	}
+	length := int(binary.BigEndian.Uint16(p.payload[4:]))
+	if length < udpHeaderLen || length > len(p.payload) {
+		return
+	}
+	// a zero checksum is only allowed over IPv4, where it means none
+	if csum := binary.BigEndian.Uint16(p.payload[6:]); (csum != 0 || p.dst.Is6()) && !p.checksumValid(p.payload[:length]) {
+		return
+	}
+	id := flowID{
+		src: netip.AddrPortFrom(p.src, binary.BigEndian.Uint16(p.payload[0:])),
+		dst: netip.AddrPortFrom(p.dst, binary.BigEndian.Uint16(p.payload[2:])),
+	}
+	datagram := append([]byte(nil), p.payload[udpHeaderLen:length]...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	c := s.udp[id]
+	if c == nil {
+		c = &udpConn{
+			s:            s,
+			id:           id,
+			in:           make(chan []byte, udpQueueLen),
+			done:         make(chan struct{}),
+			readDeadline: makeDeadline(),
+		}
+		s.udp[id] = c
+		go s.h.HandleUDP(c)
+	}
+	select {
+	case c.in <- datagram:
+	default:
+	}
+}
+
+// udpConn is a UDP flow terminated by a Netstack, from its source to its destination.
+type udpConn struct {
+	s  *stack
+	id flowID
+
+	in           chan []byte
+	done         chan struct{}
+	once         sync.Once
+	readDeadline deadline
+}
+
+// Read reads a datagram from the source, truncated to b.
+func (c *udpConn) Read(b []byte) (int, error) {
+	select {
+	case <-c.done:
+		return 0, net.ErrClosed
+	case <-c.readDeadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	default:
+	}
+	select {
+	case datagram := <-c.in:
+		return copy(b, datagram), nil
+	case <-c.done:
+		return 0, net.ErrClosed
+	case <-c.readDeadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	}
+}
+
+// Write sends a datagram from the destination to the source, it isn't fragmented.
+func (c *udpConn) Write(b []byte) (int, error) {
+	select {
+	case <-c.done:
+		return 0, net.ErrClosed
+	default:
+	}
+	if udpHeaderLen+len(b) > 0xFFFF {
+		return 0, ErrTooLarge
+	}
+	d := make([]byte, udpHeaderLen+len(b))
+	binary.BigEndian.PutUint16(d[0:], c.id.dst.Port())
+	binary.BigEndian.PutUint16(d[2:], c.id.src.Port())
+	binary.BigEndian.PutUint16(d[4:], uint16(len(d)))
+	copy(d[udpHeaderLen:], b)
+	c.s.write(c.id.dst.Addr(), c.id.src.Addr(), protoUDP, d, 6)
+	return len(b), nil
+}
+
+// Close ends the flow, the next datagrams from the source starting a new one.
+func (c *udpConn) Close() error {
+	err := net.ErrClosed
+	c.once.Do(func() {
+		close(c.done)
+		c.s.mu.Lock()
+		if c.s.udp[c.id] == c {
+			delete(c.s.udp, c.id)
+		}
+		c.s.mu.Unlock()
+		err = nil
+	})
+	return err
+}
+
+func (c *udpConn) LocalAddr() net.Addr {
+	return net.UDPAddrFromAddrPort(c.id.dst)
+}
+
+func (c *udpConn) RemoteAddr() net.Addr {
+	return net.UDPAddrFromAddrPort(c.id.src)
+}
+
+func (c *udpConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *udpConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline does nothing, the writes don't wait.
+func (c *udpConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
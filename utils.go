@@ -1,8 +1,43 @@
 package socks
 
-import "io"
+import (
+	"io"
+	"net"
+)
 
-func copy(closer chan struct{}, dst io.Writer, src io.Reader) {
-	_, _ = io.Copy(dst, src)
-	closer <- struct{}{} // connection is closed, send signal to stop proxy
+// Relay copies data between a and b until both directions are done, and then closes them.
+// The end of the data read from one side is passed on to the other with CloseWrite, looking
+// through the connections wrapped with a NetConn method as tls.Conn does. Both sides are
+// closed at once when a direction fails or can't be half-closed.
+func Relay(a, b net.Conn) {
+	halfClosed := make(chan bool, 2)
+	go pipe(halfClosed, a, b)
+	go pipe(halfClosed, b, a)
+	if !<-halfClosed {
+		a.Close()
+		b.Close()
+	}
+	<-halfClosed
+	a.Close()
+	b.Close()
+}
+
+// pipe copies src to dst, and reports whether the end of src was passed on to dst.
+func pipe(halfClosed chan<- bool, dst, src net.Conn) {
+	_, err := io.Copy(dst, src)
+	halfClosed <- err == nil && closeWrite(dst)
+}
+
+// closeWrite shuts down the writing side of c, and reports whether it could.
+func closeWrite(c net.Conn) bool {
+	for {
+		switch w := c.(type) {
+		case interface{ CloseWrite() error }:
+			return w.CloseWrite() == nil
+		case interface{ NetConn() net.Conn }:
+			c = w.NetConn()
+		default:
+			return false
+		}
+	}
 }